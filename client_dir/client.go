@@ -10,78 +10,297 @@ package main
 
 import (
 	"bufio"
+	"crypto/tls"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
+	"os/signal"
+	"sort"
 	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"project3/recordlib"
-	"golang.org/x/sys/unix"
 )
 
+//longest input line the REPL accepts (bufio.Scanner default is 64KB)
+const max_input_line = 1 << 20
+
+const handshake_timeout = 3 * time.Second //max wait for the server's first frame
+
 //multiple error defs
 var (
 	ErrServer           = fmt.Errorf("error occurred on server-side")
+	ErrStreamIncomplete = fmt.Errorf("stream incomplete, the listing is missing trainers")
+	ErrTimeout          = fmt.Errorf("server stopped the listing at its -scan-timeout, retry once it is less busy")
 	ErrInvalidReq       = fmt.Errorf("invalid request, check arguments")
+	ErrUnauthorized     = fmt.Errorf("not authorized, connect with the server's -secret")
+	ErrInputTooLong     = fmt.Errorf("input too long, max %d bytes per line", max_input_line)
+	ErrGetPokeTopArgs   = fmt.Errorf("'get pokemon top' expects 1 argument <n>: int 1-100")
+	ErrGetPokeWhereArgs = fmt.Errorf("'get pokemon where' expects gen <n>, legendary, or both")
+	ErrNoPokeMatch      = fmt.Errorf("no pokemon matched")
+	ErrTooManyToSort    = fmt.Errorf("too many trainers for the server to sort, use 'get trainer'")
+	ErrServerBusy       = fmt.Errorf("server is at its connection limit, try again later")
 	ErrGetNoArg         = fmt.Errorf("'get' requires at least 1 argument")
 	ErrGetPokeNoID      = fmt.Errorf("'get pokemon' requires <id>: int")
 	ErrGetPokeIDLess    = fmt.Errorf("pokemon id starts at 1")
 	ErrGetPokeManyArg   = fmt.Errorf("'get pokemon' expects only 1 argument <id>: int")
 	ErrPokeNotFound     = fmt.Errorf("pokemon ID not found")
-	ErrGetTrainerArgs   = fmt.Errorf("'get trainer' expects 0 or 1 argument <id>: int")
+	ErrGetPokeRawArgs   = fmt.Errorf("'get pokemon raw' expects 1 argument <id>: int")
+	ErrGetPokeSimilarArgs = fmt.Errorf("'get pokemon similar' expects 1 argument <id>: int")
+	ErrGetPokeIDsArgs   = fmt.Errorf("'get pokemon ids' expects 1 argument <id,id,...>: up to 100 positive ints")
+	ErrGetPokeNameArgs  = fmt.Errorf("'get pokename' expects only 1 argument <id>: int")
+	ErrGetTrainerArgs   = fmt.Errorf("'get trainer' expects no argument, <id>: int, <id> full, or sort <name|id> [asc|desc]")
 	ErrGetTrainerIDLess = fmt.Errorf("trainer id starts at 1")
 	ErrTrainerNotFound  = fmt.Errorf("trainer ID not found")
 	ErrTrainerFileEmpty = fmt.Errorf("there are currently no trainers")
-	ErrPostArgsMissing  = fmt.Errorf("'post' requires at least 3 arguments - trainer <name> <pokemon_id> [<pokemon_id> ...]")
+	ErrGetTrainersUsingArgs = fmt.Errorf("'get trainers using' expects 1 argument <pokemon id or name>")
+	ErrPostArgsMissing  = fmt.Errorf("'post' requires at least 2 arguments - trainer <name> [<pokemon_id> ...]")
 	ErrPostPokeMax      = fmt.Errorf("'post' allows max. 6 pokemon")
+	ErrPostKeyReused    = fmt.Errorf("key already used for a different post, pick a new key")
 	ErrPutArgsMissing   = fmt.Errorf("'put' requires at least 3 arguments - trainer <id> <pokemon_id> [<pokemon_id> ...]")
 	ErrPutPokeMax       = fmt.Errorf("'put' allows max. 6 pokemon")
+	ErrAddArgsMissing   = fmt.Errorf("'add' requires at least 3 arguments - trainer <id> <pokemon_id> [<pokemon_id> ...]")
+	ErrAddPokeMax       = fmt.Errorf("'add' allows max. 6 pokemon")
 	ErrPostLongName     = fmt.Errorf("name too long, max 15 characters")
+	ErrBadName          = fmt.Errorf("name may only contain printable ASCII characters")
 	ErrBadPost          = fmt.Errorf("one or more pokemon IDs were not found")
+	ErrPokeIDRange      = fmt.Errorf("pokemon id out of range, must be 1-65535")
 	ErrGetLogNoN        = fmt.Errorf("'get log' requires <n>: int")
-	ErrGetLogManyArg    = fmt.Errorf("'get log' expects only 1 argument <id>: int")
+	ErrGetLogSinceArgs  = fmt.Errorf("'get log since' expects 1 argument <timestamp>, e.g. 2024-01-02T15:04:05 (server local time) or 2024-01-02T15:04:05Z")
+	ErrGetLogFilterArgs = fmt.Errorf("'get log port|cmd' expects 2 arguments <port or command> <n>: int")
+	ErrGetLogManyArg    = fmt.Errorf("'get log' expects only 1 argument <n>: int and optional --all-files")
+	ErrRepeatArgs       = fmt.Errorf("'repeat' requires at least 2 arguments - <n> <command> [<arg> ...]")
+	ErrRepeatN          = fmt.Errorf("'repeat' <n> must be a positive integer")
 )
 
+type client_state struct {
+	sock        recordlib.Conn
+	resp_chan   chan string       //server responses from reader goroutine
+	server_exit chan struct{}     //closed when server shuts down
+	poke_names  map[string]uint16 //lowercase pokemon name -> id, nil if unavailable
+	binary      bool              //records arrive as raw binary (negotiated with HELLO binary)
+	timing_chan chan string       //TIMING line of the last reply, nil unless --timing
+	trace_chan  chan string       //TRACE line of the last reply, nil unless --trace
+	compress    bool              //trainer streams arrive as one gzip frame (negotiated with COMPRESS gzip)
+	scanner     *bufio.Scanner    //user input, also read for confirmation prompts
+	input       *bufio.Reader     //stdin under scanner, used to skip the rest of a too long line
+	table       bool              //print trainer listings as one aligned table (--format=table)
+	dry_run     bool              //print mutating requests instead of sending them (-dry-run)
+	suppressed  int               //mutating requests not sent because of dry_run
+	page        int               //lines per page for pageable commands (-page), 0 prints straight through
+	pending     chan line_result  //pager read of stdin still running after Ctrl-C, read_line takes its line
+}
+
+//one line of user input read on another goroutine
+type line_result struct {
+	line string
+	err  error
+}
+
+type client_opts struct {
+	host   string
+	port   int
+	binary bool
+	timing bool
+	trace  bool
+	compress bool
+	secret  string
+	tls     *tls.Config //server verification for -tls, nil for plaintext
+	net_transport bool  //-transport net, dial with the net package instead of raw syscalls
+	table   bool
+	dry_run bool
+	page    int
+}
+
+/*
+Function Name:  suppress
+Description:	method of client_state
+				in dry run mode prints a mutating request instead of it
+				being sent and counts it, read requests never call this
+Parameters:		req: the request that would be sent
+Return Value:   true if the request must not be sent
+Type:           string -> bool
+*/
+func (cs *client_state) suppress(req string) bool {
+	if !cs.dry_run {
+		return false
+	}
+	cs.suppressed++
+	fmt.Printf("[dry-run] would send: %s\n\n", req)
+	return true
+}
+
+/*
+Function Name:  decode_record
+Description:	method of client_state
+				decodes a record response in the negotiated mode
+Parameters:		msg: record response from server
+				rec: pointer to PokeRec or TrainerRec to fill
+Return Value:   nil or decoding error
+Type:           string, any -> error
+*/
+func (cs *client_state) decode_record(msg string, rec any) error {
+	if cs.binary {
+		return recordlib.DecodeRecordBinary(msg, rec)
+	}
+	return json.Unmarshal([]byte(msg), rec)
+}
+
 /*
 Function Name:  get_opts
 Description:	parses flag arguments for client program
 				exits if -help or --help used for help
 Parameters:     N/A
-Return Value:   the two required arguments and error (if any)
-Type:           n/a -> string, int, error
+Return Value:   parsed options and error (if any)
+Type:           n/a -> client_opts, error
 */
-func get_opts() (string, int, error) {
+func get_opts() (client_opts, error) {
 	help_flag := flag.Bool("help", false, "Show help (must be used on its own)")
 	host_flag := flag.String("h", "", "Server's host IP")
 	port_flag := flag.Int("p", -1, "Port number")
+	binary_flag := flag.Bool("b", false, "Receive records as compact binary instead of JSON")
+	timing_flag := flag.Bool("timing", false, "Show server-side lock wait, file I/O and total time per request")
+	trace_flag := flag.Bool("trace", false, "Show the server connection and request number of each request, as in the server log")
+	compress_flag := flag.Bool("compress", false, "Receive trainer listings and exports gzip compressed, for slow links")
+	secret_flag := flag.String("secret", "", "Server token, sent with AUTH on connect to allow trainer mutations and admin commands")
+	transport_flag := flag.String("transport", "syscall", "Socket transport: syscall (raw unix sockets) or net (Go net package)")
+	tls_flag := flag.Bool("tls", false, "Connect with TLS, for a server started with -cert/-key")
+	ca_flag := flag.String("ca", "", "CA certificate (PEM) to verify the server with under -tls, system roots if unset")
+	format_flag := flag.String("format", "verbose", "Trainer listing format: verbose or table")
+	dry_run_flag := flag.Bool("dry-run", false, "Print post/put/add/remove/patch/delete/import/clear requests instead of sending them")
+	page_flag := flag.Int("page", 0, "Page get/diff/describe output, n lines at a time with a -- more -- prompt (0 is off)")
 
 	flag.Parse()
 	if *help_flag {
 		if flag.NFlag() > 1 {
-			return "", -1, fmt.Errorf("-help must be used alone")
+			return client_opts{}, fmt.Errorf("-help must be used alone")
 		}
 		fmt.Println("Usage:")
 		fmt.Println("  -h string\n        Server's host IP")
 		fmt.Println("  -p int\n        Port number (10000-65535)")
-		unix.Exit(0)
+		fmt.Println("  -b\n        Receive records as compact binary instead of JSON")
+		fmt.Println("  --timing\n        Show server-side lock wait, file I/O and total time per request")
+		fmt.Println("  --trace\n        Show the server connection and request number of each request, as in the server log")
+		fmt.Println("  --compress\n        Receive trainer listings and exports gzip compressed, for slow links")
+		fmt.Println("  -secret string\n        Server token, sent with AUTH on connect to allow trainer mutations and admin commands")
+		fmt.Println("  -transport syscall|net\n        Socket transport: raw unix sockets or Go net package (default syscall)")
+		fmt.Println("  -tls\n        Connect with TLS, for a server started with -cert/-key")
+		fmt.Println("  -ca string\n        CA certificate (PEM) to verify the server with under -tls, system roots if unset")
+		fmt.Println("  --format verbose|table\n        Trainer listing format (default verbose)")
+		fmt.Println("  -dry-run\n        Print post/put/add/remove/patch/delete/import/clear requests instead of sending them")
+		fmt.Println("  -page int\n        Page get/diff/describe output, n lines at a time with a -- more -- prompt (0 is off)")
+		os.Exit(0)
 	}
 
 	if *host_flag == "" || *port_flag == -1 {
-		return "", -1, fmt.Errorf("-h and -p are required")
+		return client_opts{}, fmt.Errorf("-h and -p are required")
+	}
+	if *format_flag != "verbose" && *format_flag != "table" {
+		return client_opts{}, fmt.Errorf("--format must be verbose or table")
 	}
 
 	if *port_flag < 10000 || *port_flag > 65535 {
 		fmt.Println("Error: Invalid port number!")
 		fmt.Println("Must be in range 10000-65535")
-		unix.Exit(1)
+		os.Exit(1)
+	}
+
+	if *page_flag < 0 {
+		return client_opts{}, fmt.Errorf("-page must be 0 or more lines")
+	}
+	if *transport_flag != "syscall" && *transport_flag != "net" {
+		return client_opts{}, fmt.Errorf("-transport must be syscall or net")
+	}
+	if *ca_flag != "" && !*tls_flag {
+		return client_opts{}, fmt.Errorf("-ca needs -tls")
+	}
+	var tls_cfg *tls.Config
+	if *tls_flag {
+		cfg, err := recordlib.ClientTLSConfig(*ca_flag, *host_flag)
+		if err != nil {
+			return client_opts{}, err
+		}
+		tls_cfg = cfg
+	}
+
+	return client_opts{host: *host_flag, port: *port_flag, binary: *binary_flag, timing: *timing_flag, trace: *trace_flag, compress: *compress_flag, secret: *secret_flag, tls: tls_cfg, net_transport: *transport_flag == "net", table: *format_flag == "table", dry_run: *dry_run_flag, page: *page_flag}, nil
+}
+
+/*
+Function Name:  connect
+Description:    dials the server (and runs the TLS handshake under -tls),
+				checks its protocol handshake, then reads
+				the ephemeral port it sends next, if the server is at its
+				connection limit (SERVER_BUSY in place of the handshake)
+				waits and redials with a doubling backoff a few times
+Parameters:		host_addr: IPv4 address of server
+				port: port of server
+				tls_cfg: TLS config, nil for plaintext
+				use_net: dial with the net package transport (-transport net)
+Return Value:   server stream, ephemeral port and error (if any)
+Type:           [4]byte, int, *tls.Config, bool -> recordlib.Conn, string, error
+*/
+func connect(host_addr [4]byte, port int, tls_cfg *tls.Config, use_net bool) (recordlib.Conn, string, error) {
+	dial := recordlib.Dial
+	if use_net {
+		dial = recordlib.DialNet
 	}
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		sock, err := dial(host_addr, port)
+		if err != nil {
+			return nil, "", err
+		}
+		if tls_cfg != nil {
+			sock, err = recordlib.WrapClientTLS(sock, tls_cfg)
+			if err != nil {
+				return nil, "", err
+			}
+			if err := recordlib.HandshakeTLS(sock, handshake_timeout); err != nil {
+				sock.Close()
+				return nil, "", fmt.Errorf("TLS handshake with server failed: %v", err)
+			}
+		}
+		//a pre-handshake server's first frame is shorter than our frame
+		//header says, so without a timeout the read would block forever
+		recordlib.SetReadTimeout(sock, handshake_timeout)
+		handshake, err := recordlib.ReallyRead(sock)
+		recordlib.SetReadTimeout(sock, 0)
+		if err != nil {
+			sock.Close()
+			return nil, "", fmt.Errorf("no valid handshake from server (%v), it may speak a protocol older than %d.%d", err, recordlib.ProtocolMajor, recordlib.ProtocolMinor)
+		}
+		if handshake == "SERVER_BUSY" {
+			sock.Close()
+			if attempt == 4 {
+				return nil, "", ErrServerBusy
+			}
+			fmt.Printf("Server busy (connection limit reached), retrying in %v...\n", backoff)
+			time.Sleep(backoff)
+			backoff *= 2
+			continue
+		}
+		if err := recordlib.CheckHandshake(handshake); err != nil {
+			sock.Close()
+			return nil, "", err
+		}
 
-	return *host_flag, *port_flag, nil
+		e_port, err := recordlib.ReallyRead(sock)
+		if err != nil {
+			sock.Close()
+			return nil, "", fmt.Errorf("failed to read ephemeral port from server: %v", err)
+		}
+		return sock, e_port, nil
+	}
 }
 
 /*
@@ -103,33 +322,1600 @@ func server_resp(resp chan string, server_exit chan struct{}) (string, error) {
 }
 
 /*
-Function Name:  repl
-Description:	handles one iteration of the REPL loop
-				parses user input, validates commands/args
+Function Name:  display_name
+Description:	escapes control and other non-printable characters in a
+				stored name so it can be echoed safely to the terminal
+Parameters:		name: name as stored by the server
+Return Value:   printable form of name
+Type:           string -> string
+*/
+func display_name(name string) string {
+	quoted := strconv.QuoteToGraphic(name)
+	return quoted[1 : len(quoted)-1]
+}
+
+/*
+Function Name:  print_trainer_table
+Description:	prints trainers as an aligned table of ID, name and the
+				names of the filled pokemon slots, comma joined
+Parameters:		trainers: records in the order received
+Return Value:   n/a
+Type:           []recordlib.TrainerRec -> n/a
+*/
+func print_trainer_table(trainers []recordlib.TrainerRec) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tName\tPokemon")
+	for _, trainer := range trainers {
+		var pokemon []string
+		for _, poke := range []recordlib.PokeDisplay{trainer.Poke1, trainer.Poke2, trainer.Poke3, trainer.Poke4, trainer.Poke5, trainer.Poke6} {
+			if poke.ID != 0 {
+				pokemon = append(pokemon, display_name(recordlib.CString(poke.Name[:])))
+			}
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", trainer.ID, display_name(recordlib.CString(trainer.Name[:])), strings.Join(pokemon, ", "))
+	}
+	tw.Flush()
+	fmt.Println()
+}
+
+/*
+Function Name:  fetch_poke_names
+Description:	requests the pokemon name list once at startup, before the
+				response reader goroutine starts, and caches it by name
+Parameters:		sock: file stream to communicate with server
+Return Value:   lowercase name -> id map and error (if any)
+Type:           recordlib.Conn -> map[string]uint16, error
+*/
+func fetch_poke_names(sock recordlib.Conn) (map[string]uint16, error) {
+	if err := recordlib.ReallyWrite(sock, "REQ_POKE_NAME_LIST"); err != nil {
+		return nil, err
+	}
+	resp, err := recordlib.ReallyRead(sock)
+	if err != nil {
+		return nil, err
+	}
+	switch resp {
+	case "CLIENT_REQ_INVALID", "SERVER_ERROR", "OUT_OF_BOUNDS":
+		return nil, fmt.Errorf("server replied %s", resp)
+	}
+
+	names := make(map[string]uint16)
+	for _, line := range strings.Split(strings.TrimSpace(resp), "\n") {
+		var poke_name recordlib.PokeName
+		if err := json.Unmarshal([]byte(line), &poke_name); err != nil {
+			return nil, err
+		}
+		names[strings.ToLower(strings.TrimSpace(poke_name.Name))] = poke_name.ID
+	}
+	return names, nil
+}
+
+/*
+Function Name:  complete_poke_name
+Description:	method of client_state
+				lists cached pokemon names starting with prefix (case insensitive)
+Parameters:		prefix: start of a pokemon name
+Return Value:   sorted matching names
+Type:           string -> []string
+*/
+func (cs *client_state) complete_poke_name(prefix string) []string {
+	prefix = strings.ToLower(prefix)
+	var matches []string
+	for name := range cs.poke_names {
+		if strings.HasPrefix(name, prefix) {
+			matches = append(matches, name)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+/*
+Function Name:  resolve_poke_ids
+Description:	method of client_state
+				translates pokemon arguments to IDs, numeric arguments pass
+				through, names resolve by exact match then unique prefix
+Parameters:		args: pokemon arguments typed by user
+Return Value:   arguments as numeric ID strings or error naming the failed name
+Type:           []string -> []string, error
+*/
+func (cs *client_state) resolve_poke_ids(args []string) ([]string, error) {
+	ids := make([]string, 0, len(args))
+	for _, arg := range args {
+		if _, err := strconv.Atoi(arg); err == nil {
+			ids = append(ids, arg)
+			continue
+		}
+		if cs.poke_names == nil {
+			return nil, fmt.Errorf("pokemon name '%s' can't be looked up, use numeric IDs", arg)
+		}
+		if id, ok := cs.poke_names[strings.ToLower(arg)]; ok {
+			ids = append(ids, strconv.Itoa(int(id)))
+			continue
+		}
+		matches := cs.complete_poke_name(arg)
+		switch len(matches) {
+		case 0:
+			return nil, fmt.Errorf("pokemon name '%s' not found", arg)
+		case 1:
+			ids = append(ids, strconv.Itoa(int(cs.poke_names[matches[0]])))
+		default:
+			return nil, fmt.Errorf("pokemon name '%s' is ambiguous: %s", arg, strings.Join(matches, ", "))
+		}
+	}
+	return ids, nil
+}
+
+/*
+Function Name:  new_input_scanner
+Description:	makes a line scanner over input that accepts lines up to max_input_line
+Parameters:		input: buffered stdin
+Return Value:   line scanner
+Type:           *bufio.Reader -> *bufio.Scanner
+*/
+func new_input_scanner(input *bufio.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 0, 64*1024), max_input_line)
+	return scanner
+}
+
+/*
+Function Name:  read_line
+Description:	method of client_state
+				reads one line of user input, a line longer than
+				max_input_line is skipped and the scanner replaced (a
+				scanner stops for good after bufio.ErrTooLong), if the
+				pager was cut off by Ctrl-C its pending read is the line
+Parameters:		n/a
+Return Value:   the line, io.EOF on CTRL-D, ErrInputTooLong or read error
+Type:           n/a -> string, error
+*/
+func (cs *client_state) read_line() (string, error) {
+	if cs.pending != nil { //the pager's read is still using the scanner
+		res := <-cs.pending
+		cs.pending = nil
+		return res.line, res.err
+	}
+	if cs.scanner.Scan() {
+		return cs.scanner.Text(), nil
+	}
+	err := cs.scanner.Err()
+	if err == nil { //CTRL-D
+		return "", io.EOF
+	}
+	if err == bufio.ErrTooLong {
+		//scanner buffer held only the start of the line, rest is still unread
+		if _, err := cs.input.ReadString('\n'); err != nil && err != io.EOF {
+			return "", err
+		}
+		cs.scanner = new_input_scanner(cs.input)
+		return "", ErrInputTooLong
+	}
+	return "", err
+}
+
+/*
+Function Name:  repl
+Description:	handles one iteration of the REPL loop
+				reads and splits user input, then runs the command
+Parameters:		cs: client connection state
+Return Value:   nil if all input and output is good otherwise error
+Type:           *client_state -> error
+*/
+func repl(cs *client_state) error {
+	fmt.Printf("PokeDB> ")
+
+	line, err := cs.read_line()
+	if err != nil {
+		if err == io.EOF {
+			fmt.Println()
+		}
+		return err
+	}
+	cmd := strings.Fields(strings.TrimSpace(line))
+	if len(cmd) == 0 {
+		return nil
+	}
+	if cs.page > 0 && pageable(cmd) {
+		err = cs.run_paged(cmd)
+	} else {
+		err = run_cmd(cs, cmd)
+	}
+	cs.print_timing()
+	cs.print_trace()
+	return err
+}
+
+/*
+Function Name:  pageable
+Description:	whether a command's output goes through the -page pager, only
+				commands that print without asking anything, so a prompt
+				is never held back in the buffer, and not the endless
+				get log follow
+Parameters:		cmd: the command split into fields
+Return Value:   true if the output can be buffered and paged
+Type:           []string -> bool
+*/
+func pageable(cmd []string) bool {
+	switch cmd[0] {
+	case "get":
+		return !(len(cmd) >= 3 && cmd[1] == "log" && cmd[2] == "follow")
+	case "diff", "describe", "help":
+		return true
+	}
+	return false
+}
+
+/*
+Function Name:  run_paged
+Description:	method of client_state
+				runs a command with stdout redirected into a pipe, so the
+				whole result (a stream up to its DONE) is buffered before
+				anything is shown and the server is never kept waiting on
+				the pager, then pages what it printed
+Parameters:		cmd: the command split into fields
+Return Value:   the command's error, or pipe error
+Type:           []string -> error
+*/
+func (cs *client_state) run_paged(cmd []string) error {
+	pipe_r, pipe_w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	captured := make(chan []byte)
+	go func() {
+		out, _ := io.ReadAll(pipe_r)
+		captured <- out
+	}()
+
+	stdout := os.Stdout
+	os.Stdout = pipe_w
+	cmd_err := run_cmd(cs, cmd)
+	os.Stdout = stdout
+	pipe_w.Close()
+	out := <-captured
+	pipe_r.Close()
+
+	cs.page_output(string(out))
+	return cmd_err
+}
+
+/*
+Function Name:  page_output
+Description:	method of client_state
+				prints out cs.page lines at a time, waiting for Enter at
+				"-- more --" (q or Ctrl-C stops and drops the rest), the
+				wait runs in a goroutine so Ctrl-C can end it, a line typed
+				after Ctrl-C goes to the next read_line instead of the pager
+Parameters:		out: buffered command output
+Return Value:   n/a
+Type:           string -> n/a
+*/
+func (cs *client_state) page_output(out string) {
+	lines := strings.SplitAfter(out, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	for start := 0; start < len(lines); start += cs.page {
+		end := min(start+cs.page, len(lines))
+		fmt.Print(strings.Join(lines[start:end], ""))
+		if end == len(lines) {
+			return
+		}
+
+		fmt.Printf("-- more -- (%d of %d lines, Enter to continue, q to quit) ", end, len(lines))
+		answer := make(chan line_result, 1)
+		go func() {
+			line, err := cs.read_line()
+			answer <- line_result{line, err}
+		}()
+		select {
+		case <-interrupt:
+			cs.pending = answer
+			fmt.Printf("\n-- %d lines not shown --\n\n", len(lines)-end)
+			return
+		case res := <-answer:
+			if res.err != nil || strings.TrimSpace(res.line) == "q" {
+				fmt.Printf("-- %d lines not shown --\n\n", len(lines)-end)
+				return
+			}
+		}
+	}
+}
+
+/*
+Function Name:  print_timing
+Description:	method of client_state
+				prints the server timing of the last reply, if one arrived
+Parameters:		n/a
+Return Value:   n/a
+Type:           n/a -> n/a
+*/
+func (cs *client_state) print_timing() {
+	select {
+	case timing := <-cs.timing_chan: //nil channel never ready
+		fmt.Printf("[timing] %s\n\n", timing)
+	default:
+	}
+}
+
+/*
+Function Name:  print_trace
+Description:	method of client_state
+				prints the server connection and request number of the last
+				reply, if one arrived, it matches the [conn=NN req=MM] tag of
+				the server log line for that request
+Parameters:		n/a
+Return Value:   n/a
+Type:           n/a -> n/a
+*/
+func (cs *client_state) print_trace() {
+	select {
+	case trace := <-cs.trace_chan: //nil channel never ready
+		fmt.Printf("[trace] %s\n\n", trace)
+	default:
+	}
+}
+
+/*
+Function Name:  run_repeat
+Description:	runs the same command n times against the server,
+				stops early if the server shuts down, prints a summary
+Parameters:		cs: client connection state
+				n: number of times to run the command
+				cmd: the command to repeat, split into fields
+Return Value:   nil after summary, io.EOF if server shut down mid-repeat
+Type:           *client_state, int, []string -> error
+*/
+func run_repeat(cs *client_state, n int, cmd []string) error {
+	good, bad := 0, 0
+	var last_err error
+	for run := 0; run < n; run++ {
+		err := run_cmd(cs, cmd)
+		if err == io.EOF {
+			fmt.Printf("Repeat stopped after %d of %d runs: server shut down\n", run, n)
+			fmt.Printf("Summary: %d succeeded, %d failed\n\n", good, bad)
+			return err
+		}
+		if err != nil {
+			bad++
+			last_err = err
+		} else {
+			good++
+		}
+	}
+
+	fmt.Printf("Repeated '%s' %d times\n", strings.Join(cmd, " "), n)
+	fmt.Printf("Summary: %d succeeded, %d failed\n", good, bad)
+	if last_err != nil {
+		fmt.Printf("Last error: %v\n", last_err)
+	}
+	fmt.Println()
+	return nil
+}
+
+/*
+Function Name:  confirm
+Description:	method of client_state
+				asks the user a yes/no question on stdin
+Parameters:		prompt: question to print
+Return Value:   true only if the user answered y or yes
+Type:           string -> bool
+*/
+func (cs *client_state) confirm(prompt string) bool {
+	fmt.Printf("%s [y/N] ", prompt)
+	line, err := cs.read_line()
+	if err != nil {
+		fmt.Println()
+		return false
+	}
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes"
+}
+
+/*
+Function Name:  run_delete_where
+Description:	asks for confirmation then bulk deletes every trainer
+				matching predicate, prints the count and deleted IDs
+Parameters:		cs: client connection state
+				predicate: predicate text, validated by the server
+Return Value:   nil on success or error
+Type:           *client_state, string -> error
+*/
+func run_delete_where(cs *client_state, predicate string) error {
+	if cs.suppress("REQ_TRAINER_DELETE_WHERE " + predicate) {
+		return nil
+	}
+	if !cs.confirm(fmt.Sprintf("Delete ALL trainers matching '%s'?", predicate)) {
+		fmt.Printf("Bulk delete cancelled\n\n")
+		return nil
+	}
+	recordlib.ReallyWrite(cs.sock, "REQ_TRAINER_DELETE_WHERE "+predicate)
+
+	bytes, err := server_resp(cs.resp_chan, cs.server_exit)
+	if err != nil {
+		fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
+		return err
+	}
+	status, detail, _ := strings.Cut(bytes, " ")
+	switch {
+	case bytes == "CLIENT_REQ_INVALID":
+		return ErrInvalidReq
+	case bytes == "UNAUTHORIZED":
+		return ErrUnauthorized
+	case bytes == "FILE_ERROR":
+		return ErrServer
+	case strings.HasPrefix(bytes, "BAD_PREDICATE."):
+		return fmt.Errorf("%s", strings.TrimPrefix(bytes, "BAD_PREDICATE."))
+	case status == "DELETED":
+		count, ids, _ := strings.Cut(detail, " ")
+		fmt.Printf("Deleted %s Trainer(s)\n", count)
+		if ids != "" {
+			fmt.Printf("IDs: %s\n", ids)
+		}
+		fmt.Println()
+		return nil
+	default:
+		return fmt.Errorf("delete where: extraneous error")
+	}
+}
+
+/*
+Function Name:  run_index_cmd
+Description:	asks the server to dump its pokemon name index to disk or
+				reload it (admin), prints how many names the index holds
+Parameters:		cs: client connection state
+				action: "dump" or "reload"
+Return Value:   nil on success or error
+Type:           *client_state, string -> error
+*/
+func run_index_cmd(cs *client_state, action string) error {
+	req := "REQ_DUMP_INDEX"
+	if action == "reload" {
+		req = "REQ_RELOAD_INDEX"
+	}
+	recordlib.ReallyWrite(cs.sock, req)
+
+	bytes, err := server_resp(cs.resp_chan, cs.server_exit)
+	if err != nil {
+		fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
+		return err
+	}
+	status, count, _ := strings.Cut(bytes, " ")
+	switch status {
+	case "CLIENT_REQ_INVALID":
+		return ErrInvalidReq
+	case "UNAUTHORIZED":
+		return ErrUnauthorized
+	case "SERVER_ERROR":
+		return ErrServer
+	case "INDEX_DUMPED":
+		fmt.Printf("Name index dumped (%s names)\n\n", count)
+	case "INDEX_LOADED":
+		fmt.Printf("Name index reloaded from dump (%s names)\n\n", count)
+	case "INDEX_REBUILT":
+		fmt.Printf("Name index dump missing or stale, rebuilt from pokemon file (%s names)\n\n", count)
+	default:
+		return fmt.Errorf("%s index: extraneous error", action)
+	}
+	return nil
+}
+
+/*
+Function Name:  run_verify
+Description:	asks the server to scan the pokemon and trainer files and
+				prints the record counts and every issue reported
+Parameters:		cs: client connection state
+Return Value:   nil on success or error
+Type:           *client_state -> error
+*/
+func run_verify(cs *client_state) error {
+	recordlib.ReallyWrite(cs.sock, "REQ_FSCK")
+
+	bytes, err := server_resp(cs.resp_chan, cs.server_exit)
+	if err != nil {
+		fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
+		return err
+	}
+	switch bytes {
+	case "CLIENT_REQ_INVALID":
+		return ErrInvalidReq
+	case "SERVER_ERROR":
+		return ErrServer
+	}
+	var report recordlib.VerifyReport
+	if err := json.Unmarshal([]byte(bytes), &report); err != nil {
+		return err
+	}
+
+	fmt.Printf("Pokemon records: %d\n", report.PokemonRecords)
+	fmt.Printf("Trainer records: %d (%d deleted)\n", report.TrainerRecords, report.Deleted)
+	for _, issue := range report.Issues {
+		if issue.Record == 0 {
+			fmt.Printf("  ! %s file: %s\n", issue.File, issue.Problem)
+		} else {
+			fmt.Printf("  ! %s record %d: %s\n", issue.File, issue.Record, issue.Problem)
+		}
+	}
+	if report.TotalIssues > len(report.Issues) {
+		fmt.Printf("  ! ... %d more issues not shown\n", report.TotalIssues-len(report.Issues))
+	}
+	if report.TotalIssues == 0 {
+		fmt.Printf("No problems found\n\n")
+	} else {
+		fmt.Printf("%d problems found\n\n", report.TotalIssues)
+	}
+	return nil
+}
+
+/*
+Function Name:  run_verify_refs
+Description:	asks the server for trainer slots referencing a pokemon that
+				isn't in the pokemon file and prints them, with repair
+				(admin) the server drops those slots and the removed ones
+				are printed
+Parameters:		cs: client connection state
+				repair: drop the dangling slots
+Return Value:   nil on success or error
+Type:           *client_state, bool -> error
+*/
+func run_verify_refs(cs *client_state, repair bool) error {
+	req := "REQ_VALIDATE_REFS"
+	if repair {
+		req += " REPAIR"
+		if cs.suppress(req) {
+			return nil
+		}
+	}
+	recordlib.ReallyWrite(cs.sock, req)
+
+	bytes, err := server_resp(cs.resp_chan, cs.server_exit)
+	if err != nil {
+		fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
+		return err
+	}
+	switch bytes {
+	case "CLIENT_REQ_INVALID":
+		return ErrInvalidReq
+	case "UNAUTHORIZED":
+		return ErrUnauthorized
+	case "SERVER_ERROR", "FILE_ERROR":
+		return ErrServer
+	}
+	var refs []recordlib.DanglingRef
+	if err := json.Unmarshal([]byte(bytes), &refs); err != nil {
+		return err
+	}
+
+	for _, ref := range refs {
+		fmt.Printf("  ! trainer %d slot %d: pokemon %d not in the pokemon file\n", ref.TrainerID, ref.Slot, ref.PokeID)
+	}
+	switch {
+	case len(refs) == 0:
+		fmt.Printf("No dangling references\n\n")
+	case repair:
+		fmt.Printf("Removed %d dangling references\n\n", len(refs))
+	default:
+		fmt.Printf("%d dangling references, 'verify refs repair' removes them\n\n", len(refs))
+	}
+	return nil
+}
+
+/*
+Function Name:  run_status
+Description:	fetches and prints the server start time, uptime and
+				connection counts
+Parameters:		cs: client connection state
+Return Value:   nil on success or error
+Type:           *client_state -> error
+*/
+func run_status(cs *client_state) error {
+	recordlib.ReallyWrite(cs.sock, "REQ_STATUS")
+
+	bytes, err := server_resp(cs.resp_chan, cs.server_exit)
+	if err != nil {
+		fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
+		return err
+	}
+	switch bytes {
+	case "CLIENT_REQ_INVALID":
+		return ErrInvalidReq
+	case "SERVER_ERROR":
+		return ErrServer
+	}
+	var status recordlib.ServerStatus
+	if err := json.Unmarshal([]byte(bytes), &status); err != nil {
+		return err
+	}
+
+	fmt.Printf("Server started: %s\n", status.StartTime)
+	fmt.Printf("Uptime: %s\n", time.Duration(status.UptimeSec)*time.Second)
+	fmt.Printf("Connections served: %d\n", status.Connections)
+	fmt.Printf("Connections active: %d\n\n", status.Active)
+	return nil
+}
+
+/*
+Function Name:  run_log_query
+Description:	fetches and prints the server log entries selected by a log
+				since or log filter request
+Parameters:		cs: client connection state
+				req: REQ_LOG_SINCE or REQ_LOG_FILTER request
+Return Value:   nil on success or error
+Type:           *client_state, string -> error
+*/
+func run_log_query(cs *client_state, req string) error {
+	recordlib.ReallyWrite(cs.sock, req)
+	bytes, err := server_resp(cs.resp_chan, cs.server_exit)
+	if err != nil {
+		fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
+		return err
+	}
+
+	switch bytes {
+	case "CLIENT_REQ_INVALID":
+		return ErrInvalidReq
+	case "SERVER_ERROR":
+		return ErrServer
+	case "BAD_TIME":
+		return ErrGetLogSinceArgs
+	case "BAD_FILTER":
+		return fmt.Errorf("log filter port must be 1-65535")
+	case "BAD_COUNT":
+		return recordlib.ErrLogNTooLarge
+	default:
+		fmt.Printf("\nRequested Log Entries\n")
+		fmt.Println(bytes)
+		fmt.Printf("End of Log\n\n")
+		return nil
+	}
+}
+
+/*
+Function Name:  run_log_follow
+Description:	follows the server log, printing lines as the server appends
+				them until Ctrl-C, which sends STOP and waits for the DONE
+				that closes the stream, Ctrl-C only stops the follow while
+				it runs, outside it the default (exit) applies
+Parameters:		cs: client connection state
+Return Value:   nil on success or error
+Type:           *client_state -> error
+*/
+func run_log_follow(cs *client_state) error {
+	recordlib.ReallyWrite(cs.sock, "REQ_LOG_FOLLOW")
+	ready, err := server_resp(cs.resp_chan, cs.server_exit)
+	if err != nil {
+		fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
+		return err
+	}
+	switch ready {
+	case "CLIENT_REQ_INVALID":
+		return ErrInvalidReq
+	case "SERVER_ERROR":
+		return ErrServer
+	case "SENDING":
+		break
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	fmt.Printf("\nFollowing server log, Ctrl-C to stop\n")
+	for {
+		select {
+		case <-interrupt:
+			fmt.Printf("\r")
+			recordlib.ReallyWrite(cs.sock, "STOP")
+		case bytes := <-cs.resp_chan:
+			if bytes == "DONE" {
+				fmt.Printf("End of Log\n\n")
+				return nil
+			}
+			fmt.Println(bytes)
+		case <-cs.server_exit:
+			fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
+			return io.EOF
+		}
+	}
+}
+
+/*
+Function Name:  run_whoami
+Description:	fetches and prints what the server knows about this
+				connection, quote the connection ID when reporting a problem
+Parameters:		cs: client connection state
+Return Value:   nil on success or error
+Type:           *client_state -> error
+*/
+func run_whoami(cs *client_state) error {
+	recordlib.ReallyWrite(cs.sock, "REQ_WHOAMI")
+
+	bytes, err := server_resp(cs.resp_chan, cs.server_exit)
+	if err != nil {
+		fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
+		return err
+	}
+	switch bytes {
+	case "CLIENT_REQ_INVALID":
+		return ErrInvalidReq
+	case "SERVER_ERROR":
+		return ErrServer
+	}
+	var info recordlib.WhoAmI
+	if err := json.Unmarshal([]byte(bytes), &info); err != nil {
+		return err
+	}
+
+	fmt.Printf("Connection ID: %d\n", info.ConnID)
+	fmt.Printf("Source port: %d\n", info.SrcPort)
+	fmt.Printf("Connected for: %s\n", time.Duration(info.ConnectedSec)*time.Second)
+	fmt.Printf("Server up: %s\n", time.Duration(info.UptimeSec)*time.Second)
+	fmt.Printf("Protocol: %s\n\n", info.Protocol)
+	return nil
+}
+
+/*
+Function Name:  print_schema_fields
+Description:	writes one row per field, a nested record's fields follow it
+				indented under its name
+Parameters:		tw: table writer
+				fields: fields to write
+				indent: prefix for field names
+Return Value:   n/a
+Type:           *tabwriter.Writer, []recordlib.SchemaField, string -> n/a
+*/
+func print_schema_fields(tw *tabwriter.Writer, fields []recordlib.SchemaField, indent string) {
+	for _, field := range fields {
+		fmt.Fprintf(tw, "%d\t%d\t%s%s\t%s\t%s\n", field.Offset, field.Size, indent, field.Name, field.Type, field.Constraint)
+		print_schema_fields(tw, field.Fields, indent+"  ")
+	}
+}
+
+/*
+Function Name:  run_describe
+Description:	fetches the on-disk format of pokemon or trainer records and
+				prints each field's offset, size, type and constraints
+Parameters:		cs: client connection state
+				record: "pokemon" or "trainer"
+Return Value:   nil on success or error
+Type:           *client_state, string -> error
+*/
+func run_describe(cs *client_state, record string) error {
+	recordlib.ReallyWrite(cs.sock, "REQ_SCHEMA "+record)
+
+	bytes, err := server_resp(cs.resp_chan, cs.server_exit)
+	if err != nil {
+		fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
+		return err
+	}
+	switch bytes {
+	case "CLIENT_REQ_INVALID":
+		return ErrInvalidReq
+	case "SERVER_ERROR":
+		return ErrServer
+	}
+	var schema recordlib.RecordSchema
+	if err := json.Unmarshal([]byte(bytes), &schema); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: %d bytes per record, %s", schema.Record, schema.Size, schema.ByteOrder)
+	if schema.Header > 0 {
+		fmt.Printf(", after a %d byte file header", schema.Header)
+	}
+	fmt.Println()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Offset\tSize\tField\tType\tConstraint")
+	print_schema_fields(tw, schema.Fields, "")
+	tw.Flush()
+	fmt.Println()
+	return nil
+}
+
+/*
+Function Name:  run_metrics
+Description:	fetches the server request counters and prints them as a
+				table with each type's share of all requests
+Parameters:		cs: client connection state
+Return Value:   nil on success or error
+Type:           *client_state -> error
+*/
+func run_metrics(cs *client_state) error {
+	recordlib.ReallyWrite(cs.sock, "REQ_METRICS")
+
+	bytes, err := server_resp(cs.resp_chan, cs.server_exit)
+	if err != nil {
+		fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
+		return err
+	}
+	switch bytes {
+	case "CLIENT_REQ_INVALID":
+		return ErrInvalidReq
+	case "SERVER_ERROR":
+		return ErrServer
+	}
+	var metrics recordlib.Metrics
+	if err := json.Unmarshal([]byte(bytes), &metrics); err != nil {
+		return err
+	}
+
+	fmt.Printf("Server up %s\n", time.Duration(metrics.UptimeSec)*time.Second)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "Type\tCount\tShare\t")
+	for _, row := range []struct {
+		name  string
+		count uint64
+	}{
+		{"gets", metrics.Gets},
+		{"posts", metrics.Posts},
+		{"puts", metrics.Puts},
+		{"deletes", metrics.Deletes},
+		{"errors", metrics.Errors},
+		{"total", metrics.Requests},
+	} {
+		share := 0.0
+		if metrics.Requests > 0 {
+			share = 100 * float64(row.count) / float64(metrics.Requests)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%.1f%%\t\n", row.name, row.count, share)
+	}
+	tw.Flush()
+	fmt.Println()
+	return nil
+}
+
+/*
+Function Name:  run_export
+Description:	streams every trainer record from the server as CSV and
+				writes the lines to a local file, the file is written under a
+				temporary name and only renamed into place once DONE arrives
+				with the count of records received
+Parameters:		cs: client connection state
+				path: local file to write
+Return Value:   nil on success or error
+Type:           *client_state, string -> error
+*/
+func run_export(cs *client_state, path string) error {
+	tmp_path := path + ".tmp"
+	out, err := os.Create(tmp_path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp_path) //no-op once renamed
+	defer out.Close()
+
+	recordlib.ReallyWrite(cs.sock, "REQ_EXPORT_TRAINERS")
+
+	lines := 0
+	var write_err error
+	for {
+		bytes, err := server_resp(cs.resp_chan, cs.server_exit)
+		if err != nil {
+			fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
+			return err
+		}
+		if count, done := stream_done(bytes); done {
+			if write_err != nil {
+				return write_err
+			}
+			if lines-1 != count { //first line is the header
+				return fmt.Errorf("%w: received %d of %d, nothing written", ErrStreamIncomplete, max(lines-1, 0), count)
+			}
+			if err := out.Close(); err != nil {
+				return err
+			}
+			if err := os.Rename(tmp_path, path); err != nil {
+				return err
+			}
+			fmt.Printf("Exported %d trainers to %s\n\n", count, path)
+			return nil
+		}
+		switch bytes {
+		case "CLIENT_REQ_INVALID":
+			return ErrInvalidReq
+		case "SERVER_ERROR":
+			return ErrServer
+		case "OUT_OF_BOUNDS":
+			return ErrTrainerFileEmpty
+		case "FILE_ERROR":
+			return fmt.Errorf("trainers file corrupted")
+		case "TIMEOUT":
+			return ErrTimeout
+		case "SENDING":
+			continue
+		default:
+			//keep draining the stream after a write error so the next reply lines up
+			if write_err == nil {
+				_, write_err = fmt.Fprintln(out, bytes)
+			}
+			lines++
+		}
+	}
+}
+
+/*
+Function Name:  run_import
+Description:	reads trainers from a CSV file in the export format (the
+				id and pokemon name columns are ignored, new IDs are assigned)
+				and posts them all in one BULK_POST_TRAINER request, the
+				server creates every trainer or none of them
+Parameters:		cs: client connection state
+				path: local CSV file to read
+Return Value:   nil on success or error
+Type:           *client_state, string -> error
+*/
+func run_import(cs *client_state, path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	reader := csv.NewReader(in)
+	reader.FieldsPerRecord = -1 //trailing empty slots may be left off
+	var rows []string
+	var lines []int //file line of each row, for error reports
+	for first := true; ; first = false {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		line, _ := reader.FieldPos(0)
+		if first && fields[0] == "id" {
+			continue //header line written by export
+		}
+		if len(fields) < 2 || fields[1] == "" || strings.ContainsAny(fields[1], " \t") {
+			return fmt.Errorf("import: line %d: trainer name missing or contains spaces", line)
+		}
+		row := fields[1]
+		for col := 2; col < len(fields); col += 2 {
+			if fields[col] == "" {
+				continue //empty slot
+			}
+			if _, err := strconv.ParseUint(fields[col], 10, 16); err != nil {
+				return fmt.Errorf("import: line %d: bad pokemon ID %q", line, fields[col])
+			}
+			row += " " + fields[col]
+		}
+		if len(strings.Fields(row)) > 7 {
+			return fmt.Errorf("import: line %d: more than 6 pokemon", line)
+		}
+		rows = append(rows, row)
+		lines = append(lines, line)
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("import: no trainers in %s", path)
+	}
+
+	req := "BULK_POST_TRAINER\n" + strings.Join(rows, "\n")
+	if cs.suppress(req) {
+		return nil
+	}
+	recordlib.ReallyWrite(cs.sock, req)
+
+	bytes, err := server_resp(cs.resp_chan, cs.server_exit)
+	if err != nil {
+		fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
+		return err
+	}
+	fields := strings.Fields(bytes)
+	switch {
+	case bytes == "CLIENT_REQ_INVALID":
+		return ErrInvalidReq
+	case bytes == "UNAUTHORIZED":
+		return ErrUnauthorized
+	case bytes == "SERVER_ERROR":
+		return ErrServer
+	case len(fields) > 0 && fields[0] == "POSTED":
+		fmt.Printf("Imported %d trainers, IDs: %s\n\n", len(fields)-1, strings.Join(fields[1:], " "))
+		return nil
+	case len(fields) == 3 && fields[0] == "BAD_BULK":
+		row, err := strconv.Atoi(fields[1])
+		if err != nil || row < 1 || row > len(lines) {
+			return fmt.Errorf("import: extraneous error")
+		}
+		reason := "file error"
+		switch fields[2] {
+		case "LONG_NAME":
+			reason = "name too long"
+		case "BAD_NAME":
+			reason = "name not printable ASCII"
+		case "BAD_POST":
+			reason = "pokemon not found"
+		case "BAD_POKE_ID":
+			reason = "pokemon id out of range"
+		}
+		return fmt.Errorf("import: line %d rejected (%s), no trainers were imported", lines[row-1], reason)
+	default:
+		return fmt.Errorf("import: extraneous error")
+	}
+}
+
+/*
+Function Name:  run_poke_raw
+Description:	fetches the on-disk bytes of a pokemon record and prints an
+				offset/hex/ASCII dump, for checking the record layout
+Parameters:		cs: client connection state
+				id: pokemon ID
+Return Value:   nil on success or error
+Type:           *client_state, int -> error
+*/
+func run_poke_raw(cs *client_state, id int) error {
+	recordlib.ReallyWrite(cs.sock, fmt.Sprintf("REQ_POKE_RAW %d", id))
+
+	bytes, err := server_resp(cs.resp_chan, cs.server_exit)
+	if err != nil {
+		fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
+		return err
+	}
+	switch bytes {
+	case "CLIENT_REQ_INVALID":
+		return ErrInvalidReq
+	case "SERVER_ERROR":
+		return ErrServer
+	case "OUT_OF_BOUNDS":
+		return ErrPokeNotFound
+	}
+	raw, err := hex.DecodeString(bytes)
+	if err != nil {
+		return fmt.Errorf("raw record: %v", err)
+	}
+	fmt.Printf("Pokemon %d, %d bytes at file offset %d\n", id, len(raw), (id-1)*recordlib.PokeRecSize)
+	fmt.Println(hex.Dump(raw))
+	return nil
+}
+
+/*
+Function Name:  run_poke_multi
+Description:	fetches several pokemon records in one request and prints
+				each in the order asked, naming any ID that wasn't found
+Parameters:		cs: client connection state
+				ids: pokemon IDs
+Return Value:   nil on success or error
+Type:           *client_state, []int -> error
+*/
+func run_poke_multi(cs *client_state, ids []int) error {
+	list := make([]string, len(ids))
+	for idx, id := range ids {
+		list[idx] = strconv.Itoa(id)
+	}
+	recordlib.ReallyWrite(cs.sock, "REQ_POKE_MULTI "+strings.Join(list, ","))
+
+	bytes, err := server_resp(cs.resp_chan, cs.server_exit)
+	if err != nil {
+		fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
+		return err
+	}
+	switch bytes {
+	case "CLIENT_REQ_INVALID":
+		return ErrInvalidReq
+	case "BAD_COUNT":
+		return ErrGetPokeIDsArgs
+	case "SERVER_ERROR":
+		return ErrServer
+	}
+	var recs []*recordlib.PokeRec
+	if err := json.Unmarshal([]byte(bytes), &recs); err != nil {
+		return fmt.Errorf("pokemon records: %v", err)
+	}
+	if len(recs) != len(ids) {
+		return fmt.Errorf("asked for %d pokemon, server sent %d", len(ids), len(recs))
+	}
+	for idx, rec := range recs {
+		if rec == nil {
+			fmt.Printf("Pokemon %d: %v\n", ids[idx], ErrPokeNotFound)
+			continue
+		}
+		rec.Print()
+	}
+	return nil
+}
+
+/*
+Function Name:  run_poke_list
+Description:	sends a pokemon query and prints each streamed record
+Parameters:		cs: client connection state
+				req: pokemon query request (REQ_POKE_TOP, REQ_POKE_FILTER or REQ_POKE_SIMILAR)
+Return Value:   nil on success or error
+Type:           *client_state, string -> error
+*/
+func run_poke_list(cs *client_state, req string) error {
+	recordlib.ReallyWrite(cs.sock, req)
+
+	for {
+		bytes, err := server_resp(cs.resp_chan, cs.server_exit)
+		if err != nil {
+			fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
+			return err
+		}
+		switch bytes {
+		case "CLIENT_REQ_INVALID", "BAD_COUNT":
+			return ErrInvalidReq
+		case "SERVER_ERROR":
+			return ErrServer
+		case "FILE_ERROR":
+			return fmt.Errorf("pokemon file corrupted")
+		case "OUT_OF_BOUNDS":
+			return ErrNoPokeMatch
+		case "BAD_POKE_ID": //REQ_POKE_SIMILAR reference
+			return ErrPokeNotFound
+		case "SENDING":
+			continue
+		case "DONE":
+			return nil
+		default:
+			var pokemon recordlib.PokeRec
+			if err := cs.decode_record(bytes, &pokemon); err != nil {
+				return err
+			}
+			pokemon.Print()
+		}
+	}
+}
+
+/*
+Function Name:  run_poke_types
+Description:	fetches the distinct pokemon types in use and prints them
+				one per line, sorted
+Parameters:		cs: client connection state
+Return Value:   nil on success or error
+Type:           *client_state -> error
+*/
+func run_poke_types(cs *client_state) error {
+	recordlib.ReallyWrite(cs.sock, "REQ_POKE_TYPES")
+
+	bytes, err := server_resp(cs.resp_chan, cs.server_exit)
+	if err != nil {
+		fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
+		return err
+	}
+	switch bytes {
+	case "CLIENT_REQ_INVALID":
+		return ErrInvalidReq
+	case "SERVER_ERROR":
+		return ErrServer
+	case "FILE_ERROR":
+		return fmt.Errorf("pokemon file corrupted")
+	case "OUT_OF_BOUNDS":
+		return fmt.Errorf("pokemon file is empty")
+	}
+	var types []string
+	if err := json.Unmarshal([]byte(bytes), &types); err != nil {
+		return err
+	}
+	for _, poke_type := range types {
+		fmt.Println(poke_type)
+	}
+	fmt.Printf("\n%d types\n\n", len(types))
+	return nil
+}
+
+/*
+Function Name:  run_poke_agg
+Description:	fetches the aggregate pokemon statistics and prints the totals,
+				the height and weight range, a table per generation (count,
+				average stat total) and the types by count, most common first
+Parameters:		cs: client connection state
+Return Value:   nil on success or error
+Type:           *client_state -> error
+*/
+func run_poke_agg(cs *client_state) error {
+	recordlib.ReallyWrite(cs.sock, "REQ_POKE_AGG")
+
+	bytes, err := server_resp(cs.resp_chan, cs.server_exit)
+	if err != nil {
+		fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
+		return err
+	}
+	switch bytes {
+	case "CLIENT_REQ_INVALID":
+		return ErrInvalidReq
+	case "SERVER_ERROR":
+		return ErrServer
+	case "FILE_ERROR":
+		return fmt.Errorf("pokemon file corrupted")
+	case "OUT_OF_BOUNDS":
+		return fmt.Errorf("pokemon file is empty")
+	}
+	var agg recordlib.PokeAggregate
+	if err := json.Unmarshal([]byte(bytes), &agg); err != nil {
+		return err
+	}
+
+	fmt.Printf("Pokemon: %d (%d legendary)\n", agg.Count, agg.Legendaries)
+	fmt.Printf("Height (m): %.2f - %.2f | Weight (kg): %.1f - %.1f\n\n",
+		float32(agg.MinHeightM)/100.0, float32(agg.MaxHeightM)/100.0,
+		float32(agg.MinWeightKg)/10.0, float32(agg.MaxWeightKg)/10.0)
+
+	gens := make([]uint8, 0, len(agg.CountByGen))
+	for gen := range agg.CountByGen {
+		gens = append(gens, gen)
+	}
+	sort.Slice(gens, func(i, j int) bool { return gens[i] < gens[j] })
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Generation\tCount\tAvg total")
+	for _, gen := range gens {
+		fmt.Fprintf(tw, "%d\t%d\t%.1f\n", gen, agg.CountByGen[gen], agg.AvgTotalGen[gen])
+	}
+	tw.Flush()
+	fmt.Println()
+
+	types := make([]string, 0, len(agg.ByType))
+	for poke_type := range agg.ByType {
+		types = append(types, poke_type)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		if agg.ByType[types[i]] != agg.ByType[types[j]] {
+			return agg.ByType[types[i]] > agg.ByType[types[j]]
+		}
+		return types[i] < types[j]
+	})
+	tw = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Type\tCount")
+	for _, poke_type := range types {
+		fmt.Fprintf(tw, "%s\t%d\n", poke_type, agg.ByType[poke_type])
+	}
+	tw.Flush()
+	fmt.Println("(dual type pokemon count under both types)")
+	fmt.Println()
+	return nil
+}
+
+/*
+Function Name:  run_trainers_using
+Description:	asks which trainers hold a pokemon and prints the count and
+				their IDs, 15 per line
+Parameters:		cs: client connection state
+				poke_id: numeric pokemon ID
+Return Value:   nil on success or error
+Type:           *client_state, string -> error
+*/
+func run_trainers_using(cs *client_state, poke_id string) error {
+	recordlib.ReallyWrite(cs.sock, "REQ_TRAINER_WITH_POKE "+poke_id)
+
+	bytes, err := server_resp(cs.resp_chan, cs.server_exit)
+	if err != nil {
+		fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
+		return err
+	}
+	switch bytes {
+	case "CLIENT_REQ_INVALID":
+		return ErrInvalidReq
+	case "SERVER_ERROR":
+		return ErrServer
+	case "FILE_ERROR":
+		return fmt.Errorf("trainers file corrupted")
+	case "BAD_POKE_ID":
+		return ErrPokeNotFound
+	}
+	var using recordlib.TrainersUsing
+	if err := json.Unmarshal([]byte(bytes), &using); err != nil {
+		return err
+	}
+
+	fmt.Printf("Pokemon %d is on %d trainer(s)\n", using.PokeID, using.Count)
+	for start := 0; start < len(using.Trainers); start += 15 {
+		row := make([]string, 0, 15)
+		for _, id := range using.Trainers[start:min(start+15, len(using.Trainers))] {
+			row = append(row, strconv.Itoa(int(id)))
+		}
+		fmt.Printf("  %s\n", strings.Join(row, " "))
+	}
+	fmt.Println()
+	return nil
+}
+
+/*
+Function Name:  run_trainer_expand
+Description:	fetches a trainer with the full record of each of its
+				pokemon, prints the trainer then each pokemon, noting a
+				slot whose pokemon is no longer in the pokemon file
+Parameters:		cs: client connection state
+				id: trainer ID
+Return Value:   nil on success or error
+Type:           *client_state, int -> error
+*/
+func run_trainer_expand(cs *client_state, id int) error {
+	recordlib.ReallyWrite(cs.sock, fmt.Sprintf("REQ_TRAINER_EXPAND %d", id))
+
+	bytes, err := server_resp(cs.resp_chan, cs.server_exit)
+	if err != nil {
+		fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
+		return err
+	}
+	switch bytes {
+	case "CLIENT_REQ_INVALID":
+		return ErrInvalidReq
+	case "SERVER_ERROR":
+		return ErrServer
+	case "OUT_OF_BOUNDS":
+		return ErrTrainerNotFound
+	}
+	var expanded recordlib.TrainerExpanded
+	if err := json.Unmarshal([]byte(bytes), &expanded); err != nil {
+		return fmt.Errorf("trainer record: %v", err)
+	}
+	trainer := expanded.Trainer
+	trainer.Print()
+	for slot, poke := range []recordlib.PokeDisplay{trainer.Poke1, trainer.Poke2, trainer.Poke3, trainer.Poke4, trainer.Poke5, trainer.Poke6} {
+		if poke.ID == 0 {
+			continue
+		}
+		if expanded.Pokemon[slot] == nil {
+			fmt.Printf("Slot %d: pokemon %d is no longer in the pokemon file\n", slot+1, poke.ID)
+			continue
+		}
+		fmt.Printf("Slot %d:\n", slot+1)
+		expanded.Pokemon[slot].Print()
+	}
+	return nil
+}
+
+/*
+Function Name:  fetch_trainer
+Description:	gets one trainer record with REQ_TRAINER_ID
+Parameters:		cs: client connection state
+				id: trainer ID
+Return Value:   the trainer and nil, or error (which ID, if it isn't found)
+Type:           *client_state, int -> recordlib.TrainerRec, error
+*/
+func fetch_trainer(cs *client_state, id int) (recordlib.TrainerRec, error) {
+	var trainer recordlib.TrainerRec
+	recordlib.ReallyWrite(cs.sock, fmt.Sprintf("REQ_TRAINER_ID %d", id))
+
+	bytes, err := server_resp(cs.resp_chan, cs.server_exit)
+	if err != nil {
+		fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
+		return trainer, err
+	}
+	switch bytes {
+	case "CLIENT_REQ_INVALID":
+		return trainer, ErrInvalidReq
+	case "SERVER_ERROR":
+		return trainer, ErrServer
+	case "OUT_OF_BOUNDS":
+		return trainer, fmt.Errorf("%w: %d", ErrTrainerNotFound, id)
+	}
+	if err := cs.decode_record(bytes, &trainer); err != nil {
+		return trainer, err
+	}
+	return trainer, nil
+}
+
+/*
+Function Name:  run_trainer_diff
+Description:	fetches two trainers and prints which pokemon only the first
+				has, which both have and which only the second has, by
+				pokemon ID in slot order, a pokemon in two slots counts once
+Parameters:		cs: client connection state
+				id_a: first trainer ID
+				id_b: second trainer ID
+Return Value:   nil on success or error
+Type:           *client_state, int, int -> error
+*/
+func run_trainer_diff(cs *client_state, id_a int, id_b int) error {
+	trainer_a, err := fetch_trainer(cs, id_a)
+	if err != nil {
+		return err
+	}
+	trainer_b, err := fetch_trainer(cs, id_b)
+	if err != nil {
+		return err
+	}
+
+	slots := func(t recordlib.TrainerRec) []recordlib.PokeDisplay {
+		return []recordlib.PokeDisplay{t.Poke1, t.Poke2, t.Poke3, t.Poke4, t.Poke5, t.Poke6}
+	}
+	in_b := map[uint16]bool{}
+	for _, poke := range slots(trainer_b) {
+		in_b[poke.ID] = true
+	}
+	in_a := map[uint16]bool{}
+	var only_a, shared, only_b []string
+	for _, poke := range slots(trainer_a) {
+		if poke.ID == 0 || in_a[poke.ID] {
+			continue
+		}
+		in_a[poke.ID] = true
+		cell := fmt.Sprintf("%d %s", poke.ID, display_name(recordlib.CString(poke.Name[:])))
+		if in_b[poke.ID] {
+			shared = append(shared, cell)
+		} else {
+			only_a = append(only_a, cell)
+		}
+	}
+	for _, poke := range slots(trainer_b) {
+		if poke.ID == 0 || in_a[poke.ID] {
+			continue
+		}
+		in_a[poke.ID] = true //only once if B has it twice
+		only_b = append(only_b, fmt.Sprintf("%d %s", poke.ID, display_name(recordlib.CString(poke.Name[:]))))
+	}
+
+	rows := max(len(only_a), len(shared), len(only_b))
+	cell := func(col []string, row int) string {
+		if row < len(col) {
+			return col[row]
+		}
+		return ""
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Only %d %s\tShared\tOnly %d %s\n", id_a, display_name(recordlib.CString(trainer_a.Name[:])),
+		id_b, display_name(recordlib.CString(trainer_b.Name[:])))
+	for row := 0; row < rows; row++ {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", cell(only_a, row), cell(shared, row), cell(only_b, row))
+	}
+	tw.Flush()
+	fmt.Println()
+	return nil
+}
+
+/*
+Function Name:  run_random
+Description:	asks for a random pokemon or a random live trainer and prints it
+Parameters:		cs: client connection state
+				record: "pokemon" or "trainer"
+Return Value:   nil on success or error
+Type:           *client_state, string -> error
+*/
+func run_random(cs *client_state, record string) error {
+	req, not_found := "REQ_RANDOM_POKE", fmt.Errorf("pokemon file is empty")
+	if record == "trainer" {
+		req, not_found = "REQ_RANDOM_TRAINER", ErrTrainerFileEmpty
+	}
+	recordlib.ReallyWrite(cs.sock, req)
+
+	bytes, err := server_resp(cs.resp_chan, cs.server_exit)
+	if err != nil {
+		fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
+		return err
+	}
+	switch bytes {
+	case "CLIENT_REQ_INVALID":
+		return ErrInvalidReq
+	case "SERVER_ERROR":
+		return ErrServer
+	case "OUT_OF_BOUNDS":
+		return not_found
+	case "FILE_ERROR":
+		return fmt.Errorf("trainers file corrupted")
+	}
+	if record == "trainer" {
+		var trainer recordlib.TrainerRec
+		if err := cs.decode_record(bytes, &trainer); err != nil {
+			return err
+		}
+		trainer.Print()
+		return nil
+	}
+	var pokemon recordlib.PokeRec
+	if err := cs.decode_record(bytes, &pokemon); err != nil {
+		return err
+	}
+	pokemon.Print()
+	return nil
+}
+
+/*
+Function Name:  stream_done
+Description:	parses the "DONE <count>" that ends a trainer stream
+Parameters:		msg: message received in the stream
+Return Value:   record count the server sent and whether msg was the DONE
+Type:           string -> int, bool
+*/
+func stream_done(msg string) (int, bool) {
+	count_str, found := strings.CutPrefix(msg, "DONE ")
+	if !found {
+		return 0, false
+	}
+	count, err := strconv.Atoi(count_str)
+	return count, err == nil && count >= 0
+}
+
+/*
+Function Name:  run_trainer_list
+Description:	sends a trainer listing request and prints the streamed
+				records (verbose blocks or one table with --format=table),
+				checks the count in DONE against the records received and
+				warns when an error status cuts the stream short
+Parameters:		cs: client connection state
+				req: REQ_TRAINER_ALL or REQ_TRAINER_ALL_SORTED request
+Return Value:   nil on success or error (ErrStreamIncomplete (wrapped) on a
+				count mismatch)
+Type:           *client_state, string -> error
+*/
+func run_trainer_list(cs *client_state, req string) error {
+	recordlib.ReallyWrite(cs.sock, req)
+
+	ready, err := server_resp(cs.resp_chan, cs.server_exit)
+	if err != nil {
+		fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
+		return err
+	}
+	switch ready {
+	case "CLIENT_REQ_INVALID":
+		return ErrInvalidReq
+	case "SERVER_ERROR":
+		return ErrServer
+	case "OUT_OF_BOUNDS":
+		return ErrTrainerFileEmpty
+	case "FILE_ERROR":
+		return fmt.Errorf("trainers file corrupted")
+	case "TOO_MANY":
+		return ErrTooManyToSort
+	case "TIMEOUT":
+		return ErrTimeout
+	case "SENDING":
+		break
+	}
+
+	var table []recordlib.TrainerRec
+	received := 0
+	//records already printed above an error status are only part of the listing
+	cut_short := func(err error) error {
+		if received > 0 && !cs.table {
+			fmt.Printf("Warning: stream incomplete, only the %d trainer(s) above were received\n", received)
+		}
+		return err
+	}
+	for {
+		bytes, err := server_resp(cs.resp_chan, cs.server_exit)
+		if err != nil {
+			fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
+			return cut_short(err)
+		}
+		if count, done := stream_done(bytes); done {
+			if cs.table {
+				print_trainer_table(table)
+			}
+			if count != received {
+				return fmt.Errorf("%w: received %d of %d", ErrStreamIncomplete, received, count)
+			}
+			return nil
+		}
+		switch bytes {
+		case "SERVER_ERROR":
+			return cut_short(ErrServer)
+		case "FILE_ERROR":
+			return cut_short(fmt.Errorf("trainers file corrupted"))
+		case "OUT_OF_BOUNDS":
+			return cut_short(ErrTrainerFileEmpty)
+		case "TIMEOUT":
+			return cut_short(ErrTimeout)
+		default:
+			var trainer recordlib.TrainerRec
+			if err := cs.decode_record(bytes, &trainer); err != nil {
+				return cut_short(err)
+			}
+			received++
+			if cs.table {
+				table = append(table, trainer) //columns sized once all rows are in
+			} else {
+				trainer.Print()
+			}
+		}
+	}
+}
+
+/*
+Function Name:  run_cmd
+Description:	validates a split command and its args
 				sends formatted requests to server via client socket
 				receives responses from server via resp_chan
-Parameters:		sock: file stream to communicate with server
-				scanner: used to read user input
-				resp_chan: used to receive server responses
-				server_exit: used to notify client of server shutdown
+Parameters:		cs: client connection state
+				cmd: user input split into fields, at least one
 Return Value:   nil if all input and output is good otherwise error
-Type:           *os.File, *bufio.Scanner, chan string, chan struct{} -> error
+Type:           *client_state, []string -> error
 */
-func repl(sock *os.File, scanner *bufio.Scanner, resp_chan chan string, server_exit chan struct{}) error {
-	fmt.Printf("PokeDB> ")
-
-	if !scanner.Scan() {
-		if scanner.Err() == nil { //CTRL-D
-			fmt.Println()
-			return io.EOF
-		}
-		return scanner.Err()
-	}
-	cmd := strings.Fields(strings.TrimSpace(scanner.Text()))
+func run_cmd(cs *client_state, cmd []string) error {
 	cmd_len := len(cmd)
-	if cmd_len == 0 {
-		return nil
-	}
 
 	switch cmd[0] {
 	case "":
@@ -139,22 +1925,237 @@ func repl(sock *os.File, scanner *bufio.Scanner, resp_chan chan string, server_e
 		//indicate to server
 		return io.EOF
 
+	case "repeat":
+		if cmd_len < 3 {
+			return ErrRepeatArgs
+		}
+		n, err := strconv.Atoi(cmd[1])
+		if err != nil || n <= 0 {
+			return ErrRepeatN
+		}
+		if cmd[2] == "repeat" || cmd[2] == "exit" {
+			return fmt.Errorf("'%s' can't be repeated", cmd[2])
+		}
+		return run_repeat(cs, n, cmd[2:])
+
+	case "ping":
+		if cmd_len != 1 {
+			return fmt.Errorf("'ping' takes no arguments")
+		}
+		start := time.Now()
+		recordlib.ReallyWrite(cs.sock, "PING")
+		bytes, err := server_resp(cs.resp_chan, cs.server_exit)
+		if err != nil {
+			fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
+			return err
+		}
+		if bytes != "PONG" {
+			return fmt.Errorf("ping: extraneous error")
+		}
+		fmt.Printf("PONG from server in %.3fms\n\n", float64(time.Since(start).Microseconds())/1000)
+		return nil
+
+	case "verify":
+		if cmd_len >= 2 && cmd[1] == "refs" {
+			if cmd_len > 3 || (cmd_len == 3 && cmd[2] != "repair") {
+				return fmt.Errorf("'verify refs' takes only 'repair'")
+			}
+			return run_verify_refs(cs, cmd_len == 3)
+		}
+		if cmd_len != 1 {
+			return fmt.Errorf("'verify' takes no arguments, or refs [repair]")
+		}
+		return run_verify(cs)
+
+	case "whoami":
+		if cmd_len != 1 {
+			return fmt.Errorf("'whoami' takes no arguments")
+		}
+		return run_whoami(cs)
+
+	case "describe":
+		if cmd_len != 2 || (cmd[1] != "pokemon" && cmd[1] != "trainer") {
+			return fmt.Errorf("'describe' expects 1 argument - pokemon or trainer")
+		}
+		return run_describe(cs, cmd[1])
+
+	case "random":
+		if cmd_len != 2 || (cmd[1] != "pokemon" && cmd[1] != "trainer") {
+			return fmt.Errorf("'random' expects 1 argument - pokemon or trainer")
+		}
+		return run_random(cs, cmd[1])
+
+	case "diff":
+		if cmd_len != 4 || cmd[1] != "trainer" {
+			return fmt.Errorf("'diff' expects: diff trainer <id a> <id b>")
+		}
+		id_a, err_a := strconv.Atoi(cmd[2])
+		id_b, err_b := strconv.Atoi(cmd[3])
+		if err_a != nil || err_b != nil {
+			return fmt.Errorf("'diff' expects: diff trainer <id a> <id b>")
+		}
+		if id_a <= 0 || id_b <= 0 {
+			return ErrGetTrainerIDLess
+		}
+		return run_trainer_diff(cs, id_a, id_b)
+
+	case "complete":
+		if cmd_len != 2 {
+			return fmt.Errorf("'complete' expects only 1 argument <prefix>")
+		}
+		if cs.poke_names == nil {
+			return fmt.Errorf("pokemon name lookup unavailable")
+		}
+		matches := cs.complete_poke_name(cmd[1])
+		if len(matches) == 0 {
+			return fmt.Errorf("no pokemon names start with '%s'", cmd[1])
+		}
+		fmt.Printf("%s\n\n", strings.Join(matches, "  "))
+		return nil
+
 	case "help":
 		fmt.Println("Valid options:")
 		fmt.Println("  exit")
+		fmt.Println("  ping")
+		fmt.Println("  whoami  (connection ID, source port, server uptime)")
+		fmt.Println("  describe pokemon|trainer  (record format: fields, offsets, constraints)")
 		fmt.Println("  get pokemon <id>")
+		fmt.Println("  get pokemon top <n>  (highest stat totals, n 1-100)")
+		fmt.Println("  get pokemon where [gen <n>] [legendary]")
+		fmt.Println("  get pokemon raw <id>  (on-disk record bytes)")
+		fmt.Println("  get pokemon ids <id,id,...>  (several records in one request)")
+		fmt.Println("  get pokemon similar <id>  (same body style or a shared egg group)")
+		fmt.Println("  get pokemon agg  (counts by generation and type, averages, height/weight range)")
+		fmt.Println("  get pokemon types  (every type in use, sorted)")
+		fmt.Println("  get pokename <id>")
+		fmt.Println("  random pokemon | random trainer  (any record, deleted trainers skipped)")
 		fmt.Println("  get trainer")
 		fmt.Println("  get trainer <id>")
-		fmt.Println("  post trainer <name> <pokemon 1> [... <pokemon 6>]")
+		fmt.Println("  get trainer <id> full  (with each pokemon's full record)")
+		fmt.Println("  get trainer sort <name|id> [asc|desc]")
+		fmt.Println("  get trainers using <pokemon>  (count and IDs of trainers holding it)")
+		fmt.Println("  diff trainer <id a> <id b>  (pokemon only a has, both have, only b has)")
+		fmt.Println("  post trainer <name> [<pokemon 1> ... <pokemon 6>] [key <token>]")
+		fmt.Println("    (retrying with the same key returns the first post's ID, no duplicate)")
 		fmt.Println("  put trainer <id> <pokemon 1> [... <pokemon 6>]")
+		fmt.Println("  add trainer <id> <pokemon 1> [... <pokemon 6>]")
+		fmt.Println("    (pokemon may be given by ID, name or unique name prefix)")
+		fmt.Println("  remove trainer <id> <slot 1-6>")
+		fmt.Println("  swap <id a> <slot a> <id b> <slot b>  (trade two trainers' pokemon)")
+		fmt.Println("  move <from id> <slot> <to id>  (give a pokemon to another trainer's first free slot)")
+		fmt.Println("  patch pokemon <id> <field> <value>  (one numeric field, e.g. CatchRate, see describe pokemon)")
+		fmt.Println("  complete <pokemon name prefix>")
 		fmt.Println("  delete trainer <id>")
-		fmt.Printf("  get log <n>\n\n")
+		fmt.Println("  delete trainer where <predicate>  (admin, -secret)")
+		fmt.Println("    predicate: empty | name_prefix <text> | has_poke <id>, joined with 'and'")
+		fmt.Println("  export trainers <file>  (CSV)")
+		fmt.Println("  import trainers <file>  (CSV, export format, all or nothing)")
+		fmt.Println("  get log <n> [--all-files]  (n at most 10000)")
+		fmt.Println("  get log since <timestamp>  (RFC 3339, e.g. 2024-01-02T15:04:05)")
+		fmt.Println("  get log port <port> <n> | get log cmd <command> <n>  (last n matching entries)")
+		fmt.Println("  get log follow  (print new log lines as they are written, Ctrl-C to stop)")
+		fmt.Println("  get metrics  (server request counters)")
+		fmt.Println("  get status  (server uptime and connections)")
+		fmt.Println("  verify  (scan pokemon and trainer files for corruption)")
+		fmt.Println("  verify refs  (trainer slots holding a pokemon that isn't in the pokemon file)")
+		fmt.Println("  verify refs repair  (drop those slots, admin, -secret)")
+		if cs.dry_run {
+			fmt.Println("  (-dry-run: post, put, add, remove, swap, move, patch, delete, import, verify refs repair and clear are printed, not sent)")
+		}
+		fmt.Println("  clear log  (admin, -secret)")
+		fmt.Println("  dump index | reload index  (admin, -secret)")
+		fmt.Printf("  repeat <n> <command ...>\n\n")
 		return nil
 
 	case "get":
 		if cmd_len >= 2 {
 			switch cmd[1] {
 			case "pokemon":
+				if cmd_len >= 3 && cmd[2] == "top" {
+					if cmd_len != 4 {
+						return ErrGetPokeTopArgs
+					}
+					num, err := strconv.Atoi(cmd[3])
+					if err != nil || num < 1 || num > 100 {
+						return ErrGetPokeTopArgs
+					}
+					return run_poke_list(cs, fmt.Sprintf("REQ_POKE_TOP %d", num))
+				}
+				if cmd_len >= 3 && cmd[2] == "ids" {
+					if cmd_len != 4 {
+						return ErrGetPokeIDsArgs
+					}
+					var ids []int
+					for _, field := range strings.Split(cmd[3], ",") {
+						num, err := strconv.Atoi(field)
+						if err != nil || num <= 0 {
+							return ErrGetPokeIDsArgs
+						}
+						ids = append(ids, num)
+					}
+					if len(ids) > 100 {
+						return ErrGetPokeIDsArgs
+					}
+					return run_poke_multi(cs, ids)
+				}
+				if cmd_len >= 3 && cmd[2] == "agg" {
+					if cmd_len != 3 {
+						return fmt.Errorf("'get pokemon agg' takes no arguments")
+					}
+					return run_poke_agg(cs)
+				}
+				if cmd_len >= 3 && cmd[2] == "types" {
+					if cmd_len != 3 {
+						return fmt.Errorf("'get pokemon types' takes no arguments")
+					}
+					return run_poke_types(cs)
+				}
+				if cmd_len >= 3 && cmd[2] == "similar" {
+					if cmd_len != 4 {
+						return ErrGetPokeSimilarArgs
+					}
+					num, err := strconv.Atoi(cmd[3])
+					if err != nil {
+						return ErrGetPokeSimilarArgs
+					} else if num <= 0 {
+						return ErrGetPokeIDLess
+					}
+					return run_poke_list(cs, fmt.Sprintf("REQ_POKE_SIMILAR %d", num))
+				}
+				if cmd_len >= 3 && cmd[2] == "raw" {
+					if cmd_len != 4 {
+						return ErrGetPokeRawArgs
+					}
+					num, err := strconv.Atoi(cmd[3])
+					if err != nil {
+						return ErrGetPokeRawArgs
+					} else if num <= 0 {
+						return ErrGetPokeIDLess
+					}
+					return run_poke_raw(cs, num)
+				}
+				if cmd_len >= 3 && cmd[2] == "where" {
+					gen, legendary := 0, 0
+					for idx := 3; idx < cmd_len; idx++ {
+						switch {
+						case cmd[idx] == "legendary" && legendary == 0:
+							legendary = 1
+						case cmd[idx] == "gen" && gen == 0 && idx+1 < cmd_len:
+							num, err := strconv.Atoi(cmd[idx+1])
+							if err != nil || num < 1 || num > 255 {
+								return ErrGetPokeWhereArgs
+							}
+							gen = num
+							idx++
+						default:
+							return ErrGetPokeWhereArgs
+						}
+					}
+					if gen == 0 && legendary == 0 {
+						return ErrGetPokeWhereArgs
+					}
+					return run_poke_list(cs, fmt.Sprintf("REQ_POKE_FILTER gen=%d legendary=%d", gen, legendary))
+				}
 				if cmd_len < 3 {
 					return ErrGetPokeNoID
 				} else if cmd_len > 3 {
@@ -169,9 +2170,9 @@ func repl(sock *os.File, scanner *bufio.Scanner, resp_chan chan string, server_e
 						}
 					}
 					req := fmt.Sprintf("REQ_POKE_ID %s", cmd[2])
-					recordlib.ReallyWrite(sock, req)
+					recordlib.ReallyWrite(cs.sock, req)
 
-					bytes, err := server_resp(resp_chan, server_exit)
+					bytes, err := server_resp(cs.resp_chan, cs.server_exit)
 					if err != nil {
 						fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
 						return err
@@ -185,7 +2186,7 @@ func repl(sock *os.File, scanner *bufio.Scanner, resp_chan chan string, server_e
 						return ErrPokeNotFound
 					default:
 						var pokemon recordlib.PokeRec
-						if err := json.Unmarshal([]byte(bytes), &pokemon); err != nil {
+						if err := cs.decode_record(bytes, &pokemon); err != nil {
 							return err
 						} else {
 							pokemon.Print()
@@ -194,6 +2195,53 @@ func repl(sock *os.File, scanner *bufio.Scanner, resp_chan chan string, server_e
 					}
 				}
 
+			case "trainers":
+				if cmd_len != 4 || cmd[2] != "using" {
+					return ErrGetTrainersUsingArgs
+				}
+				ids, err := cs.resolve_poke_ids(cmd[3:])
+				if err != nil {
+					return err
+				}
+				if num, err := strconv.Atoi(ids[0]); err != nil || num <= 0 || num > 0xFFFF {
+					return ErrPokeIDRange
+				}
+				return run_trainers_using(cs, ids[0])
+
+			case "pokename":
+				if cmd_len != 3 {
+					return ErrGetPokeNameArgs
+				}
+				num, err := strconv.Atoi(cmd[2])
+				if err != nil {
+					return err
+				} else if num <= 0 {
+					return ErrGetPokeIDLess
+				}
+				req := fmt.Sprintf("REQ_POKE_NAME_ID %s", cmd[2])
+				recordlib.ReallyWrite(cs.sock, req)
+
+				bytes, err := server_resp(cs.resp_chan, cs.server_exit)
+				if err != nil {
+					fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
+					return err
+				}
+				switch bytes {
+				case "CLIENT_REQ_INVALID":
+					return ErrInvalidReq
+				case "SERVER_ERROR":
+					return ErrServer
+				case "OUT_OF_BOUNDS":
+					return ErrPokeNotFound
+				default:
+					var poke_name recordlib.PokeName
+					if err := json.Unmarshal([]byte(bytes), &poke_name); err != nil {
+						return err
+					}
+					fmt.Printf("%d: %s\n\n", poke_name.ID, poke_name.Name)
+					return nil
+				}
+
 			case "trainer":
 				switch cmd_len {
 				case 3:
@@ -206,9 +2254,9 @@ func repl(sock *os.File, scanner *bufio.Scanner, resp_chan chan string, server_e
 						}
 					}
 					req := fmt.Sprintf("REQ_TRAINER_ID %s", cmd[2])
-					recordlib.ReallyWrite(sock, req)
+					recordlib.ReallyWrite(cs.sock, req)
 
-					bytes, err := server_resp(resp_chan, server_exit)
+					bytes, err := server_resp(cs.resp_chan, cs.server_exit)
 					if err != nil {
 						fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
 						return err
@@ -222,7 +2270,7 @@ func repl(sock *os.File, scanner *bufio.Scanner, resp_chan chan string, server_e
 						return ErrTrainerNotFound
 					default:
 						var trainer recordlib.TrainerRec
-						if err := json.Unmarshal([]byte(bytes), &trainer); err != nil {
+						if err := cs.decode_record(bytes, &trainer); err != nil {
 							return err
 						} else {
 							trainer.Print()
@@ -231,70 +2279,93 @@ func repl(sock *os.File, scanner *bufio.Scanner, resp_chan chan string, server_e
 					}
 
 				case 2:
-					req := "REQ_TRAINER_ALL"
-					recordlib.ReallyWrite(sock, req)
-
-					ready, err := server_resp(resp_chan, server_exit)
-					if err != nil {
-						fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
-						return err
-					}
-					switch ready {
-					case "CLIENT_REQ_INVALID":
-						return ErrInvalidReq
-					case "SERVER_ERROR":
-						return ErrServer
-					case "OUT_OF_BOUNDS":
-						return ErrTrainerFileEmpty
-					case "FILE_ERROR":
-						return fmt.Errorf("trainers file corrupted")
-					case "SENDING":
-						break
-					}
+					return run_trainer_list(cs, "REQ_TRAINER_ALL")
 
-					for {
-						bytes, err := server_resp(resp_chan, server_exit)
+				case 4, 5:
+					if cmd_len == 4 && cmd[3] == "full" {
+						num, err := strconv.Atoi(cmd[2])
 						if err != nil {
-							fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
-							return err
+							return ErrGetTrainerArgs
+						} else if num <= 0 {
+							return ErrGetTrainerIDLess
 						}
-						switch bytes {
-						case "SERVER_ERROR":
-							return ErrServer
-						case "OUT_OF_BOUNDS":
-							return ErrTrainerFileEmpty
-						case "DONE":
-							return nil
-						default:
-							var trainer recordlib.TrainerRec
-							if err := json.Unmarshal([]byte(bytes), &trainer); err != nil {
-								return err
-							} else {
-								trainer.Print()
-							}
+						return run_trainer_expand(cs, num)
+					}
+					if cmd[2] != "sort" || (cmd[3] != "name" && cmd[3] != "id") {
+						return ErrGetTrainerArgs
+					}
+					dir := "asc"
+					if cmd_len == 5 {
+						if cmd[4] != "asc" && cmd[4] != "desc" {
+							return ErrGetTrainerArgs
 						}
+						dir = cmd[4]
 					}
+					return run_trainer_list(cs, fmt.Sprintf("REQ_TRAINER_ALL_SORTED %s %s", cmd[3], dir))
 
 				default:
 					return ErrGetTrainerArgs
 				}
 
+			case "metrics":
+				if cmd_len != 2 {
+					return fmt.Errorf("'get metrics' takes no arguments")
+				}
+				return run_metrics(cs)
+
+			case "status":
+				if cmd_len != 2 {
+					return fmt.Errorf("'get status' takes no arguments")
+				}
+				return run_status(cs)
+
 			case "log":
+				if cmd_len >= 3 && (cmd[2] == "port" || cmd[2] == "cmd") {
+					if cmd_len != 5 {
+						return ErrGetLogFilterArgs
+					}
+					n, err := strconv.Atoi(cmd[4])
+					if errors.Is(err, strconv.ErrRange) {
+						return recordlib.ErrLogNTooLarge
+					} else if err != nil || n <= 0 {
+						return fmt.Errorf("argument <n> must be a positive integer")
+					} else if n > recordlib.MaxLogN {
+						return recordlib.ErrLogNTooLarge
+					}
+					return run_log_query(cs, fmt.Sprintf("REQ_LOG_FILTER %s %s %d", cmd[2], cmd[3], n))
+				}
+				if cmd_len == 3 && cmd[2] == "follow" {
+					return run_log_follow(cs)
+				}
+				if cmd_len >= 3 && cmd[2] == "since" {
+					if cmd_len != 4 {
+						return ErrGetLogSinceArgs
+					}
+					return run_log_query(cs, "REQ_LOG_SINCE "+cmd[3])
+				}
+				all_files := cmd_len == 4 && cmd[3] == "--all-files"
 				if cmd_len < 3 {
 					return ErrGetLogNoN
-				} else if cmd_len > 3 {
+				} else if cmd_len > 3 && !all_files {
 					return ErrGetLogManyArg
 				}
 				n, err := strconv.Atoi(cmd[2])
-				if err != nil {
+				if errors.Is(err, strconv.ErrRange) {
+					return recordlib.ErrLogNTooLarge //digits past the int range
+				} else if err != nil {
 					return fmt.Errorf("invalid argument for 'get log'")
 				} else if n <= 0 {
 					return fmt.Errorf("argument <n> must be a positive integer")
+				} else if n > recordlib.MaxLogN {
+					return recordlib.ErrLogNTooLarge
 				}
 
 				req := fmt.Sprintf("REQ_LOG_FILE %s", cmd[2])
-				recordlib.ReallyWrite(sock, req)
-				bytes, err := server_resp(resp_chan, server_exit)
+				if all_files {
+					req = fmt.Sprintf("REQ_LOG_FILE_ALL %s", cmd[2])
+				}
+				recordlib.ReallyWrite(cs.sock, req)
+				bytes, err := server_resp(cs.resp_chan, cs.server_exit)
 				if err != nil {
 					fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
 					return err
@@ -305,6 +2376,8 @@ func repl(sock *os.File, scanner *bufio.Scanner, resp_chan chan string, server_e
 					return ErrInvalidReq
 				case "SERVER_ERROR":
 					return ErrServer
+				case "BAD_COUNT":
+					return recordlib.ErrLogNTooLarge
 				default:
 					fmt.Printf("\nRequested Log Entries\n")
 					fmt.Println(bytes)
@@ -320,18 +2393,31 @@ func repl(sock *os.File, scanner *bufio.Scanner, resp_chan chan string, server_e
 		}
 
 	case "post":
-		if cmd_len >= 4 {
+		key := "" //idempotency key, "post ... key <token>"
+		if cmd_len >= 5 && cmd[cmd_len-2] == "key" {
+			key = cmd[cmd_len-1]
+			cmd = cmd[:cmd_len-2]
+			cmd_len -= 2
+		}
+		if cmd_len >= 3 {
 			if cmd_len <= 9 {
 				if cmd[1] != "trainer" {
 					return fmt.Errorf("'%s' invalid option for post", cmd[1])
 				}
-				req := fmt.Sprintf("POST_TRAINER %s %s", cmd[2], cmd[3])
-				for idx := 4; idx < cmd_len; idx++ {
-					req += " " + cmd[idx]
+				ids, err := cs.resolve_poke_ids(cmd[3:])
+				if err != nil {
+					return err
+				}
+				req := strings.Join(append([]string{"POST_TRAINER", cmd[2]}, ids...), " ")
+				if key != "" {
+					req += " KEY=" + key //after the pokemon, a leading token would read as the name
+				}
+				if cs.suppress(req) {
+					return nil
 				}
-				recordlib.ReallyWrite(sock, req)
+				recordlib.ReallyWrite(cs.sock, req)
 
-				bytes, err := server_resp(resp_chan, server_exit)
+				bytes, err := server_resp(cs.resp_chan, cs.server_exit)
 				if err != nil {
 					fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
 					return err
@@ -339,15 +2425,24 @@ func repl(sock *os.File, scanner *bufio.Scanner, resp_chan chan string, server_e
 				switch bytes {
 				case "CLIENT_REQ_INVALID":
 					return ErrInvalidReq
+				case "UNAUTHORIZED":
+					return ErrUnauthorized
 				case "SERVER_ERROR":
 					return ErrServer
 				case "LONG_NAME":
 					return ErrPostLongName
+				case "BAD_NAME":
+					return ErrBadName
 				case "BAD_POST":
 					return ErrBadPost
+				case "BAD_POKE_ID":
+					return ErrPokeIDRange
+				case "BAD_KEY":
+					return ErrPostKeyReused
 				default:
-					fmt.Printf("Added Trainer '%s' to Trainer Database\n", cmd[2])
-					fmt.Printf("New Trainer ID: %s\n\n", bytes)
+					id, stored_name, _ := strings.Cut(bytes, " ") //"<id> <stored name>"
+					fmt.Printf("Added Trainer '%s' to Trainer Database\n", display_name(stored_name))
+					fmt.Printf("New Trainer ID: %s\n\n", id)
 					return nil
 				}
 			} else {
@@ -363,13 +2458,17 @@ func repl(sock *os.File, scanner *bufio.Scanner, resp_chan chan string, server_e
 				if cmd[1] != "trainer" {
 					return fmt.Errorf("'%s' invalid option for post", cmd[1])
 				}
-				req := fmt.Sprintf("PUT_TRAINER %s %s", cmd[2], cmd[3])
-				for idx := 4; idx < cmd_len; idx++ {
-					req += " " + cmd[idx]
+				ids, err := cs.resolve_poke_ids(cmd[3:])
+				if err != nil {
+					return err
+				}
+				req := fmt.Sprintf("PUT_TRAINER %s %s", cmd[2], strings.Join(ids, " "))
+				if cs.suppress(req) {
+					return nil
 				}
-				recordlib.ReallyWrite(sock, req)
+				recordlib.ReallyWrite(cs.sock, req)
 
-				bytes, err := server_resp(resp_chan, server_exit)
+				bytes, err := server_resp(cs.resp_chan, cs.server_exit)
 				if err != nil {
 					fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
 					return err
@@ -378,6 +2477,8 @@ func repl(sock *os.File, scanner *bufio.Scanner, resp_chan chan string, server_e
 				switch opt_bytes[0] {
 				case "CLIENT_REQ_INVALID":
 					return ErrInvalidReq
+				case "UNAUTHORIZED":
+					return ErrUnauthorized
 				case "SERVER_ERROR":
 					return ErrServer
 				case "BAD_PUT":
@@ -395,22 +2496,291 @@ func repl(sock *os.File, scanner *bufio.Scanner, resp_chan chan string, server_e
 			return ErrPutArgsMissing
 		}
 
+	case "add":
+		if cmd_len < 4 {
+			return ErrAddArgsMissing
+		} else if cmd_len > 9 {
+			return ErrAddPokeMax
+		}
+		if cmd[1] != "trainer" {
+			return fmt.Errorf("'%s' invalid option for add", cmd[1])
+		}
+		ids, err := cs.resolve_poke_ids(cmd[3:])
+		if err != nil {
+			return err
+		}
+		req := fmt.Sprintf("APPEND_TRAINER %s %s", cmd[2], strings.Join(ids, " "))
+		if cs.suppress(req) {
+			return nil
+		}
+		recordlib.ReallyWrite(cs.sock, req)
+
+		bytes, err := server_resp(cs.resp_chan, cs.server_exit)
+		if err != nil {
+			fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
+			return err
+		}
+		opt_bytes := strings.SplitN(bytes, ".", 2)
+		switch opt_bytes[0] {
+		case "CLIENT_REQ_INVALID":
+			return ErrInvalidReq
+		case "UNAUTHORIZED":
+			return ErrUnauthorized
+		case "SERVER_ERROR":
+			return ErrServer
+		case "BAD_PUT":
+			return fmt.Errorf("%s", opt_bytes[1])
+		case "GOOD_PUT":
+			fmt.Printf("Added pokemon to Trainer ID: %s\n\n", cmd[2])
+			return nil
+		default:
+			return fmt.Errorf("add: extraneous error")
+		}
+
+	case "clear":
+		if cmd_len != 2 || cmd[1] != "log" {
+			return fmt.Errorf("'clear' expects only 1 argument - log")
+		}
+		if cs.suppress("CLEAR_LOG") {
+			return nil
+		}
+		if !cs.confirm("Clear the server log file?") {
+			fmt.Printf("Clear log cancelled\n\n")
+			return nil
+		}
+		recordlib.ReallyWrite(cs.sock, "CLEAR_LOG")
+
+		bytes, err := server_resp(cs.resp_chan, cs.server_exit)
+		if err != nil {
+			fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
+			return err
+		}
+		switch bytes {
+		case "CLIENT_REQ_INVALID":
+			return ErrInvalidReq
+		case "UNAUTHORIZED":
+			return ErrUnauthorized
+		case "SERVER_ERROR":
+			return ErrServer
+		case "LOG_CLEARED":
+			fmt.Printf("Server log cleared\n\n")
+			return nil
+		default:
+			return fmt.Errorf("clear: extraneous error")
+		}
+
+	case "dump", "reload":
+		if cmd_len != 2 || cmd[1] != "index" {
+			return fmt.Errorf("'%s' expects only 1 argument - index", cmd[0])
+		}
+		return run_index_cmd(cs, cmd[0])
+
+	case "export":
+		if cmd_len != 3 || cmd[1] != "trainers" {
+			return fmt.Errorf("'export' requires 2 arguments - trainers <file>")
+		}
+		return run_export(cs, cmd[2])
+
+	case "import":
+		if cmd_len != 3 || cmd[1] != "trainers" {
+			return fmt.Errorf("'import' requires 2 arguments - trainers <file>")
+		}
+		return run_import(cs, cmd[2])
+
+	case "remove":
+		if cmd_len != 4 {
+			return fmt.Errorf("'remove' requires 3 arguments - trainer <id> <slot>")
+		}
+		if cmd[1] != "trainer" {
+			return fmt.Errorf("'%s' invalid option for remove", cmd[1])
+		}
+		slot, err := strconv.Atoi(cmd[3])
+		if err != nil || slot < 1 || slot > 6 {
+			return fmt.Errorf("argument <slot> must be an integer 1-6")
+		}
+		req := fmt.Sprintf("REMOVE_TRAINER_POKE %s %d", cmd[2], slot)
+		if cs.suppress(req) {
+			return nil
+		}
+		recordlib.ReallyWrite(cs.sock, req)
+
+		bytes, err := server_resp(cs.resp_chan, cs.server_exit)
+		if err != nil {
+			fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
+			return err
+		}
+		opt_bytes := strings.SplitN(bytes, ".", 2)
+		switch opt_bytes[0] {
+		case "CLIENT_REQ_INVALID":
+			return ErrInvalidReq
+		case "UNAUTHORIZED":
+			return ErrUnauthorized
+		case "SERVER_ERROR":
+			return ErrServer
+		case "BAD_PUT":
+			return fmt.Errorf("%s", opt_bytes[1])
+		case "GOOD_PUT":
+			fmt.Printf("Removed slot %d from Trainer ID: %s\n\n", slot, cmd[2])
+			return nil
+		default:
+			return fmt.Errorf("remove: extraneous error")
+		}
+
+	case "swap":
+		if cmd_len != 5 {
+			return fmt.Errorf("'swap' requires 4 arguments - <id a> <slot a> <id b> <slot b>")
+		}
+		var args [4]int
+		for idx := range args {
+			num, err := strconv.Atoi(cmd[idx+1])
+			if err != nil || num < 1 {
+				return fmt.Errorf("'swap' arguments must be positive integers - <id a> <slot a> <id b> <slot b>")
+			}
+			if idx%2 == 1 && num > 6 {
+				return fmt.Errorf("argument <slot> must be an integer 1-6")
+			}
+			args[idx] = num
+		}
+		req := fmt.Sprintf("SWAP_TRAINER_POKE %d %d %d %d", args[0], args[1], args[2], args[3])
+		if cs.suppress(req) {
+			return nil
+		}
+		recordlib.ReallyWrite(cs.sock, req)
+
+		bytes, err := server_resp(cs.resp_chan, cs.server_exit)
+		if err != nil {
+			fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
+			return err
+		}
+		opt_bytes := strings.SplitN(bytes, ".", 2)
+		switch opt_bytes[0] {
+		case "CLIENT_REQ_INVALID":
+			return ErrInvalidReq
+		case "UNAUTHORIZED":
+			return ErrUnauthorized
+		case "SERVER_ERROR":
+			return ErrServer
+		case "BAD_PUT":
+			return fmt.Errorf("%s", opt_bytes[1])
+		case "GOOD_PUT":
+			fmt.Printf("Swapped Trainer %d slot %d with Trainer %d slot %d\n\n", args[0], args[1], args[2], args[3])
+			return nil
+		default:
+			return fmt.Errorf("swap: extraneous error")
+		}
+
+	case "move":
+		if cmd_len != 4 {
+			return fmt.Errorf("'move' requires 3 arguments - <from id> <slot> <to id>")
+		}
+		var args [3]int
+		for idx := range args {
+			num, err := strconv.Atoi(cmd[idx+1])
+			if err != nil || num < 1 {
+				return fmt.Errorf("'move' arguments must be positive integers - <from id> <slot> <to id>")
+			}
+			if idx == 1 && num > 6 {
+				return fmt.Errorf("argument <slot> must be an integer 1-6")
+			}
+			args[idx] = num
+		}
+		req := fmt.Sprintf("MOVE_TRAINER_POKE %d %d %d", args[0], args[1], args[2])
+		if cs.suppress(req) {
+			return nil
+		}
+		recordlib.ReallyWrite(cs.sock, req)
+
+		bytes, err := server_resp(cs.resp_chan, cs.server_exit)
+		if err != nil {
+			fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
+			return err
+		}
+		opt_bytes := strings.SplitN(bytes, ".", 2)
+		switch opt_bytes[0] {
+		case "CLIENT_REQ_INVALID":
+			return ErrInvalidReq
+		case "UNAUTHORIZED":
+			return ErrUnauthorized
+		case "SERVER_ERROR":
+			return ErrServer
+		case "BAD_PUT":
+			return fmt.Errorf("%s", opt_bytes[1])
+		case "GOOD_PUT":
+			fmt.Printf("Moved Trainer %d slot %d to Trainer %d\n\n", args[0], args[1], args[2])
+			return nil
+		default:
+			return fmt.Errorf("move: extraneous error")
+		}
+
+	case "patch":
+		if cmd_len != 5 || cmd[1] != "pokemon" {
+			return fmt.Errorf("'patch' requires 4 arguments - pokemon <id> <field> <value>")
+		}
+		id, err := strconv.Atoi(cmd[2])
+		if err != nil || id < 1 || id > 65535 {
+			return fmt.Errorf("argument <id> must be an integer 1-65535")
+		}
+		value, err := strconv.ParseUint(cmd[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("argument <value> must be a non-negative integer")
+		}
+		req := fmt.Sprintf("PATCH_POKE %d %s %d", id, cmd[3], value)
+		if !recordlib.ReqPatchPoke.MatchString(req) {
+			return fmt.Errorf("argument <field> must be a pokemon field name, see 'describe pokemon'")
+		}
+		if cs.suppress(req) {
+			return nil
+		}
+		recordlib.ReallyWrite(cs.sock, req)
+
+		bytes, err := server_resp(cs.resp_chan, cs.server_exit)
+		if err != nil {
+			fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
+			return err
+		}
+		opt_bytes := strings.SplitN(bytes, ".", 2)
+		switch opt_bytes[0] {
+		case "CLIENT_REQ_INVALID":
+			return ErrInvalidReq
+		case "UNAUTHORIZED":
+			return ErrUnauthorized
+		case "SERVER_ERROR":
+			return ErrServer
+		case "BAD_PUT":
+			return fmt.Errorf("%s", opt_bytes[1])
+		case "GOOD_PUT":
+			fmt.Printf("Patched Pokemon %d %s to %d\n\n", id, cmd[3], value)
+			return nil
+		default:
+			return fmt.Errorf("patch: extraneous error")
+		}
+
 	case "delete":
+		if cmd_len >= 4 && cmd[1] == "trainer" && cmd[2] == "where" {
+			return run_delete_where(cs, strings.Join(cmd[3:], " "))
+		}
 		if cmd_len == 3 {
 			if cmd[1] != "trainer" {
 				return fmt.Errorf("'%s' invalid option for delete", cmd[1])
 			}
 			req := fmt.Sprintf("DEL_TRAINER %s", cmd[2])
-			recordlib.ReallyWrite(sock, req)
+			if cs.suppress(req) {
+				return nil
+			}
+			recordlib.ReallyWrite(cs.sock, req)
 
-			bytes, err := server_resp(resp_chan, server_exit)
+			bytes, err := server_resp(cs.resp_chan, cs.server_exit)
 			if err != nil {
 				fmt.Println("Warning: Server is shutting down.\nRequest not processed, exiting client...")
 				return err
 			}
-			switch bytes { //server error not possible?
+			switch bytes {
 			case "CLIENT_REQ_INVALID":
 				return ErrInvalidReq
+			case "UNAUTHORIZED":
+				return ErrUnauthorized
+			case "SERVER_ERROR": //damaged trainer file, see GetTrainer
+				return ErrServer
 			case "OUT_OF_BOUNDS":
 				return ErrTrainerNotFound
 			case "DELETED":
@@ -421,7 +2791,7 @@ func repl(sock *os.File, scanner *bufio.Scanner, resp_chan chan string, server_e
 			}
 
 		} else {
-			return fmt.Errorf("'delete' requires at 2 arguments - trainer <id>: int")
+			return fmt.Errorf("'delete' requires 2 arguments - trainer <id>: int, or trainer where <predicate>")
 		}
 
 	default:
@@ -430,39 +2800,43 @@ func repl(sock *os.File, scanner *bufio.Scanner, resp_chan chan string, server_e
 }
 
 func main() {
-	host, port, err := get_opts()
+	opts, err := get_opts()
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 		fmt.Printf("Usage:\n")
 		fmt.Println(" --help\n       Show help (must be used on its own)")
 		fmt.Println("  -h string\n        Server's host IP")
 		fmt.Println("  -p int\n        Port number (10000-65535)")
-		unix.Exit(1)
-	}
-
-	sock_fd, err := unix.Socket(unix.AF_INET, unix.SOCK_STREAM, 0)
-	if err != nil {
-		log.Printf("Error: Failed to create socket!\n%v", err)
-		unix.Exit(1)
+		fmt.Println("  -b\n        Receive records as compact binary instead of JSON")
+		fmt.Println("  --timing\n        Show server-side lock wait, file I/O and total time per request")
+		fmt.Println("  --trace\n        Show the server connection and request number of each request, as in the server log")
+		fmt.Println("  --compress\n        Receive trainer listings and exports gzip compressed, for slow links")
+		fmt.Println("  -secret string\n        Server token, sent with AUTH on connect to allow trainer mutations and admin commands")
+		fmt.Println("  -transport syscall|net\n        Socket transport: raw unix sockets or Go net package (default syscall)")
+		fmt.Println("  -tls\n        Connect with TLS, for a server started with -cert/-key")
+		fmt.Println("  -ca string\n        CA certificate (PEM) to verify the server with under -tls, system roots if unset")
+		fmt.Println("  --format verbose|table\n        Trainer listing format (default verbose)")
+		fmt.Println("  -dry-run\n        Print post/put/add/remove/patch/delete/import/clear requests instead of sending them")
+		fmt.Println("  -page int\n        Page get/diff/describe output, n lines at a time with a -- more -- prompt (0 is off)")
+		os.Exit(1)
 	}
 
 	var host_addr [4]byte
-	if host == "localhost" {
+	if opts.host == "localhost" {
 		host_addr = [4]byte{127, 0, 0, 1}
 	} else {
-		parsed_ip := net.ParseIP(host).To4()
-		host_addr = [4]byte(parsed_ip.To4())
+		parsed_ip := net.ParseIP(opts.host).To4()
+		if parsed_ip == nil {
+			fmt.Printf("Error: '%s' is not a valid IPv4 address\n", opts.host)
+			os.Exit(1)
+		}
+		host_addr = [4]byte(parsed_ip)
 	}
 
-	addr := &unix.SockaddrInet4{Addr: host_addr, Port: port}
-	if err := unix.Connect(sock_fd, addr); err != nil { //handles timeout
-		log.Printf("Error: Failed to connect to server!\n%v", err)
-		unix.Exit(1)
-	}
-	sock := os.NewFile(uintptr(sock_fd), "socket")
-	if sock == nil {
-		log.Println("Error: Failed to create socket stream!")
-		unix.Exit(1)
+	sock, e_port, err := connect(host_addr, opts.port, opts.tls, opts.net_transport)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		os.Exit(1)
 	}
 	defer func() {
 		if err := sock.Close(); err != nil {
@@ -470,15 +2844,90 @@ func main() {
 		}
 	}() //sock_fd closed on sock.Close()
 
-	e_port, err := recordlib.ReallyRead(sock)
+	fmt.Printf("Pokemon DataBase REPL\nConnected to localhost | ephemeral port %s\n", e_port)
+	cs := &client_state{
+		sock:        sock,
+		resp_chan:   make(chan string),
+		server_exit: make(chan struct{}),
+		table:       opts.table,
+		dry_run:     opts.dry_run,
+		page:        opts.page,
+	}
+	if cs.dry_run {
+		fmt.Printf("Dry run: mutating requests are printed, not sent\n")
+		defer func() {
+			fmt.Printf("Dry run: %d mutating request(s) not sent\n", cs.suppressed)
+		}()
+	}
+	if opts.binary {
+		if err := recordlib.ReallyWrite(sock, "HELLO binary"); err != nil {
+			log.Printf("Error: %v", err)
+			return
+		}
+		resp, err := recordlib.ReallyRead(sock)
+		if err != nil || resp != "HELLO binary" {
+			fmt.Println("Warning: server refused binary mode, using JSON")
+		} else {
+			cs.binary = true
+		}
+	}
+	if opts.secret != "" {
+		if err := recordlib.ReallyWrite(sock, "AUTH "+opts.secret); err != nil {
+			log.Printf("Error: %v", err)
+			return
+		}
+		resp, err := recordlib.ReallyRead(sock)
+		if err != nil || resp != "AUTH_OK" {
+			fmt.Println("Warning: server rejected -secret, admin commands unavailable")
+		}
+	}
+	names, err := fetch_poke_names(sock)
 	if err != nil {
-		fmt.Println("Error: Failed to read ephemeral port from server!")
-		return
+		fmt.Printf("Warning: pokemon name lookup unavailable (%v), use numeric IDs\n", err)
+	} else {
+		cs.poke_names = names
 	}
-	fmt.Printf("Pokemon DataBase REPL\nConnected to localhost | ephemeral port %s\n", e_port)
-	scanner := bufio.NewScanner(os.Stdin)
-	response := make(chan string)
-	server_exit := make(chan struct{})
+	//after the name list, which is read directly and must not carry a TIMING line
+	if opts.timing {
+		if err := recordlib.ReallyWrite(sock, "TIMING on"); err != nil {
+			log.Printf("Error: %v", err)
+			return
+		}
+		resp, err := recordlib.ReallyRead(sock)
+		if err != nil || resp != "TIMING on" {
+			fmt.Println("Warning: server refused request timing")
+		} else {
+			cs.timing_chan = make(chan string, 1)
+		}
+	}
+	if opts.trace {
+		if err := recordlib.ReallyWrite(sock, "TRACE on"); err != nil {
+			log.Printf("Error: %v", err)
+			return
+		}
+		resp, err := recordlib.ReallyRead(sock)
+		if err != nil || resp != "TRACE on" {
+			fmt.Println("Warning: server refused request tracing")
+		} else {
+			cs.trace_chan = make(chan string, 1)
+		}
+	}
+	if opts.compress {
+		if err := recordlib.ReallyWrite(sock, "COMPRESS gzip"); err != nil {
+			log.Printf("Error: %v", err)
+			return
+		}
+		resp, err := recordlib.ReallyRead(sock)
+		if err != nil || resp != "COMPRESS gzip" {
+			fmt.Println("Warning: server refused stream compression, streams are sent uncompressed")
+		} else {
+			cs.compress = true
+		}
+	}
+	cs.input = bufio.NewReader(os.Stdin)
+	cs.scanner = new_input_scanner(cs.input)
+	response := cs.resp_chan
+	server_exit := cs.server_exit
 
 	go func() {
 		for {
@@ -490,7 +2939,43 @@ func main() {
 				return
 			}
 
-			serv_msg = strings.TrimSpace(serv_msg)
+			if cs.compress && strings.HasPrefix(serv_msg, recordlib.CompressPrefix) {
+				msgs, err := recordlib.InflateFrames(serv_msg)
+				if err != nil {
+					log.Printf("Error reading from server: %v", err)
+					recordlib.ReallyWrite(sock, "EXIT")
+					close(server_exit)
+					return
+				}
+				for _, msg := range msgs { //the stream's frames, as if sent one by one
+					if !cs.binary {
+						msg = strings.TrimSpace(msg)
+					}
+					response <- msg
+				}
+				continue
+			}
+			if !cs.binary {
+				serv_msg = strings.TrimSpace(serv_msg) //binary records may start or end with whitespace bytes
+			}
+			if cs.trace_chan != nil && strings.HasPrefix(serv_msg, "TRACE ") { //comes before TIMING
+				trace, rest, _ := strings.Cut(serv_msg, "\n")
+				select {
+				case <-cs.trace_chan: //keep only the latest
+				default:
+				}
+				cs.trace_chan <- strings.TrimPrefix(trace, "TRACE ")
+				serv_msg = rest
+			}
+			if cs.timing_chan != nil && strings.HasPrefix(serv_msg, "TIMING ") {
+				timing, rest, _ := strings.Cut(serv_msg, "\n")
+				select {
+				case <-cs.timing_chan: //keep only the latest
+				default:
+				}
+				cs.timing_chan <- strings.TrimPrefix(timing, "TIMING ")
+				serv_msg = rest
+			}
 			if serv_msg == "BYE" {
 				recordlib.ReallyWrite(sock, "EXIT")
 				close(server_exit)
@@ -508,7 +2993,7 @@ func main() {
 			return //notified in REPL
 
 		default:
-			err := repl(sock, scanner, response, server_exit)
+			err := repl(cs)
 			if err != nil {
 				if err == io.EOF {
 					recordlib.ReallyWrite(sock, "EXIT")
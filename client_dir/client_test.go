@@ -0,0 +1,275 @@
+/*
+Filename:  client_test.go
+Description:
+  - Tests that run client commands (run_cmd) against a stand-in server on the other end of
+    a net.Pipe, which records every request and answers from a reply function
+*/
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"project3/recordlib"
+)
+
+//requests the stand-in server got, in order
+type sent_reqs struct {
+	lock sync.Mutex
+	reqs []string
+}
+
+func (s *sent_reqs) list() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string{}, s.reqs...)
+}
+
+/*
+Function Name:  fake_server
+Description:    client state over a net.Pipe whose server end records each
+				request and puts reply(request) on resp_chan, the way the
+				client's reader goroutine would
+Parameters:     t: test handle, the pipe is closed when the test ends
+				reply: server answer to one request
+Return Value:   client state, the requests sent
+Type:           *testing.T, func(string) string -> *client_state, *sent_reqs
+*/
+func fake_server(t *testing.T, reply func(string) string) (*client_state, *sent_reqs) {
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	cs := &client_state{sock: client, resp_chan: make(chan string, 16), server_exit: make(chan struct{})}
+	sent := &sent_reqs{}
+	go func() {
+		for {
+			req, err := recordlib.ReallyRead(server)
+			if err != nil {
+				return
+			}
+			sent.lock.Lock()
+			sent.reqs = append(sent.reqs, req)
+			sent.lock.Unlock()
+			cs.resp_chan <- reply(req)
+		}
+	}()
+	return cs, sent
+}
+
+/*
+Function Name:  capture_stdout
+Description:    runs fn with os.Stdout sent to a pipe and returns what it
+				printed
+Parameters:     t: test handle
+				fn: code that prints
+Return Value:   the printed text
+Type:           *testing.T, func() -> string
+*/
+func capture_stdout(t *testing.T, fn func()) string {
+	t.Helper()
+	read, write, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = write
+	printed := make(chan string, 1)
+	go func() {
+		out, _ := io.ReadAll(read)
+		printed <- string(out)
+	}()
+	fn()
+	write.Close()
+	os.Stdout = stdout
+	return <-printed
+}
+
+/*
+Function Name:  TestGetLogNTooLarge
+Description:    get log, get log --all-files and get log port|cmd with n past
+				MaxLogN, or digits too long for an int, fail with
+				ErrLogNTooLarge before sending anything, a BAD_COUNT from
+				the server gives the same error, a non-numeric n is an
+				invalid argument and MaxLogN itself is sent
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestGetLogNTooLarge(t *testing.T) {
+	cs, sent := fake_server(t, func(string) string { return "BAD_COUNT" })
+	for _, line := range []string{
+		"get log 10001",
+		"get log 99999999999999999999",
+		"get log 10001 --all-files",
+		"get log 99999999999999999999 --all-files",
+		"get log port 40000 10001",
+		"get log cmd PING 99999999999999999999",
+	} {
+		if err := run_cmd(cs, strings.Fields(line)); err != recordlib.ErrLogNTooLarge {
+			t.Fatalf("%q: %v, want ErrLogNTooLarge", line, err)
+		}
+	}
+	if reqs := sent.list(); len(reqs) != 0 {
+		t.Fatalf("requests sent for refused counts: %q", reqs)
+	}
+
+	for _, line := range []string{"get log 10000", "get log 10000 --all-files", "get log port 40000 10000"} {
+		if err := run_cmd(cs, strings.Fields(line)); err != recordlib.ErrLogNTooLarge {
+			t.Fatalf("%q answered BAD_COUNT: %v, want ErrLogNTooLarge", line, err)
+		}
+	}
+	want := []string{"REQ_LOG_FILE 10000", "REQ_LOG_FILE_ALL 10000", "REQ_LOG_FILTER port 40000 10000"}
+	if reqs := sent.list(); strings.Join(reqs, "|") != strings.Join(want, "|") {
+		t.Fatalf("requests sent: %q, want %q", reqs, want)
+	}
+
+	for _, line := range []string{"get log abc", "get log port 40000 abc"} {
+		if err := run_cmd(cs, strings.Fields(line)); err == nil || err == recordlib.ErrLogNTooLarge {
+			t.Fatalf("%q: %v, want an invalid argument error", line, err)
+		}
+	}
+}
+
+/*
+Function Name:  TestPostShowsStoredName
+Description:    post trainer prints the name from the server's reply, so an
+				over-long name shows as the truncated name that was stored
+				and control bytes show escaped, never the raw input
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestPostShowsStoredName(t *testing.T) {
+	long := "abcdefghijklmnopqrstuvwxyz"
+	stored := recordlib.StoredTrainerName(long)
+	cs, sent := fake_server(t, func(req string) string {
+		if strings.HasPrefix(req, "POST_TRAINER "+long) {
+			return "7 " + stored
+		}
+		return "8 red\x1b[0m"
+	})
+
+	var err error
+	out := capture_stdout(t, func() { err = run_cmd(cs, []string{"post", "trainer", long, "25"}) })
+	if err != nil {
+		t.Fatalf("post %s: %v", long, err)
+	}
+	if !strings.Contains(out, "Added Trainer '"+stored+"'") || !strings.Contains(out, "New Trainer ID: 7") {
+		t.Fatalf("post of an over-long name printed %q, want stored name %q and ID 7", out, stored)
+	}
+	if strings.Contains(out, long) {
+		t.Fatalf("post printed the raw input %q: %q", long, out)
+	}
+
+	out = capture_stdout(t, func() { err = run_cmd(cs, []string{"post", "trainer", "red", "25"}) })
+	if err != nil || !strings.Contains(out, `Added Trainer 'red\x1b[0m'`) {
+		t.Fatalf("post with an escape in the stored name printed %q (%v), want it escaped", out, err)
+	}
+	want := []string{"POST_TRAINER " + long + " 25", "POST_TRAINER red 25"}
+	if reqs := sent.list(); strings.Join(reqs, "|") != strings.Join(want, "|") {
+		t.Fatalf("requests sent: %q, want %q", reqs, want)
+	}
+}
+
+/*
+Function Name:  TestRepeatSummary
+Description:    repeat 5 post trainer sends the post five times and sums
+				up 3 successes, 2 failures and the last error; a server
+				shutting down on the third run stops the repeat there with
+				io.EOF and a summary of the runs before it
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestRepeatSummary(t *testing.T) {
+	replies := []string{"1 Test", "BAD_POST", "2 Test", "3 Test", "BAD_POKE_ID"}
+	run := 0
+	cs, sent := fake_server(t, func(string) string {
+		reply := replies[run%len(replies)]
+		run++
+		return reply
+	})
+	var err error
+	out := capture_stdout(t, func() { err = run_cmd(cs, strings.Fields("repeat 5 post trainer Test 25")) })
+	if err != nil {
+		t.Fatalf("repeat: %v", err)
+	}
+	for _, want := range []string{"Repeated 'post trainer Test 25' 5 times", "Summary: 3 succeeded, 2 failed", "Last error: " + ErrPokeIDRange.Error()} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("repeat printed %q, want %q in it", out, want)
+		}
+	}
+	if reqs := sent.list(); len(reqs) != 5 || strings.Count(strings.Join(reqs, "\n"), "POST_TRAINER Test 25") != 5 {
+		t.Fatalf("requests sent: %q, want POST_TRAINER Test 25 five times", reqs)
+	}
+
+	hold := make(chan struct{})
+	t.Cleanup(func() { close(hold) })
+	run = 0
+	cs, sent = fake_server(t, func(string) string {
+		run++
+		if run == 3 {
+			close(cs.server_exit) //shut down instead of answering
+			//bounded, so a repeat that runs on past the shutdown fails rather than hangs
+			select {
+			case <-hold:
+			case <-time.After(2 * time.Second):
+			}
+		}
+		return fmt.Sprintf("%d Test", run)
+	})
+	out = capture_stdout(t, func() { err = run_cmd(cs, strings.Fields("repeat 5 post trainer Test 25")) })
+	if err != io.EOF {
+		t.Fatalf("repeat through a shutdown: %v, want io.EOF", err)
+	}
+	if !strings.Contains(out, "Repeat stopped after 2 of 5 runs") || !strings.Contains(out, "Summary: 2 succeeded, 0 failed") {
+		t.Fatalf("repeat through a shutdown printed %q", out)
+	}
+	if reqs := sent.list(); len(reqs) != 3 {
+		t.Fatalf("%d requests sent through a shutdown, want 3", len(reqs))
+	}
+}
+
+/*
+Function Name:  TestOverlongInputLine
+Description:    a line past the old 64KB scanner limit still runs, one past
+				max_input_line is ErrInputTooLong and sends nothing, and the
+				REPL reads the next line normally afterwards instead of
+				stopping
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestOverlongInputLine(t *testing.T) {
+	cs, sent := fake_server(t, func(string) string { return "PONG" })
+	input := "ping" + strings.Repeat(" ", 100*1024) + "\n" +
+		"post trainer " + strings.Repeat("x", max_input_line) + " 25\n" +
+		"ping\n"
+	cs.input = bufio.NewReader(strings.NewReader(input))
+	cs.scanner = new_input_scanner(cs.input)
+
+	var errs []error
+	capture_stdout(t, func() {
+		for idx := 0; idx < 4; idx++ {
+			errs = append(errs, repl(cs))
+		}
+	})
+	want := []error{nil, ErrInputTooLong, nil, io.EOF}
+	for idx := range want {
+		if errs[idx] != want[idx] {
+			t.Fatalf("repl %d: %v, want %v", idx+1, errs[idx], want[idx])
+		}
+	}
+	if reqs := sent.list(); strings.Join(reqs, "|") != "PING|PING" {
+		t.Fatalf("requests sent: %.80q, want two PINGs and no post", reqs)
+	}
+}
@@ -0,0 +1,207 @@
+/*
+Filename:  inspect.go
+Description:
+  - Offline inspection of a pokemon or trainer binary data file, no server or socket involved
+  - Validates the file size (and trainer file header), counts records and prints the first and last records
+  - Runs the recordlib record checks on every record and reports any corruption found
+  - Exits 1 if the file could not be read or any problem was found, so it can gate scripts
+*/
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"project3/recordlib"
+)
+
+const max_problems = 20 //problems printed before the rest are only counted
+
+type report struct {
+	problems int
+}
+
+/*
+Function Name:  problem
+Description:    method of report
+				counts a problem and prints it unless too many were printed
+Parameters:     id: record ID the problem was found at
+				msg: problem description
+Return Value:   n/a
+Type:           uint16, string -> n/a
+*/
+func (r *report) problem(id uint16, msg string) {
+	r.problems++
+	if r.problems <= max_problems {
+		fmt.Printf("  ! record %d: %s\n", id, msg)
+	}
+}
+
+/*
+Function Name:  check_size
+Description:    stats the file and splits what follows the header into
+				whole records, a trailing partial record is reported as a problem
+Parameters:     file: the binary data file
+				header_size: bytes before the first record
+				rec_size: size of one record in bytes
+				r: report to add problems to
+Return Value:   number of whole records and error (if any)
+Type:           *os.File, int64, int64, *report -> int64, error
+*/
+func check_size(file *os.File, header_size int64, rec_size int64, r *report) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size() - header_size
+	if size < 0 {
+		size = 0
+	}
+	num_recs := size / rec_size
+	fmt.Printf("File size:    %d bytes (%d byte records)\n", info.Size(), rec_size)
+	fmt.Printf("Records:      %d\n", num_recs)
+	if size%rec_size != 0 {
+		r.problems++
+		fmt.Printf("  ! %s: %d trailing bytes after the last whole record\n", recordlib.ErrFileSize, size%rec_size)
+	}
+	if num_recs > 0xFFFF {
+		r.problems++
+		fmt.Printf("  ! more records than IDs (max 65535)\n")
+		num_recs = 0xFFFF
+	}
+	return num_recs, nil
+}
+
+/*
+Function Name:  inspect_pokemon
+Description:    reads every pokemon record, checks each one and prints the
+				first and last records
+Parameters:     file: the pokemon binary data file
+				r: report to add problems to
+Return Value:   nil or read error
+Type:           *os.File, *report -> error
+*/
+func inspect_pokemon(file *os.File, r *report) error {
+	num_recs, err := check_size(file, 0, recordlib.PokeRecSize, r)
+	if err != nil {
+		return err
+	}
+
+	var first, last recordlib.PokeRec
+	for id := uint16(1); int64(id) <= num_recs; id++ {
+		poke, err := recordlib.GetPokemon(file, id)
+		if err != nil {
+			return err
+		}
+		for _, msg := range recordlib.CheckPokeRec(poke, id) {
+			r.problem(id, msg)
+		}
+		if id == 1 {
+			first = poke
+		}
+		last = poke
+	}
+
+	if num_recs > 0 {
+		fmt.Printf("\nFirst record:\n")
+		first.Print()
+		fmt.Printf("Last record:\n")
+		last.Print()
+	}
+	return nil
+}
+
+/*
+Function Name:  inspect_trainers
+Description:    checks the file header, then reads every trainer record,
+				counts live and deleted records, checks each live one and
+				prints the first and last live records
+Parameters:     file: the trainer binary data file
+				r: report to add problems to
+Return Value:   nil or read error
+Type:           *os.File, *report -> error
+*/
+func inspect_trainers(file *os.File, r *report) error {
+	trainer_size := int64(recordlib.TrainerRecSize)
+	if err := recordlib.ValidateHeader(file, trainer_size); err != nil {
+		r.problems++
+		fmt.Printf("  ! header: %v (start the server with -migrate to rewrite the file)\n", err)
+		return nil //record offsets can't be trusted without a matching header
+	}
+	num_recs, err := check_size(file, recordlib.HeaderSize, trainer_size, r)
+	if err != nil {
+		return err
+	}
+
+	live := 0
+	var first, last recordlib.TrainerRec
+	for id := uint16(1); int64(id) <= num_recs; id++ {
+		trainer, err := recordlib.GetTrainer(file, id)
+		if err == recordlib.ErrTrainerNotFound {
+			continue //blank record from deletion
+		}
+		if err == recordlib.ErrIDMismatch {
+			r.problem(id, err.Error()+", the record holds another trainer's ID")
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, msg := range recordlib.CheckTrainerRec(trainer, id) {
+			r.problem(id, msg)
+		}
+		if live == 0 {
+			first = trainer
+		}
+		last = trainer
+		live++
+	}
+	fmt.Printf("Live:         %d\n", live)
+	fmt.Printf("Deleted:      %d\n", num_recs-int64(live))
+
+	if live > 0 {
+		fmt.Printf("\nFirst live record:\n")
+		first.Print()
+		fmt.Printf("Last live record:\n")
+		last.Print()
+	}
+	return nil
+}
+
+func main() {
+	if len(os.Args) != 3 || (os.Args[2] != "pokemon" && os.Args[2] != "trainer") {
+		fmt.Printf("Usage: %s <file> <pokemon|trainer>\n", os.Args[0])
+		os.Exit(1)
+	}
+	path, kind := os.Args[1], os.Args[2]
+
+	file, err := os.Open(path) //read only, safe to run beside a server
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer file.Close()
+
+	fmt.Printf("Inspecting %s file %s\n", kind, path)
+	var r report
+	if kind == "pokemon" {
+		err = inspect_pokemon(file, &r)
+	} else {
+		err = inspect_trainers(file, &r)
+	}
+	if err != nil && err != io.EOF {
+		fmt.Printf("Error: failed reading %s: %v\n", path, err)
+		os.Exit(1)
+	}
+
+	if r.problems > max_problems {
+		fmt.Printf("  ! ... %d more problems not shown\n", r.problems-max_problems)
+	}
+	if r.problems > 0 {
+		fmt.Printf("%d problems found, file looks corrupted\n", r.problems)
+		file.Close()
+		os.Exit(1) //deferred Close doesn't run
+	}
+	fmt.Printf("No problems found\n")
+}
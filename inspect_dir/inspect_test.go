@@ -0,0 +1,158 @@
+/*
+Filename:  inspect_test.go
+Description:
+  - inspect_pokemon and inspect_trainers on fixture files built in a temporary directory
+    from the bundled poke.bin: intact files, a cut file, a bad boolean, a moved trainer
+    record and a headerless trainer file
+*/
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"project3/recordlib"
+)
+
+/*
+Function Name:  fixture
+Description:    writes data to a file in the test's temporary directory and
+				opens it read only, as the tool does
+Parameters:     t: test handle, the file is closed when the test ends
+				name: file name
+				data: file contents
+Return Value:   the open file
+Type:           *testing.T, string, []byte -> *os.File
+*/
+func fixture(t *testing.T, name string, data []byte) *os.File {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	return file
+}
+
+/*
+Function Name:  trainer_fixture
+Description:    trainer file bytes (header and records) for ash, misty and
+				brock, each with pokemon 25
+Parameters:     t: test handle
+Return Value:   the file contents
+Type:           *testing.T -> []byte
+*/
+func trainer_fixture(t *testing.T) []byte {
+	t.Helper()
+	dir := t.TempDir()
+	trainer_file, err := os.OpenFile(filepath.Join(dir, "trainer.bin"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer trainer_file.Close()
+	poke_file, err := os.Open("../poke.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer poke_file.Close()
+	if err := recordlib.WriteHeader(trainer_file, recordlib.TrainerRecSize); err != nil {
+		t.Fatal(err)
+	}
+	store := recordlib.NewFileTrainerStore(trainer_file, poke_file)
+	for _, name := range []string{"ash", "misty", "brock"} {
+		if _, err := store.Post(name, []uint16{25}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(trainer_file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+/*
+Function Name:  TestInspectPokemon
+Description:    the bundled file has no problems, a trailing partial record
+				and an out of range boolean are each one problem
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestInspectPokemon(t *testing.T) {
+	good, err := os.ReadFile("../poke.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	poke, err := recordlib.DecodePokeRec(good[recordlib.PokeRecSize*24 : recordlib.PokeRecSize*25])
+	if err != nil {
+		t.Fatal(err)
+	}
+	poke.IsLegendary = 7
+	bad_bool := append([]byte{}, good...)
+	copy(bad_bool[recordlib.PokeRecSize*24:], recordlib.EncodePokeRec(poke))
+
+	tests := []struct {
+		name     string
+		data     []byte
+		problems int
+	}{
+		{"intact", good, 0},
+		{"cut", good[:len(good)-recordlib.PokeRecSize/2], 1},
+		{"bad boolean", bad_bool, 1},
+		{"empty", nil, 0},
+	}
+	for _, tt := range tests {
+		var r report
+		if err := inspect_pokemon(fixture(t, "poke.bin", tt.data), &r); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if r.problems != tt.problems {
+			t.Fatalf("%s: %d problems, want %d", tt.name, r.problems, tt.problems)
+		}
+	}
+}
+
+/*
+Function Name:  TestInspectTrainers
+Description:    an intact trainer file has no problems, a record written at
+				another ID's offset, trailing bytes and a missing header are
+				each reported as a problem rather than a read error
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestInspectTrainers(t *testing.T) {
+	good := trainer_fixture(t)
+	rec := func(id int) []byte {
+		start := recordlib.HeaderSize + (id-1)*recordlib.TrainerRecSize
+		return good[start : start+recordlib.TrainerRecSize]
+	}
+	moved := append([]byte{}, good...)
+	copy(moved[recordlib.HeaderSize+recordlib.TrainerRecSize:], rec(3)) //trainer 3's record at 2's offset
+
+	tests := []struct {
+		name     string
+		data     []byte
+		problems int
+	}{
+		{"intact", good, 0},
+		{"moved record", moved, 1},
+		{"trailing bytes", append(append([]byte{}, good...), 1, 2, 3), 1},
+		{"no header", good[recordlib.HeaderSize:], 1},
+	}
+	for _, tt := range tests {
+		var r report
+		if err := inspect_trainers(fixture(t, "trainer.bin", tt.data), &r); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if r.problems != tt.problems {
+			t.Fatalf("%s: %d problems, want %d", tt.name, r.problems, tt.problems)
+		}
+	}
+}
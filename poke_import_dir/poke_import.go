@@ -0,0 +1,50 @@
+/*
+Filename:  poke_import.go
+Description:
+  - Offline tool that builds a pokemon binary data file from a JSON array, no server involved
+  - ./poke_import <json file> <pokemon file>, the pokemon file is created or replaced
+  - Every record is validated first (recordlib.ImportPokemonJSON), a bad one leaves the
+    pokemon file untouched and is reported with its position in the array
+  - Stop any server using the pokemon file first, it caches the records in memory
+*/
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"project3/recordlib"
+)
+
+func main() {
+	if len(os.Args) != 3 {
+		fmt.Printf("Usage: %s <json file> <pokemon file>\n", os.Args[0])
+		os.Exit(1)
+	}
+	json_path, poke_path := os.Args[1], os.Args[2]
+
+	json_file, err := os.Open(json_path)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer json_file.Close()
+	poke_file, err := os.OpenFile(poke_path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer poke_file.Close()
+
+	count, err := recordlib.ImportPokemonJSON(poke_file, json_file)
+	var batch_err *recordlib.BatchError
+	if errors.As(err, &batch_err) {
+		fmt.Printf("Error: pokemon %d in %s: %v\n%s not modified\n", batch_err.Row, json_path, batch_err.Err, poke_path)
+		os.Exit(1) //deferred Close doesn't run, exit closes the files
+	} else if err != nil {
+		fmt.Printf("Error: importing %s: %v\n", json_path, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Imported %d pokemon into %s\n", count, poke_path)
+}
@@ -0,0 +1,222 @@
+/*
+Filename:  bench_test.go
+Description:
+  - Benchmarks behind the numbers in the README: posts under each -sync mode, puts with and
+    without the WAL, JSON against binary records, LogReadN on a short and a long log, and
+    pokemon reads from the PokeCache against the file
+  - go test -bench . -run '^$' ./recordlib, the file ones need a real disk for fsync costs
+*/
+package recordlib
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+Function Name:  BenchmarkPostSync
+Description:    single trainer posts to a trainer file under each sync mode,
+				the file is emptied (untimed) before it runs out of IDs
+Parameters:     b: benchmark handle
+Return Value:   n/a
+Type:           *testing.B -> n/a
+*/
+func BenchmarkPostSync(b *testing.B) {
+	poke_file, err := os.Open("../poke.bin")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer poke_file.Close()
+	for _, mode := range []SyncMode{SyncAlways, SyncBatch, SyncOS} {
+		b.Run(mode.String(), func(b *testing.B) {
+			trainer_file, err := os.OpenFile(filepath.Join(b.TempDir(), "trainer.bin"), os.O_RDWR|os.O_CREATE, 0644)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer trainer_file.Close()
+			if err := WriteHeader(trainer_file, TrainerRecSize); err != nil {
+				b.Fatal(err)
+			}
+			store := NewFileTrainerStore(trainer_file, poke_file)
+			store.Syncer = NewSyncer(trainer_file, mode, 100, 200*time.Millisecond) //server defaults
+			defer store.Syncer.Close()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if i%60000 == 59999 {
+					b.StopTimer()
+					trainer_file.Truncate(HeaderSize)
+					b.StartTimer()
+				}
+				if _, err := store.Post("ash", []uint16{25, 6}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+/*
+Function Name:  BenchmarkPutWAL
+Description:    trainer puts under -sync always, without and with a WAL
+Parameters:     b: benchmark handle
+Return Value:   n/a
+Type:           *testing.B -> n/a
+*/
+func BenchmarkPutWAL(b *testing.B) {
+	for _, with_wal := range []bool{false, true} {
+		b.Run(map[bool]string{false: "nowal", true: "wal"}[with_wal], func(b *testing.B) {
+			path := filepath.Join(b.TempDir(), "trainer.bin")
+			trainer_file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer trainer_file.Close()
+			poke_file, err := os.Open("../poke.bin")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer poke_file.Close()
+			if err := WriteHeader(trainer_file, TrainerRecSize); err != nil {
+				b.Fatal(err)
+			}
+			store := NewFileTrainerStore(trainer_file, poke_file)
+			if _, err := store.Post("ash", nil); err != nil {
+				b.Fatal(err)
+			}
+			if with_wal {
+				if store.WAL, err = NewWAL(path + ".wal"); err != nil {
+					b.Fatal(err)
+				}
+				defer store.WAL.Close()
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := store.Put(1, []uint16{uint16(i%700 + 1)}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+/*
+Function Name:  BenchmarkRecordEncoding
+Description:    encodes and decodes a pokemon and a trainer record the way
+				the two response modes send them, JSON and HELLO binary
+Parameters:     b: benchmark handle
+Return Value:   n/a
+Type:           *testing.B -> n/a
+*/
+func BenchmarkRecordEncoding(b *testing.B) {
+	poke_file, err := os.Open("../poke.bin")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer poke_file.Close()
+	poke, err := GetPokemon(poke_file, 25)
+	if err != nil {
+		b.Fatal(err)
+	}
+	trainer := TrainerRec{ID: 1}
+	copy(trainer.Name[:], "ash")
+	if err := fill_slots(poke_file, &trainer, []uint16{25, 6, 9}); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("json", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			poke_msg, _ := json.Marshal(poke)
+			trainer_msg, _ := json.Marshal(trainer)
+			var poke_back PokeRec
+			var trainer_back TrainerRec
+			if json.Unmarshal(poke_msg, &poke_back) != nil || json.Unmarshal(trainer_msg, &trainer_back) != nil {
+				b.Fatal("decode failed")
+			}
+		}
+	})
+	b.Run("binary", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			poke_msg, _ := EncodeRecordBinary(poke)
+			trainer_msg, _ := EncodeRecordBinary(trainer)
+			var poke_back PokeRec
+			var trainer_back TrainerRec
+			if DecodeRecordBinary(poke_msg, &poke_back) != nil || DecodeRecordBinary(trainer_msg, &trainer_back) != nil {
+				b.Fatal("decode failed")
+			}
+		}
+	})
+}
+
+/*
+Function Name:  BenchmarkLogReadN
+Description:    last 20 lines of a 1000 line and a 1000000 line log, the
+				backward read should cost the same for both
+Parameters:     b: benchmark handle
+Return Value:   n/a
+Type:           *testing.B -> n/a
+*/
+func BenchmarkLogReadN(b *testing.B) {
+	line := "2026/10/14 12:00:00 [conn=1 req=2] [192.0.2.7:40000] PUT_TRAINER 1 25 6\n"
+	for _, lines := range []int{1000, 1000000} {
+		b.Run(fmt.Sprintf("lines=%d", lines), func(b *testing.B) {
+			path := filepath.Join(b.TempDir(), "server.log")
+			if err := os.WriteFile(path, []byte(strings.Repeat(line, lines)), 0644); err != nil {
+				b.Fatal(err)
+			}
+			log_file, err := os.Open(path)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer log_file.Close()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := LogReadN(log_file, 20); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+/*
+Function Name:  BenchmarkGetPokemon
+Description:    pokemon lookups from the PokeCache and from the file (-no-cache)
+Parameters:     b: benchmark handle
+Return Value:   n/a
+Type:           *testing.B -> n/a
+*/
+func BenchmarkGetPokemon(b *testing.B) {
+	poke_file, err := os.Open("../poke.bin")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer poke_file.Close()
+	cache, err := NewPokeCache(poke_file)
+	if err != nil {
+		b.Fatal(err)
+	}
+	count := uint16(cache.Len())
+
+	b.Run("cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := cache.Get(uint16(i)%count + 1); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("file", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := GetPokemon(poke_file, uint16(i)%count+1); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
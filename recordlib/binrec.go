@@ -0,0 +1,98 @@
+/*
+Filename:  binrec.go
+Description:
+  - Compact binary record encoding, negotiated per connection with HELLO binary
+  - Records are the codec.go bytes of PokeRec/TrainerRec (the same layout as the
+    binary data files), framed like every other message
+  - Status strings (OUT_OF_BOUNDS, SENDING, DONE, ...) are unchanged in binary mode
+*/
+package recordlib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"regexp"
+)
+
+//regexp for the response mode handshake, server replies HELLO <mode>
+var ReqHello = regexp.MustCompile(`^HELLO (json|binary)$`)
+
+/*
+Function Name:  EncodeRecordBinary
+Description:    encodes a record in its on-disk layout, PokeRec and TrainerRec
+				use the codec.go serializer, any other fixed size struct
+				falls back to its binary.Write bytes
+Parameters:     rec: PokeRec or TrainerRec (any fixed size struct)
+Return Value:   encoded record as a message string and error (if any)
+Type:           any -> string, error
+*/
+func EncodeRecordBinary(rec any) (string, error) {
+	switch rec := rec.(type) {
+	case PokeRec:
+		return string(EncodePokeRec(rec)), nil
+	case *PokeRec:
+		return string(EncodePokeRec(*rec)), nil
+	case TrainerRec:
+		return string(EncodeTrainerRec(rec)), nil
+	case *TrainerRec:
+		return string(EncodeTrainerRec(*rec)), nil
+	}
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, rec); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+/*
+Function Name:  DecodeRecordBinary
+Description:    decodes a message produced by EncodeRecordBinary into rec
+Parameters:     msg: received message
+                rec: pointer to PokeRec or TrainerRec to fill
+Return Value:   nil or decoding error (io.ErrUnexpectedEOF if msg is too short)
+Type:           string, any -> error
+*/
+func DecodeRecordBinary(msg string, rec any) error {
+	var err error
+	switch rec := rec.(type) {
+	case *PokeRec:
+		*rec, err = DecodePokeRec([]byte(msg))
+		return err
+	case *TrainerRec:
+		*rec, err = DecodeTrainerRec([]byte(msg))
+		return err
+	}
+	return binary.Read(bytes.NewReader([]byte(msg)), binary.LittleEndian, rec)
+}
+
+/*
+Function Name:  WriteRecordBinary
+Description:    encodes rec in binary and sends it as one framed message
+Parameters:		fp: the connection stream
+				rec: PokeRec or TrainerRec
+Return Value:   nil or encoding/write error
+Type:           Conn, any -> error
+*/
+func WriteRecordBinary(fp Conn, rec any) error {
+	msg, err := EncodeRecordBinary(rec)
+	if err != nil {
+		return err
+	}
+	return ReallyWrite(fp, msg)
+}
+
+/*
+Function Name:  ReadRecordBinary
+Description:    reads one framed message and decodes it into rec
+Parameters:		fp: the connection stream
+				rec: pointer to PokeRec or TrainerRec to fill
+Return Value:   nil or read/decoding error
+Type:           Conn, any -> error
+*/
+func ReadRecordBinary(fp Conn, rec any) error {
+	msg, err := ReallyRead(fp)
+	if err != nil {
+		return err
+	}
+	return DecodeRecordBinary(msg, rec)
+}
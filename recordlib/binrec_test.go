@@ -0,0 +1,95 @@
+/*
+Filename:  binrec_test.go
+Description:
+  - HELLO binary record encoding: PokeRec and TrainerRec round trips through
+    EncodeRecordBinary/DecodeRecordBinary and over a connection with
+    WriteRecordBinary/ReadRecordBinary
+*/
+package recordlib
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+/*
+Function Name:  TestRecordBinaryRoundTrip
+Description:    pokemon from poke.bin, a max-stat pokemon and a trainer with
+				every slot and name byte filled decode back to the same
+				record, by value or pointer, directly and over a net.Pipe;
+				the message is the on-disk record size and a short one is
+				io.ErrUnexpectedEOF
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestRecordBinaryRoundTrip(t *testing.T) {
+	poke_file := open_test_poke(t)
+	var pokes []PokeRec
+	for _, id := range []uint16{1, 25, 150, 721} {
+		rec, err := GetPokemon(poke_file, id)
+		if err != nil {
+			t.Fatalf("pokemon %d: %v", id, err)
+		}
+		pokes = append(pokes, rec)
+	}
+	maxed := PokeRec{ID: 0xFFFF, HP: 255, Attack: 255, Defense: 255, SpAtk: 255, SpDef: 255, Speed: 255,
+		Generation: 255, IsLegendary: 1, HasGender: 1, PrMale: 8, HasMegaEvo: 1, HeightM: 0xFFFF, WeightKg: 0xFFFF, CatchRate: 255}
+	copy(maxed.Name[:], "Fletchinder")
+	copy(maxed.BodyStyle[:], "bipedal_tailless")
+	pokes = append(pokes, maxed)
+
+	trainer := TrainerRec{ID: 0xFFFF}
+	copy(trainer.Name[:], "abcdefghijklmnop")
+	for idx, slot := range []*PokeDisplay{&trainer.Poke1, &trainer.Poke2, &trainer.Poke3, &trainer.Poke4, &trainer.Poke5, &trainer.Poke6} {
+		slot.ID = uint16(idx*100 + 1)
+		copy(slot.Name[:], "Pikachu")
+	}
+
+	for _, poke := range pokes {
+		for _, rec := range []any{poke, &poke} {
+			msg, err := EncodeRecordBinary(rec)
+			if err != nil || len(msg) != PokeRecSize {
+				t.Fatalf("encode pokemon %d: %d bytes, %v, want %d", poke.ID, len(msg), err, PokeRecSize)
+			}
+			var back PokeRec
+			if err := DecodeRecordBinary(msg, &back); err != nil || back != poke {
+				t.Fatalf("pokemon %d round trip: %+v, %v", poke.ID, back, err)
+			}
+		}
+	}
+	for _, rec := range []any{trainer, &trainer} {
+		msg, err := EncodeRecordBinary(rec)
+		if err != nil || len(msg) != TrainerRecSize {
+			t.Fatalf("encode trainer: %d bytes, %v, want %d", len(msg), err, TrainerRecSize)
+		}
+		var back TrainerRec
+		if err := DecodeRecordBinary(msg, &back); err != nil || back != trainer {
+			t.Fatalf("trainer round trip: %+v, %v", back, err)
+		}
+		if err := DecodeRecordBinary(msg[:len(msg)-1], &back); err != io.ErrUnexpectedEOF {
+			t.Fatalf("short trainer message: %v, want io.ErrUnexpectedEOF", err)
+		}
+	}
+
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	go func() {
+		for _, poke := range pokes {
+			WriteRecordBinary(server, poke)
+		}
+		WriteRecordBinary(server, &trainer)
+	}()
+	for _, poke := range pokes {
+		var back PokeRec
+		if err := ReadRecordBinary(client, &back); err != nil || back != poke {
+			t.Fatalf("pokemon %d over the pipe: %+v, %v", poke.ID, back, err)
+		}
+	}
+	var back TrainerRec
+	if err := ReadRecordBinary(client, &back); err != nil || back != trainer {
+		t.Fatalf("trainer over the pipe: %+v, %v", back, err)
+	}
+}
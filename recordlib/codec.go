@@ -0,0 +1,191 @@
+/*
+Filename:  codec.go
+Description:
+  - Explicit little endian (de)serializer for PokeRec and TrainerRec
+  - Every field is read and written at a fixed offset in declaration order with no padding,
+    so the on-disk layout no longer depends on unsafe.Sizeof or how a compiler lays out the struct
+  - PokeRecSize and TrainerRecSize are the on-disk record sizes all offset math uses
+  - The layout is the one the bundled pokemon file was written with (on acad)
+*/
+package recordlib
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+const (
+	PokeRecSize    = 96  //bytes per pokemon record on disk
+	TrainerRecSize = 102 //bytes per trainer record on disk
+	poke_name_offset = 2 //Name follows the uint16 ID
+)
+
+//sequential fixed offset field access over one record buffer
+type field_cursor struct {
+	buf []byte
+	off int
+}
+
+func (c *field_cursor) put_u8(v uint8) {
+	c.buf[c.off] = v
+	c.off++
+}
+
+func (c *field_cursor) put_u16(v uint16) {
+	binary.LittleEndian.PutUint16(c.buf[c.off:], v)
+	c.off += 2
+}
+
+func (c *field_cursor) put_bytes(b []byte) {
+	c.off += copy(c.buf[c.off:c.off+len(b)], b)
+}
+
+func (c *field_cursor) u8() uint8 {
+	c.off++
+	return c.buf[c.off-1]
+}
+
+func (c *field_cursor) u16() uint16 {
+	c.off += 2
+	return binary.LittleEndian.Uint16(c.buf[c.off-2:])
+}
+
+func (c *field_cursor) bytes(dst []byte) {
+	c.off += copy(dst, c.buf[c.off:c.off+len(dst)])
+}
+
+/*
+Function Name:  EncodePokeRec
+Description:    serializes a pokemon record field by field
+Parameters:     rec: pokemon record
+Return Value:   PokeRecSize bytes
+Type:           PokeRec -> []byte
+*/
+func EncodePokeRec(rec PokeRec) []byte {
+	c := field_cursor{buf: make([]byte, PokeRecSize)}
+	c.put_u16(rec.ID)
+	c.put_bytes(rec.Name[:])
+	c.put_bytes(rec.Type1[:])
+	c.put_bytes(rec.Type2[:])
+	c.put_u8(rec.HP)
+	c.put_u8(rec.Attack)
+	c.put_u8(rec.Defense)
+	c.put_u8(rec.SpAtk)
+	c.put_u8(rec.SpDef)
+	c.put_u8(rec.Speed)
+	c.put_u8(rec.Generation)
+	c.put_u8(rec.IsLegendary)
+	c.put_bytes(rec.Color[:])
+	c.put_u8(rec.HasGender)
+	c.put_u8(rec.PrMale)
+	c.put_bytes(rec.EggGroup1[:])
+	c.put_bytes(rec.EggGroup2[:])
+	c.put_u8(rec.HasMegaEvo)
+	c.put_u16(rec.HeightM)
+	c.put_u16(rec.WeightKg)
+	c.put_u8(rec.CatchRate)
+	c.put_bytes(rec.BodyStyle[:])
+	return c.buf
+}
+
+/*
+Function Name:  DecodePokeRec
+Description:    deserializes a pokemon record written by EncodePokeRec
+Parameters:     buf: record bytes
+Return Value:   pokemon record and nil or io.ErrUnexpectedEOF if buf is too short
+Type:           []byte -> PokeRec, error
+*/
+func DecodePokeRec(buf []byte) (PokeRec, error) {
+	var rec PokeRec
+	if len(buf) < PokeRecSize {
+		return rec, io.ErrUnexpectedEOF
+	}
+	c := field_cursor{buf: buf}
+	rec.ID = c.u16()
+	c.bytes(rec.Name[:])
+	c.bytes(rec.Type1[:])
+	c.bytes(rec.Type2[:])
+	rec.HP = c.u8()
+	rec.Attack = c.u8()
+	rec.Defense = c.u8()
+	rec.SpAtk = c.u8()
+	rec.SpDef = c.u8()
+	rec.Speed = c.u8()
+	rec.Generation = c.u8()
+	rec.IsLegendary = c.u8()
+	c.bytes(rec.Color[:])
+	rec.HasGender = c.u8()
+	rec.PrMale = c.u8()
+	c.bytes(rec.EggGroup1[:])
+	c.bytes(rec.EggGroup2[:])
+	rec.HasMegaEvo = c.u8()
+	rec.HeightM = c.u16()
+	rec.WeightKg = c.u16()
+	rec.CatchRate = c.u8()
+	c.bytes(rec.BodyStyle[:])
+	return rec, nil
+}
+
+/*
+Function Name:  EncodeTrainerRec
+Description:    serializes a trainer record field by field
+Parameters:     rec: trainer record
+Return Value:   TrainerRecSize bytes
+Type:           TrainerRec -> []byte
+*/
+func EncodeTrainerRec(rec TrainerRec) []byte {
+	c := field_cursor{buf: make([]byte, TrainerRecSize)}
+	c.put_u16(rec.ID)
+	c.put_bytes(rec.Name[:])
+	for _, poke := range []PokeDisplay{rec.Poke1, rec.Poke2, rec.Poke3, rec.Poke4, rec.Poke5, rec.Poke6} {
+		c.put_u16(poke.ID)
+		c.put_bytes(poke.Name[:])
+	}
+	return c.buf
+}
+
+/*
+Function Name:  DecodeTrainerRec
+Description:    deserializes a trainer record written by EncodeTrainerRec
+Parameters:     buf: record bytes
+Return Value:   trainer record and nil or io.ErrUnexpectedEOF if buf is too short
+Type:           []byte -> TrainerRec, error
+*/
+func DecodeTrainerRec(buf []byte) (TrainerRec, error) {
+	var rec TrainerRec
+	if len(buf) < TrainerRecSize {
+		return rec, io.ErrUnexpectedEOF
+	}
+	c := field_cursor{buf: buf}
+	rec.ID = c.u16()
+	c.bytes(rec.Name[:])
+	for _, poke := range []*PokeDisplay{&rec.Poke1, &rec.Poke2, &rec.Poke3, &rec.Poke4, &rec.Poke5, &rec.Poke6} {
+		poke.ID = c.u16()
+		c.bytes(poke.Name[:])
+	}
+	return rec, nil
+}
+
+/*
+Function Name:  read_at
+Description:    reads exactly size bytes at offset, positioned (pread) so
+				it doesn't share the file offset with concurrent readers
+Parameters:     file: the binary data file
+				offset: byte offset of the record
+				size: record size
+Return Value:   record bytes and nil, io.EOF if offset is at or past the end,
+				io.ErrUnexpectedEOF for a partial record, or read error
+Type:           *os.File, int64, int -> []byte, error
+*/
+func read_at(file *os.File, offset int64, size int) ([]byte, error) {
+	buf := make([]byte, size)
+	bytes_read, err := file.ReadAt(buf, offset)
+	if bytes_read == size {
+		return buf, nil
+	}
+	if err == io.EOF && bytes_read > 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return nil, err
+}
@@ -0,0 +1,110 @@
+/*
+Filename:  compress.go
+Description:
+  - Optional gzip compression of record streams (COMPRESS gzip at handshake)
+  - The frames a stream would send between SENDING and DONE are collected in a FrameGzip
+    and sent as one frame, CompressPrefix followed by the gzip bytes
+  - Inside the gzip stream each message is length prefixed like a wire frame, without the
+    crc32, gzip already checks the whole stream
+  - InflateFrames gives the receiver back the messages in order, so stream handling after
+    it is the same as uncompressed
+*/
+package recordlib
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const CompressPrefix = "GZIP " //start of a frame holding compressed frames
+
+var ErrCompressedFrame = fmt.Errorf("bad compressed frame")
+
+//collects messages for one compressed frame
+type FrameGzip struct {
+	buf bytes.Buffer
+	zw  *gzip.Writer
+}
+
+/*
+Function Name:  NewFrameGzip
+Description:    starts an empty compressed frame
+Parameters:     n/a
+Return Value:   the frame builder
+Type:           n/a -> *FrameGzip
+*/
+func NewFrameGzip() *FrameGzip {
+	f := &FrameGzip{}
+	f.buf.WriteString(CompressPrefix)
+	f.zw = gzip.NewWriter(&f.buf)
+	return f
+}
+
+/*
+Function Name:  Add
+Description:    method of FrameGzip
+				appends one message, compressed into memory so it can't fail
+Parameters:     msg: the message, as it would be sent on its own
+Return Value:   n/a
+Type:           string -> n/a
+*/
+func (f *FrameGzip) Add(msg string) {
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(msg)))
+	f.zw.Write(length)
+	f.zw.Write([]byte(msg))
+}
+
+/*
+Function Name:  Close
+Description:    method of FrameGzip
+				finishes the gzip stream, the frame can't be added to after
+Parameters:     n/a
+Return Value:   frame to send with ReallyWrite and error (if any)
+Type:           n/a -> string, error
+*/
+func (f *FrameGzip) Close() (string, error) {
+	if err := f.zw.Close(); err != nil {
+		return "", err
+	}
+	return f.buf.String(), nil
+}
+
+/*
+Function Name:  InflateFrames
+Description:    decompresses a frame built by FrameGzip
+Parameters:     msg: received frame, starting with CompressPrefix
+Return Value:   the messages in order and nil, or ErrCompressedFrame (wrapped)
+Type:           string -> []string, error
+*/
+func InflateFrames(msg string) ([]string, error) {
+	data, found := bytes.CutPrefix([]byte(msg), []byte(CompressPrefix))
+	if !found {
+		return nil, ErrCompressedFrame
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCompressedFrame, err)
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCompressedFrame, err)
+	}
+
+	var msgs []string
+	for len(raw) > 0 {
+		if len(raw) < 4 {
+			return nil, fmt.Errorf("%w: truncated length", ErrCompressedFrame)
+		}
+		length := binary.BigEndian.Uint32(raw[:4])
+		if uint64(len(raw)-4) < uint64(length) {
+			return nil, fmt.Errorf("%w: truncated message", ErrCompressedFrame)
+		}
+		msgs = append(msgs, string(raw[4:4+length]))
+		raw = raw[4+length:]
+	}
+	return msgs, nil
+}
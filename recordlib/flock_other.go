@@ -0,0 +1,38 @@
+//go:build !unix
+
+/*
+Filename:  flock_other.go
+Description:
+  - No advisory file locks on non-unix systems, LockFile always succeeds
+  - Two servers on the same files are not detected on these platforms
+*/
+package recordlib
+
+import (
+	"fmt"
+	"os"
+)
+
+var ErrFileLocked = fmt.Errorf("file is locked by another process")
+
+/*
+Function Name:  LockFile
+Description:    no-op on this platform
+Parameters:     file: open file to lock
+Return Value:   nil
+Type:           *os.File -> error
+*/
+func LockFile(file *os.File) error {
+	return nil
+}
+
+/*
+Function Name:  UnlockFile
+Description:    no-op on this platform
+Parameters:     file: locked file
+Return Value:   nil
+Type:           *os.File -> error
+*/
+func UnlockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,44 @@
+//go:build unix
+
+/*
+Filename:  flock_unix.go
+Description:
+  - Advisory whole-file locks so two servers can't share the same data files
+  - Lock is released by UnlockFile or when the file is closed
+*/
+package recordlib
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+var ErrFileLocked = fmt.Errorf("file is locked by another process")
+
+/*
+Function Name:  LockFile
+Description:    takes an exclusive non-blocking advisory lock (flock) on file
+Parameters:     file: open file to lock
+Return Value:   nil, ErrFileLocked if another process holds the lock, or flock error
+Type:           *os.File -> error
+*/
+func LockFile(file *os.File) error {
+	err := unix.Flock(int(file.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if err == unix.EWOULDBLOCK {
+		return ErrFileLocked
+	}
+	return err
+}
+
+/*
+Function Name:  UnlockFile
+Description:    releases a lock taken with LockFile
+Parameters:     file: locked file
+Return Value:   nil or flock error
+Type:           *os.File -> error
+*/
+func UnlockFile(file *os.File) error {
+	return unix.Flock(int(file.Fd()), unix.LOCK_UN)
+}
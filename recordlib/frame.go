@@ -0,0 +1,133 @@
+/*
+Filename:  frame.go
+Description:
+  - Wire framing shared by the server and client: 4 byte big endian payload length,
+    crc32 (IEEE) of the payload, then the payload
+  - Frame/Unframe work on byte slices, ReallyWrite/ReallyRead on any stream, ReallyWrite
+    sends a Frame and ReallyRead checks the same header Unframe does
+  - A bad checksum still consumes the whole frame so the stream (or buffer) stays in step
+  - A header announcing more than MaxFrameSize bytes is refused before anything is
+    allocated, the stream is then out of step and the connection has to be dropped
+*/
+package recordlib
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+const FrameHeaderSize = 8 //payload length and crc32, 4 bytes each
+
+const MaxFrameSize = 64 << 20 //largest payload sent or accepted, well above a full trainer listing
+
+var (
+	ErrChecksum      = fmt.Errorf("frame checksum mismatch")
+	ErrFrameTooLarge = fmt.Errorf("frame larger than %d bytes", MaxFrameSize)
+)
+
+/*
+Function Name:  Frame
+Description:    builds the frame for one message
+Parameters:     msg: the message to send
+Return Value:   header followed by the payload
+Type:           string -> []byte
+*/
+func Frame(msg string) []byte {
+	packet := make([]byte, FrameHeaderSize, FrameHeaderSize+len(msg))
+	binary.BigEndian.PutUint32(packet[:4], uint32(len(msg))) //network byte order
+	binary.BigEndian.PutUint32(packet[4:], crc32.ChecksumIEEE([]byte(msg)))
+	return append(packet, msg...)
+}
+
+/*
+Function Name:  Unframe
+Description:    takes the first frame off data, io.ErrUnexpectedEOF if data ends
+				inside it (wait for more bytes), a bad checksum still reports
+				the frame's size so the caller can skip it
+Parameters:     data: received bytes, starting at a frame boundary
+Return Value:   the message, bytes the frame took and nil, ErrChecksum (with
+				the size), ErrFrameTooLarge or io.ErrUnexpectedEOF (size 0)
+Type:           []byte -> string, int, error
+*/
+func Unframe(data []byte) (string, int, error) {
+	if len(data) < FrameHeaderSize {
+		return "", 0, io.ErrUnexpectedEOF
+	}
+	length := binary.BigEndian.Uint32(data[:4])
+	if length > MaxFrameSize {
+		return "", 0, ErrFrameTooLarge
+	}
+	if uint64(len(data)-FrameHeaderSize) < uint64(length) {
+		return "", 0, io.ErrUnexpectedEOF
+	}
+	size := FrameHeaderSize + int(length)
+	msg := data[FrameHeaderSize:size]
+	if crc32.ChecksumIEEE(msg) != binary.BigEndian.Uint32(data[4:FrameHeaderSize]) {
+		return "", size, ErrChecksum
+	}
+	return string(msg), size, nil
+}
+
+/*
+Function Name:  ReallyWrite
+Description:    guarantees that entire message is written to the stream as
+				one Frame
+Parameters:		fp: any stream, a Conn (file on unix, net.Conn, TLS) or a buffer
+				msg: the message to send over stream
+Return Value:   nil if all bytes were successfully written or error
+				(ErrFrameTooLarge, nothing written, if msg is over MaxFrameSize)
+Type:           io.Writer, string -> error
+*/
+func ReallyWrite(fp io.Writer, msg string) error {
+	if len(msg) > MaxFrameSize {
+		return ErrFrameTooLarge
+	}
+	packet := Frame(msg)
+	total := 0
+	for total < len(packet) {
+		bytes_written, err := fp.Write(packet[total:])
+		if err != nil {
+			return err
+		}
+		total += bytes_written
+	}
+	return nil
+}
+
+/*
+Function Name:  ReallyRead
+Description:    guarantees that entire message is read from the stream
+				and that it matches the checksum sent with it, on a mismatch
+				the whole frame is still consumed so the stream stays in step,
+				short reads are retried until the frame is complete
+Parameters:     fp: any stream, a Conn (file on unix, net.Conn, TLS) or a buffer
+Return Value:   the message read from the stream or error (ErrChecksum on mismatch,
+				io.ErrUnexpectedEOF if the stream ends inside a frame,
+				ErrFrameTooLarge if the header announces more than MaxFrameSize)
+Type:           io.Reader -> string, error
+*/
+func ReallyRead(fp io.Reader) (string, error) {
+	header := make([]byte, FrameHeaderSize)
+	//ReadFull loops over short reads, io.EOF only if the stream ended before the frame
+	if _, err := io.ReadFull(fp, header); err != nil {
+		return "", err
+	}
+
+	length := binary.BigEndian.Uint32(header[:4])
+	if length > MaxFrameSize { //untrusted, don't allocate what a remote peer asks for
+		return "", ErrFrameTooLarge
+	}
+	msg := make([]byte, length)
+	if _, err := io.ReadFull(fp, msg); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF //header without its payload
+		}
+		return "", err
+	}
+	if crc32.ChecksumIEEE(msg) != binary.BigEndian.Uint32(header[4:]) {
+		return "", ErrChecksum
+	}
+	return string(msg), nil
+}
@@ -0,0 +1,253 @@
+/*
+Filename:  frame_test.go
+Description:
+  - Tests for the wire framing over in-memory streams (bytes.Buffer and short-read readers)
+*/
+package recordlib
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+//reader that hands out at most one byte per Read, the worst case of a stream socket
+type one_byte_reader struct {
+	r io.Reader
+}
+
+func (o one_byte_reader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return o.r.Read(p[:1])
+}
+
+/*
+Function Name:  TestReallyWriteRead
+Description:    writes each message to a bytes.Buffer and reads it back, both
+				straight from the buffer and one byte per Read
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestReallyWriteRead(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  string
+	}{
+		{"empty", ""},
+		{"short", "GET_POKEMON 25"},
+		{"binary", "\x00\xff\x00\x01"},
+		{"large", strings.Repeat("0123456789abcdef", 1<<16)}, //1 MiB
+	}
+	for _, tt := range tests {
+		for _, short := range []bool{false, true} {
+			var buf bytes.Buffer
+			if err := ReallyWrite(&buf, tt.msg); err != nil {
+				t.Fatalf("%s: ReallyWrite: %v", tt.name, err)
+			}
+			if buf.Len() != FrameHeaderSize+len(tt.msg) {
+				t.Fatalf("%s: frame is %d bytes, want %d", tt.name, buf.Len(), FrameHeaderSize+len(tt.msg))
+			}
+			var r io.Reader = &buf
+			if short {
+				r = one_byte_reader{r}
+			}
+			got, err := ReallyRead(r)
+			if err != nil {
+				t.Fatalf("%s (short reads %v): ReallyRead: %v", tt.name, short, err)
+			}
+			if got != tt.msg {
+				t.Fatalf("%s (short reads %v): read %d bytes, want %d", tt.name, short, len(got), len(tt.msg))
+			}
+			if _, err := ReallyRead(r); err != io.EOF {
+				t.Fatalf("%s (short reads %v): read after the frame gave %v, want io.EOF", tt.name, short, err)
+			}
+		}
+	}
+}
+
+/*
+Function Name:  TestReallyReadSequence
+Description:    several frames back to back in one buffer come out in order,
+				one byte per Read
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestReallyReadSequence(t *testing.T) {
+	msgs := []string{"SENDING", "", "{\"ID\":1}", "DONE"}
+	var buf bytes.Buffer
+	for _, msg := range msgs {
+		if err := ReallyWrite(&buf, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := one_byte_reader{&buf}
+	for i, want := range msgs {
+		got, err := ReallyRead(r)
+		if err != nil || got != want {
+			t.Fatalf("frame %d: got %q, %v, want %q", i, got, err, want)
+		}
+	}
+}
+
+/*
+Function Name:  TestFrameRoundTrip
+Description:    Frame→Unframe and ReallyWrite→ReallyRead give back the message,
+				and the two produce the same bytes
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestFrameRoundTrip(t *testing.T) {
+	for _, msg := range []string{"", "EXIT", strings.Repeat("x", 70000)} {
+		packet := Frame(msg)
+		got, size, err := Unframe(packet)
+		if err != nil || got != msg || size != len(packet) {
+			t.Fatalf("Unframe(Frame(%d bytes)): %d bytes, size %d, %v", len(msg), len(got), size, err)
+		}
+
+		var buf bytes.Buffer
+		if err := ReallyWrite(&buf, msg); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), packet) {
+			t.Fatalf("ReallyWrite of %d bytes differs from Frame", len(msg))
+		}
+		if got, err := ReallyRead(&buf); err != nil || got != msg {
+			t.Fatalf("ReallyRead: %d bytes, %v", len(got), err)
+		}
+	}
+
+	//two frames in one buffer, the size says where the second starts
+	data := append(Frame("SENDING"), Frame("DONE")...)
+	first, size, err := Unframe(data)
+	if err != nil || first != "SENDING" {
+		t.Fatalf("first frame: %q, %v", first, err)
+	}
+	if second, _, err := Unframe(data[size:]); err != nil || second != "DONE" {
+		t.Fatalf("second frame: %q, %v", second, err)
+	}
+}
+
+/*
+Function Name:  TestReallyReadPartial
+Description:    the partial-read loop: a frame delivered one byte per Read is
+				read whole, a stream ending inside the header or the payload
+				gives io.ErrUnexpectedEOF, and a bad checksum still consumes the
+				frame so the next one reads
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestReallyReadPartial(t *testing.T) {
+	packet := Frame("GET_TRAINER 7")
+	if got, err := ReallyRead(one_byte_reader{bytes.NewReader(packet)}); err != nil || got != "GET_TRAINER 7" {
+		t.Fatalf("one byte at a time: %q, %v", got, err)
+	}
+
+	for _, cut := range []int{1, FrameHeaderSize - 1, FrameHeaderSize, len(packet) - 1} {
+		_, err := ReallyRead(one_byte_reader{bytes.NewReader(packet[:cut])})
+		if err != io.ErrUnexpectedEOF {
+			t.Fatalf("stream cut at %d of %d bytes: %v, want io.ErrUnexpectedEOF", cut, len(packet), err)
+		}
+	}
+
+	bad := Frame("PUT_TRAINER 1 ash 25")
+	bad[len(bad)-1] ^= 0x01
+	r := one_byte_reader{bytes.NewReader(append(bad, Frame("EXIT")...))}
+	if _, err := ReallyRead(r); err != ErrChecksum {
+		t.Fatalf("flipped payload byte: %v, want ErrChecksum", err)
+	}
+	if got, err := ReallyRead(r); err != nil || got != "EXIT" {
+		t.Fatalf("frame after the bad one: %q, %v", got, err)
+	}
+}
+
+/*
+Function Name:  TestFrameTooLarge
+Description:    a header announcing more than MaxFrameSize is refused by
+				ReallyRead and Unframe without reading the payload, and
+				ReallyWrite won't send such a message
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestFrameTooLarge(t *testing.T) {
+	header := []byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0} //4 GiB announced, nothing behind it
+	if _, err := ReallyRead(bytes.NewReader(header)); err != ErrFrameTooLarge {
+		t.Fatalf("ReallyRead: %v, want ErrFrameTooLarge", err)
+	}
+	if _, _, err := Unframe(header); err != ErrFrameTooLarge {
+		t.Fatalf("Unframe: %v, want ErrFrameTooLarge", err)
+	}
+
+	var buf bytes.Buffer
+	if err := ReallyWrite(&buf, strings.Repeat("x", MaxFrameSize+1)); err != ErrFrameTooLarge || buf.Len() != 0 {
+		t.Fatalf("ReallyWrite: %v with %d bytes written, want ErrFrameTooLarge and none", err, buf.Len())
+	}
+}
+
+/*
+Function Name:  FuzzUnframe
+Description:    Unframe never panics on arbitrary bytes, a frame it accepts
+				re-frames to the same bytes, and every strict prefix of an
+				accepted frame gives io.ErrUnexpectedEOF
+Parameters:     f: fuzz handle
+Return Value:   n/a
+Type:           *testing.F -> n/a
+*/
+func FuzzUnframe(f *testing.F) {
+	f.Add([]byte{})
+	f.Add(Frame(""))
+	f.Add(Frame("GET_POKEMON 25"))
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, size, err := Unframe(data)
+		if err != nil {
+			if err != io.ErrUnexpectedEOF && err != ErrChecksum && err != ErrFrameTooLarge {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if size < 0 || size > len(data) {
+				t.Fatalf("size %d outside %d bytes of input", size, len(data))
+			}
+			return
+		}
+		if !bytes.Equal(Frame(msg), data[:size]) {
+			t.Fatalf("accepted frame doesn't re-frame to the same bytes")
+		}
+		for cut := 0; cut < size; cut++ {
+			if _, n, err := Unframe(data[:cut]); err != io.ErrUnexpectedEOF || n != 0 {
+				t.Fatalf("prefix of %d/%d bytes: size %d, %v, want io.ErrUnexpectedEOF", cut, size, n, err)
+			}
+		}
+	})
+}
+
+/*
+Function Name:  TestFrameChecksum
+Description:    flipping any single bit of the checksum or the payload makes
+				ReallyRead and Unframe return ErrChecksum, never the altered
+				message
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestFrameChecksum(t *testing.T) {
+	packet := Frame(`{"ID":25,"Name":"Pikachu"}`)
+	for pos := 4; pos < len(packet); pos++ { //after the length, which is checked by size
+		for bit := 0; bit < 8; bit++ {
+			bad := append([]byte{}, packet...)
+			bad[pos] ^= 1 << bit
+			if got, err := ReallyRead(bytes.NewReader(bad)); err != ErrChecksum {
+				t.Fatalf("bit %d of byte %d flipped: ReallyRead gave %q, %v, want ErrChecksum", bit, pos, got, err)
+			}
+			if got, _, err := Unframe(bad); err != ErrChecksum {
+				t.Fatalf("bit %d of byte %d flipped: Unframe gave %q, %v, want ErrChecksum", bit, pos, got, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,198 @@
+/*
+Filename:  header.go
+Description:
+  - Fixed header at the start of the trainer binary data file: magic, format version, record size
+  - Written when the server creates the file, checked on every open so a record layout change
+    can't silently misread an existing file
+  - Trainer record offsets all start after the header (see trainer_offset)
+  - MigrateTrainers rewrites a headerless or other record size file in the current layout
+  - The pokemon file is read only and shipped as is, it has no header
+*/
+package recordlib
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	HeaderSize    = 16 //bytes before the first trainer record
+	HeaderVersion = 1
+	//record size of trainer files written before headers were added
+	LegacyTrainerRecSize = 102
+)
+
+var HeaderMagic = [4]byte{'P', 'K', 'T', 'R'}
+
+var (
+	ErrNoHeader      = fmt.Errorf("file has no PokeDB header")
+	ErrHeaderVersion = fmt.Errorf("unsupported file header version")
+	ErrRecordSize    = fmt.Errorf("on-disk record size does not match this build")
+)
+
+type FileHeader struct {
+	Magic      [4]byte
+	Version    uint16
+	RecordSize uint16
+	Reserved   [8]byte //zero, room for later fields
+}
+
+/*
+Function Name:  WriteHeader
+Description:    writes a header for records of rec_size bytes at the start
+				of file, used when the trainer file is created empty
+Parameters:     file: the trainer binary data file
+				rec_size: size of one record in bytes
+Return Value:   nil or write error
+Type:           *os.File, int64 -> error
+*/
+func WriteHeader(file *os.File, rec_size int64) error {
+	hdr := FileHeader{Magic: HeaderMagic, Version: HeaderVersion, RecordSize: uint16(rec_size)}
+	if _, err := file.Seek(0, 0); err != nil {
+		return err
+	}
+	if err := binary.Write(file, binary.LittleEndian, &hdr); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+/*
+Function Name:  read_header
+Description:    reads the header at the start of file
+Parameters:     file: the binary data file
+Return Value:   the header and nil, ErrNoHeader if the magic is missing, or read error
+Type:           *os.File -> FileHeader, error
+*/
+func read_header(file *os.File) (FileHeader, error) {
+	var hdr FileHeader
+	if _, err := file.Seek(0, 0); err != nil {
+		return hdr, err
+	}
+	if err := binary.Read(file, binary.LittleEndian, &hdr); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return hdr, ErrNoHeader //shorter than a header
+		}
+		return hdr, err
+	}
+	if hdr.Magic != HeaderMagic {
+		return hdr, ErrNoHeader
+	}
+	return hdr, nil
+}
+
+/*
+Function Name:  ValidateHeader
+Description:    checks the file header matches this build, the version must
+				be known and the record size must equal rec_size
+Parameters:     file: the trainer binary data file
+				rec_size: compiled size of one record in bytes
+Return Value:   nil, ErrNoHeader, ErrHeaderVersion, ErrRecordSize (wrapped with details) or read error
+Type:           *os.File, int64 -> error
+*/
+func ValidateHeader(file *os.File, rec_size int64) error {
+	hdr, err := read_header(file)
+	if err != nil {
+		return err
+	}
+	if hdr.Version != HeaderVersion {
+		return fmt.Errorf("%w: version %d, this build reads %d", ErrHeaderVersion, hdr.Version, HeaderVersion)
+	}
+	if int64(hdr.RecordSize) != rec_size {
+		return fmt.Errorf("%w: file has %d byte records, this build uses %d", ErrRecordSize, hdr.RecordSize, rec_size)
+	}
+	return nil
+}
+
+/*
+Function Name:  trainer_offset
+Description:    file offset of the trainer record with id
+Parameters:     id: the record ID
+Return Value:   offset in bytes
+Type:           uint16 -> int64
+*/
+func trainer_offset(id uint16) int64 {
+	return HeaderSize + int64(id-1)*TrainerRecSize
+}
+
+/*
+Function Name:  trainer_count
+Description:    number of trainer records the file holds after the header
+Parameters:     trainer_file: the trainer binary data file
+Return Value:   record count and nil, ErrFileSize or stat error
+Type:           *os.File -> int64, error
+*/
+func trainer_count(trainer_file *os.File) (int64, error) {
+	trainer_size := int64(TrainerRecSize)
+	info, err := trainer_file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	data_size := info.Size() - HeaderSize
+	if data_size < 0 || data_size%trainer_size != 0 {
+		return 0, ErrFileSize
+	}
+	return data_size / trainer_size, nil
+}
+
+/*
+Function Name:  MigrateTrainers
+Description:    rewrites the trainer file with a current header and records
+				of TrainerRecSize, the old record size comes from the
+				header or is LegacyTrainerRecSize for a headerless file,
+				the original bytes are first copied to <file>.bak
+				this is a stub: each old record is copied byte for byte and
+				truncated or zero padded, so it is only right while fields
+				keep their offsets, a reordering layout change needs a field
+				by field conversion here
+Parameters:     trainer_file: the trainer binary data file, open read/write
+Return Value:   number of records migrated and error (if any)
+Type:           *os.File -> int, error
+*/
+func MigrateTrainers(trainer_file *os.File) (int, error) {
+	old_size, data_start := int64(LegacyTrainerRecSize), int64(0)
+	hdr, err := read_header(trainer_file)
+	if err == nil {
+		old_size, data_start = int64(hdr.RecordSize), HeaderSize
+	} else if err != ErrNoHeader {
+		return 0, err
+	}
+	if old_size == 0 {
+		return 0, fmt.Errorf("%w: header record size is 0", ErrRecordSize)
+	}
+
+	if _, err := trainer_file.Seek(0, 0); err != nil {
+		return 0, err
+	}
+	old, err := io.ReadAll(trainer_file)
+	if err != nil {
+		return 0, err
+	}
+	if (int64(len(old))-data_start)%old_size != 0 {
+		return 0, ErrFileSize
+	}
+	if err := os.WriteFile(trainer_file.Name()+".bak", old, 0644); err != nil {
+		return 0, err
+	}
+
+	new_size := int64(TrainerRecSize)
+	num_recs := (int64(len(old)) - data_start) / old_size
+	recs := make([]byte, num_recs*new_size)
+	for idx := int64(0); idx < num_recs; idx++ {
+		src := old[data_start+idx*old_size : data_start+(idx+1)*old_size]
+		copy(recs[idx*new_size:(idx+1)*new_size], src)
+	}
+
+	if err := trainer_file.Truncate(0); err != nil {
+		return 0, err
+	}
+	if err := WriteHeader(trainer_file, new_size); err != nil {
+		return 0, err
+	}
+	if _, err := trainer_file.WriteAt(recs, HeaderSize); err != nil {
+		return 0, err
+	}
+	return int(num_recs), trainer_file.Sync()
+}
@@ -0,0 +1,306 @@
+/*
+Filename:  lock_track.go
+Description:
+  - Per-connection views of a GlobalManager (Track) that remember the locks taken through
+    them, so a handler that panics between a lock and its unlock can have them released
+    (ReleaseHeld) instead of leaving the record locked for every later request
+  - A view shares every lock with the manager it came from, only the bookkeeping is its own
+  - TrackRWMutex does the same for a plain RWMutex shared by every connection (the server's
+    pokemon lock), handlers take it as an RWLocker so either kind can be passed
+  - WLockRecordTimeout, a record write lock that gives up with ErrLockTimeout after a while
+*/
+package recordlib
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+var ErrLockTimeout = fmt.Errorf("timed out waiting for record lock")
+
+//how often WLockRecordTimeout retries the global read lock while ReadAll holds it
+const lock_poll_interval = time.Millisecond
+
+type held_kind int
+
+const (
+	held_read     held_kind = iota //RLockRecord
+	held_write                     //WLockRecord, WLockRecords (global read lock plus each record)
+	held_read_all                  //LockReadAll
+	held_append                    //LockAppend
+)
+
+type held_entry struct {
+	kind held_kind
+	ids  []uint16 //record IDs, sorted unique for held_write
+}
+
+//locks taken through one tracked view, in the order they were taken
+type held_locks struct {
+	lock    sync.Mutex
+	entries []held_entry
+}
+
+/*
+Function Name:  add
+Description:    method of held_locks
+				records a lock that was just taken, no-op on a nil
+				(untracked) manager
+Parameters:     kind: which lock call
+				ids: record IDs it locked, none for read-all and append
+Return Value:   n/a
+Type:           held_kind, ...uint16 -> n/a
+*/
+func (h *held_locks) add(kind held_kind, ids ...uint16) {
+	if h == nil {
+		return
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.entries = append(h.entries, held_entry{kind: kind, ids: ids})
+}
+
+/*
+Function Name:  remove
+Description:    method of held_locks
+				forgets the latest matching lock after it was released,
+				nothing matches once ReleaseHeld has taken the entries
+Parameters:     kind: which lock call
+				ids: record IDs it locked
+Return Value:   n/a
+Type:           held_kind, ...uint16 -> n/a
+*/
+func (h *held_locks) remove(kind held_kind, ids ...uint16) {
+	if h == nil {
+		return
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for idx := len(h.entries) - 1; idx >= 0; idx-- {
+		if h.entries[idx].kind == kind && slices.Equal(h.entries[idx].ids, ids) {
+			h.entries = slices.Delete(h.entries, idx, idx+1)
+			return
+		}
+	}
+}
+
+/*
+Function Name:  Track
+Description:    method of GlobalManager
+				view of the manager for one connection, locking through it
+				is locking the manager itself, it also remembers what is
+				held for ReleaseHeld, meant for a single goroutine
+Parameters:     n/a
+Return Value:   the tracked view
+Type:           n/a -> *GlobalManager
+*/
+func (m *GlobalManager) Track() *GlobalManager {
+	view := *m
+	view.held = &held_locks{}
+	return &view
+}
+
+/*
+Function Name:  ReleaseHeld
+Description:    method of GlobalManager
+				releases every lock still held through a tracked view, the
+				latest first, for a handler that panicked mid-operation,
+				no-op on an untracked manager
+Parameters:     n/a
+Return Value:   number of lock calls undone
+Type:           n/a -> int
+*/
+func (m *GlobalManager) ReleaseHeld() int {
+	if m.held == nil {
+		return 0
+	}
+	m.held.lock.Lock()
+	entries := m.held.entries
+	m.held.entries = nil
+	m.held.lock.Unlock()
+
+	for idx := len(entries) - 1; idx >= 0; idx-- {
+		entry := entries[idx]
+		switch entry.kind {
+		case held_read:
+			m.RUnlockRecord(entry.ids[0])
+		case held_write:
+			m.WUnlockRecords(entry.ids...)
+		case held_read_all:
+			m.UnlockReadAll()
+		case held_append:
+			m.UnlockAppend()
+		}
+	}
+	return len(entries)
+}
+
+/*
+Function Name:  LockAppend
+Description:    method of GlobalManager
+				global read lock then AppendLock, for posts
+Parameters:     n/a
+Return Value:   n/a
+Type:           n/a -> n/a
+*/
+func (m *GlobalManager) LockAppend() {
+	m.GlobalLock.RLock()
+	m.AppendLock.Lock()
+	m.held.add(held_append)
+}
+
+/*
+Function Name:  UnlockAppend
+Description:    method of GlobalManager
+				releases the locks taken by LockAppend
+Parameters:     n/a
+Return Value:   n/a
+Type:           n/a -> n/a
+*/
+func (m *GlobalManager) UnlockAppend() {
+	m.AppendLock.Unlock()
+	m.GlobalLock.RUnlock()
+	m.held.remove(held_append)
+}
+
+/*
+Function Name:  WLockRecordTimeout
+Description:    method of GlobalManager
+				WLockRecord that gives up after d, the wait for the global
+				read lock (behind a ReadAll) counts toward d, release with
+				WUnlockRecord as usual
+Parameters:     id: trainer record id
+				d: longest time to wait
+Return Value:   nil once locked, ErrLockTimeout with nothing held otherwise
+Type:           uint16, time.Duration -> error
+*/
+func (m *GlobalManager) WLockRecordTimeout(id uint16, d time.Duration) error {
+	deadline := time.Now().Add(d)
+	for !m.GlobalLock.TryRLock() {
+		if !time.Now().Before(deadline) {
+			return ErrLockTimeout
+		}
+		time.Sleep(lock_poll_interval)
+	}
+	if !m.wlock_record(id, deadline) {
+		m.GlobalLock.RUnlock()
+		return ErrLockTimeout
+	}
+	m.held.add(held_write, id)
+	return nil
+}
+
+//an RWMutex as request handlers use it, *sync.RWMutex or a *TrackedRWMutex view of one
+type RWLocker interface {
+	Lock()
+	Unlock()
+	RLock()
+	RUnlock()
+}
+
+//view of a shared RWMutex for one connection, counts what is held through it
+type TrackedRWMutex struct {
+	mu      *sync.RWMutex
+	lock    sync.Mutex //guards the counts
+	readers int        //read locks held through this view
+	writer  bool       //write lock held through this view
+}
+
+/*
+Function Name:  TrackRWMutex
+Description:    view of mu for one connection, locking through it is locking
+				mu itself, it also remembers what is held for ReleaseHeld
+Parameters:     mu: the shared lock
+Return Value:   the tracked view
+Type:           *sync.RWMutex -> *TrackedRWMutex
+*/
+func TrackRWMutex(mu *sync.RWMutex) *TrackedRWMutex {
+	return &TrackedRWMutex{mu: mu}
+}
+
+/*
+Function Name:  RLock
+Description:    method of TrackedRWMutex
+				read locks the shared lock
+Parameters:     n/a
+Return Value:   n/a
+Type:           n/a -> n/a
+*/
+func (t *TrackedRWMutex) RLock() {
+	t.mu.RLock()
+	t.lock.Lock()
+	t.readers++
+	t.lock.Unlock()
+}
+
+/*
+Function Name:  RUnlock
+Description:    method of TrackedRWMutex
+				releases one read lock taken through this view
+Parameters:     n/a
+Return Value:   n/a
+Type:           n/a -> n/a
+*/
+func (t *TrackedRWMutex) RUnlock() {
+	t.lock.Lock()
+	t.readers--
+	t.lock.Unlock()
+	t.mu.RUnlock()
+}
+
+/*
+Function Name:  Lock
+Description:    method of TrackedRWMutex
+				write locks the shared lock
+Parameters:     n/a
+Return Value:   n/a
+Type:           n/a -> n/a
+*/
+func (t *TrackedRWMutex) Lock() {
+	t.mu.Lock()
+	t.lock.Lock()
+	t.writer = true
+	t.lock.Unlock()
+}
+
+/*
+Function Name:  Unlock
+Description:    method of TrackedRWMutex
+				releases the write lock taken through this view
+Parameters:     n/a
+Return Value:   n/a
+Type:           n/a -> n/a
+*/
+func (t *TrackedRWMutex) Unlock() {
+	t.lock.Lock()
+	t.writer = false
+	t.lock.Unlock()
+	t.mu.Unlock()
+}
+
+/*
+Function Name:  ReleaseHeld
+Description:    method of TrackedRWMutex
+				releases every lock still held through this view, for a
+				handler that panicked or returned between lock and unlock
+Parameters:     n/a
+Return Value:   number of lock calls undone
+Type:           n/a -> int
+*/
+func (t *TrackedRWMutex) ReleaseHeld() int {
+	t.lock.Lock()
+	readers, writer := t.readers, t.writer
+	t.readers, t.writer = 0, false
+	t.lock.Unlock()
+
+	for idx := 0; idx < readers; idx++ {
+		t.mu.RUnlock()
+	}
+	if writer {
+		t.mu.Unlock()
+		return readers + 1
+	}
+	return readers
+}
@@ -0,0 +1,113 @@
+/*
+Filename:  lock_track_test.go
+Description:
+  - Tests for tracked GlobalManager / RWMutex views and WLockRecordTimeout
+*/
+package recordlib
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+/*
+Function Name:  write_and_panic
+Description:    takes the locks a trainer put takes through tracked views,
+				panics before unlocking, and recovers the way handle_client
+				does, releasing what the views hold
+Parameters:     gm: tracked manager view
+				poke_lock: tracked pokemon lock view
+				id: record to lock
+Return Value:   lock calls ReleaseHeld undid
+Type:           *GlobalManager, *TrackedRWMutex, uint16 -> int
+*/
+func write_and_panic(gm *GlobalManager, poke_lock *TrackedRWMutex, id uint16) (released int) {
+	defer func() {
+		recover()
+		released = poke_lock.ReleaseHeld() + gm.ReleaseHeld()
+	}()
+	gm.WLockRecord(id)
+	poke_lock.RLock()
+	panic("mid-write")
+}
+
+/*
+Function Name:  TestPanicMidWriteReleases
+Description:    after a panic between lock and unlock the record can be
+				locked again by another connection, the global lock is free
+				for ReadAll and the pokemon lock for a writer
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestPanicMidWriteReleases(t *testing.T) {
+	for _, policy := range []LockPolicy{LockWriterFirst, LockReaderFirst, LockFIFO} {
+		gm := NewGlobalManager(policy)
+		var poke_lock sync.RWMutex
+		if released := write_and_panic(gm.Track(), TrackRWMutex(&poke_lock), 7); released != 2 {
+			t.Fatalf("%v: released %d lock calls, want 2", policy, released)
+		}
+
+		other := gm.Track()
+		if err := other.WLockRecordTimeout(7, 100*time.Millisecond); err != nil {
+			t.Fatalf("%v: record 7 after the panic: %v", policy, err)
+		}
+		other.WUnlockRecord(7)
+		if !gm.GlobalLock.TryLock() {
+			t.Fatalf("%v: global read lock still held after the panic", policy)
+		}
+		gm.GlobalLock.Unlock()
+		if !poke_lock.TryLock() {
+			t.Fatalf("%v: pokemon read lock still held after the panic", policy)
+		}
+		poke_lock.Unlock()
+		if other.ReleaseHeld() != 0 {
+			t.Fatalf("%v: clean lock and unlock left entries behind", policy)
+		}
+	}
+}
+
+/*
+Function Name:  TestWLockRecordTimeout
+Description:    a write lock on a held record times out with nothing held,
+				behind a ReadAll too, and succeeds once the record is free
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestWLockRecordTimeout(t *testing.T) {
+	gm := NewGlobalManager(LockWriterFirst)
+	gm.RLockRecord(3)
+	start := time.Now()
+	if err := gm.WLockRecordTimeout(3, 20*time.Millisecond); err != ErrLockTimeout {
+		t.Fatalf("held by a reader: %v, want ErrLockTimeout", err)
+	}
+	if waited := time.Since(start); waited < 20*time.Millisecond || waited > time.Second {
+		t.Fatalf("timed out after %v, want about 20ms", waited)
+	}
+	//the timed out writer left the queue, under writer-first a new reader would wait behind it
+	got := make(chan struct{})
+	go func() {
+		gm.RLockRecord(3)
+		gm.RUnlockRecord(3)
+		close(got)
+	}()
+	select {
+	case <-got:
+	case <-time.After(time.Second):
+		t.Fatal("reader blocked behind a writer that timed out")
+	}
+	gm.RUnlockRecord(3)
+
+	gm.LockReadAll()
+	if err := gm.WLockRecordTimeout(3, 20*time.Millisecond); err != ErrLockTimeout {
+		t.Fatalf("behind ReadAll: %v, want ErrLockTimeout", err)
+	}
+	gm.UnlockReadAll()
+
+	if err := gm.WLockRecordTimeout(3, time.Second); err != nil {
+		t.Fatalf("free record: %v", err)
+	}
+	gm.WUnlockRecord(3)
+}
@@ -0,0 +1,138 @@
+/*
+Filename:  log_test.go
+Description:
+  - Log tail reads: LogReadN on small files and lines around its chunk boundary, and
+    LogReadNAcrossFiles over a current and a rotated log, in a temporary directory
+*/
+package recordlib
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/*
+Function Name:  TestLogReadN
+Description:    LogReadN on an empty file, files with and without a final
+				newline, n past the line count, and lines that straddle or
+				end exactly on a log_chunk_size boundary gives the same last
+				n lines as splitting the whole file (tail_lines)
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestLogReadN(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.log")
+	read := func(data string, n int) string {
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		log_file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer log_file.Close()
+		out, err := LogReadN(log_file, n)
+		if err != nil {
+			t.Fatalf("LogReadN(%d) on %d bytes: %v", n, len(data), err)
+		}
+		return out
+	}
+
+	if out := read("", 5); out != "Log file empty." {
+		t.Fatalf("empty log: %q, want Log file empty.", out)
+	}
+	tests := []struct {
+		data string
+		n    int
+		want string
+	}{
+		{"one\n", 1, "one\n"},
+		{"one", 1, "one\n"},
+		{"one\ntwo", 1, "two\n"},
+		{"one\ntwo\nthree\n", 2, "two\nthree\n"},
+		{"one\ntwo\nthree", 10, "one\ntwo\nthree\n"},
+		{"\n", 1, "\n"},
+		{"one\n\nthree\n", 2, "\nthree\n"},
+	}
+	for _, tt := range tests {
+		if out := read(tt.data, tt.n); out != tt.want {
+			t.Fatalf("last %d of %q: %q, want %q", tt.n, tt.data, out, tt.want)
+		}
+	}
+
+	//long lines put newlines just before, on and just after each chunk boundary
+	for _, size := range []int{log_chunk_size - 1, log_chunk_size, log_chunk_size + 1, 2*log_chunk_size + 3} {
+		line := strings.Repeat("x", size-1) + "\n" //size bytes with its newline
+		for _, data := range []string{
+			"first\n" + line + line + "last\n",
+			line + line + line,
+			"a\n" + strings.TrimSuffix(line+line, "\n"),
+		} {
+			for _, n := range []int{1, 2, 3, 4, 10} {
+				if out, want := read(data, n), tail_lines([]byte(data), n); out != want {
+					t.Fatalf("last %d of %d bytes with %d byte lines: %d bytes, want %d", n, len(data), size, len(out), len(want))
+				}
+			}
+		}
+	}
+}
+
+/*
+Function Name:  TestLogReadNAcrossFiles
+Description:    the last n lines across <base>.1 and base come oldest first,
+				spanning both files when n is larger than the current log,
+				a rotated file without a final newline doesn't run into the
+				current one, and a missing or empty file is just skipped
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestLogReadNAcrossFiles(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "server.log")
+	write := func(name, data string) {
+		if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(n int) string {
+		out, err := LogReadNAcrossFiles(base, n)
+		if err != nil {
+			t.Fatalf("LogReadNAcrossFiles(%d): %v", n, err)
+		}
+		return out
+	}
+
+	if out := read(5); out != "Log file empty." {
+		t.Fatalf("no log files: %q, want Log file empty.", out)
+	}
+	write(base, "c1\nc2\n")
+	if out := read(5); out != "c1\nc2\n" {
+		t.Fatalf("no rotated file: %q", out)
+	}
+
+	write(base+".1", "r1\nr2\nr3") //rotated mid-line, no final newline
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{1, "c2\n"},
+		{2, "c1\nc2\n"},
+		{3, "r3\nc1\nc2\n"},
+		{4, "r2\nr3\nc1\nc2\n"},
+		{5, "r1\nr2\nr3\nc1\nc2\n"},
+		{50, "r1\nr2\nr3\nc1\nc2\n"},
+	}
+	for _, tt := range tests {
+		if out := read(tt.n); out != tt.want {
+			t.Fatalf("last %d across both files: %q, want %q", tt.n, out, tt.want)
+		}
+	}
+
+	write(base, "") //just rotated, nothing logged since
+	if out := read(2); out != "r2\nr3\n" {
+		t.Fatalf("empty current log: %q, want the rotated tail", out)
+	}
+}
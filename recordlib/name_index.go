@@ -0,0 +1,198 @@
+/*
+Filename:  name_index.go
+Description:
+  - In-memory pokemon name index (id/name pairs in id order) served to clients
+  - Can be dumped to disk and reloaded so a restart skips the full record scan
+  - A dump records the pokemon file size, modification time and CRC32, a stale dump is rebuilt
+  - Load compares size and mtime first and only reads the whole file for its CRC32 when the
+    size matches but the mtime doesn't (a touch, a copy), so a restart on an unchanged file
+    reads just the dump
+*/
+package recordlib
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+var ErrIndexStale = fmt.Errorf("name index does not match pokemon file")
+
+type PokeNameIndex struct {
+	lock  sync.RWMutex
+	names []PokeName
+	size  int64  //pokemon file size when built
+	mtime int64  //pokemon file modification time when built, Unix nanoseconds
+	crc   uint32 //pokemon file CRC32 when built
+}
+
+//on-disk form of a PokeNameIndex
+type poke_name_index_file struct {
+	Size  int64      `json:"size"`
+	MTime int64      `json:"mtime"` //0 in dumps from before it was recorded, the CRC decides
+	CRC   uint32     `json:"crc32"`
+	Names []PokeName `json:"names"`
+}
+
+/*
+Function Name:  poke_file_sum
+Description:    computes the size and CRC32 of the pokemon file, used to
+				check that an index on disk still matches the data
+Parameters:     poke_file: the pokemon binary data file
+Return Value:   file size, CRC32 and error (if any)
+Type:           *os.File -> int64, uint32, error
+*/
+func poke_file_sum(poke_file *os.File) (int64, uint32, error) {
+	info, err := poke_file.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	hash := crc32.NewIEEE()
+	if _, err := io.Copy(hash, io.NewSectionReader(poke_file, 0, info.Size())); err != nil {
+		return 0, 0, err
+	}
+	return info.Size(), hash.Sum32(), nil
+}
+
+/*
+Function Name:  NewPokeNameIndex
+Description:    builds a name index with a full scan of the pokemon file
+Parameters:     poke_file: the pokemon binary data file
+Return Value:   newly built index and error (if any)
+Type:           *os.File -> *PokeNameIndex, error
+*/
+func NewPokeNameIndex(poke_file *os.File) (*PokeNameIndex, error) {
+	idx := &PokeNameIndex{}
+	if err := idx.Rebuild(poke_file); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+/*
+Function Name:  Rebuild
+Description:    method of PokeNameIndex
+				replaces the index contents with a full scan of the pokemon file
+Parameters:     poke_file: the pokemon binary data file
+Return Value:   nil or read error (index unchanged on error)
+Type:           *os.File -> error
+*/
+func (idx *PokeNameIndex) Rebuild(poke_file *os.File) error {
+	info, err := poke_file.Stat()
+	if err != nil {
+		return err
+	}
+	size, crc, err := poke_file_sum(poke_file)
+	if err != nil {
+		return err
+	}
+	names, err := PokeNameList(poke_file)
+	if err != nil {
+		return err
+	}
+	idx.lock.Lock()
+	idx.names, idx.size, idx.mtime, idx.crc = names, size, info.ModTime().UnixNano(), crc
+	idx.lock.Unlock()
+	return nil
+}
+
+/*
+Function Name:  Names
+Description:    method of PokeNameIndex
+				returns the indexed id/name pairs in id order, must not be modified
+Parameters:     n/a
+Return Value:   list of id/name pairs
+Type:           n/a -> []PokeName
+*/
+func (idx *PokeNameIndex) Names() []PokeName {
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+	return idx.names
+}
+
+/*
+Function Name:  Dump
+Description:    method of PokeNameIndex
+				writes the index with its pokemon file size, mtime and CRC32 to path,
+				written to a temp file first then renamed over path
+Parameters:     path: index file to write
+Return Value:   nil or write error
+Type:           string -> error
+*/
+func (idx *PokeNameIndex) Dump(path string) error {
+	idx.lock.RLock()
+	data, err := json.Marshal(poke_name_index_file{Size: idx.size, MTime: idx.mtime, CRC: idx.crc, Names: idx.names})
+	idx.lock.RUnlock()
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+/*
+Function Name:  Load
+Description:    method of PokeNameIndex
+				replaces the index contents with the dump at path, the dump
+				is used if the pokemon file's size and mtime match it, a
+				different size is stale without reading the file, a
+				different mtime falls back to comparing the CRC32
+Parameters:     path: index file written by Dump
+				poke_file: the pokemon binary data file
+Return Value:   nil, ErrIndexStale, or read/decode error (index unchanged on error)
+Type:           string, *os.File -> error
+*/
+func (idx *PokeNameIndex) Load(path string, poke_file *os.File) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var dump poke_name_index_file
+	if err := json.Unmarshal(data, &dump); err != nil {
+		return err
+	}
+	info, err := poke_file.Stat()
+	if err != nil {
+		return err
+	}
+	mtime := info.ModTime().UnixNano()
+	if dump.Size != info.Size() {
+		return ErrIndexStale
+	}
+	if dump.MTime != mtime {
+		_, crc, err := poke_file_sum(poke_file)
+		if err != nil {
+			return err
+		}
+		if dump.CRC != crc {
+			return ErrIndexStale
+		}
+	}
+	idx.lock.Lock()
+	idx.names, idx.size, idx.mtime, idx.crc = dump.Names, dump.Size, mtime, dump.CRC
+	idx.lock.Unlock()
+	return nil
+}
+
+/*
+Function Name:  LoadOrRebuild
+Description:    method of PokeNameIndex
+				loads the dump at path, falling back to a full rescan if the
+				dump is missing, unreadable or stale
+Parameters:     path: index file written by Dump
+				poke_file: the pokemon binary data file
+Return Value:   true if loaded from the dump, false if rebuilt, and rebuild error (if any)
+Type:           string, *os.File -> bool, error
+*/
+func (idx *PokeNameIndex) LoadOrRebuild(path string, poke_file *os.File) (bool, error) {
+	if err := idx.Load(path, poke_file); err == nil {
+		return true, nil
+	}
+	return false, idx.Rebuild(poke_file)
+}
@@ -0,0 +1,118 @@
+/*
+Filename:  name_index_test.go
+Description:
+  - Dump and reload of the pokemon name index against a copy of the bundled poke.bin, the
+    way a server restart goes through LoadOrRebuild
+*/
+package recordlib
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+/*
+Function Name:  temp_poke_copy
+Description:    copies the bundled poke.bin into the test's temporary directory
+Parameters:     t: test handle, the copy is closed when the test ends
+Return Value:   the copy, open read/write, and its path
+Type:           *testing.T -> *os.File, string
+*/
+func temp_poke_copy(t *testing.T) (*os.File, string) {
+	t.Helper()
+	data, err := os.ReadFile("../poke.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "poke.bin")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	poke_file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { poke_file.Close() })
+	return poke_file, path
+}
+
+/*
+Function Name:  TestNameIndexReload
+Description:    a dump reloads after a restart, still reloads after a touch
+				(mtime moved, CRC32 the same), is stale after a same-size
+				edit or an append, and a dump without an mtime is checked by
+				its CRC32
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestNameIndexReload(t *testing.T) {
+	poke_file, poke_path := temp_poke_copy(t)
+	built, err := NewPokeNameIndex(poke_file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dump_path := poke_path + ".idx"
+	if err := built.Dump(dump_path); err != nil {
+		t.Fatal(err)
+	}
+
+	//restart: a fresh index comes from the dump, equal to the scan
+	restarted := &PokeNameIndex{}
+	if loaded, err := restarted.LoadOrRebuild(dump_path, poke_file); !loaded || err != nil {
+		t.Fatalf("restart: loaded %v, %v, want the dump", loaded, err)
+	}
+	if !slices.Equal(restarted.Names(), built.Names()) {
+		t.Fatal("reloaded names differ from the scan")
+	}
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(poke_path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&PokeNameIndex{}).Load(dump_path, poke_file); err != nil {
+		t.Fatalf("after a touch: %v, want the dump (same CRC32)", err)
+	}
+
+	legacy := &PokeNameIndex{names: built.names, size: built.size, crc: built.crc} //dump from before mtimes
+	if err := legacy.Dump(dump_path); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&PokeNameIndex{}).Load(dump_path, poke_file); err != nil {
+		t.Fatalf("dump without an mtime: %v, want the dump", err)
+	}
+	if err := built.Dump(dump_path); err != nil {
+		t.Fatal(err)
+	}
+
+	//same size, one name byte changed, the edit moves the mtime
+	if _, err := poke_file.WriteAt([]byte("Q"), 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(poke_path, later.Add(time.Hour), later.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&PokeNameIndex{}).Load(dump_path, poke_file); err != ErrIndexStale {
+		t.Fatalf("after a same-size edit: %v, want ErrIndexStale", err)
+	}
+	rebuilt := &PokeNameIndex{}
+	if loaded, err := rebuilt.LoadOrRebuild(dump_path, poke_file); loaded || err != nil {
+		t.Fatalf("after a same-size edit: loaded %v, %v, want a rebuild", loaded, err)
+	}
+	if name := rebuilt.Names()[0].Name; name[0] != 'Q' {
+		t.Fatalf("rebuilt index has %q for pokemon 1, want the edit", name)
+	}
+
+	if err := rebuilt.Dump(dump_path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := poke_file.WriteAt(make([]byte, PokeRecSize), int64(PokeRecSize*len(rebuilt.Names()))); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&PokeNameIndex{}).Load(dump_path, poke_file); err != ErrIndexStale {
+		t.Fatalf("after an append: %v, want ErrIndexStale", err)
+	}
+}
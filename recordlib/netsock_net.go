@@ -0,0 +1,67 @@
+/*
+Filename:  netsock_net.go
+Description:
+  - Transport built on Go's net package (net.Listener/net.Conn), on every platform
+  - The only transport off unix (netsock_other.go), on unix chosen with -transport net
+    instead of the raw syscall path, connections then get deadlines from the runtime poller
+  - Listener.Accept/Close use it whenever the Listener holds a net.Listener
+*/
+package recordlib
+
+import (
+	"fmt"
+	"net"
+)
+
+/*
+Function Name:  ListenNet
+Description:    listens for TCP connections on host:port with the net package
+Parameters:     host: IPv4 address to bind
+				port: port to bind
+Return Value:   listening socket and error (if any)
+Type:           [4]byte, int -> *Listener, error
+*/
+func ListenNet(host [4]byte, port int) (*Listener, error) {
+	addr := &net.TCPAddr{IP: net.IP(host[:]), Port: port}
+	ln, err := net.ListenTCP("tcp4", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on socket: %v", err)
+	}
+	return &Listener{ln: ln}, nil
+}
+
+/*
+Function Name:  accept_net
+Description:    blocks until a client connects to a net package listener
+Parameters:     ln: listener from ListenNet
+Return Value:   client stream, client address, client port and error (if any)
+Type:           net.Listener -> Conn, [4]byte, int, error
+*/
+func accept_net(ln net.Listener) (Conn, [4]byte, int, error) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return nil, [4]byte{}, 0, err
+	}
+
+	var host [4]byte
+	tcp_addr := conn.RemoteAddr().(*net.TCPAddr)
+	copy(host[:], tcp_addr.IP.To4())
+	return conn, host, tcp_addr.Port, nil
+}
+
+/*
+Function Name:  DialNet
+Description:    connects to host:port over TCP with the net package
+Parameters:     host: IPv4 address of server
+				port: port of server
+Return Value:   server stream and error (if any)
+Type:           [4]byte, int -> Conn, error
+*/
+func DialNet(host [4]byte, port int) (Conn, error) {
+	addr := &net.TCPAddr{IP: net.IP(host[:]), Port: port}
+	conn, err := net.DialTCP("tcp4", nil, addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to server: %v", err)
+	}
+	return conn, nil
+}
@@ -0,0 +1,126 @@
+//go:build !unix
+
+/*
+Filename:  netsock_other.go
+Description:
+  - Portable fallback transport for non-unix systems (development and testing)
+  - Same API as netsock_unix.go built on the net package instead of raw syscalls,
+    Listen and Dial are the netsock_net.go transport
+*/
+package recordlib
+
+import (
+	"errors"
+	"net"
+	"os"
+	"time"
+)
+
+//connection stream to a peer
+type Conn = net.Conn
+
+type Listener struct {
+	ln net.Listener
+}
+
+/*
+Function Name:  OpenFile
+Description:    opens file with os.OpenFile
+Parameters:     name: path of file
+				flags: os.O_* open flags
+				perm: permission bits used when file is created
+Return Value:   opened file and error (if any)
+Type:           string, int, uint32 -> *os.File, error
+*/
+func OpenFile(name string, flags int, perm uint32) (*os.File, error) {
+	return os.OpenFile(name, flags, os.FileMode(perm))
+}
+
+/*
+Function Name:  Listen
+Description:    listens for TCP connections on host:port
+Parameters:     host: IPv4 address to bind
+				port: port to bind
+				backlog: unused, the net package picks the backlog
+Return Value:   listening socket and error (if any)
+Type:           [4]byte, int, int -> *Listener, error
+*/
+func Listen(host [4]byte, port int, backlog int) (*Listener, error) {
+	return ListenNet(host, port)
+}
+
+/*
+Function Name:  Accept
+Description:    method of Listener
+				blocks until a client connects
+Parameters:     n/a
+Return Value:   client stream, client address, client port and error (if any)
+Type:           n/a -> Conn, [4]byte, int, error
+*/
+func (l *Listener) Accept() (Conn, [4]byte, int, error) {
+	return accept_net(l.ln)
+}
+
+/*
+Function Name:  IsRetriableAcceptError
+Description:    reports whether an Accept error is transient so the
+				listener is still usable and Accept can be retried
+Parameters:     err: error returned by Accept
+Return Value:   true if Accept should be retried
+Type:           error -> bool
+*/
+func IsRetriableAcceptError(err error) bool {
+	var net_err net.Error
+	return errors.As(err, &net_err) && net_err.Timeout()
+}
+
+/*
+Function Name:  Close
+Description:    method of Listener
+				closes listening socket, blocked Accept returns error
+Parameters:     n/a
+Return Value:   error (if any)
+Type:           n/a -> error
+*/
+func (l *Listener) Close() error {
+	return l.ln.Close()
+}
+
+/*
+Function Name:  SetReadTimeout
+Description:    makes reads taking longer than timeout from now fail,
+				a zero timeout blocks forever again
+Parameters:     conn: connection stream
+				timeout: max time a read may block, 0 for no limit
+Return Value:   error (if any)
+Type:           Conn, time.Duration -> error
+*/
+func SetReadTimeout(conn Conn, timeout time.Duration) error {
+	if timeout == 0 {
+		return conn.SetReadDeadline(time.Time{})
+	}
+	return conn.SetReadDeadline(time.Now().Add(timeout))
+}
+
+/*
+Function Name:  Dial
+Description:    connects to host:port over TCP
+Parameters:     host: IPv4 address of server
+				port: port of server
+Return Value:   server stream and error (if any)
+Type:           [4]byte, int -> Conn, error
+*/
+func Dial(host [4]byte, port int) (Conn, error) {
+	return DialNet(host, port)
+}
+
+/*
+Function Name:  net_conn
+Description:    the stream for crypto/tls, already a net.Conn here
+Parameters:     conn: stream from Accept or Dial
+Return Value:   network connection and error (always nil)
+Type:           Conn -> net.Conn, error
+*/
+func net_conn(conn Conn) (net.Conn, error) {
+	return conn, nil
+}
@@ -0,0 +1,240 @@
+//go:build unix
+
+/*
+Filename:  netsock_unix.go
+Description:
+  - Raw syscall transport for unix systems (the production path)
+  - Creates, binds, listens, accepts and connects IPv4 stream sockets with golang.org/x/sys/unix
+  - Wraps file and socket descriptors into *os.File streams
+  - ListenNet/DialNet (netsock_net.go) are the alternative net package transport, a
+    Listener from ListenNet holds ln and its fd is unused
+  - A TLS stream (see tls.go) runs over the socket turned into a net.Conn, so Conn is an
+    interface here and most connections are still a plain *os.File
+*/
+package recordlib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+//connection stream to a peer, a socket fd wrapped in a file on unix, or TLS over it
+type Conn = io.ReadWriteCloser
+
+type Listener struct {
+	fd int
+	ln net.Listener //set by ListenNet, nil for the syscall path
+}
+
+/*
+Function Name:  OpenFile
+Description:    opens file with unix.Open and wraps descriptor into os.File
+Parameters:     name: path of file
+				flags: os.O_* open flags (same values as unix.O_*)
+				perm: permission bits used when file is created
+Return Value:   opened file and error (if any)
+Type:           string, int, uint32 -> *os.File, error
+*/
+func OpenFile(name string, flags int, perm uint32) (*os.File, error) {
+	fd, err := unix.Open(name, flags, perm)
+	if err != nil {
+		return nil, err
+	}
+	file := os.NewFile(uintptr(fd), name)
+	if file == nil {
+		if err := unix.Close(fd); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to wrap fd into File")
+	}
+	return file, nil
+}
+
+/*
+Function Name:  Listen
+Description:    creates IPv4 stream socket bound to host:port and listens on it
+Parameters:     host: IPv4 address to bind
+				port: port to bind
+				backlog: listen backlog
+Return Value:   listening socket and error (if any)
+Type:           [4]byte, int, int -> *Listener, error
+*/
+func Listen(host [4]byte, port int, backlog int) (*Listener, error) {
+	sock_fd, err := unix.Socket(unix.AF_INET, unix.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create socket: %v", err)
+	}
+
+	addr := &unix.SockaddrInet4{Addr: host, Port: port}
+	if err := unix.Bind(sock_fd, addr); err != nil {
+		unix.Close(sock_fd)
+		return nil, fmt.Errorf("failed to bind socket: %v", err)
+	}
+	if err := unix.Listen(sock_fd, backlog); err != nil {
+		unix.Close(sock_fd)
+		return nil, fmt.Errorf("failed to listen on socket: %v", err)
+	}
+	return &Listener{fd: sock_fd}, nil
+}
+
+/*
+Function Name:  Accept
+Description:    method of Listener
+				blocks until a client connects, wraps client fd into a Conn
+				(from ListenNet the client is a net.Conn instead)
+Parameters:     n/a
+Return Value:   client stream, client address, client port and error (if any)
+Type:           n/a -> Conn, [4]byte, int, error
+*/
+func (l *Listener) Accept() (Conn, [4]byte, int, error) {
+	if l.ln != nil {
+		return accept_net(l.ln)
+	}
+	client_fd, client_addr, err := unix.Accept(l.fd)
+	if err != nil {
+		return nil, [4]byte{}, 0, err
+	}
+
+	inet4, ok := client_addr.(*unix.SockaddrInet4)
+	if !ok {
+		unix.Close(client_fd)
+		return nil, [4]byte{}, 0, fmt.Errorf("client address is not IPv4")
+	}
+	client_sock := os.NewFile(uintptr(client_fd), "client_sock")
+	if client_sock == nil {
+		unix.Close(client_fd)
+		return nil, [4]byte{}, 0, fmt.Errorf("failed to wrap client fd into File")
+	}
+	return client_sock, inet4.Addr, inet4.Port, nil
+}
+
+/*
+Function Name:  IsRetriableAcceptError
+Description:    reports whether an Accept error is transient (interrupted
+				call, aborted connection, out of fds or buffers) so the
+				listener is still usable and Accept can be retried
+Parameters:     err: error returned by Accept
+Return Value:   true if Accept should be retried
+Type:           error -> bool
+*/
+func IsRetriableAcceptError(err error) bool {
+	for _, errno := range []unix.Errno{unix.EINTR, unix.EAGAIN, unix.ECONNABORTED,
+		unix.EMFILE, unix.ENFILE, unix.ENOBUFS, unix.ENOMEM, unix.EPROTO} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Function Name:  Close
+Description:    method of Listener
+				shuts down then closes listening socket, the shutdown is
+				what wakes an Accept blocked in another thread (close alone
+				doesn't on linux), blocked Accept returns error, a ListenNet
+				listener is just closed
+Parameters:     n/a
+Return Value:   error (if any)
+Type:           n/a -> error
+*/
+func (l *Listener) Close() error {
+	if l.ln != nil {
+		return l.ln.Close()
+	}
+	unix.Shutdown(l.fd, unix.SHUT_RDWR) //fails harmlessly if never connected
+	return unix.Close(l.fd)
+}
+
+/*
+Function Name:  SetReadTimeout
+Description:    sets SO_RCVTIMEO so a read blocked longer than timeout fails
+				(EAGAIN), the fd is blocking so file deadlines don't apply,
+				a zero timeout blocks forever again, a TLS or net transport
+				stream gets a read deadline instead
+Parameters:     conn: connection stream
+				timeout: max time a read may block, 0 for no limit
+Return Value:   error (if any)
+Type:           Conn, time.Duration -> error
+*/
+func SetReadTimeout(conn Conn, timeout time.Duration) error {
+	file, ok := conn.(*os.File)
+	if !ok { //TLS or ListenNet/DialNet, a net.Conn has deadlines
+		nc := conn.(net.Conn)
+		if timeout == 0 {
+			return nc.SetReadDeadline(time.Time{})
+		}
+		return nc.SetReadDeadline(time.Now().Add(timeout))
+	}
+	raw, err := file.SyscallConn()
+	if err != nil {
+		return err
+	}
+	tv := unix.NsecToTimeval(timeout.Nanoseconds())
+	var opt_err error
+	if err := raw.Control(func(fd uintptr) {
+		opt_err = unix.SetsockoptTimeval(int(fd), unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv)
+	}); err != nil {
+		return err
+	}
+	return opt_err
+}
+
+/*
+Function Name:  Dial
+Description:    creates IPv4 stream socket and connects to host:port
+Parameters:     host: IPv4 address of server
+				port: port of server
+Return Value:   server stream and error (if any)
+Type:           [4]byte, int -> Conn, error
+*/
+func Dial(host [4]byte, port int) (Conn, error) {
+	sock_fd, err := unix.Socket(unix.AF_INET, unix.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create socket: %v", err)
+	}
+
+	addr := &unix.SockaddrInet4{Addr: host, Port: port}
+	if err := unix.Connect(sock_fd, addr); err != nil { //handles timeout
+		unix.Close(sock_fd)
+		return nil, fmt.Errorf("failed to connect to server: %v", err)
+	}
+	sock := os.NewFile(uintptr(sock_fd), "socket")
+	if sock == nil {
+		unix.Close(sock_fd)
+		return nil, fmt.Errorf("failed to create socket stream")
+	}
+	return sock, nil //sock_fd closed on sock.Close()
+}
+
+/*
+Function Name:  net_conn
+Description:    turns a socket file into a net.Conn for crypto/tls, the fd
+				is duplicated and conn closed, so only the result is used after,
+				a net package transport stream is returned as is
+Parameters:     conn: stream from Accept or Dial
+Return Value:   network connection and error (if any)
+Type:           Conn -> net.Conn, error
+*/
+func net_conn(conn Conn) (net.Conn, error) {
+	if nc, ok := conn.(net.Conn); ok { //ListenNet/DialNet
+		return nc, nil
+	}
+	file, ok := conn.(*os.File)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("connection is not a socket file")
+	}
+	defer file.Close()
+	nc, err := net.FileConn(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap socket for TLS: %v", err)
+	}
+	return nc, nil
+}
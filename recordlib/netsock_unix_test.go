@@ -0,0 +1,57 @@
+//go:build unix
+
+/*
+Filename:  netsock_unix_test.go
+Description:
+  - Which Accept errors of the syscall and net package transports are retried
+*/
+package recordlib
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+/*
+Function Name:  TestIsRetriableAcceptError
+Description:    interrupted calls, aborted connections and running out of
+				fds or buffers are retriable bare, wrapped, as a syscall
+				error and inside a net.OpError (the net transport), a
+				closed or bad listener and other errors are not
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestIsRetriableAcceptError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"EINTR", unix.EINTR, true},
+		{"EAGAIN", unix.EAGAIN, true},
+		{"ECONNABORTED", unix.ECONNABORTED, true},
+		{"EMFILE", unix.EMFILE, true},
+		{"ENFILE", unix.ENFILE, true},
+		{"ENOBUFS", unix.ENOBUFS, true},
+		{"ENOMEM", unix.ENOMEM, true},
+		{"EPROTO", unix.EPROTO, true},
+		{"wrapped EINTR", fmt.Errorf("accept: %w", unix.EINTR), true},
+		{"syscall error EMFILE", os.NewSyscallError("accept4", unix.EMFILE), true},
+		{"net transport ECONNABORTED", &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept4", unix.ECONNABORTED)}, true},
+		{"EBADF", unix.EBADF, false},
+		{"EINVAL", unix.EINVAL, false},
+		{"closed net listener", &net.OpError{Op: "accept", Net: "tcp", Err: net.ErrClosed}, false},
+		{"other", errors.New("client address is not IPv4"), false},
+	}
+	for _, tt := range tests {
+		if got := IsRetriableAcceptError(tt.err); got != tt.want {
+			t.Fatalf("%s: %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
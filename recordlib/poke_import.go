@@ -0,0 +1,207 @@
+/*
+Filename:  poke_import.go
+Description:
+  - Builds a pokemon binary file from a JSON array of PokeRec-shaped objects (poke_import tool)
+  - Field names are the PokeRec ones (any case), text fields are JSON strings or, as the
+    server's own PokeRec JSON has them, arrays of the field's raw bytes
+  - Total is accepted and ignored (it is derived from the stats), any other unknown field is
+    refused so a misspelt field isn't silently left 0
+  - Every record is checked before anything is written (CheckPokeRec, text lengths, the
+    PATCH_POKE value ranges), the first bad one fails the whole import with its row
+  - Records are written in array order from ID 1, the file holds exactly the imported ones
+*/
+package recordlib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+)
+
+//text field, a JSON string or the raw field bytes as an array of values (how PokeRec's
+//[N]byte marshals), raw bytes are kept whole so the server's JSON imports byte for byte
+type poke_text struct {
+	value string
+	raw   bool
+}
+
+/*
+Function Name:  UnmarshalJSON
+Description:    method of poke_text
+				decodes a string, or an array of byte values kept as the
+				raw field contents
+Parameters:     data: the JSON value
+Return Value:   nil or decode error
+Type:           []byte -> error
+*/
+func (t *poke_text) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &t.value); err == nil {
+		return nil
+	}
+	var values []byte //strings were taken above, so only an array of numbers decodes here
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("text field must be a string or an array of byte values")
+	}
+	t.value, t.raw = string(values), true
+	return nil
+}
+
+//one array element, PokeRec with poke_text for the text fields
+type poke_json struct {
+	ID          uint16 //0 or the element's position (1-based)
+	Name        poke_text
+	Type1       poke_text
+	Type2       poke_text
+	HP          uint8
+	Attack      uint8
+	Defense     uint8
+	SpAtk       uint8
+	SpDef       uint8
+	Speed       uint8
+	Generation  uint8
+	IsLegendary uint8
+	Color       poke_text
+	HasGender   uint8
+	PrMale      uint8
+	EggGroup1   poke_text
+	EggGroup2   poke_text
+	HasMegaEvo  uint8
+	HeightM     uint16
+	WeightKg    uint16
+	CatchRate   uint8
+	BodyStyle   poke_text
+	Total       uint16 //in the server's PokeRec JSON, derived from the stats so ignored
+}
+
+/*
+Function Name:  set_text
+Description:    copies a text value into a fixed size text field, a string
+				must leave the last byte a null, raw field bytes may fill
+				the field (the bundled poke.bin has stray bytes after some
+				full-length values)
+Parameters:     field: name of the field (for the message)
+				dst: field bytes
+				text: new value
+Return Value:   nil or error if text doesn't fit
+Type:           string, []byte, poke_text -> error
+*/
+func set_text(field string, dst []byte, text poke_text) error {
+	switch {
+	case text.raw && len(text.value) > len(dst):
+		return fmt.Errorf("%s is %d bytes, the field holds %d", field, len(text.value), len(dst))
+	case !text.raw && len(text.value) > len(dst)-1:
+		return fmt.Errorf("%s %q longer than %d characters", field, text.value, len(dst)-1)
+	}
+	copy(dst, text.value)
+	return nil
+}
+
+/*
+Function Name:  poke_rec
+Description:    method of poke_json
+				converts a decoded element into the record for ID id and
+				checks it the way PATCH_POKE and verify would
+Parameters:     id: the element's position (1-based)
+Return Value:   the record and nil, or the first problem
+Type:           uint16 -> PokeRec, error
+*/
+func (p poke_json) poke_rec(id uint16) (PokeRec, error) {
+	if p.ID != 0 && p.ID != id {
+		return PokeRec{}, fmt.Errorf("ID %d at position of ID %d", p.ID, id)
+	}
+	rec := PokeRec{
+		ID: id, HP: p.HP, Attack: p.Attack, Defense: p.Defense, SpAtk: p.SpAtk, SpDef: p.SpDef, Speed: p.Speed,
+		Generation: p.Generation, IsLegendary: p.IsLegendary, HasGender: p.HasGender, PrMale: p.PrMale,
+		HasMegaEvo: p.HasMegaEvo, HeightM: p.HeightM, WeightKg: p.WeightKg, CatchRate: p.CatchRate,
+	}
+	for _, text := range []struct {
+		field string
+		dst   []byte
+		value poke_text
+	}{
+		{"name", rec.Name[:], p.Name},
+		{"type 1", rec.Type1[:], p.Type1},
+		{"type 2", rec.Type2[:], p.Type2},
+		{"color", rec.Color[:], p.Color},
+		{"egg group 1", rec.EggGroup1[:], p.EggGroup1},
+		{"egg group 2", rec.EggGroup2[:], p.EggGroup2},
+		{"body style", rec.BodyStyle[:], p.BodyStyle},
+	} {
+		if err := set_text(text.field, text.dst, text.value); err != nil {
+			return PokeRec{}, err
+		}
+	}
+	if problems := CheckPokeRec(rec, id); len(problems) > 0 {
+		return PokeRec{}, fmt.Errorf("%s", problems[0])
+	}
+	fields := reflect.ValueOf(rec)
+	for _, name := range slices.Sorted(maps.Keys(poke_field_ranges)) {
+		if name == "PrMale" && rec.HasGender == 0 {
+			continue //unused, genderless records in the bundled poke.bin hold stray values
+		}
+		limit := poke_field_ranges[name]
+		if value := fields.FieldByName(name).Uint(); value < limit.min || value > limit.max {
+			return PokeRec{}, fmt.Errorf("%w: %s must be %d-%d", ErrFieldRange, name, limit.min, limit.max)
+		}
+	}
+	return rec, nil
+}
+
+/*
+Function Name:  ImportPokemonJSON
+Description:    decodes a JSON array of pokemon and replaces the contents of
+				poke_file with them, in array order from ID 1, nothing is
+				written unless every element is valid
+Parameters:     poke_file: pokemon binary data file, open read/write, not in
+				use by a server
+				r: the JSON array
+Return Value:   number of pokemon written and error, *BatchError (Row is the
+				1-based element) for a bad element, a file error otherwise
+Type:           *os.File, io.Reader -> int, error
+*/
+func ImportPokemonJSON(poke_file *os.File, r io.Reader) (int, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return 0, fmt.Errorf("pokemon JSON must be an array of objects")
+	}
+
+	var recs []PokeRec
+	for dec.More() {
+		row := len(recs) + 1
+		if row > 0xFFFF {
+			return 0, &BatchError{Row: row, Err: fmt.Errorf("more pokemon than IDs")}
+		}
+		var p poke_json
+		if err := dec.Decode(&p); err != nil {
+			return 0, &BatchError{Row: row, Err: err}
+		}
+		rec, err := p.poke_rec(uint16(row))
+		if err != nil {
+			return 0, &BatchError{Row: row, Err: err}
+		}
+		recs = append(recs, rec)
+	}
+	if _, err := dec.Token(); err != nil { //closing ]
+		return 0, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return 0, fmt.Errorf("data after the pokemon array")
+	}
+
+	buf := make([]byte, 0, len(recs)*PokeRecSize)
+	for _, rec := range recs {
+		buf = append(buf, EncodePokeRec(rec)...)
+	}
+	if _, err := poke_file.WriteAt(buf, 0); err != nil {
+		return 0, err
+	}
+	if err := poke_file.Truncate(int64(len(buf))); err != nil {
+		return 0, err
+	}
+	return len(recs), poke_file.Sync()
+}
@@ -0,0 +1,122 @@
+/*
+Filename:  poke_import_test.go
+Description:
+  - ImportPokemonJSON against the bundled poke.bin: the server's own PokeRec JSON imports
+    back to the same records, and the refused inputs stay refused
+*/
+package recordlib
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/*
+Function Name:  temp_poke_file
+Description:    new empty file in the test's temporary directory to import into
+Parameters:     t: test handle, the file is closed when the test ends
+Return Value:   the file, open read/write
+Type:           *testing.T -> *os.File
+*/
+func temp_poke_file(t *testing.T) *os.File {
+	t.Helper()
+	poke_file, err := os.OpenFile(filepath.Join(t.TempDir(), "poke.bin"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { poke_file.Close() })
+	return poke_file
+}
+
+/*
+Function Name:  TestImportServerJSON
+Description:    every record of poke.bin marshalled the way the server sends
+				it (Total included, text fields as byte arrays), imported
+				into a new file, reads back from GetPokemon equal to the
+				original
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestImportServerJSON(t *testing.T) {
+	src := open_test_poke(t)
+	count, err := PokeCount(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recs := make([]PokeRec, count)
+	for idx := range recs {
+		if recs[idx], err = GetPokemon(src, uint16(idx+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := json.Marshal(recs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"Total":`) || !strings.Contains(string(data), `"Name":[`) {
+		t.Fatalf("server JSON no longer has Total and byte array names, test needs updating")
+	}
+
+	dst := temp_poke_file(t)
+	imported, err := ImportPokemonJSON(dst, strings.NewReader(string(data)))
+	if err != nil || imported != count {
+		t.Fatalf("import: %d records, %v, want %d", imported, err, count)
+	}
+	for idx, want := range recs {
+		got, err := GetPokemon(dst, uint16(idx+1))
+		if err != nil || got != want {
+			t.Fatalf("pokemon %d: %+v, %v, want %+v", idx+1, got, err, want)
+		}
+	}
+}
+
+/*
+Function Name:  TestImportJSONForms
+Description:    string text fields import like byte arrays, Total is ignored
+				even when wrong, and a misspelt field, an overlong name or
+				a byte array past the field size or a bad text value fail
+				with the element's row
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestImportJSONForms(t *testing.T) {
+	const pikachu = `"Type1":"Electric","HP":35,"Attack":55,"Defense":40,"SpAtk":50,"SpDef":50,"Speed":90,` +
+		`"Generation":1,"Color":"Yellow","HasGender":1,"PrMale":4,"EggGroup1":"Field","HeightM":40,` +
+		`"WeightKg":60,"CatchRate":190,"BodyStyle":"quadruped"`
+	tests := []struct {
+		name string
+		json string
+		row  int //0 for success
+	}{
+		{"strings", `[{"Name":"Pikachu",` + pikachu + `}]`, 0},
+		{"byte array", `[{"Name":[80,105,107,97,99,104,117,0,0,0,0,0],` + pikachu + `}]`, 0},
+		{"wrong total", `[{"Name":"Pikachu","Total":1,` + pikachu + `}]`, 0},
+		{"misspelt field", `[{"Name":"Pikachu",` + pikachu + `},{"Nmae":"Raichu",` + pikachu + `}]`, 2},
+		{"long name", `[{"Name":"Pikachupikachu",` + pikachu + `}]`, 1},
+		{"long byte array", `[{"Name":[80,105,107,97,99,104,117,0,0,0,0,0,0],` + pikachu + `}]`, 1},
+		{"number name", `[{"Name":7,` + pikachu + `}]`, 1},
+	}
+	for _, tt := range tests {
+		dst := temp_poke_file(t)
+		_, err := ImportPokemonJSON(dst, strings.NewReader(tt.json))
+		var batch_err *BatchError
+		switch {
+		case tt.row == 0 && err != nil:
+			t.Fatalf("%s: %v", tt.name, err)
+		case tt.row != 0 && (!errors.As(err, &batch_err) || batch_err.Row != tt.row):
+			t.Fatalf("%s: %v, want a BatchError for row %d", tt.name, err, tt.row)
+		case tt.row != 0:
+			continue
+		}
+		got, err := GetPokemon(dst, 1)
+		if err != nil || CString(got.Name[:]) != "Pikachu" || got.Total() != 320 {
+			t.Fatalf("%s: imported %+v, %v", tt.name, got, err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+/*
+Filename:  poke_patch.go
+Description:
+  - Updates one numeric field of a pokemon record in place (PATCH_POKE), the rest of the
+    record is left as it is on disk
+  - Field names and offsets come from the schema (DescribeRecord), so they follow the
+    PokeRec struct, text fields and the ID can't be patched
+  - Value ranges match schema_constraints, the caller holds the pokemon lock's write side
+    and updates a PokeCache with the re-read record
+*/
+package recordlib
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	ErrPokeField  = fmt.Errorf("no such numeric pokemon field")
+	ErrFieldRange = fmt.Errorf("value out of range for field")
+)
+
+//inclusive value range of a patchable field, fields not listed take any value of their type
+type field_range struct {
+	min, max uint64
+}
+
+var poke_field_ranges = map[string]field_range{
+	"Generation":  {1, 6},
+	"IsLegendary": {0, 1},
+	"HasGender":   {0, 1},
+	"PrMale":      {0, 8},
+	"HasMegaEvo":  {0, 1},
+	"HeightM":     {0, 1450},
+	"WeightKg":    {0, 9500},
+}
+
+/*
+Function Name:  poke_field
+Description:    looks up a numeric pokemon field by name (any case)
+Parameters:     field: field name as in PokeRec, e.g. CatchRate
+Return Value:   the schema field and nil, or ErrPokeField for text fields,
+				the ID and unknown names
+Type:           string -> SchemaField, error
+*/
+func poke_field(field string) (SchemaField, error) {
+	schema, err := DescribeRecord("pokemon")
+	if err != nil {
+		return SchemaField{}, err
+	}
+	for _, f := range schema.Fields {
+		if strings.EqualFold(f.Name, field) && f.Name != "ID" && (f.Type == "uint8" || f.Type == "uint16") {
+			return f, nil
+		}
+	}
+	return SchemaField{}, fmt.Errorf("%w: %s", ErrPokeField, field)
+}
+
+/*
+Function Name:  PatchPokemonField
+Description:    writes one numeric field of a pokemon record at its offset
+				and syncs, the caller holds the pokemon lock's write side
+Parameters:     poke_file: the pokemon binary data file, open read/write
+				id: the record ID to patch
+				field: field name as in PokeRec (any case), e.g. CatchRate
+				value: new value
+Return Value:   nil on success, ErrInvalidID, ErrPokeNotFound, ErrPokeField,
+				ErrFieldRange or a file error
+Type:           *os.File, uint16, string, uint64 -> error
+*/
+func PatchPokemonField(poke_file *os.File, id uint16, field string, value uint64) error {
+	if id == 0 {
+		return ErrInvalidID
+	}
+	f, err := poke_field(field)
+	if err != nil {
+		return err
+	}
+	limit, ok := poke_field_ranges[f.Name]
+	if !ok {
+		limit = field_range{0, 1<<(8*f.Size) - 1}
+	}
+	if value < limit.min || value > limit.max {
+		return fmt.Errorf("%w: %s must be %d-%d", ErrFieldRange, f.Name, limit.min, limit.max)
+	}
+
+	num_recs, err := PokeCount(poke_file)
+	if err != nil {
+		return err
+	}
+	if int(id) > num_recs {
+		return ErrPokeNotFound
+	}
+
+	buf := make([]byte, f.Size)
+	if f.Size == 1 {
+		buf[0] = uint8(value)
+	} else {
+		binary.LittleEndian.PutUint16(buf, uint16(value))
+	}
+	if _, err := poke_file.WriteAt(buf, int64(id-1)*PokeRecSize+int64(f.Offset)); err != nil {
+		return err
+	}
+	return poke_file.Sync()
+}
+
+/*
+Function Name:  Put
+Description:    method of PokeCache
+				replaces a cached record after a pokemon write, caller holds
+				the pokemon lock's write side
+Parameters:     rec: the record as now on disk
+Return Value:   n/a
+Type:           PokeRec -> n/a
+*/
+func (c *PokeCache) Put(rec PokeRec) {
+	if rec.ID == 0 || int(rec.ID) > len(c.recs) {
+		return
+	}
+	c.recs[rec.ID-1] = rec
+}
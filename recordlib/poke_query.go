@@ -0,0 +1,305 @@
+/*
+Filename:  poke_query.go
+Description:
+  - Whole-file queries over the pokemon binary file
+  - Each query scans every record in ID order with GetPokemon
+  - Callers hold the poke file read lock for the whole scan
+  - PokeCache keeps every record in memory for lookups without a seek
+*/
+package recordlib
+
+import (
+	"container/heap"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+/*
+Function Name:  each_pokemon
+Description:    visits every pokemon record in ID order until end of file
+Parameters:     poke_file: the pokemon binary data file
+				visit: called once per record
+Return Value:   nil at end of file or read error
+Type:           *os.File, func(PokeRec) -> error
+*/
+func each_pokemon(poke_file *os.File, visit func(PokeRec)) error {
+	for id := 1; id <= 0xFFFF; id++ {
+		poke, err := GetPokemon(poke_file, uint16(id))
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		visit(poke)
+	}
+	return nil
+}
+
+//min-heap on Total (ID breaks ties) holding the best n records seen so far
+type poke_heap []PokeRec
+
+//true if a ranks below b: lower total, or same total and higher ID
+func ranks_below(a, b PokeRec) bool {
+	if a.Total() != b.Total() {
+		return a.Total() < b.Total()
+	}
+	return a.ID > b.ID
+}
+
+func (h poke_heap) Len() int           { return len(h) }
+func (h poke_heap) Less(i, j int) bool { return ranks_below(h[i], h[j]) }
+func (h poke_heap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *poke_heap) Push(x any)        { *h = append(*h, x.(PokeRec)) }
+func (h *poke_heap) Pop() any {
+	old := *h
+	rec := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return rec
+}
+
+/*
+Function Name:  TopPokemonByTotal
+Description:    scans all pokemon keeping the n with the highest stat total
+				in a bounded min-heap (O(file * log n), n records held),
+				equal totals rank the lower ID first
+Parameters:     poke_file: the pokemon binary data file
+				n: how many records to return, at least 1
+Return Value:   up to n records sorted by total descending and read error (if any)
+Type:           *os.File, int -> []PokeRec, error
+*/
+func TopPokemonByTotal(poke_file *os.File, n int) ([]PokeRec, error) {
+	top := make(poke_heap, 0, n)
+	err := each_pokemon(poke_file, func(poke PokeRec) {
+		if len(top) < n {
+			heap.Push(&top, poke)
+		} else if ranks_below(top[0], poke) {
+			top[0] = poke
+			heap.Fix(&top, 0)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(top, func(i, j int) bool { return ranks_below(top[j], top[i]) })
+	return top, nil
+}
+
+/*
+Function Name:  FilterPokemon
+Description:    scans all pokemon keeping those matching every given predicate
+Parameters:     poke_file: the pokemon binary data file
+				gen: generation to match, 0 for any
+				legendary_only: keep only legendary pokemon
+Return Value:   matching records in ID order and read error (if any)
+Type:           *os.File, int, bool -> []PokeRec, error
+*/
+func FilterPokemon(poke_file *os.File, gen int, legendary_only bool) ([]PokeRec, error) {
+	var matches []PokeRec
+	err := each_pokemon(poke_file, func(poke PokeRec) {
+		if gen != 0 && int(poke.Generation) != gen {
+			return
+		}
+		if legendary_only && poke.IsLegendary == 0 {
+			return
+		}
+		matches = append(matches, poke)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
+/*
+Function Name:  egg_groups
+Description:    the pokemon's egg groups as strings, one or two
+Parameters:     poke: pokemon record
+Return Value:   EggGroup1 and EggGroup2 if set
+Type:           PokeRec -> []string
+*/
+func egg_groups(poke PokeRec) []string {
+	groups := []string{CString(poke.EggGroup1[:])}
+	if poke.EggGroup2[0] != 0 {
+		groups = append(groups, CString(poke.EggGroup2[:]))
+	}
+	return groups
+}
+
+/*
+Function Name:  SimilarPokemon
+Description:    reads the reference pokemon then scans all pokemon keeping
+				those with the same body style or an egg group in common
+				(either of the reference's against either of theirs), the
+				reference itself is left out
+Parameters:     poke_file: the pokemon binary data file
+				id: reference pokemon ID
+Return Value:   matching records in ID order and nil, io.EOF if id is not
+				in the file, or read error
+Type:           *os.File, uint16 -> []PokeRec, error
+*/
+func SimilarPokemon(poke_file *os.File, id uint16) ([]PokeRec, error) {
+	ref, err := GetPokemon(poke_file, id)
+	if err != nil {
+		return nil, err
+	}
+	ref_groups := egg_groups(ref)
+
+	var matches []PokeRec
+	err = each_pokemon(poke_file, func(poke PokeRec) {
+		if poke.ID == ref.ID {
+			return
+		}
+		if poke.BodyStyle == ref.BodyStyle {
+			matches = append(matches, poke)
+			return
+		}
+		for _, group := range egg_groups(poke) {
+			if slices.Contains(ref_groups, group) {
+				matches = append(matches, poke)
+				return
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
+//reply for REQ_POKE_AGG, heights and weights are in PokeRec units
+//(HeightM is m x 100, WeightKg is kg x 10), a dual type pokemon counts
+//toward both of its types in ByType
+type PokeAggregate struct {
+	Count       int               `json:"count"`
+	Legendaries int               `json:"legendaries"`
+	CountByGen  map[uint8]int     `json:"count_by_gen"`
+	AvgTotalGen map[uint8]float64 `json:"avg_total_by_gen"`
+	ByType      map[string]int    `json:"count_by_type"`
+	MinHeightM  uint16            `json:"min_height_m"`
+	MaxHeightM  uint16            `json:"max_height_m"`
+	MinWeightKg uint16            `json:"min_weight_kg"`
+	MaxWeightKg uint16            `json:"max_weight_kg"`
+}
+
+/*
+Function Name:  PokemonAggregate
+Description:    one scan of all pokemon accumulating counts by generation and
+				type, the legendary count, the average stat total of each
+				generation and the height and weight range
+Parameters:     poke_file: the pokemon binary data file
+Return Value:   totals (Count 0 for an empty file) and read error (if any)
+Type:           *os.File -> PokeAggregate, error
+*/
+func PokemonAggregate(poke_file *os.File) (PokeAggregate, error) {
+	agg := PokeAggregate{
+		CountByGen:  make(map[uint8]int),
+		AvgTotalGen: make(map[uint8]float64),
+		ByType:      make(map[string]int),
+	}
+	gen_totals := make(map[uint8]int)
+	err := each_pokemon(poke_file, func(poke PokeRec) {
+		if agg.Count == 0 {
+			agg.MinHeightM, agg.MaxHeightM = poke.HeightM, poke.HeightM
+			agg.MinWeightKg, agg.MaxWeightKg = poke.WeightKg, poke.WeightKg
+		}
+		agg.Count++
+		if poke.IsLegendary != 0 {
+			agg.Legendaries++
+		}
+		agg.CountByGen[poke.Generation]++
+		gen_totals[poke.Generation] += int(poke.Total())
+		agg.ByType[CString(poke.Type1[:])]++
+		if poke.Type2[0] != 0 {
+			agg.ByType[CString(poke.Type2[:])]++
+		}
+		agg.MinHeightM, agg.MaxHeightM = min(agg.MinHeightM, poke.HeightM), max(agg.MaxHeightM, poke.HeightM)
+		agg.MinWeightKg, agg.MaxWeightKg = min(agg.MinWeightKg, poke.WeightKg), max(agg.MaxWeightKg, poke.WeightKg)
+	})
+	if err != nil {
+		return PokeAggregate{}, err
+	}
+	for gen, total := range gen_totals {
+		agg.AvgTotalGen[gen] = float64(total) / float64(agg.CountByGen[gen])
+	}
+	return agg, nil
+}
+
+/*
+Function Name:  DistinctPokemonTypes
+Description:    one scan of all pokemon collecting every Type1 and Type2 in
+				use, trimmed, the empty Type2 of single type pokemon is skipped
+Parameters:     poke_file: the pokemon binary data file
+Return Value:   type names sorted ascending without repeats (empty for an
+				empty file) and read error (if any)
+Type:           *os.File -> []string, error
+*/
+func DistinctPokemonTypes(poke_file *os.File) ([]string, error) {
+	seen := make(map[string]bool)
+	err := each_pokemon(poke_file, func(poke PokeRec) {
+		for _, field := range [][]byte{poke.Type1[:], poke.Type2[:]} {
+			if poke_type := strings.TrimSpace(CString(field)); poke_type != "" {
+				seen[poke_type] = true
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return slices.Sorted(maps.Keys(seen)), nil
+}
+
+//every pokemon record held in memory, index is ID-1
+//guarded by the same lock as the pokemon file, pokemon writes (PATCH_POKE)
+//update the entry with Put under that lock's write side
+type PokeCache struct {
+	recs []PokeRec
+}
+
+/*
+Function Name:  NewPokeCache
+Description:    reads the whole pokemon file into memory
+Parameters:     poke_file: the pokemon binary data file
+Return Value:   filled cache and read error (if any)
+Type:           *os.File -> *PokeCache, error
+*/
+func NewPokeCache(poke_file *os.File) (*PokeCache, error) {
+	cache := &PokeCache{}
+	err := each_pokemon(poke_file, func(poke PokeRec) {
+		cache.recs = append(cache.recs, poke)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+/*
+Function Name:  Get
+Description:    method of PokeCache
+				returns the cached record, same results as GetPokemon
+Parameters:     id: the record id to look up
+Return Value:   the pokemon record and io.EOF if out of range
+Type:           uint16 -> PokeRec, error
+*/
+func (c *PokeCache) Get(id uint16) (PokeRec, error) {
+	if id == 0 || int(id) > len(c.recs) {
+		return PokeRec{}, io.EOF
+	}
+	return c.recs[id-1], nil
+}
+
+/*
+Function Name:  Len
+Description:    method of PokeCache
+Parameters:     N/A
+Return Value:   number of cached records
+Type:           n/a -> int
+*/
+func (c *PokeCache) Len() int {
+	return len(c.recs)
+}
@@ -9,26 +9,69 @@ Description:
 package recordlib
 
 import (
+	"bufio"
 	"bytes"
 	"container/list"
-	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
-	"unsafe"
-
-	"golang.org/x/sys/unix"
+	"time"
 )
 
+//every field is guarded by Lock, waiters check their predicate and call Cond.Wait
+//with Lock held (Wait releases it atomically) and every change that can let a
+//waiter proceed Broadcasts under Lock, so no wakeup falls between a check and its Wait
 type RecordLock struct {
 	Lock *sync.Mutex
 	Cond *sync.Cond
 	NumReading int
 	NumWriting int
-	WrQueue *list.List
+	WrQueue *list.List //waiting writers, with LockFIFO waiting readers too (element value true for a writer)
+	NumReadWaiting int //readers waiting for the lock
+}
+
+//order in which waiting readers and writers of one record get its lock
+type LockPolicy int
+
+const (
+	LockWriterFirst LockPolicy = iota //readers wait while any writer is active or queued (default)
+	LockReaderFirst                   //writers wait until no reader is active or waiting
+	LockFIFO                          //arrival order, consecutive readers share the lock
+)
+
+/*
+Function Name:  ParseLockPolicy
+Description:    lock policy by name, as given to the server's -lock-policy
+Parameters:     name: "writer", "reader" or "fifo"
+Return Value:   the policy and nil, or error for an unknown name
+Type:           string -> LockPolicy, error
+*/
+func ParseLockPolicy(name string) (LockPolicy, error) {
+	switch name {
+	case "writer":
+		return LockWriterFirst, nil
+	case "reader":
+		return LockReaderFirst, nil
+	case "fifo":
+		return LockFIFO, nil
+	}
+	return LockWriterFirst, fmt.Errorf("lock policy must be writer, reader or fifo")
+}
+
+func (p LockPolicy) String() string {
+	switch p {
+	case LockReaderFirst:
+		return "reader"
+	case LockFIFO:
+		return "fifo"
+	}
+	return "writer"
 }
 
 /*
@@ -50,10 +93,15 @@ func NewRecordLock() *RecordLock {
 
 type GlobalManager struct {
 	TrainerRecLocks map[uint16]*RecordLock
-	MapLock sync.Mutex
+	MapLock *sync.Mutex
 	GlobalLock *sync.RWMutex
+	//serializes appends (post, bulk post), the next ID is the record count
+	//so counting and writing must be one step, taken after GlobalLock.RLock
+	AppendLock *sync.Mutex
     NumReading int
 	NumWritingOrQueued int //currently writing or queued to write
+	Policy LockPolicy //record lock fairness, fixed when the manager is created
+	held *held_locks //locks taken through this manager, nil unless made by Track
 }
 
 /*
@@ -76,12 +124,32 @@ func (m *GlobalManager) GetRecordLock(id uint16) *RecordLock {
     return rec_lock
 }
 
+/*
+Function Name:  writer_ahead
+Description:    whether a writer is queued in front of waiter, LockFIFO only,
+				caller holds rec_lock.Lock
+Parameters:     rec_lock: record lock
+				waiter: queued reader
+Return Value:   true if a writer arrived before waiter and still waits
+Type:           *RecordLock, *list.Element -> bool
+*/
+func writer_ahead(rec_lock *RecordLock, waiter *list.Element) bool {
+	for elem := rec_lock.WrQueue.Front(); elem != waiter; elem = elem.Next() {
+		if elem.Value.(bool) {
+			return true
+		}
+	}
+	return false
+}
+
 /*
 Function Name:  RLockRecord
 Description:    method of GlobalManager
 				acquires reader lock for specified record id, prevents
                 global ReadAll from starting while record op begins,
-                waits if writers are active or queued
+                waits while a writer is active, and by m.Policy also while
+                writers are queued (LockWriterFirst) or queued ahead of
+                it (LockFIFO)
 Parameters:     id: trainer record id
 Return Value:   n/a
 Type:           uint16 -> n/a
@@ -91,11 +159,32 @@ func (m *GlobalManager) RLockRecord(id uint16) {
     rec_lock := m.GetRecordLock(id)
     rec_lock.Lock.Lock()
 
-    for rec_lock.NumWriting > 0 || rec_lock.WrQueue.Len() > 0 {
-        rec_lock.Cond.Wait() //if any writers are active or queued, readers wait
+	var waiter *list.Element
+	if m.Policy == LockFIFO {
+		waiter = rec_lock.WrQueue.PushBack(false) //readers queue with the writers
+	}
+	rec_lock.NumReadWaiting++
+    for { //re-checked after every wakeup, Lock is held here and across Wait
+		blocked := rec_lock.NumWriting > 0
+		switch m.Policy {
+		case LockWriterFirst:
+			blocked = blocked || rec_lock.WrQueue.Len() > 0 //if any writers are active or queued, readers wait
+		case LockFIFO:
+			blocked = blocked || writer_ahead(rec_lock, waiter)
+		}
+		if !blocked {
+			break
+		}
+        rec_lock.Cond.Wait()
     }
+	rec_lock.NumReadWaiting--
+	if waiter != nil {
+		rec_lock.WrQueue.Remove(waiter)
+		rec_lock.Cond.Broadcast() //a writer behind this reader may now be at the head
+	}
     rec_lock.NumReading++
     rec_lock.Lock.Unlock()
+	m.held.add(held_read, id)
 }
 
 /*
@@ -118,6 +207,7 @@ func (m *GlobalManager) RUnlockRecord(id uint16) {
     }
     rec_lock.Lock.Unlock()
     m.GlobalLock.RUnlock() //release global lock previously taken
+	m.held.remove(held_read, id)
 }
 
 /*
@@ -133,19 +223,51 @@ Type:           uint16 -> n/a
 func (m *GlobalManager) WLockRecord(id uint16) {
     //block ReadAll from taking exclusive lock while writer progresses
     m.GlobalLock.RLock()
+    m.wlock_record(id, time.Time{})
+	m.held.add(held_write, id)
+}
+
+/*
+Function Name:  wlock_record
+Description:    method of GlobalManager
+				record part of WLockRecord, caller already holds GlobalLock.RLock,
+				a writer still waiting at the deadline leaves the queue
+Parameters:     id: trainer record id
+				deadline: when to give up, zero to wait as long as it takes
+Return Value:   true once locked, false at the deadline
+Type:           uint16, time.Time -> bool
+*/
+func (m *GlobalManager) wlock_record(id uint16, deadline time.Time) bool {
     rec_lock := m.GetRecordLock(id)
     rec_lock.Lock.Lock()
-    waiter := rec_lock.WrQueue.PushBack(struct{}{}) //insert blank marker into writer queue
+    waiter := rec_lock.WrQueue.PushBack(true) //insert writer marker into queue
+	if !deadline.IsZero() {
+		//Cond has no timed Wait, wake the waiters at the deadline to re-check
+		timer := time.AfterFunc(time.Until(deadline), func() {
+			rec_lock.Lock.Lock()
+			rec_lock.Cond.Broadcast()
+			rec_lock.Lock.Unlock()
+		})
+		defer timer.Stop()
+	}
 
 	//conditions for writer to work
 	//has to be at head of queue
 	//can't have active readers
 	//can't have active writer
+	//with LockReaderFirst, can't have waiting readers
     for {
         front := rec_lock.WrQueue.Front()
-        if front == waiter && rec_lock.NumReading == 0 && rec_lock.NumWriting == 0 {
+        if front == waiter && rec_lock.NumReading == 0 && rec_lock.NumWriting == 0 &&
+			(m.Policy != LockReaderFirst || rec_lock.NumReadWaiting == 0) {
             break
         }
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			rec_lock.WrQueue.Remove(waiter)
+			rec_lock.Cond.Broadcast() //readers held back by this writer may go
+			rec_lock.Lock.Unlock()
+			return false
+		}
         rec_lock.Cond.Wait()
     }
 
@@ -153,6 +275,7 @@ func (m *GlobalManager) WLockRecord(id uint16) {
     rec_lock.WrQueue.Remove(waiter)
     rec_lock.NumWriting = 1
     rec_lock.Lock.Unlock()
+	return true
 }
 
 /*
@@ -165,13 +288,78 @@ Return Value:   n/a
 Type:           uint16 -> n/a
 */
 func (m *GlobalManager) WUnlockRecord(id uint16) {
+    m.wunlock_record(id)
+    m.GlobalLock.RUnlock() //release global rec_lock taken in WLockRecord
+	m.held.remove(held_write, id)
+}
+
+/*
+Function Name:  wunlock_record
+Description:    method of GlobalManager
+				record part of WUnlockRecord, caller releases GlobalLock.RLock
+Parameters:     id: trainer record id
+Return Value:   n/a
+Type:           uint16 -> n/a
+*/
+func (m *GlobalManager) wunlock_record(id uint16) {
     rec_lock := m.GetRecordLock(id)
     rec_lock.Lock.Lock()
     rec_lock.NumWriting = 0
 
     rec_lock.Cond.Broadcast() //wake next writer or waiting readers
     rec_lock.Lock.Unlock() //release writer lock
-    m.GlobalLock.RUnlock() //release global rec_lock taken in WLockRecord
+}
+
+/*
+Function Name:  WLockRecords
+Description:    method of GlobalManager
+				acquires writer locks for several records, ids are
+				deduplicated and locked in ascending order so two callers
+				locking overlapping sets can't deadlock, global read lock
+				is taken once (taking it per record could deadlock with a
+				waiting ReadAll)
+Parameters:     ids: trainer record ids, any order, repeats allowed
+Return Value:   sorted unique ids, pass these to WUnlockRecords
+Type:           ...uint16 -> []uint16
+*/
+func (m *GlobalManager) WLockRecords(ids ...uint16) []uint16 {
+	locked := sorted_unique(ids)
+	m.GlobalLock.RLock()
+	for _, id := range locked {
+		m.wlock_record(id, time.Time{})
+	}
+	m.held.add(held_write, locked...)
+	return locked
+}
+
+/*
+Function Name:  WUnlockRecords
+Description:    method of GlobalManager
+				releases writer locks taken by WLockRecords, in reverse order
+Parameters:     ids: trainer record ids, any order, repeats allowed
+Return Value:   n/a
+Type:           ...uint16 -> n/a
+*/
+func (m *GlobalManager) WUnlockRecords(ids ...uint16) {
+	locked := sorted_unique(ids)
+	for idx := len(locked) - 1; idx >= 0; idx-- {
+		m.wunlock_record(locked[idx])
+	}
+	m.GlobalLock.RUnlock()
+	m.held.remove(held_write, locked...)
+}
+
+/*
+Function Name:  sorted_unique
+Description:    copies ids into ascending order without repeats
+Parameters:     ids: trainer record ids
+Return Value:   sorted unique copy of ids
+Type:           []uint16 -> []uint16
+*/
+func sorted_unique(ids []uint16) []uint16 {
+	sorted := append([]uint16(nil), ids...)
+	slices.Sort(sorted)
+	return slices.Compact(sorted)
 }
 
 
@@ -187,6 +375,7 @@ Type:           n/a -> n/a
 */
 func (gm *GlobalManager) LockReadAll() {
     gm.GlobalLock.Lock()
+	gm.held.add(held_read_all)
 }
 
 /*
@@ -199,6 +388,7 @@ Type:           n/a -> n/a
 */
 func (gm *GlobalManager) UnlockReadAll() {
     gm.GlobalLock.Unlock()
+	gm.held.remove(held_read_all)
 }
 
 /*
@@ -206,28 +396,87 @@ Function Name:  NewGlobalManager
 Description:    allocates and initialize a GlobalManager containing
                 per-record lock map and global read/write mutex,
 				used to coordinate ReadAll vs other record ops
-Parameters:     N/A
+Parameters:     policy: fairness of the record locks, LockWriterFirst
+				keeps the original behavior
 Return Value:   newly allocated and initialized GlobalManager
-Type:           n/a -> *GlobalManager
+Type:           LockPolicy -> *GlobalManager
 */
-func NewGlobalManager() *GlobalManager {
+func NewGlobalManager(policy LockPolicy) *GlobalManager {
 	m := &GlobalManager{
 		TrainerRecLocks: make(map[uint16]*RecordLock),
+		MapLock: new(sync.Mutex),
 		GlobalLock: new(sync.RWMutex),
+		AppendLock: new(sync.Mutex),
+		Policy: policy,
 	}
 	return m
 }
 
+//errors returned by record functions and stores
+var (
+	ErrTrainerNotFound = fmt.Errorf("trainer ID not found")
+	ErrPokeNotFound    = fmt.Errorf("pokemon ID not found")
+	ErrFileSize        = fmt.Errorf("file size is not a multiple of record size")
+	ErrPokeIDRange     = fmt.Errorf("pokemon id out of range")
+	ErrTrainerIDRange  = fmt.Errorf("trainer id out of range")
+	ErrBadName         = fmt.Errorf("trainer name must be printable ASCII")
+	ErrIDMismatch      = fmt.Errorf("record id mismatch")
+	ErrInvalidID       = fmt.Errorf("invalid id 0")
+	ErrLogNTooLarge    = fmt.Errorf("n too large, at most %d log lines", MaxLogN)
+)
+
 //regexp for client requests
 var (
+	ReqPing          = regexp.MustCompile(`^PING$`)
 	ReqGetPokeID     = regexp.MustCompile(`^REQ_POKE_ID ([1-9][0-9]*)$`)
+	ReqTopPoke       = regexp.MustCompile(`^REQ_POKE_TOP (\d+)$`)
+	ReqGetPokeFilter = regexp.MustCompile(`^REQ_POKE_FILTER gen=(\d+) legendary=(0|1)$`) //gen=0 is any
+	ReqPokeMulti     = regexp.MustCompile(`^REQ_POKE_MULTI ((?:\d+,?)+)$`) //comma separated IDs
+	ReqPokeSimilar   = regexp.MustCompile(`^REQ_POKE_SIMILAR ([1-9][0-9]*)$`)
+	ReqPokeAgg       = regexp.MustCompile(`^REQ_POKE_AGG$`)
+	ReqPokeTypes     = regexp.MustCompile(`^REQ_POKE_TYPES$`)
 	ReqGetTrainerID  = regexp.MustCompile(`^REQ_TRAINER_ID ([1-9][0-9]*)$`)
 	ReqGetTrainerAll = regexp.MustCompile(`^REQ_TRAINER_ALL$`)
-	//regexp individually captures pokemon ids, if less than 6 then next capture is ""
-	ReqPostTrainer = regexp.MustCompile(`^POST_TRAINER (\S+)(?: (\d+))?(?: (\d+))?(?: (\d+))?(?: (\d+))?(?: (\d+))?(?: (\d+))?$`)
+	ReqTrainerExpand = regexp.MustCompile(`^REQ_TRAINER_EXPAND ([1-9][0-9]*)$`)
+	ReqRandomPoke    = regexp.MustCompile(`^REQ_RANDOM_POKE$`)
+	ReqRandomTrainer = regexp.MustCompile(`^REQ_RANDOM_TRAINER$`)
+	ReqTrainerWithPoke = regexp.MustCompile(`^REQ_TRAINER_WITH_POKE ([1-9][0-9]*)$`)
+	ReqGetTrainerAllSorted = regexp.MustCompile(`^REQ_TRAINER_ALL_SORTED (name|id) (asc|desc)$`)
+	ReqExportTrainers = regexp.MustCompile(`^REQ_EXPORT_TRAINERS$`)
+	//name, then regexp individually captures pokemon ids, if less than 6 then next capture is "",
+	//then the optional idempotency key (capture 8, "" if none), last so it can't be taken for a name
+	ReqPostTrainer = regexp.MustCompile(`^POST_TRAINER (\S+)(?: (\d+))?(?: (\d+))?(?: (\d+))?(?: (\d+))?(?: (\d+))?(?: (\d+))?(?: KEY=([A-Za-z0-9_-]{1,64}))?$`)
+	//one "<name> [<pokemon>...]" row per line after the command line
+	ReqBulkPost    = regexp.MustCompile(`^BULK_POST_TRAINER((?:\n\S+(?: \d+){0,6})+)$`)
 	ReqPutTrainer  = regexp.MustCompile(`^PUT_TRAINER (\d+)(?: (\d+))?(?: (\d+))?(?: (\d+))?(?: (\d+))?(?: (\d+))?(?: (\d+))?$`)
+	ReqAppendTrainer = regexp.MustCompile(`^APPEND_TRAINER (\d+) (\d+)(?: (\d+))?(?: (\d+))?(?: (\d+))?(?: (\d+))?(?: (\d+))?$`)
+	ReqRemoveTrainerPoke = regexp.MustCompile(`^REMOVE_TRAINER_POKE (\d+) (\d+)$`)
+	ReqSwap              = regexp.MustCompile(`^SWAP_TRAINER_POKE (\d+) (\d+) (\d+) (\d+)$`)
+	ReqMovePoke          = regexp.MustCompile(`^MOVE_TRAINER_POKE (\d+) (\d+) (\d+)$`)
+	ReqPatchPoke         = regexp.MustCompile(`^PATCH_POKE (\d+) (\w+) (\d+)$`)
 	ReqDelTrainer  = regexp.MustCompile(`^DEL_TRAINER (\d+)$`)
+	ReqDelTrainerWhere = regexp.MustCompile(`^REQ_TRAINER_DELETE_WHERE (.+)$`)
+	ReqAuth            = regexp.MustCompile(`^AUTH (\S+)$`)
 	ReqGetLogN     = regexp.MustCompile(`^REQ_LOG_FILE (\d+)$`)
+	ReqGetLogAllN  = regexp.MustCompile(`^REQ_LOG_FILE_ALL (\d+)$`)
+	ReqLogSince    = regexp.MustCompile(`^REQ_LOG_SINCE (\S+)$`)
+	ReqLogFilter   = regexp.MustCompile(`^REQ_LOG_FILTER (port|cmd) (\S+) (\d+)$`)
+	ReqLogFollow   = regexp.MustCompile(`^REQ_LOG_FOLLOW$`)
+	ReqClearLog    = regexp.MustCompile(`^CLEAR_LOG$`)
+	ReqDumpIndex   = regexp.MustCompile(`^REQ_DUMP_INDEX$`)
+	ReqReloadIndex = regexp.MustCompile(`^REQ_RELOAD_INDEX$`)
+	ReqGetPokeName = regexp.MustCompile(`^REQ_POKE_NAME_ID (\d+)$`)
+	ReqPokeRaw     = regexp.MustCompile(`^REQ_POKE_RAW (\d+)$`)
+	ReqPokeNameList = regexp.MustCompile(`^REQ_POKE_NAME_LIST$`)
+	ReqTiming       = regexp.MustCompile(`^TIMING (on|off)$`)
+	ReqTrace        = regexp.MustCompile(`^TRACE (on|off)$`)
+	ReqCompress     = regexp.MustCompile(`^COMPRESS (gzip|off)$`)
+	ReqMetrics      = regexp.MustCompile(`^REQ_METRICS$`)
+	ReqWhoAmI       = regexp.MustCompile(`^REQ_WHOAMI$`)
+	ReqStatus       = regexp.MustCompile(`^REQ_STATUS$`)
+	ReqFsck         = regexp.MustCompile(`^REQ_FSCK$`)
+	ReqValidateRefs = regexp.MustCompile(`^REQ_VALIDATE_REFS( REPAIR)?$`) //REPAIR is admin
+	ReqSchema       = regexp.MustCompile(`^REQ_SCHEMA (pokemon|trainer)$`)
 )
 
 type PokeRec struct {
@@ -256,6 +505,37 @@ type PokeRec struct {
 	BodyStyle   [17]byte //bipedal_tailless\0
 }
 
+/*
+Function Name:  Total()
+Description:    method of PokeRec
+				sums the six base stats, each is widened first since the
+				uint8 sum overflows (max 6*255 = 1530, fits in uint16)
+Parameters:     N/A
+Return Value:   total of all base stats
+Type:           n/a -> uint16
+*/
+func (rec PokeRec) Total() uint16 {
+	return uint16(rec.HP) + uint16(rec.Attack) + uint16(rec.Defense) +
+		uint16(rec.SpAtk) + uint16(rec.SpDef) + uint16(rec.Speed)
+}
+
+/*
+Function Name:  MarshalJSON()
+Description:    method of PokeRec
+				encodes the record fields as usual plus the derived Total,
+				Total is ignored when unmarshaling back into a PokeRec
+Parameters:     N/A
+Return Value:   JSON bytes or marshal error
+Type:           n/a -> []byte, error
+*/
+func (rec PokeRec) MarshalJSON() ([]byte, error) {
+	type plain PokeRec //no methods, so no MarshalJSON recursion
+	return json.Marshal(struct {
+		plain
+		Total uint16
+	}{plain(rec), rec.Total()})
+}
+
 /*
 Function Name:  Print()
 Description:    method of PokeRec
@@ -275,7 +555,7 @@ func (rec PokeRec) Print() {
 	} else {
 		fmt.Println(" | Type 2: N/A")
 	}
-	fmt.Printf(" | Total: %d\n", rec.HP+rec.Attack+rec.Defense+rec.SpAtk+rec.SpDef+rec.Speed)
+	fmt.Printf(" | Total: %d\n", rec.Total())
 	fmt.Printf(" | -  HP: %d", rec.HP)
 	fmt.Printf(" | Attack: %d", rec.Attack)
 	fmt.Printf(" | Defense: %d\n", rec.Defense)
@@ -318,6 +598,98 @@ func (rec PokeRec) Print() {
 	fmt.Printf(" | Body style: %s\n\n", rec.BodyStyle)
 }
 
+//lightweight reply for REQ_POKE_NAME_ID
+type PokeName struct {
+	ID   uint16 `json:"id"`
+	Name string `json:"name"`
+}
+
+//reply for REQ_METRICS, request counts since server start,
+//Errors counts requests of any type whose final reply was an error status
+type Metrics struct {
+	UptimeSec int64  `json:"uptime_s"`
+	Requests  uint64 `json:"requests"`
+	Gets      uint64 `json:"gets"`
+	Posts     uint64 `json:"posts"`
+	Puts      uint64 `json:"puts"`
+	Deletes   uint64 `json:"deletes"`
+	Errors    uint64 `json:"errors"`
+}
+
+//reply for REQ_STATUS, StartTime is RFC 3339 server local time,
+//Connections counts every client served since start, Active those still connected
+type ServerStatus struct {
+	StartTime   string `json:"start_time"`
+	UptimeSec   int64  `json:"uptime_s"`
+	Connections uint64 `json:"connections"`
+	Active      int    `json:"active"`
+}
+
+//reply for REQ_TRAINER_EXPAND, Pokemon has one entry per slot (Poke1 to Poke6),
+//null for an empty slot or one whose pokemon ID isn't in the pokemon file
+type TrainerExpanded struct {
+	Trainer TrainerRec  `json:"trainer"`
+	Pokemon [6]*PokeRec `json:"pokemon"`
+}
+
+//reply for REQ_WHOAMI, what the server knows about the asking connection,
+//ConnID matches the conn=NN tag of its server log lines
+type WhoAmI struct {
+	SrcPort      int    `json:"src_port"`
+	ConnID       uint64 `json:"conn_id"`
+	ConnectedSec int64  `json:"connected_s"`
+	UptimeSec    int64  `json:"uptime_s"`
+	Protocol     string `json:"protocol"`
+}
+
+/*
+Function Name:  CString
+Description:    converts a null padded fixed size byte array field to a string
+Parameters:     b: field bytes, e.g. rec.Name[:]
+Return Value:   string up to (excluding) the first null byte
+Type:           []byte -> string
+*/
+func CString(b []byte) string {
+	if idx := bytes.IndexByte(b, 0); idx >= 0 {
+		b = b[:idx]
+	}
+	return string(b)
+}
+
+/*
+Function Name:  CheckTrainerName
+Description:    checks every byte of a trainer name is printable ASCII, names
+				are stored in a fixed byte array, printed with %s and written
+				to JSON and CSV, so control bytes (NUL, tab, escapes) and
+				multi-byte characters that can be cut mid-character would
+				corrupt them
+Parameters:     name: trainer name as requested
+Return Value:   nil or ErrBadName
+Type:           string -> error
+*/
+func CheckTrainerName(name string) error {
+	for idx := 0; idx < len(name); idx++ {
+		if name[idx] < 0x20 || name[idx] > 0x7E {
+			return ErrBadName
+		}
+	}
+	return nil
+}
+
+/*
+Function Name:  StoredTrainerName
+Description:    returns name exactly as PostTrainer stores it in the fixed
+				size TrainerRec.Name field (truncated to fit, cut at a null)
+Parameters:     name: trainer name as requested
+Return Value:   name as it will be read back from the record
+Type:           string -> string
+*/
+func StoredTrainerName(name string) string {
+	var stored [16]byte
+	copy(stored[:], name)
+	return CString(stored[:])
+}
+
 type PokeDisplay struct {
 	ID   uint16
 	Name [12]byte
@@ -369,21 +741,55 @@ Function Name:  GetPokemon
 Description:    seeks in pokemon file for pokemon record by id
 Parameters:		poke_file: the pokemon binary data file
 				id: the record id to search for
-Return Value:   the entire pokemon record if found and error (if any)
+Return Value:   the entire pokemon record if found and error (if any), ErrInvalidID
+				for id 0, io.EOF past the last record
 Type:           *os.File, uint16 -> PokeRec, error
 */
 func GetPokemon(poke_file *os.File, id uint16) (PokeRec, error) {
-	var poke PokeRec
-	offset := int64(id-1) * int64(unsafe.Sizeof(poke))
-	if _, err := poke_file.Seek(offset, 0); err != nil {
-		return PokeRec{}, err
+	if id == 0 {
+		return PokeRec{}, ErrInvalidID //id-1 would wrap to the last possible record
 	}
-
-	if err := binary.Read(poke_file, binary.LittleEndian, &poke); err != nil {
+	buf, err := read_at(poke_file, int64(id-1)*PokeRecSize, PokeRecSize)
+	if err != nil {
 		return PokeRec{}, err
 	} //assumed binary files written on acad
 
-	return poke, nil
+	return DecodePokeRec(buf)
+}
+
+/*
+Function Name:  GetPokeRaw
+Description:	reads the on-disk bytes of a pokemon record without decoding,
+				for debugging the record layout
+Parameters:		poke_file: the pokemon binary data file
+				id: the record ID to search for
+Return Value:   PokeRecSize bytes if found and error (if any), ErrInvalidID for id 0,
+				io.EOF past the last record
+Type:           *os.File, uint16 -> []byte, error
+*/
+func GetPokeRaw(poke_file *os.File, id uint16) ([]byte, error) {
+	if id == 0 {
+		return nil, ErrInvalidID
+	}
+	return read_at(poke_file, int64(id-1)*PokeRecSize, PokeRecSize)
+}
+
+/*
+Function Name:  PokeCount
+Description:	number of pokemon records in the pokemon file, from its size
+Parameters:		poke_file: the pokemon binary data file
+Return Value:   record count and nil, ErrFileSize or stat error
+Type:           *os.File -> int, error
+*/
+func PokeCount(poke_file *os.File) (int, error) {
+	info, err := poke_file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if info.Size()%PokeRecSize != 0 {
+		return 0, ErrFileSize
+	}
+	return int(info.Size() / PokeRecSize), nil
 }
 
 /*
@@ -397,38 +803,71 @@ Type:           *os.File, uint16 -> [12]byte, error
 */
 func GetPokeName(poke_file *os.File, id uint16) ([12]byte, error) {
 	var poke_name [12]byte
-	offset := int64(id-1)*int64(unsafe.Sizeof(PokeRec{})) + 2
-	if _, err := poke_file.Seek(offset, 0); err != nil {
-		return poke_name, err
+	if id == 0 {
+		return poke_name, ErrInvalidID
 	}
-
-	if err := binary.Read(poke_file, binary.LittleEndian, &poke_name); err != nil {
+	buf, err := read_at(poke_file, int64(id-1)*PokeRecSize+poke_name_offset, len(poke_name))
+	if err != nil {
 		return poke_name, err
 	} //assumed binary files written on acad
 
+	copy(poke_name[:], buf)
 	return poke_name, nil
 }
 
+/*
+Function Name:  PokeNameList
+Description:	reads the id and name of every pokemon record in order
+				used to build client side name lookup and completion
+Parameters:		poke_file: the pokemon binary data file
+Return Value:   list of all id/name pairs and error (if any)
+Type:           *os.File -> []PokeName, error
+*/
+func PokeNameList(poke_file *os.File) ([]PokeName, error) {
+	var names []PokeName
+	for id := 1; id <= 0xFFFF; id++ {
+		name, err := GetPokeName(poke_file, uint16(id))
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, PokeName{ID: uint16(id), Name: CString(name[:])})
+	}
+	return names, nil
+}
+
 /*
 Function Name:  GetTrainer
-Description:    seeks in trainer file for trainer record by ID
+Description:    seeks in trainer file for trainer record by ID, offsets are
+				positional, so a live record at that offset holding another
+				ID means the file layout broke and is refused rather than
+				returned (and then overwritten by PutTrainer/DeleteTrainer)
 Parameters:		trainer_file: the trainer binary data file
 				id: the record ID to search for
-Return Value:   the entire trainer record if found and error (if any)
+Return Value:   the entire trainer record if found and error (if any),
+				ErrTrainerNotFound for a deleted record, ErrIDMismatch,
+				ErrInvalidID for id 0, io.EOF past the last record
 Type:           *os.File, uint16 -> TrainerRec, error
 */
 func GetTrainer(trainer_file *os.File, id uint16) (TrainerRec, error) {
-	var trainer TrainerRec
-	offset := int64(id-1) * int64(unsafe.Sizeof(trainer))
-	if _, err := trainer_file.Seek(offset, 0); err != nil {
+	if id == 0 {
+		return TrainerRec{}, ErrInvalidID //id-1 would wrap to the last possible record
+	}
+	buf, err := read_at(trainer_file, trainer_offset(id), TrainerRecSize)
+	if err != nil {
 		return TrainerRec{}, err
 	}
-
-	if err := binary.Read(trainer_file, binary.LittleEndian, &trainer); err != nil {
+	trainer, err := DecodeTrainerRec(buf)
+	if err != nil {
 		return TrainerRec{}, err
 	}
 	if trainer.ID == 0 {
-		return TrainerRec{}, fmt.Errorf("trainer ID not found")
+		return TrainerRec{}, ErrTrainerNotFound
+	}
+	if trainer.ID != id {
+		return TrainerRec{}, ErrIDMismatch
 	}
 
 	return trainer, nil
@@ -436,7 +875,10 @@ func GetTrainer(trainer_file *os.File, id uint16) (TrainerRec, error) {
 
 /*
 Function Name:  PostTrainer
-Description:    creates a new record and appends to end of trainer file
+Description:    creates a new record and appends to end of trainer file,
+				the new ID is the record count plus one and the record is
+				written at that ID's offset, so the caller must hold
+				GlobalManager.AppendLock or two posts can take the same ID
 Parameters:		trainer_file: the trainer binary data file
 				poke_file: the pokemon binary data file
 				name: name of the trainer (15 chars or less)
@@ -445,19 +887,35 @@ Return Value:   the new trainer's id if all pokemon were found and record succes
 Type:           *os.File, *os.File, string, []uint16 -> uint16, error
 */
 func PostTrainer(trainer_file *os.File, poke_file *os.File, name string, pokemon []uint16) (uint16, error) {
-	var trainer TrainerRec
-	trainer_size := int64(unsafe.Sizeof(trainer))
-	info, err := trainer_file.Stat()
+	id, err := post_trainer(trainer_file, poke_file, name, pokemon)
 	if err != nil {
 		return 0, err
 	}
+	return id, trainer_file.Sync()
+}
 
-	file_size := info.Size()
-	if file_size%trainer_size != 0 { //gofmt pushes these together?
-		return 0, fmt.Errorf("file size is not a multiple of record size")
+/*
+Function Name:  post_trainer
+Description:    PostTrainer without the Sync, for stores that sync on their
+				own schedule (see Syncer)
+Parameters:		trainer_file: the trainer binary data file
+				poke_file: the pokemon binary data file
+				name: name of the trainer (15 chars or less)
+				pokemon: list of assigned pokemon IDs
+Return Value:   the new trainer's id and error (if any)
+Type:           *os.File, *os.File, string, []uint16 -> uint16, error
+*/
+func post_trainer(trainer_file *os.File, poke_file *os.File, name string, pokemon []uint16) (uint16, error) {
+	var trainer TrainerRec
+	if err := CheckTrainerName(name); err != nil {
+		return 0, err
+	}
+	num_recs, err := trainer_count(trainer_file)
+	if err != nil {
+		return 0, err
 	}
 
-	next := uint64(file_size/trainer_size) + 1
+	next := uint64(num_recs) + 1
 	if next > 0xFFFF { //max
 		return 0, fmt.Errorf("next ID out of range")
 	}
@@ -477,21 +935,19 @@ func PostTrainer(trainer_file *os.File, poke_file *os.File, name string, pokemon
 		var display PokeDisplay
 		name, err := GetPokeName(poke_file, pokemon[idx])
 		if err != nil {
-			return 0, fmt.Errorf("pokemon ID not found")
+			return 0, ErrPokeNotFound
 		}
 		display.ID = pokemon[idx]
 		display.Name = name
 		*poke_slots[idx] = display
 	} //if there aren't 6, the remaining ids are 0 by default
 
-	if _, err := trainer_file.Seek(0, unix.SEEK_END); err != nil {
-		return 0, err
-	}
-	if err := binary.Write(trainer_file, binary.LittleEndian, &trainer); err != nil {
+	//the record count is read above, the caller holds AppendLock so the end hasn't moved
+	if _, err := trainer_file.WriteAt(EncodeTrainerRec(trainer), trainer_offset(trainer.ID)); err != nil {
 		return 0, err
 	}
 
-	return trainer.ID, trainer_file.Sync()
+	return trainer.ID, nil
 }
 
 /*
@@ -505,26 +961,44 @@ Return Value:   nil if trainer was found, pokemon were found, and modification w
 Type:           *os.File, *os.File, uint16, []uint16 -> error
 */
 func PutTrainer(trainer_file *os.File, poke_file *os.File, id uint16, pokemon []uint16) error {
+	if err := put_trainer(trainer_file, poke_file, nil, id, pokemon); err != nil {
+		return err
+	}
+	return trainer_file.Sync()
+}
+
+/*
+Function Name:  put_trainer
+Description:    PutTrainer without the Sync, for stores that sync on their
+				own schedule (see Syncer), logging the write first when
+				given a WAL
+Parameters:		trainer_file: the trainer binary data file
+				poke_file: the pokemon binary data file
+				wal: write-ahead log, nil for none
+				id: the record ID to search for
+				pokemon: list of new pokemon IDs to assign
+Return Value:   nil on success or error
+Type:           *os.File, *os.File, *WAL, uint16, []uint16 -> error
+*/
+func put_trainer(trainer_file *os.File, poke_file *os.File, wal *WAL, id uint16, pokemon []uint16) error {
+	if id == 0 {
+		return ErrInvalidID
+	}
 	old_data, err := GetTrainer(trainer_file, id)
-	if err != nil {
-		return fmt.Errorf("trainer ID not found")
+	if err == ErrIDMismatch {
+		return err //never write over another trainer's record
+	} else if err != nil {
+		return ErrTrainerNotFound
 	}
 
 	var trainer TrainerRec
 	trainer.ID = old_data.ID
 	trainer.Name = old_data.Name
 
-	trainer_size := int64(unsafe.Sizeof(trainer))
-	info, err := trainer_file.Stat()
-	if err != nil {
+	if _, err := trainer_count(trainer_file); err != nil {
 		return err
 	}
 
-	file_size := info.Size()
-	if file_size%trainer_size != 0 {
-		return fmt.Errorf("file size is not a multiple of record size")
-	}
-
 	poke_slots := []*PokeDisplay{
 		&trainer.Poke1,
 		&trainer.Poke2,
@@ -538,7 +1012,7 @@ func PutTrainer(trainer_file *os.File, poke_file *os.File, id uint16, pokemon []
 		if idx < len(pokemon) {
 			name, err := GetPokeName(poke_file, pokemon[idx])
 			if err != nil {
-				return fmt.Errorf("pokemon ID not found")
+				return ErrPokeNotFound
 			}
 			poke_name := name
 			*poke_slots[idx] = PokeDisplay{ID: pokemon[idx], Name: poke_name}
@@ -547,16 +1021,11 @@ func PutTrainer(trainer_file *os.File, poke_file *os.File, id uint16, pokemon []
 		}
 	}
 
-	offset := int64(id-1) * trainer_size
-	if _, err := trainer_file.Seek(offset, 0); err != nil {
-		return err
-	}
-
-	if err := binary.Write(trainer_file, binary.LittleEndian, &trainer); err != nil {
+	if err := write_logged(trainer_file, wal, trainer_offset(id), EncodeTrainerRec(trainer)); err != nil {
 		return err
 	}
 
-	return trainer_file.Sync()
+	return nil
 }
 
 /*
@@ -568,37 +1037,50 @@ Return Value:   nil if trainer found and no other file errors or error
 Type:           *os.File, uint16 -> error
 */
 func DeleteTrainer(trainer_file *os.File, id uint16) error {
+	return delete_trainer(trainer_file, nil, id)
+}
+
+/*
+Function Name:  delete_trainer
+Description:    DeleteTrainer, logging the write first when given a WAL
+Parameters:		trainer_file: the trainer binary data file
+				wal: write-ahead log, nil for none
+				id: the record ID to search for
+Return Value:   nil if trainer found and no other file errors or error
+Type:           *os.File, *WAL, uint16 -> error
+*/
+func delete_trainer(trainer_file *os.File, wal *WAL, id uint16) error {
+	if id == 0 {
+		return ErrInvalidID
+	}
 	if _, err := GetTrainer(trainer_file, id); err != nil {
 		return err
 	}
 
 	var blank TrainerRec
-	trainer_size := int64(unsafe.Sizeof(blank))
-	info, err := trainer_file.Stat()
-	if err != nil {
+	if _, err := trainer_count(trainer_file); err != nil {
 		return err
 	}
 
-	file_size := info.Size()
-	if file_size%trainer_size != 0 {
-		return fmt.Errorf("file size is not a multiple of record size")
-	}
-
-	offset := int64(id-1) * trainer_size
-	if _, err := trainer_file.Seek(offset, 0); err != nil {
-		return err
-	}
-	if err := binary.Write(trainer_file, binary.LittleEndian, &blank); err != nil {
+	if err := write_logged(trainer_file, wal, trainer_offset(id), EncodeTrainerRec(blank)); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+//size of the chunks LogReadN reads backward from the end of the log
+const log_chunk_size = 8192
+
+//largest n a log tail request (REQ_LOG_FILE, REQ_LOG_FILE_ALL, REQ_LOG_FILTER) accepts
+const MaxLogN = 10000
+
 /*
 Function Name:  LogReadN
 Description:    reads the last n lines from the log file,
 				if file has fewer than n lines, return whole file
+				reads backward from the end in log_chunk_size chunks until n
+				newlines are found, so cost is O(size of the tail) not O(file size)
 Parameters:     log_file: log file to read from
                 n: number of lines to return
 Return Value:   single newline-terminated string of all requested logs and error (if any)
@@ -613,74 +1095,375 @@ func LogReadN(log_file *os.File, n int) (string, error) {
 		return "Log file empty.", nil
 	}
 
-	if _, err := log_file.Seek(0, 0); err != nil {
+	end := info.Size()
+	buf := make([]byte, log_chunk_size)
+	if _, err := log_file.ReadAt(buf[:1], end-1); err != nil {
 		return "", err
 	}
-	data, err := io.ReadAll(log_file)
+	if buf[0] == '\n' {
+		end-- //final newline terminates the last line, it doesn't start a new one
+	}
+
+	start := int64(0) //whole file unless n newlines are found
+	if n == 0 {
+		start = end
+	}
+	count := 0
+	for pos := end; pos > 0 && start == 0 && n > 0; {
+		read_size := int64(log_chunk_size)
+		if pos < read_size {
+			read_size = pos
+		}
+		pos -= read_size
+		if _, err := log_file.ReadAt(buf[:read_size], pos); err != nil && err != io.EOF {
+			return "", err
+		}
+		for idx := read_size - 1; idx >= 0; idx-- {
+			if buf[idx] == '\n' {
+				count++
+				if count == n {
+					start = pos + idx + 1
+					break
+				}
+			}
+		}
+	}
+
+	tail := make([]byte, end-start)
+	if _, err := log_file.ReadAt(tail, start); err != nil && err != io.EOF {
+		return "", err
+	}
+	return string(tail) + "\n", nil
+}
+
+/*
+Function Name:  LogReadFiltered
+Description:    returns the last n lines of the log file that match, reads
+				backward from the end in log_chunk_size chunks like LogReadN
+				and stops once n matches are found, so a filter that matches
+				recent lines costs O(size of the tail), one matching nothing
+				reads the whole file
+Parameters:     log_file: log file to read from
+                n: number of matching lines to return
+                match: reports whether a line (without its newline) is wanted
+Return Value:   single newline-terminated string of the matching lines oldest
+				first, "" if none match, and error (if any)
+Type:           *os.File, int, func(string) bool -> string, error
+*/
+func LogReadFiltered(log_file *os.File, n int, match func(string) bool) (string, error) {
+	info, err := log_file.Stat()
 	if err != nil {
 		return "", err
 	}
+	var found []string //newest first
+	var carry []byte   //start of the line that continues into the chunk after
+	buf := make([]byte, log_chunk_size)
+	for pos := info.Size(); pos > 0 && len(found) < n; {
+		read_size := min(int64(log_chunk_size), pos)
+		pos -= read_size
+		if _, err := log_file.ReadAt(buf[:read_size], pos); err != nil && err != io.EOF {
+			return "", err
+		}
+		data := append(append([]byte(nil), buf[:read_size]...), carry...)
+		line_end := len(data)
+		for idx := len(data) - 1; idx >= 0 && len(found) < n; idx-- {
+			if data[idx] == '\n' {
+				if line := string(data[idx+1 : line_end]); line != "" && match(line) {
+					found = append(found, line)
+				}
+				line_end = idx
+			}
+		}
+		carry = data[:line_end]
+		if pos == 0 && len(found) < n && len(carry) > 0 && match(string(carry)) {
+			found = append(found, string(carry)) //first line of the file
+		}
+	}
 
-	lines := strings.Split(string(bytes.TrimSuffix(data, []byte{'\n'})), "\n")
-	start_idx := 0
-	if len(lines) > n {
-		start_idx = len(lines) - n
+	slices.Reverse(found)
+	if len(found) == 0 {
+		return "", nil
 	}
-	return strings.Join(lines[start_idx:], "\n") + "\n", nil
+	return strings.Join(found, "\n") + "\n", nil
+}
+
+//bytes LogFollower keeps from the end of what it returned, to recognize its file after a rotation
+const follow_mark_size = 64
+
+//reads lines appended to a log file since the last call, see Next
+type LogFollower struct {
+	file   *os.File
+	offset int64  //bytes of file already returned
+	mark   []byte //last bytes returned, up to follow_mark_size
 }
 
 /*
-Function Name:  ReallyWrite
-Description:    guarantees that entire message is written to file stream
-Parameters:		fp: the file stream (abstract of file descriptor)
-				msg: the message to send over stream
-Return Value:   nil if all bytes were successfully written or error
-Type:           *os.File, string -> error
+Function Name:  NewLogFollower
+Description:    starts following a log file at its current end
+Parameters:     log_file: log file to follow
+Return Value:   follower and error (if any)
+Type:           *os.File -> *LogFollower, error
 */
-func ReallyWrite(fp *os.File, msg string) error {
-	data := []byte(msg)
-	len_buf := make([]byte, 4)
-	binary.BigEndian.PutUint32(len_buf, uint32(len(data))) //network byte order
+func NewLogFollower(log_file *os.File) (*LogFollower, error) {
+	info, err := log_file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	follower := &LogFollower{file: log_file, offset: info.Size()}
+	if follower.offset > 0 {
+		start := max(follower.offset-follow_mark_size, 0)
+		follower.mark = make([]byte, follower.offset-start)
+		if _, err := log_file.ReadAt(follower.mark, start); err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+	return follower, nil
+}
 
-	packet := append(len_buf, data...)
-	total := 0
-	for total < len(packet) {
-		bytes_written, err := fp.Write(packet[total:])
-		if err != nil {
-			return err
+/*
+Function Name:  Next
+Description:    method of LogFollower
+				returns the whole lines appended since the last call, a
+				partial last line waits for its newline, if the file shrank
+				it was rotated (RotateLog) or cleared if it no longer holds the
+				bytes last returned where they were, after a rotation the
+				lines written between the last call and the rotation are
+				read from <file>.1 (recognized by ending where this follower
+				left off), then following goes on from the start of the file,
+				the caller holds the log lock
+Parameters:     n/a
+Return Value:   new lines, "" if none, and error (if any)
+Type:           n/a -> string, error
+*/
+func (f *LogFollower) Next() (string, error) {
+	info, err := f.file.Stat()
+	if err != nil {
+		return "", err
+	}
+	var out []byte
+	if replaced, err := f.replaced(info.Size()); err != nil {
+		return "", err
+	} else if replaced {
+		if rotated, err := os.ReadFile(f.file.Name() + ".1"); err == nil && int64(len(rotated)) >= f.offset &&
+			bytes.Equal(rotated[f.offset-int64(len(f.mark)):f.offset], f.mark) {
+			out = append(out, rotated[f.offset:]...)
 		}
-		total += bytes_written
+		f.offset, f.mark = 0, nil
 	}
-	return nil
+
+	if info.Size() > f.offset {
+		data := make([]byte, info.Size()-f.offset)
+		if _, err := f.file.ReadAt(data, f.offset); err != nil && err != io.EOF {
+			return "", err
+		}
+		whole := bytes.LastIndexByte(data, '\n') + 1 //0 if no line is complete yet
+		f.offset += int64(whole)
+		f.mark = append(f.mark, data[:whole]...)
+		out = append(out, data[:whole]...)
+	}
+	if len(f.mark) > follow_mark_size {
+		f.mark = append([]byte(nil), f.mark[len(f.mark)-follow_mark_size:]...)
+	}
+	return string(out), nil
 }
 
 /*
-Function Name:  ReallyRead
-Description:    guarantees that entire message is read from file stream
-Parameters:     fp: the file stream (abstract of file descriptor)
-Return Value:   the message read from file stream or error
-Type:           *os.File -> string, error
+Function Name:  replaced
+Description:    method of LogFollower
+				reports whether the file was rotated or cleared since the last
+				call: it is shorter than what was returned, or the bytes last
+				returned are gone (the new log grew past the old offset)
+Parameters:     size: current file size
+Return Value:   true if replaced and error (if any)
+Type:           int64 -> bool, error
 */
-func ReallyRead(fp *os.File) (string, error) {
-	len_buf := make([]byte, 4)
-	total := 0
-	for total < 4 { //lenth up to 4 bytes
-		bytes_read, err := fp.Read(len_buf[total:])
-		if err != nil {
+func (f *LogFollower) replaced(size int64) (bool, error) {
+	if size < f.offset {
+		return true, nil
+	}
+	if len(f.mark) == 0 {
+		return false, nil
+	}
+	buf := make([]byte, len(f.mark))
+	if _, err := f.file.ReadAt(buf, f.offset-int64(len(f.mark))); err != nil && err != io.EOF {
+		return false, err
+	}
+	return !bytes.Equal(buf, f.mark), nil
+}
+
+//timestamp the log package writes at the start of each line (log.LstdFlags, local time)
+const log_time_layout = "2006/01/02 15:04:05"
+
+/*
+Function Name:  ParseLogSince
+Description:    parses a log since timestamp, RFC 3339 with or without a zone,
+				without one it is server local time like the log itself
+Parameters:     text: timestamp, e.g. 2024-01-02T15:04:05 or 2024-01-02T15:04:05Z
+Return Value:   the time and error (if not a timestamp)
+Type:           string -> time.Time, error
+*/
+func ParseLogSince(text string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, text); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02T15:04:05", text, time.Local)
+}
+
+/*
+Function Name:  LogReadSince
+Description:    reads the log from the start and returns every line stamped
+				at or after since, the log has whole second stamps so since
+				is truncated to the second, lines without a stamp (the
+				continuation of a multi-line entry) are skipped
+Parameters:     log_file: log file to read from
+                since: earliest entry to return
+Return Value:   single newline-terminated string of the matching lines, "" if
+				none match, and error (if any)
+Type:           *os.File, time.Time -> string, error
+*/
+func LogReadSince(log_file *os.File, since time.Time) (string, error) {
+	info, err := log_file.Stat()
+	if err != nil {
+		return "", err
+	}
+	since = since.Truncate(time.Second)
+	reader := bufio.NewReader(io.NewSectionReader(log_file, 0, info.Size())) //ReadAt, leaves the offset alone
+	var out strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) >= len(log_time_layout) {
+			stamp, perr := time.ParseInLocation(log_time_layout, line[:len(log_time_layout)], time.Local)
+			if perr == nil && !stamp.Before(since) {
+				out.WriteString(strings.TrimSuffix(line, "\n") + "\n")
+			}
+		}
+		if err == io.EOF {
+			return out.String(), nil
+		} else if err != nil {
 			return "", err
 		}
-		total += bytes_read
 	}
+}
 
-	length := binary.BigEndian.Uint32(len_buf)
-	msg := make([]byte, length)
-	total = 0
-	for total < int(length) {
-		bytes_read, err := fp.Read(msg[total:])
+/*
+Function Name:  LogReadNAcrossFiles
+Description:    reads the last n lines across the rotated log (<base_name>.1)
+				and the current log (base_name), oldest first, so the tail is
+				correct right after a rotation, a missing rotated file is
+				treated as empty
+Parameters:     base_name: path of the current log file
+                n: number of lines to return
+Return Value:   single newline-terminated string of all requested logs and error (if any)
+Type:           string, int -> string, error
+*/
+func LogReadNAcrossFiles(base_name string, n int) (string, error) {
+	var data []byte
+	for _, name := range []string{base_name + ".1", base_name} {
+		file_data, err := os.ReadFile(name)
 		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
 			return "", err
 		}
-		total += bytes_read
+		if len(file_data) > 0 && file_data[len(file_data)-1] != '\n' {
+			file_data = append(file_data, '\n') //keep files from running together
+		}
+		data = append(data, file_data...)
+	}
+	if len(data) == 0 {
+		return "Log file empty.", nil
+	}
+	return tail_lines(data, n), nil
+}
+
+/*
+Function Name:  RotateLog
+Description:    moves the contents of the log to <name>.1 (replacing an older
+				rotation) and truncates the log in place, the open descriptor,
+				its file lock and any writer on it keep working, the caller
+				must hold the log lock
+Parameters:     log_file: the current log file, open read/write
+Return Value:   nil or error, the log is left untouched if the copy fails
+Type:           *os.File -> error
+*/
+func RotateLog(log_file *os.File) error {
+	if _, err := log_file.Seek(0, 0); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(log_file)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(log_file.Name()+".1", data, 0644); err != nil {
+		return err
+	}
+	if err := log_file.Truncate(0); err != nil {
+		return err
 	}
-	return string(msg), nil
+	_, err = log_file.Seek(0, 0) //O_APPEND writes go to the new end regardless
+	return err
+}
+
+/*
+Function Name:  tail_lines
+Description:    returns the last n lines of data, or all of data if it has
+				fewer than n lines
+Parameters:     data: newline separated log entries
+                n: number of lines to return
+Return Value:   single newline-terminated string of the selected lines
+Type:           []byte, int -> string
+*/
+func tail_lines(data []byte, n int) string {
+	lines := strings.Split(string(bytes.TrimSuffix(data, []byte{'\n'})), "\n")
+	start_idx := 0
+	if len(lines) > n {
+		start_idx = len(lines) - n
+	}
+	return strings.Join(lines[start_idx:], "\n") + "\n"
+}
+
+//wire protocol version sent in the handshake, a client only refuses a
+//server with a different major version
+//major: bump when framing, the connect sequence or an existing reply changes
+//(2: crc32 frames, 3: trainer listings end with "DONE <count>")
+//minor: bump when commands are added, older clients simply never send them
+const (
+	ProtocolMajor = 3
+	ProtocolMinor = 10 //1: POST_TRAINER trailing KEY=<token>, 2: REQ_SCHEMA, 3: REQ_RANDOM_POKE/TRAINER, 4: REQ_POKE_SIMILAR, 5: REQ_POKE_AGG, 6: REQ_TRAINER_WITH_POKE, 7: MOVE_TRAINER_POKE, 8: PATCH_POKE, 9: REQ_POKE_TYPES, 10: REQ_VALIDATE_REFS
+)
+
+/*
+Function Name:  Handshake
+Description:    first frame the server sends a client, "POKEDB/<major>.<minor>",
+				the ephemeral port follows in the next frame
+Parameters:     N/A
+Return Value:   handshake message
+Type:           n/a -> string
+*/
+func Handshake() string {
+	return fmt.Sprintf("POKEDB/%d.%d", ProtocolMajor, ProtocolMinor)
+}
+
+/*
+Function Name:  CheckHandshake
+Description:    checks the server handshake is for this protocol major version
+Parameters:     msg: first frame read from the server
+Return Value:   nil or error if not a handshake or the major version differs
+Type:           string -> error
+*/
+func CheckHandshake(msg string) error {
+	version, found := strings.CutPrefix(msg, "POKEDB/")
+	if !found {
+		return fmt.Errorf("unexpected handshake from server: %q", msg)
+	}
+	major_str, _, _ := strings.Cut(version, ".")
+	major, err := strconv.Atoi(major_str)
+	if err != nil {
+		return fmt.Errorf("unexpected handshake from server: %q", msg)
+	}
+	if major != ProtocolMajor {
+		return fmt.Errorf("server speaks protocol %s, client speaks %d.%d", version, ProtocolMajor, ProtocolMinor)
+	}
+	return nil
 }
@@ -0,0 +1,539 @@
+/*
+Filename:  record_test.go
+Description:
+  - Concurrency tests for trainer posts and the GlobalManager record locks
+  - The id 0 guard of the record readers and writers
+*/
+package recordlib
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/*
+Function Name:  TestConcurrentPostIDs
+Description:    50 goroutines post to one trainer file at once, each under
+				LockAppend like the server's POST handler, the IDs are
+				exactly 1..50 and every record sits at its ID's position
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestConcurrentPostIDs(t *testing.T) {
+	const posters = 50
+	store, _ := temp_trainer_store(t)
+	gm := NewGlobalManager(LockWriterFirst)
+
+	ids := make([]uint16, posters)
+	errs := make([]error, posters)
+	var start, done sync.WaitGroup
+	start.Add(1)
+	for idx := 0; idx < posters; idx++ {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			start.Wait() //all posts begin together
+			gm.LockAppend()
+			ids[idx], errs[idx] = store.Post(fmt.Sprintf("t%d", idx), []uint16{uint16(idx + 1)})
+			gm.UnlockAppend()
+		}()
+	}
+	start.Done()
+	done.Wait()
+
+	for idx, err := range errs {
+		if err != nil {
+			t.Fatalf("post %d: %v", idx, err)
+		}
+	}
+	for idx, id := range ids {
+		trainer, err := store.Get(id) //ErrIDMismatch if the record isn't at its ID's offset
+		if err != nil || CString(trainer.Name[:]) != fmt.Sprintf("t%d", idx) || trainer.Poke1.ID != uint16(idx+1) {
+			t.Fatalf("post %d got ID %d, record %+v, %v", idx, id, trainer, err)
+		}
+	}
+	sorted := slices.Sorted(slices.Values(ids))
+	for idx, id := range sorted {
+		if id != uint16(idx+1) {
+			t.Fatalf("IDs %v, want 1..%d with no duplicates", sorted, posters)
+		}
+	}
+	if count, _ := store.Count(); count != posters {
+		t.Fatalf("%d records, want %d", count, posters)
+	}
+}
+
+/*
+Function Name:  wait_or_fail
+Description:    waits for a WaitGroup, failing the test if it takes longer
+				than limit (a deadlock)
+Parameters:     t: test handle
+				wg: the goroutines to wait for
+				limit: longest wait
+				what: description for the failure
+Return Value:   n/a
+Type:           *testing.T, *sync.WaitGroup, time.Duration, string -> n/a
+*/
+func wait_or_fail(t *testing.T, wg *sync.WaitGroup, limit time.Duration, what string) {
+	t.Helper()
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(limit):
+		t.Fatalf("%s didn't finish within %v, deadlocked", what, limit)
+	}
+}
+
+/*
+Function Name:  TestOppositeSwapsNoDeadlock
+Description:    two goroutines swap pokemon between trainers A and B, one
+				locking (A,B) and the other (B,A), beside a ReadAll reader,
+				under every lock policy, all finish, the reader never sees a
+				half-done swap and the six pokemon are still all there
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestOppositeSwapsNoDeadlock(t *testing.T) {
+	const rounds = 5000
+	for _, policy := range []LockPolicy{LockWriterFirst, LockReaderFirst, LockFIFO} {
+		store := NewMemTrainerStore(open_test_poke(t))
+		store.Post("a", []uint16{1, 2, 3})
+		store.Post("b", []uint16{4, 5, 6})
+		gm := NewGlobalManager(policy)
+		all_poke := func() []uint16 {
+			a, _ := store.Get(1)
+			b, _ := store.Get(2)
+			return slices.Sorted(slices.Values(append(TrainerPokeIDs(a), TrainerPokeIDs(b)...)))
+		}
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 2*rounds)
+		for _, pair := range [][2]uint16{{1, 2}, {2, 1}} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for round := 0; round < rounds; round++ {
+					locked := gm.WLockRecords(pair[0], pair[1])
+					if err := SwapTrainerPoke(store, pair[0], round%3+1, pair[1], (round+1)%3+1); err != nil {
+						errs <- err
+					}
+					gm.WUnlockRecords(locked...)
+				}
+			}()
+		}
+		torn := make(chan []uint16, 1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for round := 0; round < rounds/10; round++ {
+				gm.LockReadAll()
+				if got := all_poke(); !slices.Equal(got, []uint16{1, 2, 3, 4, 5, 6}) {
+					select {
+					case torn <- got:
+					default:
+					}
+				}
+				gm.UnlockReadAll()
+			}
+		}()
+		wait_or_fail(t, &wg, 10*time.Second, fmt.Sprintf("%v: opposite swaps", policy))
+
+		close(errs)
+		for err := range errs {
+			t.Fatalf("%v: swap: %v", policy, err)
+		}
+		select {
+		case got := <-torn:
+			t.Fatalf("%v: ReadAll saw pokemon %v mid-swap", policy, got)
+		default:
+		}
+		if got := all_poke(); !slices.Equal(got, []uint16{1, 2, 3, 4, 5, 6}) {
+			t.Fatalf("%v: pokemon after the swaps %v, want 1..6", policy, got)
+		}
+	}
+}
+
+/*
+Function Name:  TestWLockRecordsDedup
+Description:    repeated and unordered ids lock each record once, so the
+				call doesn't deadlock on itself, and unlock releases them all
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestWLockRecordsDedup(t *testing.T) {
+	gm := NewGlobalManager(LockWriterFirst)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		locked := gm.WLockRecords(9, 3, 9, 3, 5)
+		if !slices.Equal(locked, []uint16{3, 5, 9}) {
+			t.Errorf("locked %v, want [3 5 9]", locked)
+		}
+		gm.WUnlockRecords(locked...)
+	}()
+	wait_or_fail(t, &wg, 5*time.Second, "WLockRecords with repeats")
+	if err := gm.WLockRecordTimeout(9, 100*time.Millisecond); err != nil {
+		t.Fatalf("record 9 after WUnlockRecords: %v", err)
+	}
+	gm.WUnlockRecord(9)
+}
+
+/*
+Function Name:  TestRecordLockStress
+Description:    32 goroutines take 3000 random record read, record write,
+				two-record write and read-all locks each on four records,
+				under every policy, nothing may hang (a lost wakeup in
+				RLockRecord or WLockRecord would) and no reader may overlap
+				a writer of its record
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestRecordLockStress(t *testing.T) {
+	const workers, ops, records = 32, 3000, 4
+	for _, policy := range []LockPolicy{LockWriterFirst, LockReaderFirst, LockFIFO} {
+		gm := NewGlobalManager(policy)
+		var readers, writers [records + 1]atomic.Int32
+		var overlaps atomic.Int32
+		check_read := func(id uint16) {
+			runtime.Gosched() //let others run while the lock is held, even on one CPU
+			if writers[id].Load() != 0 {
+				overlaps.Add(1)
+			}
+		}
+		write := func(id uint16) {
+			if writers[id].Add(1) != 1 || readers[id].Load() != 0 {
+				overlaps.Add(1)
+			}
+			runtime.Gosched()
+			writers[id].Add(-1)
+		}
+
+		var wg sync.WaitGroup
+		for worker := 0; worker < workers; worker++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				rng := rand.New(rand.NewPCG(uint64(worker), uint64(policy)))
+				for op := 0; op < ops; op++ {
+					id := uint16(rng.IntN(records) + 1)
+					switch rng.IntN(10) {
+					case 0, 1, 2, 3, 4: //reads are the common case
+						gm.RLockRecord(id)
+						readers[id].Add(1)
+						check_read(id)
+						readers[id].Add(-1)
+						gm.RUnlockRecord(id)
+					case 5, 6, 7:
+						gm.WLockRecord(id)
+						write(id)
+						gm.WUnlockRecord(id)
+					case 8:
+						other := uint16(rng.IntN(records) + 1)
+						locked := gm.WLockRecords(id, other)
+						for _, locked_id := range locked {
+							write(locked_id)
+						}
+						gm.WUnlockRecords(locked...)
+					case 9:
+						gm.LockReadAll()
+						for rec := uint16(1); rec <= records; rec++ {
+							check_read(rec)
+						}
+						gm.UnlockReadAll()
+					}
+				}
+			}()
+		}
+		wait_or_fail(t, &wg, 60*time.Second, fmt.Sprintf("%v: lock stress", policy))
+		if n := overlaps.Load(); n > 0 {
+			t.Fatalf("%v: %d times a reader or second writer overlapped a writer", policy, n)
+		}
+	}
+}
+
+/*
+Function Name:  wait_queued
+Description:    polls a record lock until the given numbers of readers and
+				writers wait on it, so the next locker arrives after them
+Parameters:     t: test handle
+				gm: lock manager
+				id: record id
+				readers: waiting readers (NumReadWaiting)
+				writers: queued writers (true markers in WrQueue)
+Return Value:   n/a
+Type:           *testing.T, *GlobalManager, uint16, int, int -> n/a
+*/
+func wait_queued(t *testing.T, gm *GlobalManager, id uint16, readers int, writers int) {
+	t.Helper()
+	rec_lock := gm.GetRecordLock(id)
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		rec_lock.Lock.Lock()
+		queued := 0
+		for elem := rec_lock.WrQueue.Front(); elem != nil; elem = elem.Next() {
+			if elem.Value.(bool) {
+				queued++
+			}
+		}
+		ok := rec_lock.NumReadWaiting == readers && queued == writers
+		rec_lock.Lock.Unlock()
+		if ok {
+			return
+		}
+	}
+	t.Fatalf("record %d never had %d waiting readers and %d queued writers", id, readers, writers)
+}
+
+/*
+Function Name:  TestLockPolicyOrder
+Description:    while a writer holds a record, reader r1, writer w2 and
+				reader r3 queue on it in that order, on release the policy
+				decides who goes first: writer-first lets w2 in before both
+				readers, reader-first both readers before w2, fifo arrival
+				order r1, w2, r3
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestLockPolicyOrder(t *testing.T) {
+	tests := []struct {
+		policy LockPolicy
+		want   [][]string //groups in order, names inside a group may come in any order
+	}{
+		{LockWriterFirst, [][]string{{"w2"}, {"r1", "r3"}}},
+		{LockReaderFirst, [][]string{{"r1", "r3"}, {"w2"}}},
+		{LockFIFO, [][]string{{"r1"}, {"w2"}, {"r3"}}},
+	}
+	for _, tt := range tests {
+		gm := NewGlobalManager(tt.policy)
+		var order_lock sync.Mutex
+		var order []string
+		took := func(name string) {
+			order_lock.Lock()
+			order = append(order, name)
+			order_lock.Unlock()
+			time.Sleep(5 * time.Millisecond) //hold it, a wrong policy lets the next one in meanwhile
+		}
+
+		gm.WLockRecord(1)
+		var wg sync.WaitGroup
+		reader := func(name string) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				gm.RLockRecord(1)
+				took(name)
+				gm.RUnlockRecord(1)
+			}()
+		}
+		reader("r1")
+		wait_queued(t, gm, 1, 1, 0)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			gm.WLockRecord(1)
+			took("w2")
+			gm.WUnlockRecord(1)
+		}()
+		wait_queued(t, gm, 1, 1, 1)
+		reader("r3")
+		wait_queued(t, gm, 1, 2, 1)
+		gm.WUnlockRecord(1)
+		wait_or_fail(t, &wg, 5*time.Second, fmt.Sprintf("%v: queued lockers", tt.policy))
+
+		pos := 0
+		for _, group := range tt.want {
+			got := slices.Sorted(slices.Values(order[pos : pos+len(group)]))
+			if !slices.Equal(got, group) {
+				t.Fatalf("%v: lock order %v, want groups %v", tt.policy, order, tt.want)
+			}
+			pos += len(group)
+		}
+	}
+}
+
+/*
+Function Name:  TestInvalidID
+Description:    id 0 gives ErrInvalidID from GetPokemon, GetPokeRaw,
+				GetPokeName, GetTrainer, PutTrainer and DeleteTrainer instead
+				of wrapping to the last record, and the writers leave the
+				trainer file untouched
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestInvalidID(t *testing.T) {
+	store, path := temp_trainer_store(t)
+	if _, err := store.Post("ash", []uint16{25}); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := GetPokemon(store.PokeFile, 0); err != ErrInvalidID {
+		t.Fatalf("GetPokemon: %v, want ErrInvalidID", err)
+	}
+	if _, err := GetPokeRaw(store.PokeFile, 0); err != ErrInvalidID {
+		t.Fatalf("GetPokeRaw: %v, want ErrInvalidID", err)
+	}
+	if _, err := GetPokeName(store.PokeFile, 0); err != ErrInvalidID {
+		t.Fatalf("GetPokeName: %v, want ErrInvalidID", err)
+	}
+	if _, err := GetTrainer(store.TrainerFile, 0); err != ErrInvalidID {
+		t.Fatalf("GetTrainer: %v, want ErrInvalidID", err)
+	}
+	if err := PutTrainer(store.TrainerFile, store.PokeFile, 0, []uint16{6}); err != ErrInvalidID {
+		t.Fatalf("PutTrainer: %v, want ErrInvalidID", err)
+	}
+	if err := DeleteTrainer(store.TrainerFile, 0); err != ErrInvalidID {
+		t.Fatalf("DeleteTrainer: %v, want ErrInvalidID", err)
+	}
+
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(before) {
+		t.Fatalf("trainer file changed: %d bytes before, %d after", len(before), len(after))
+	}
+}
+
+/*
+Function Name:  TestCheckTrainerName
+Description:    printable ASCII names pass, an embedded tab, NUL, other
+				control bytes, DEL and multi-byte characters give ErrBadName
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestCheckTrainerName(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"ash", true},
+		{"Mr.Mime-Fan_99!", true},
+		{"~ ~", true},
+		{"", true}, //the request regexps never capture an empty name
+		{"a\tb", false},
+		{"a\x00b", false},
+		{"ash\x00", false},
+		{"\x1b[31mred", false},
+		{"a\vb", false},
+		{"a\nb", false},
+		{"del\x7f", false},
+		{"pokémon", false},
+	}
+	for _, tt := range tests {
+		if err := CheckTrainerName(tt.name); (err == nil) != tt.ok {
+			t.Fatalf("CheckTrainerName(%q): %v, want ok %v", tt.name, err, tt.ok)
+		}
+		if err := CheckTrainerName(tt.name); err != nil && err != ErrBadName {
+			t.Fatalf("CheckTrainerName(%q): %v, want ErrBadName", tt.name, err)
+		}
+	}
+}
+
+/*
+Function Name:  TestStoredTrainerName
+Description:    StoredTrainerName gives the name a FileTrainerStore reads
+				back after posting it: over-long names cut to the 16 byte
+				field; anything after a null is dropped, though Post
+				refuses such a name
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestStoredTrainerName(t *testing.T) {
+	store, _ := temp_trainer_store(t)
+	tests := []struct {
+		name, stored string
+	}{
+		{"ash", "ash"},
+		{"abcdefghijklmno", "abcdefghijklmno"},
+		{"abcdefghijklmnop", "abcdefghijklmnop"},
+		{"abcdefghijklmnopqrstuvwxyz", "abcdefghijklmnop"},
+		{"ash\x00ketchum", "ash"},
+	}
+	for _, tt := range tests {
+		if got := StoredTrainerName(tt.name); got != tt.stored {
+			t.Fatalf("StoredTrainerName(%q) = %q, want %q", tt.name, got, tt.stored)
+		}
+		if CheckTrainerName(tt.name) != nil {
+			continue
+		}
+		id, err := store.Post(tt.name, []uint16{25})
+		if err != nil {
+			t.Fatalf("post %q: %v", tt.name, err)
+		}
+		trainer, err := store.Get(id)
+		if err != nil {
+			t.Fatalf("get %d: %v", id, err)
+		}
+		if got := CString(trainer.Name[:]); got != tt.stored {
+			t.Fatalf("post %q stored %q, want %q", tt.name, got, tt.stored)
+		}
+	}
+}
+
+/*
+Function Name:  TestPokeRecTotal
+Description:    Total matches a sum of the six stats done in int, for zero,
+				mixed and all-255 records where a uint8 sum would wrap, and
+				for every pokemon in poke.bin
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestPokeRecTotal(t *testing.T) {
+	sum := func(rec PokeRec) int {
+		return int(rec.HP) + int(rec.Attack) + int(rec.Defense) + int(rec.SpAtk) + int(rec.SpDef) + int(rec.Speed)
+	}
+	recs := []PokeRec{
+		{},
+		{HP: 45, Attack: 49, Defense: 49, SpAtk: 65, SpDef: 65, Speed: 45},
+		{HP: 255, Attack: 10, Defense: 10, SpAtk: 10, SpDef: 10, Speed: 10},
+		{HP: 255, Attack: 255, Defense: 255, SpAtk: 255, SpDef: 255, Speed: 255},
+	}
+	for _, rec := range recs {
+		if total := rec.Total(); int(total) != sum(rec) {
+			t.Fatalf("Total of %+v = %d, want %d", rec, total, sum(rec))
+		}
+	}
+	if total := recs[3].Total(); total != 1530 {
+		t.Fatalf("Total of all 255 stats = %d, want 1530", total)
+	}
+
+	poke_file := open_test_poke(t)
+	count := 0
+	for id := uint16(1); ; id++ {
+		rec, err := GetPokemon(poke_file, id)
+		if err != nil {
+			break
+		}
+		if int(rec.Total()) != sum(rec) {
+			t.Fatalf("pokemon %d Total = %d, want %d", id, rec.Total(), sum(rec))
+		}
+		count++
+	}
+	if count < 700 {
+		t.Fatalf("read %d pokemon from poke.bin, want the full file", count)
+	}
+}
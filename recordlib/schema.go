@@ -0,0 +1,130 @@
+/*
+Filename:  schema.go
+Description:
+  - Self-description of the on-disk record formats for tooling (REQ_SCHEMA, client describe)
+  - Field names, types, offsets and sizes come from the PokeRec/TrainerRec structs with
+    reflect, in declaration order with no padding, the same layout codec.go writes
+  - Constraints can't be read off the structs, they are kept next to them here and match the
+    record comments, CheckPokeRec/CheckTrainerRec and the server's request checks
+*/
+package recordlib
+
+import (
+	"fmt"
+	"reflect"
+)
+
+//one field of a record, nested records (trainer pokemon slots) list their own fields
+type SchemaField struct {
+	Name       string        `json:"name"`
+	Type       string        `json:"type"` //uint8, uint16, char[n] or the nested record type
+	Offset     int           `json:"offset"`
+	Size       int           `json:"size"`
+	Constraint string        `json:"constraint,omitempty"`
+	Fields     []SchemaField `json:"fields,omitempty"`
+}
+
+//reply for REQ_SCHEMA
+type RecordSchema struct {
+	Record    string        `json:"record"`
+	Size      int           `json:"size"`
+	ByteOrder string        `json:"byte_order"`
+	Header    int           `json:"header"` //bytes before the first record in the file
+	Fields    []SchemaField `json:"fields"`
+}
+
+//constraints by field name, nested fields as "<type>.<field>"
+var schema_constraints = map[string]string{
+	"PokeRec.ID":          "1-65535, equal to the record's position in the file",
+	"PokeRec.Name":        "required",
+	"PokeRec.Type1":       "required",
+	"PokeRec.Type2":       "empty for single type pokemon",
+	"PokeRec.HP":          "base stat, part of the total",
+	"PokeRec.Attack":      "base stat, part of the total",
+	"PokeRec.Defense":     "base stat, part of the total",
+	"PokeRec.SpAtk":       "base stat, part of the total",
+	"PokeRec.SpDef":       "base stat, part of the total",
+	"PokeRec.Speed":       "base stat, part of the total",
+	"PokeRec.Generation":  "generation introduced, 1-6",
+	"PokeRec.IsLegendary": "0 false, 1 true",
+	"PokeRec.Color":       "required",
+	"PokeRec.HasGender":   "0 false, 1 true",
+	"PokeRec.PrMale":      "male probability in eighths, 0-8, unused when HasGender is 0",
+	"PokeRec.EggGroup1":   "required",
+	"PokeRec.EggGroup2":   "empty if only one egg group",
+	"PokeRec.HasMegaEvo":  "0 false, 1 true",
+	"PokeRec.HeightM":     "height x 100, divides by 100, at most 1450",
+	"PokeRec.WeightKg":    "weight x 10, divides by 10, at most 9500",
+	"PokeRec.CatchRate":   "0-255",
+	"PokeRec.BodyStyle":   "required",
+
+	"TrainerRec.ID":    "1-65535, equal to the record's position in the file, an all zero record is deleted",
+	"TrainerRec.Name":  "required, one word",
+	"PokeDisplay.ID":   "pokemon ID, 0 for an empty slot, slots fill from slot 1 with no gaps",
+	"PokeDisplay.Name": "copy of the pokemon's name, empty for an empty slot",
+}
+
+/*
+Function Name:  describe_fields
+Description:    lists the fields of a record struct type with their offsets
+				from base, strings are null padded printable ASCII with room
+				for one byte less than the field
+Parameters:     t: struct type
+				base: offset of the struct in the record
+Return Value:   fields and the struct's size in bytes
+Type:           reflect.Type, int -> []SchemaField, int
+*/
+func describe_fields(t reflect.Type, base int) ([]SchemaField, int) {
+	var fields []SchemaField
+	off := base
+	for idx := 0; idx < t.NumField(); idx++ {
+		sf := t.Field(idx)
+		field := SchemaField{Name: sf.Name, Offset: off, Constraint: schema_constraints[t.Name()+"."+sf.Name]}
+		switch sf.Type.Kind() {
+		case reflect.Uint8, reflect.Uint16:
+			field.Type = sf.Type.Kind().String()
+			field.Size = int(sf.Type.Size())
+		case reflect.Array: //[n]byte text
+			field.Type = fmt.Sprintf("char[%d]", sf.Type.Len())
+			field.Size = sf.Type.Len()
+			text := fmt.Sprintf("printable ASCII, up to %d characters, null padded", sf.Type.Len()-1)
+			if field.Constraint == "" {
+				field.Constraint = text
+			} else {
+				field.Constraint = field.Constraint + ", " + text
+			}
+		case reflect.Struct:
+			field.Type = sf.Type.Name()
+			field.Fields, field.Size = describe_fields(sf.Type, off)
+		}
+		fields = append(fields, field)
+		off += field.Size
+	}
+	return fields, off - base
+}
+
+/*
+Function Name:  DescribeRecord
+Description:    schema of the pokemon or trainer record format
+Parameters:     record: "pokemon" or "trainer"
+Return Value:   the schema and nil, or error for an unknown record or a
+				struct whose size no longer matches the codec's record size
+Type:           string -> RecordSchema, error
+*/
+func DescribeRecord(record string) (RecordSchema, error) {
+	var t reflect.Type
+	var size, header int
+	switch record {
+	case "pokemon":
+		t, size = reflect.TypeOf(PokeRec{}), PokeRecSize
+	case "trainer":
+		t, size, header = reflect.TypeOf(TrainerRec{}), TrainerRecSize, HeaderSize
+	default:
+		return RecordSchema{}, fmt.Errorf("no record format %q", record)
+	}
+	fields, got := describe_fields(t, 0)
+	if got != size {
+		return RecordSchema{}, fmt.Errorf("%s fields add up to %d bytes, records are %d", t.Name(), got, size)
+	}
+	return RecordSchema{Record: t.Name(), Size: size, ByteOrder: "little endian", Header: header, Fields: fields}, nil
+}
@@ -0,0 +1,374 @@
+/*
+Filename:  store.go
+Description:
+  - Defines the TrainerStore interface the server handlers depend on
+  - FileTrainerStore implements it with the trainer binary file functions in record.go
+  - MemTrainerStore implements it entirely in memory (nothing persisted)
+  - PostBatch creates a batch of trainers all or nothing
+  - Callers are still responsible for GlobalManager record locking and poke file locking
+*/
+package recordlib
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+var ErrTrainerOrder = fmt.Errorf("trainer records visited out of ID order")
+
+//one trainer to create in a PostBatch call
+type TrainerDef struct {
+	Name    string
+	Pokemon []uint16
+}
+
+//PostBatch (or ImportPokemonJSON) failure, Row is the 1-based index of the first failing TrainerDef (or array element)
+type BatchError struct {
+	Row int
+	Err error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("row %d: %v", e.Row, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+type TrainerStore interface {
+	Get(id uint16) (TrainerRec, error)
+	//Post and PostBatch assign the next IDs, callers serialize them with GlobalManager.AppendLock
+	Post(name string, pokemon []uint16) (uint16, error)
+	//posts every def or none of them, returns new IDs in def order or *BatchError
+	PostBatch(defs []TrainerDef) ([]uint16, error)
+	Put(id uint16, pokemon []uint16) error
+	Delete(id uint16) error
+	//visits every live record in strictly ascending ID order, stops on first visit error,
+	//this order is part of the contract whatever the storage layout, since
+	//REQ_TRAINER_ALL streams records in the order they are visited
+	All(visit func(TrainerRec) error) error
+	//number of record slots, deleted records included, so IDs run 1 to Count
+	Count() (int, error)
+}
+
+type FileTrainerStore struct {
+	TrainerFile *os.File
+	PokeFile    *os.File
+	Syncer      *Syncer //when posts and puts are synced, nil syncs after each one
+	WAL         *WAL    //logs puts and deletes before they are written, nil for none
+}
+
+/*
+Function Name:  NewFileTrainerStore
+Description:    wraps the trainer and pokemon binary data files in a TrainerStore
+Parameters:     trainer_file: the trainer binary data file
+				poke_file: the pokemon binary data file (for pokemon names)
+Return Value:   newly allocated FileTrainerStore
+Type:           *os.File, *os.File -> *FileTrainerStore
+*/
+func NewFileTrainerStore(trainer_file *os.File, poke_file *os.File) *FileTrainerStore {
+	return &FileTrainerStore{TrainerFile: trainer_file, PokeFile: poke_file}
+}
+
+func (s *FileTrainerStore) Get(id uint16) (TrainerRec, error) {
+	return GetTrainer(s.TrainerFile, id)
+}
+
+func (s *FileTrainerStore) Count() (int, error) {
+	num_recs, err := trainer_count(s.TrainerFile)
+	return int(num_recs), err
+}
+
+func (s *FileTrainerStore) Post(name string, pokemon []uint16) (uint16, error) {
+	id, err := post_trainer(s.TrainerFile, s.PokeFile, name, pokemon)
+	if err != nil {
+		return 0, err
+	}
+	return id, s.synced(1)
+}
+
+/*
+Function Name:  PostBatch
+Description:    method of FileTrainerStore
+				looks up every pokemon name before writing anything, then
+				appends one record per def, if a write fails the file is
+				truncated back to its size before the batch, the caller
+				holds GlobalManager.AppendLock (see PostTrainer)
+Parameters:     defs: trainers to create, in order
+Return Value:   new trainer IDs in def order, or *BatchError
+Type:           []TrainerDef -> []uint16, error
+*/
+func (s *FileTrainerStore) PostBatch(defs []TrainerDef) ([]uint16, error) {
+	num_recs, err := trainer_count(s.TrainerFile)
+	if err != nil {
+		return nil, &BatchError{Row: 1, Err: err}
+	}
+	file_size := HeaderSize + num_recs*TrainerRecSize //rollback point
+
+	first := num_recs + 1
+	recs := make([]TrainerRec, len(defs))
+	for idx, def := range defs {
+		if first+int64(idx) > 0xFFFF {
+			return nil, &BatchError{Row: idx + 1, Err: fmt.Errorf("next ID out of range")}
+		}
+		if err := CheckTrainerName(def.Name); err != nil {
+			return nil, &BatchError{Row: idx + 1, Err: err}
+		}
+		recs[idx].ID = uint16(first + int64(idx))
+		copy(recs[idx].Name[:], def.Name)
+		if err := fill_slots(s.PokeFile, &recs[idx], def.Pokemon); err != nil {
+			return nil, &BatchError{Row: idx + 1, Err: err}
+		}
+	}
+
+	ids := make([]uint16, len(recs))
+	for idx := range recs {
+		if _, err := s.TrainerFile.WriteAt(EncodeTrainerRec(recs[idx]), trainer_offset(recs[idx].ID)); err != nil {
+			s.TrainerFile.Truncate(file_size) //roll back the partial batch
+			return nil, &BatchError{Row: idx + 1, Err: err}
+		}
+		ids[idx] = recs[idx].ID
+	}
+	if err := s.synced(len(recs)); err != nil {
+		return nil, &BatchError{Row: len(defs), Err: err}
+	}
+	return ids, nil
+}
+
+func (s *FileTrainerStore) Put(id uint16, pokemon []uint16) error {
+	if err := put_trainer(s.TrainerFile, s.PokeFile, s.WAL, id, pokemon); err != nil {
+		return err
+	}
+	return s.synced(1)
+}
+
+/*
+Function Name:  synced
+Description:    method of FileTrainerStore
+				hands finished writes to the Syncer, or syncs right away
+				without one (checkpointing the WAL)
+Parameters:     writes: records written
+Return Value:   Sync error (if any)
+Type:           int -> error
+*/
+func (s *FileTrainerStore) synced(writes int) error {
+	if s.Syncer == nil {
+		return CheckpointWAL(s.WAL, s.TrainerFile)
+	}
+	return s.Syncer.Wrote(writes)
+}
+
+func (s *FileTrainerStore) Delete(id uint16) error {
+	if err := delete_trainer(s.TrainerFile, s.WAL, id); err != nil {
+		return err
+	}
+	return s.synced(1) //like Put, or delete-only traffic would never checkpoint the WAL
+}
+
+/*
+Function Name:  All
+Description:    method of FileTrainerStore
+				validates file size and visits every live trainer record,
+				skipping logically deleted records, loop is bounded by the
+				number of records the file holds, record position is ID-1
+				so file order is ascending ID order
+Parameters:     visit: called once per live record
+Return Value:   nil once all records visited, ErrFileSize, read error or visit error
+Type:           func(TrainerRec) error -> error
+*/
+func (s *FileTrainerStore) All(visit func(TrainerRec) error) error {
+	num_recs, err := trainer_count(s.TrainerFile)
+	if err != nil {
+		return err
+	}
+
+	for idx := int64(1); idx <= num_recs; idx++ {
+		trainer, err := GetTrainer(s.TrainerFile, uint16(idx))
+		if err != nil {
+			if err == ErrTrainerNotFound {
+				continue //blank record from deletion
+			}
+			if err == io.EOF {
+				return nil //file shrank while reading, nothing left
+			}
+			return err
+		}
+		if err := visit(trainer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type MemTrainerStore struct {
+	lock     sync.Mutex
+	recs     []TrainerRec //index is id-1, zeroed record is logically deleted
+	PokeFile *os.File
+}
+
+/*
+Function Name:  NewMemTrainerStore
+Description:    allocates an empty in-memory TrainerStore, nothing is persisted
+Parameters:     poke_file: the pokemon binary data file (for pokemon names)
+Return Value:   newly allocated MemTrainerStore
+Type:           *os.File -> *MemTrainerStore
+*/
+func NewMemTrainerStore(poke_file *os.File) *MemTrainerStore {
+	return &MemTrainerStore{PokeFile: poke_file}
+}
+
+/*
+Function Name:  fill_slots
+Description:    looks up pokemon names and fills all six slots of trainer,
+				slots past len(pokemon) are zeroed
+Parameters:     poke_file: the pokemon binary data file
+				trainer: record to fill
+				pokemon: list of pokemon IDs
+Return Value:   nil or ErrPokeNotFound
+Type:           *os.File, *TrainerRec, []uint16 -> error
+*/
+func fill_slots(poke_file *os.File, trainer *TrainerRec, pokemon []uint16) error {
+	poke_slots := []*PokeDisplay{
+		&trainer.Poke1,
+		&trainer.Poke2,
+		&trainer.Poke3,
+		&trainer.Poke4,
+		&trainer.Poke5,
+		&trainer.Poke6,
+	}
+	for idx := range poke_slots {
+		if idx < len(pokemon) {
+			name, err := GetPokeName(poke_file, pokemon[idx])
+			if err != nil {
+				return ErrPokeNotFound
+			}
+			*poke_slots[idx] = PokeDisplay{ID: pokemon[idx], Name: name}
+		} else {
+			*poke_slots[idx] = PokeDisplay{}
+		}
+	}
+	return nil
+}
+
+func (s *MemTrainerStore) Get(id uint16) (TrainerRec, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if id == 0 || int(id) > len(s.recs) {
+		return TrainerRec{}, io.EOF
+	}
+	if s.recs[id-1].ID == 0 {
+		return TrainerRec{}, ErrTrainerNotFound
+	}
+	return s.recs[id-1], nil
+}
+
+func (s *MemTrainerStore) Count() (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.recs), nil
+}
+
+func (s *MemTrainerStore) Post(name string, pokemon []uint16) (uint16, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	next := len(s.recs) + 1
+	if next > 0xFFFF {
+		return 0, fmt.Errorf("next ID out of range")
+	}
+
+	var trainer TrainerRec
+	trainer.ID = uint16(next)
+	copy(trainer.Name[:], name)
+	if err := fill_slots(s.PokeFile, &trainer, pokemon); err != nil {
+		return 0, err
+	}
+	s.recs = append(s.recs, trainer)
+	return trainer.ID, nil
+}
+
+/*
+Function Name:  PostBatch
+Description:    method of MemTrainerStore
+				builds every record first and only appends once all
+				pokemon were found, so a failed batch changes nothing
+Parameters:     defs: trainers to create, in order
+Return Value:   new trainer IDs in def order, or *BatchError
+Type:           []TrainerDef -> []uint16, error
+*/
+func (s *MemTrainerStore) PostBatch(defs []TrainerDef) ([]uint16, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	recs := make([]TrainerRec, len(defs))
+	ids := make([]uint16, len(defs))
+	for idx, def := range defs {
+		next := len(s.recs) + 1 + idx
+		if next > 0xFFFF {
+			return nil, &BatchError{Row: idx + 1, Err: fmt.Errorf("next ID out of range")}
+		}
+		recs[idx].ID = uint16(next)
+		copy(recs[idx].Name[:], def.Name)
+		if err := fill_slots(s.PokeFile, &recs[idx], def.Pokemon); err != nil {
+			return nil, &BatchError{Row: idx + 1, Err: err}
+		}
+		ids[idx] = recs[idx].ID
+	}
+	s.recs = append(s.recs, recs...)
+	return ids, nil
+}
+
+func (s *MemTrainerStore) Put(id uint16, pokemon []uint16) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if id == 0 || int(id) > len(s.recs) || s.recs[id-1].ID == 0 {
+		return ErrTrainerNotFound
+	}
+
+	trainer := s.recs[id-1]
+	if err := fill_slots(s.PokeFile, &trainer, pokemon); err != nil {
+		return err
+	}
+	s.recs[id-1] = trainer
+	return nil
+}
+
+func (s *MemTrainerStore) Delete(id uint16) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if id == 0 || int(id) > len(s.recs) {
+		return io.EOF
+	}
+	if s.recs[id-1].ID == 0 {
+		return ErrTrainerNotFound
+	}
+	s.recs[id-1] = TrainerRec{}
+	return nil
+}
+
+/*
+Function Name:  All
+Description:    method of MemTrainerStore
+				visits a copy of every live trainer record in ID order,
+				visit is called without the store lock held
+Parameters:     visit: called once per live record
+Return Value:   nil once all records visited or visit error
+Type:           func(TrainerRec) error -> error
+*/
+func (s *MemTrainerStore) All(visit func(TrainerRec) error) error {
+	s.lock.Lock()
+	recs := make([]TrainerRec, len(s.recs))
+	copy(recs, s.recs)
+	s.lock.Unlock()
+
+	for _, trainer := range recs {
+		if trainer.ID == 0 {
+			continue
+		}
+		if err := visit(trainer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
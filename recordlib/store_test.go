@@ -0,0 +1,76 @@
+/*
+Filename:  store_test.go
+Description:
+  - GetTrainer's ID check on a FileTrainerStore whose layout is broken (a record at another
+    ID's offset), and what reads, writes and the trainer index build do with it
+*/
+package recordlib
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+)
+
+/*
+Function Name:  TestGetTrainerIDMismatch
+Description:    with trainer 3's record written at trainer 2's offset, Get,
+				Put and Delete of 2 fail with ErrIDMismatch and leave the
+				bytes as they were, the other records still work, and the
+				trainer index builds without 2 and lists it in Mismatched
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestGetTrainerIDMismatch(t *testing.T) {
+	store, _ := temp_trainer_store(t)
+	for _, name := range []string{"ash", "misty", "brock"} {
+		if _, err := store.Post(name, []uint16{25}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	third, err := GetTrainer(store.TrainerFile, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.TrainerFile.WriteAt(EncodeTrainerRec(third), trainer_offset(2)); err != nil {
+		t.Fatal(err)
+	}
+	raw := func() []byte {
+		buf, err := read_at(store.TrainerFile, trainer_offset(2), TrainerRecSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf
+	}
+	before := raw()
+
+	if _, err := store.Get(2); err != ErrIDMismatch {
+		t.Fatalf("Get(2): %v, want ErrIDMismatch", err)
+	}
+	if err := store.Put(2, []uint16{6}); err != ErrIDMismatch {
+		t.Fatalf("Put(2): %v, want ErrIDMismatch", err)
+	}
+	if err := store.Delete(2); err != ErrIDMismatch {
+		t.Fatalf("Delete(2): %v, want ErrIDMismatch", err)
+	}
+	if !bytes.Equal(raw(), before) {
+		t.Fatal("a refused write changed the record at trainer 2's offset")
+	}
+	for _, id := range []uint16{1, 3} {
+		if trainer, err := store.Get(id); err != nil || trainer.ID != id {
+			t.Fatalf("Get(%d): %+v, %v", id, trainer, err)
+		}
+	}
+
+	idx, err := NewTrainerPokeIndex(store)
+	if err != nil {
+		t.Fatalf("index build: %v, want the damaged record skipped", err)
+	}
+	if !slices.Equal(idx.Mismatched, []uint16{2}) {
+		t.Fatalf("Mismatched %v, want [2]", idx.Mismatched)
+	}
+	if got := idx.LookupTrainersByPoke(25); !slices.Equal(got, []uint16{1, 3}) {
+		t.Fatalf("pokemon 25 indexed for %v, want [1 3]", got)
+	}
+}
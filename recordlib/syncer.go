@@ -0,0 +1,177 @@
+/*
+Filename:  syncer.go
+Description:
+  - When trainer writes are forced to disk (fsync), chosen with the server's -sync
+  - Every write is in the kernel once WriteAt returns, so only a machine crash or power
+    loss (not a server crash) can lose an unsynced one
+  - always: Sync after every write, a write the client saw succeed is on disk
+  - batch: Sync after every N writes and from a background flusher every T while writes
+    are pending, a power loss can lose up to N writes or T worth of them
+  - os: never Sync while running, the OS writes the pages back on its own schedule (tens
+    of seconds on Linux), a power loss can lose any recent write
+  - Close syncs whatever is pending in every mode, so a clean shutdown loses nothing
+  - With WAL set every Sync is a CheckpointWAL, so the log is emptied as writes reach disk
+  - os mode never syncs, so it never checkpoints either: with a WAL the log would only grow,
+    the server refuses -wal with -sync os
+*/
+package recordlib
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type SyncMode int
+
+const (
+	SyncAlways SyncMode = iota //Sync after every write (default)
+	SyncBatch                  //Sync every N writes or every T
+	SyncOS                     //leave it to the OS
+)
+
+/*
+Function Name:  ParseSyncMode
+Description:    sync mode by name, as given to the server's -sync
+Parameters:     name: "always", "batch" or "os"
+Return Value:   the mode and nil, or error for an unknown name
+Type:           string -> SyncMode, error
+*/
+func ParseSyncMode(name string) (SyncMode, error) {
+	switch name {
+	case "always":
+		return SyncAlways, nil
+	case "batch":
+		return SyncBatch, nil
+	case "os":
+		return SyncOS, nil
+	}
+	return SyncAlways, fmt.Errorf("sync mode must be always, batch or os")
+}
+
+func (m SyncMode) String() string {
+	switch m {
+	case SyncBatch:
+		return "batch"
+	case SyncOS:
+		return "os"
+	}
+	return "always"
+}
+
+type Syncer struct {
+	file    *os.File
+	mode    SyncMode
+	every   int           //batch: writes between Syncs
+	lock    sync.Mutex    //guards pending and err, held across a batch Sync
+	pending int           //writes since the last Sync
+	err     error         //failed background Sync, returned by the next Wrote
+	stop    chan struct{} //closed by Close, stops the flusher
+	done    chan struct{} //closed when the flusher has returned
+	WAL     *WAL          //write-ahead log to checkpoint on each Sync, nil for none
+}
+
+/*
+Function Name:  NewSyncer
+Description:    syncs file the way mode says, batch mode starts the
+				background flusher, stop it with Close
+Parameters:     file: file the writes go to
+				mode: when to Sync
+				every: batch, Sync once this many writes are pending (at least 1)
+				interval: batch, how often the flusher syncs pending writes
+Return Value:   newly allocated Syncer
+Type:           *os.File, SyncMode, int, time.Duration -> *Syncer
+*/
+func NewSyncer(file *os.File, mode SyncMode, every int, interval time.Duration) *Syncer {
+	s := &Syncer{file: file, mode: mode, every: max(every, 1), stop: make(chan struct{}), done: make(chan struct{})}
+	if mode != SyncBatch {
+		close(s.done)
+		return s
+	}
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+			}
+			s.lock.Lock()
+			if s.pending > 0 {
+				if err := CheckpointWAL(s.WAL, s.file); err != nil {
+					s.err = err
+				} else {
+					s.pending = 0
+				}
+			}
+			s.lock.Unlock()
+		}
+	}()
+	return s
+}
+
+/*
+Function Name:  Wrote
+Description:    method of Syncer
+				call after writes reached the file, syncs now (always, or
+				batch once every writes are pending) or leaves them
+Parameters:     writes: records written
+Return Value:   Sync error, in batch mode also one the flusher hit since
+Type:           int -> error
+*/
+func (s *Syncer) Wrote(writes int) error {
+	switch s.mode {
+	case SyncAlways:
+		return CheckpointWAL(s.WAL, s.file)
+	case SyncOS:
+		s.lock.Lock()
+		s.pending += writes
+		s.lock.Unlock()
+		return nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.pending += writes
+	if err := s.err; err != nil {
+		s.err = nil
+		return err
+	}
+	if s.pending < s.every {
+		return nil
+	}
+	if err := CheckpointWAL(s.WAL, s.file); err != nil {
+		return err
+	}
+	s.pending = 0
+	return nil
+}
+
+/*
+Function Name:  Close
+Description:    method of Syncer
+				stops the flusher and syncs any pending writes, call before
+				closing the file
+Parameters:     n/a
+Return Value:   Sync error (if any)
+Type:           n/a -> error
+*/
+func (s *Syncer) Close() error {
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	<-s.done
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.pending == 0 {
+		return s.err
+	}
+	s.pending = 0
+	return CheckpointWAL(s.WAL, s.file)
+}
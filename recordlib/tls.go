@@ -0,0 +1,115 @@
+/*
+Filename:  tls.go
+Description:
+  - Optional TLS for the socket transport (server -cert/-key, client -tls/-ca)
+  - A Conn from Listen/Dial is first turned into a net.Conn (net_conn, per platform), then
+    wrapped by crypto/tls, the result is still a Conn so ReallyRead/ReallyWrite framing and the
+    rest of the server and client run on top of it unchanged
+  - Wrapping does no I/O, HandshakeTLS runs the handshake with a time limit so a peer that
+    never sends a hello can't hold a connection forever
+*/
+package recordlib
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+)
+
+/*
+Function Name:  ServerTLSConfig
+Description:    loads the server certificate and key (PEM) for TLS 1.2 or later
+Parameters:     cert_file: certificate chain file
+				key_file: private key file
+Return Value:   TLS config and error (if any)
+Type:           string, string -> *tls.Config, error
+*/
+func ServerTLSConfig(cert_file string, key_file string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cert_file, key_file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+/*
+Function Name:  ClientTLSConfig
+Description:    TLS 1.2 or later config that verifies the server against the
+				CA certificates in ca_file (PEM), or the system roots if ""
+Parameters:     ca_file: CA certificate file, "" for system roots
+				server_name: host the certificate must be issued for (an IP is
+				checked against the certificate's IP addresses)
+Return Value:   TLS config and error (if any)
+Type:           string, string -> *tls.Config, error
+*/
+func ClientTLSConfig(ca_file string, server_name string) (*tls.Config, error) {
+	cfg := &tls.Config{ServerName: server_name, MinVersion: tls.VersionTLS12}
+	if ca_file != "" {
+		pem, err := os.ReadFile(ca_file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates in CA file %s", ca_file)
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+/*
+Function Name:  WrapServerTLS
+Description:    wraps an accepted connection as the server side of TLS,
+				conn must not be used after, even on error
+Parameters:     conn: accepted client stream
+				cfg: config from ServerTLSConfig
+Return Value:   TLS stream and error (if any)
+Type:           Conn, *tls.Config -> Conn, error
+*/
+func WrapServerTLS(conn Conn, cfg *tls.Config) (Conn, error) {
+	nc, err := net_conn(conn)
+	if err != nil {
+		return nil, err
+	}
+	return tls.Server(nc, cfg), nil
+}
+
+/*
+Function Name:  WrapClientTLS
+Description:    wraps a dialed connection as the client side of TLS,
+				conn must not be used after, even on error
+Parameters:     conn: server stream from Dial
+				cfg: config from ClientTLSConfig
+Return Value:   TLS stream and error (if any)
+Type:           Conn, *tls.Config -> Conn, error
+*/
+func WrapClientTLS(conn Conn, cfg *tls.Config) (Conn, error) {
+	nc, err := net_conn(conn)
+	if err != nil {
+		return nil, err
+	}
+	return tls.Client(nc, cfg), nil
+}
+
+/*
+Function Name:  HandshakeTLS
+Description:    runs the TLS handshake on a wrapped stream, waiting at most
+				timeout, does nothing for a plain stream
+Parameters:     conn: stream from WrapServerTLS or WrapClientTLS, or a plain one
+				timeout: max time for the handshake
+Return Value:   nil or handshake error (bad certificate, not TLS, timeout)
+Type:           Conn, time.Duration -> error
+*/
+func HandshakeTLS(conn Conn, timeout time.Duration) error {
+	tls_conn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return tls_conn.HandshakeContext(ctx)
+}
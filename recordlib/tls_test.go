@@ -0,0 +1,176 @@
+/*
+Filename:  tls_test.go
+Description:
+  - TLS over a loopback TCP connection with a self-signed certificate made in the test's
+    temporary directory, framing on top of WrapServerTLS / WrapClientTLS and a client that
+    trusts another CA
+*/
+package recordlib
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+/*
+Function Name:  write_test_cert
+Description:    self-signed P-256 certificate for 127.0.0.1, usable as its
+				own CA, written as PEM to name.pem and name.key
+Parameters:     t: test handle
+				dir: directory for the files
+				name: file name without extension
+Return Value:   certificate file, key file
+Type:           *testing.T, string, string -> string, string
+*/
+func write_test_cert(t *testing.T, dir string, name string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key_der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert_file := filepath.Join(dir, name+".pem")
+	key_file := filepath.Join(dir, name+".key")
+	if err := os.WriteFile(cert_file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(key_file, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key_der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return cert_file, key_file
+}
+
+/*
+Function Name:  tls_pair
+Description:    a loopback TCP connection wrapped as TLS on both ends, the
+				server's handshake runs in the background
+Parameters:     t: test handle
+				cert_file: server certificate
+				key_file: server key
+				ca_file: CA the client verifies the server against
+Return Value:   server and client streams, server handshake result
+Type:           *testing.T, string, string, string -> Conn, Conn, chan error
+*/
+func tls_pair(t *testing.T, cert_file string, key_file string, ca_file string) (Conn, Conn, chan error) {
+	t.Helper()
+	server_cfg, err := ServerTLSConfig(cert_file, key_file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client_cfg, err := ClientTLSConfig(ca_file, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+	dialed, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := <-accepted
+	if plain == nil {
+		t.Fatal("accept failed")
+	}
+
+	server, err := WrapServerTLS(plain, server_cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := WrapClientTLS(dialed, client_cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	server_handshake := make(chan error, 1)
+	go func() { server_handshake <- HandshakeTLS(server, 5*time.Second) }()
+	return server, client, server_handshake
+}
+
+/*
+Function Name:  TestTLSLoopback
+Description:    with the client trusting the server's own certificate the
+				handshake succeeds and frames go both ways through
+				ReallyWrite / ReallyRead, a client given a different CA fails
+				HandshakeTLS
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestTLSLoopback(t *testing.T) {
+	dir := t.TempDir()
+	cert_file, key_file := write_test_cert(t, dir, "server")
+	other_ca, _ := write_test_cert(t, dir, "other")
+
+	server, client, server_handshake := tls_pair(t, cert_file, key_file, cert_file)
+	if err := HandshakeTLS(client, 5*time.Second); err != nil {
+		t.Fatalf("client handshake: %v", err)
+	}
+	if err := <-server_handshake; err != nil {
+		t.Fatalf("server handshake: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		req, err := ReallyRead(server)
+		if err == nil {
+			err = ReallyWrite(server, "got "+req)
+		}
+		done <- err
+	}()
+	if err := ReallyWrite(client, "REQ_POKE_ID 25"); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := ReallyRead(client); err != nil || reply != "got REQ_POKE_ID 25" {
+		t.Fatalf("reply over TLS: %q, %v", reply, err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("server side: %v", err)
+	}
+
+	_, client, server_handshake = tls_pair(t, cert_file, key_file, other_ca)
+	if err := HandshakeTLS(client, 5*time.Second); err == nil {
+		t.Fatal("client trusting another CA completed the handshake")
+	}
+	client.Close()
+	if err := <-server_handshake; err == nil {
+		t.Fatal("server handshake with a client that refused its certificate succeeded")
+	}
+}
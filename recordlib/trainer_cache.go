@@ -0,0 +1,164 @@
+/*
+Filename:  trainer_cache.go
+Description:
+  - TrainerCache wraps any TrainerStore with a fixed size LRU cache of trainer records
+  - Get is served from the cache when possible, misses fall back to the wrapped store
+  - Post, Put, Delete and PostBatch write through to the wrapped store and drop the cached entry
+  - Callers keep holding the GlobalManager record locks, which is what keeps the cache and file
+    consistent: a Get (read lock) never runs alongside a write (write lock) of the same ID
+*/
+package recordlib
+
+import (
+	"container/list"
+	"sync"
+)
+
+type TrainerCache struct {
+	inner    TrainerStore
+	lock     sync.Mutex //guards the LRU structures only, not the records
+	capacity int
+	order    *list.List               //front is most recently used, values are TrainerRec
+	entries  map[uint16]*list.Element //trainer ID -> element in order
+}
+
+/*
+Function Name:  NewTrainerCache
+Description:    wraps a TrainerStore with an LRU cache of up to capacity records
+Parameters:     inner: store that holds the records
+				capacity: max cached records, at least 1
+Return Value:   newly allocated TrainerCache
+Type:           TrainerStore, int -> *TrainerCache
+*/
+func NewTrainerCache(inner TrainerStore, capacity int) *TrainerCache {
+	return &TrainerCache{
+		inner:    inner,
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[uint16]*list.Element),
+	}
+}
+
+/*
+Function Name:  lookup
+Description:    method of TrainerCache
+				returns the cached record and marks it most recently used
+Parameters:     id: trainer ID
+Return Value:   cached record and whether it was cached
+Type:           uint16 -> TrainerRec, bool
+*/
+func (c *TrainerCache) lookup(id uint16) (TrainerRec, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	elem, ok := c.entries[id]
+	if !ok {
+		return TrainerRec{}, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(TrainerRec), true
+}
+
+/*
+Function Name:  store
+Description:    method of TrainerCache
+				caches a record, evicting the least recently used if full
+Parameters:     rec: trainer record read from the wrapped store
+Return Value:   n/a
+Type:           TrainerRec -> n/a
+*/
+func (c *TrainerCache) store(rec TrainerRec) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if elem, ok := c.entries[rec.ID]; ok {
+		elem.Value = rec
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[rec.ID] = c.order.PushFront(rec)
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(TrainerRec).ID)
+	}
+}
+
+/*
+Function Name:  invalidate
+Description:    method of TrainerCache
+				drops the cached records for ids
+Parameters:     ids: trainer IDs
+Return Value:   n/a
+Type:           ...uint16 -> n/a
+*/
+func (c *TrainerCache) invalidate(ids ...uint16) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, id := range ids {
+		if elem, ok := c.entries[id]; ok {
+			c.order.Remove(elem)
+			delete(c.entries, id)
+		}
+	}
+}
+
+func (c *TrainerCache) Get(id uint16) (TrainerRec, error) {
+	if rec, ok := c.lookup(id); ok {
+		return rec, nil
+	}
+	rec, err := c.inner.Get(id)
+	if err != nil {
+		return rec, err //misses are not cached, a later Post may create the ID
+	}
+	c.store(rec)
+	return rec, nil
+}
+
+func (c *TrainerCache) Post(name string, pokemon []uint16) (uint16, error) {
+	id, err := c.inner.Post(name, pokemon)
+	if err == nil {
+		c.invalidate(id)
+	}
+	return id, err
+}
+
+/*
+Function Name:  PostBatch
+Description:    method of TrainerCache
+				writes through, a failed batch empties the whole cache since
+				a reader may have cached a record the rollback removed
+Parameters:     defs: trainers to create, in order
+Return Value:   new trainer IDs in def order, or *BatchError
+Type:           []TrainerDef -> []uint16, error
+*/
+func (c *TrainerCache) PostBatch(defs []TrainerDef) ([]uint16, error) {
+	ids, err := c.inner.PostBatch(defs)
+	if err != nil {
+		c.lock.Lock()
+		c.order.Init()
+		c.entries = make(map[uint16]*list.Element)
+		c.lock.Unlock()
+		return ids, err
+	}
+	c.invalidate(ids...)
+	return ids, nil
+}
+
+func (c *TrainerCache) Put(id uint16, pokemon []uint16) error {
+	err := c.inner.Put(id, pokemon)
+	c.invalidate(id) //even on error, the write may have been partial
+	return err
+}
+
+func (c *TrainerCache) Delete(id uint16) error {
+	err := c.inner.Delete(id)
+	c.invalidate(id)
+	return err
+}
+
+func (c *TrainerCache) All(visit func(TrainerRec) error) error {
+	return c.inner.All(visit)
+}
+
+func (c *TrainerCache) Count() (int, error) {
+	return c.inner.Count()
+}
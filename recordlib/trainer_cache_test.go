@@ -0,0 +1,202 @@
+/*
+Filename:  trainer_cache_test.go
+Description:
+  - TrainerCache over a FileTrainerStore: no stale record after a write, alone and with
+    readers and a writer taking the GlobalManager record locks, and the failed PostBatch
+    that empties the cache
+*/
+package recordlib
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/*
+Function Name:  TestTrainerCacheNoStaleGet
+Description:    after a cached Get, each Put, failed partial Put and Delete
+				is seen by the next Get, and a deleted trainer stays gone
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestTrainerCacheNoStaleGet(t *testing.T) {
+	file_store, _ := temp_trainer_store(t)
+	inner := &failing_store{TrainerStore: file_store}
+	cache := NewTrainerCache(inner, 8)
+	id, err := cache.Post("ash", []uint16{25})
+	if err != nil {
+		t.Fatal(err)
+	}
+	get_first := func(what string) uint16 {
+		t.Helper()
+		rec, err := cache.Get(id)
+		if err != nil {
+			t.Fatalf("%s: %v", what, err)
+		}
+		return rec.Poke1.ID
+	}
+	if poke := get_first("first get"); poke != 25 {
+		t.Fatalf("first get: pokemon %d, want 25", poke)
+	}
+	if _, cached := cache.lookup(id); !cached {
+		t.Fatal("record not cached after a get")
+	}
+
+	if err := cache.Put(id, []uint16{6}); err != nil {
+		t.Fatal(err)
+	}
+	if poke := get_first("get after put"); poke != 6 {
+		t.Fatalf("get after put: pokemon %d, want 6", poke)
+	}
+	inner.fail_next, inner.partial = true, true //the write reached the file before the error
+	if err := cache.Put(id, []uint16{9}); err == nil {
+		t.Fatal("injected put failure returned nil")
+	}
+	if poke := get_first("get after a failed put"); poke != 9 {
+		t.Fatalf("get after a partial put: pokemon %d, want 9 as on disk", poke)
+	}
+
+	if err := cache.Delete(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Get(id); err != ErrTrainerNotFound {
+		t.Fatalf("get after delete: %v, want ErrTrainerNotFound", err)
+	}
+}
+
+/*
+Function Name:  TestTrainerCacheRecordLocks
+Description:    one writer puts pokemon 2 to 151 into trainer 1 under its
+				write lock, waiting for a read after each so the record is
+				cached, while readers get it under the read lock, a read
+				that starts after a put was unlocked never sees an older
+				pokemon, which a stale cached record would give
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestTrainerCacheRecordLocks(t *testing.T) {
+	file_store, _ := temp_trainer_store(t)
+	cache := NewTrainerCache(file_store, 4)
+	gm := NewGlobalManager(LockWriterFirst)
+	if _, err := cache.Post("ash", []uint16{1}); err != nil {
+		t.Fatal(err)
+	}
+
+	var published atomic.Uint32 //last pokemon whose put was unlocked
+	published.Store(1)
+	var reads atomic.Int64 //gets done, the writer waits for one after each put so it is cached
+	stale := make(chan string, 1)
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for reader := 0; reader < 4; reader++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				want := uint16(published.Load())
+				gm.RLockRecord(1)
+				rec, err := cache.Get(1)
+				gm.RUnlockRecord(1)
+				reads.Add(1)
+				runtime.Gosched() //let the writer in on a single CPU
+				if err != nil || rec.Poke1.ID < want {
+					select {
+					case stale <- fmt.Sprintf("got pokemon %d, %v after %d was put", rec.Poke1.ID, err, want):
+					default:
+					}
+					return
+				}
+			}
+		}()
+	}
+	for poke := uint16(2); poke <= 151; poke++ {
+		gm.WLockRecord(1)
+		err := cache.Put(1, []uint16{poke})
+		gm.WUnlockRecord(1)
+		if err != nil {
+			close(stop)
+			wg.Wait()
+			t.Fatal(err)
+		}
+		published.Store(uint32(poke))
+		seen := reads.Load()
+		for deadline := time.Now().Add(time.Second); reads.Load() == seen && len(stale) == 0 && time.Now().Before(deadline); {
+			runtime.Gosched()
+		}
+	}
+	close(stop)
+	wg.Wait()
+	select {
+	case msg := <-stale:
+		t.Fatal(msg)
+	default:
+	}
+}
+
+//store whose PostBatch writes the first def, lets a reader run, then rolls it back and fails
+type rollback_store struct {
+	*FileTrainerStore
+	reader func(id uint16)
+}
+
+func (s *rollback_store) PostBatch(defs []TrainerDef) ([]uint16, error) {
+	ids, err := s.FileTrainerStore.PostBatch(defs[:1])
+	if err != nil {
+		return nil, err
+	}
+	s.reader(ids[0]) //caches the record the rollback is about to remove
+	if err := s.FileTrainerStore.Delete(ids[0]); err != nil {
+		return nil, err
+	}
+	return nil, &BatchError{Row: 2, Err: ErrPokeNotFound}
+}
+
+/*
+Function Name:  TestTrainerCacheFailedBatch
+Description:    a record a reader cached while a PostBatch ran, and that the
+				batch's rollback removed, is not served after the batch fails,
+				the failure empties the cache, earlier cached trainers
+				included
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestTrainerCacheFailedBatch(t *testing.T) {
+	file_store, _ := temp_trainer_store(t)
+	inner := &rollback_store{FileTrainerStore: file_store}
+	cache := NewTrainerCache(inner, 8)
+	if _, err := cache.Post("misty", []uint16{7}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Get(1); err != nil {
+		t.Fatal(err)
+	}
+	var rolled_back uint16
+	inner.reader = func(id uint16) {
+		rolled_back = id
+		if _, err := cache.Get(id); err != nil {
+			t.Errorf("reader during the batch: %v", err)
+		}
+	}
+
+	if _, err := cache.PostBatch([]TrainerDef{{"ash", []uint16{25}}, {"brock", []uint16{74}}}); err == nil {
+		t.Fatal("PostBatch returned nil")
+	}
+	if _, err := cache.Get(rolled_back); err != ErrTrainerNotFound {
+		t.Fatalf("get of the rolled back trainer %d: %v, want ErrTrainerNotFound", rolled_back, err)
+	}
+	if _, cached := cache.lookup(1); cached {
+		t.Fatal("trainer 1 still cached after the failed batch")
+	}
+}
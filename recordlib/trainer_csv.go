@@ -0,0 +1,72 @@
+/*
+Filename:  trainer_csv.go
+Description:
+  - Formats trainer records as CSV lines for export
+  - One line per record: id,name then id,name for each of the six pokemon slots
+  - Empty pokemon slots are left as two blank fields
+*/
+package recordlib
+
+import (
+	"encoding/csv"
+	"strconv"
+	"strings"
+)
+
+/*
+Function Name:  csv_line
+Description:    joins fields into a single CSV line (no trailing newline),
+				fields holding commas, quotes or newlines are quoted
+Parameters:     fields: values for the line
+Return Value:   CSV line, or writer error
+Type:           []string -> string, error
+*/
+func csv_line(fields []string) (string, error) {
+	var buf strings.Builder
+	writer := csv.NewWriter(&buf)
+	if err := writer.Write(fields); err != nil {
+		return "", err
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+/*
+Function Name:  TrainerCSVHeader
+Description:    returns the header line matching TrainerCSVRow columns
+Parameters:     N/A
+Return Value:   CSV header line
+Type:           n/a -> string
+*/
+func TrainerCSVHeader() string {
+	fields := []string{"id", "name"}
+	for slot := 1; slot <= 6; slot++ {
+		num := strconv.Itoa(slot)
+		fields = append(fields, "poke"+num+"_id", "poke"+num+"_name")
+	}
+	line, _ := csv_line(fields) //header fields never need quoting
+	return line
+}
+
+/*
+Function Name:  TrainerCSVRow
+Description:    formats one trainer record as a CSV line, names are read up
+				to their null terminator, empty slots give blank fields
+Parameters:     rec: trainer record
+Return Value:   CSV line, or writer error
+Type:           TrainerRec -> string, error
+*/
+func TrainerCSVRow(rec TrainerRec) (string, error) {
+	fields := []string{strconv.Itoa(int(rec.ID)), CString(rec.Name[:])}
+	for _, poke := range []PokeDisplay{rec.Poke1, rec.Poke2, rec.Poke3, rec.Poke4, rec.Poke5, rec.Poke6} {
+		if poke.ID == 0 {
+			fields = append(fields, "", "")
+			continue
+		}
+		fields = append(fields, strconv.Itoa(int(poke.ID)), CString(poke.Name[:]))
+	}
+	return csv_line(fields)
+}
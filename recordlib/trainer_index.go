@@ -0,0 +1,200 @@
+/*
+Filename:  trainer_index.go
+Description:
+  - TrainerPokeIndex wraps any TrainerStore with an in-memory reverse index, pokemon ID ->
+    IDs of the live trainers holding it, so "trainers using X" needs no scan of the trainers
+  - Built from one scan of IDs 1 to Count when the server starts, kept up to date by the
+    Post, PostBatch, Put and Delete calls going through it (append, remove and swap are Puts)
+  - A record that doesn't hold its own ID (ErrIDMismatch) is left out of the index and listed
+    in Mismatched instead of failing the build, so a damaged file still lets the server start
+  - An update runs right after the wrapped write, still inside the caller's GlobalManager
+    record lock (or AppendLock), its own lock only guards the maps, so a lookup under the
+    read-all lock always matches the file
+*/
+package recordlib
+
+import (
+	"io"
+	"slices"
+	"sync"
+)
+
+type TrainerPokeIndex struct {
+	inner      TrainerStore
+	lock       sync.RWMutex
+	by_poke    map[uint16][]uint16 //pokemon ID -> trainer IDs, ascending
+	by_trainer map[uint16][]uint16 //trainer ID -> its pokemon IDs without repeats, for removal
+	Mismatched []uint16            //IDs whose record held another ID at build time, ascending
+}
+
+/*
+Function Name:  NewTrainerPokeIndex
+Description:    wraps a TrainerStore and indexes every live trainer in it,
+				records failing GetTrainer's ID check are skipped and listed
+				in Mismatched
+Parameters:     inner: store that holds the records
+Return Value:   newly built index and error from the scan (if any)
+Type:           TrainerStore -> *TrainerPokeIndex, error
+*/
+func NewTrainerPokeIndex(inner TrainerStore) (*TrainerPokeIndex, error) {
+	idx := &TrainerPokeIndex{
+		inner:      inner,
+		by_poke:    make(map[uint16][]uint16),
+		by_trainer: make(map[uint16][]uint16),
+	}
+	count, err := inner.Count()
+	if err != nil {
+		return nil, err
+	}
+	for id := 1; id <= count; id++ {
+		trainer, err := inner.Get(uint16(id))
+		switch {
+		case err == ErrTrainerNotFound:
+			continue //deleted slot
+		case err == ErrIDMismatch:
+			idx.Mismatched = append(idx.Mismatched, uint16(id))
+			continue
+		case err == io.EOF:
+			return idx, nil //file shrank under the count
+		case err != nil:
+			return nil, err
+		}
+		idx.set(trainer.ID, TrainerPokeIDs(trainer))
+	}
+	return idx, nil
+}
+
+/*
+Function Name:  set
+Description:    method of TrainerPokeIndex
+				replaces the indexed pokemon of a trainer, nil or empty
+				pokemon removes the trainer from the index
+Parameters:     id: trainer ID
+				pokemon: the trainer's pokemon IDs now, repeats allowed
+Return Value:   n/a
+Type:           uint16, []uint16 -> n/a
+*/
+func (idx *TrainerPokeIndex) set(id uint16, pokemon []uint16) {
+	idx.lock.Lock()
+	defer idx.lock.Unlock()
+	for _, poke_id := range idx.by_trainer[id] {
+		trainers := idx.by_poke[poke_id]
+		if pos, found := slices.BinarySearch(trainers, id); found {
+			trainers = slices.Delete(trainers, pos, pos+1)
+		}
+		if len(trainers) == 0 {
+			delete(idx.by_poke, poke_id)
+		} else {
+			idx.by_poke[poke_id] = trainers
+		}
+	}
+	delete(idx.by_trainer, id)
+
+	owned := slices.Compact(slices.Sorted(slices.Values(pokemon)))
+	owned = slices.DeleteFunc(owned, func(poke_id uint16) bool { return poke_id == 0 })
+	if len(owned) == 0 {
+		return
+	}
+	idx.by_trainer[id] = owned
+	for _, poke_id := range owned {
+		trainers := idx.by_poke[poke_id]
+		if pos, found := slices.BinarySearch(trainers, id); !found {
+			idx.by_poke[poke_id] = slices.Insert(trainers, pos, id)
+		}
+	}
+}
+
+/*
+Function Name:  resync
+Description:    method of TrainerPokeIndex
+				re-reads one trainer from the wrapped store after a failed
+				write, which may have been partial
+Parameters:     id: trainer ID
+Return Value:   n/a
+Type:           uint16 -> n/a
+*/
+func (idx *TrainerPokeIndex) resync(id uint16) {
+	trainer, err := idx.inner.Get(id)
+	if err != nil {
+		if err == ErrTrainerNotFound || err == io.EOF {
+			idx.set(id, nil)
+		}
+		return //read error, keep what was indexed
+	}
+	idx.set(id, TrainerPokeIDs(trainer))
+}
+
+/*
+Function Name:  LookupTrainersByPoke
+Description:    method of TrainerPokeIndex
+				IDs of the live trainers holding a pokemon, call under the
+				read-all lock for an answer consistent with the file
+Parameters:     poke_id: pokemon ID
+Return Value:   trainer IDs ascending (a copy), empty if none
+Type:           uint16 -> []uint16
+*/
+func (idx *TrainerPokeIndex) LookupTrainersByPoke(poke_id uint16) []uint16 {
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+	return append([]uint16{}, idx.by_poke[poke_id]...)
+}
+
+func (idx *TrainerPokeIndex) Get(id uint16) (TrainerRec, error) {
+	return idx.inner.Get(id)
+}
+
+func (idx *TrainerPokeIndex) Post(name string, pokemon []uint16) (uint16, error) {
+	id, err := idx.inner.Post(name, pokemon)
+	if err == nil {
+		idx.set(id, pokemon)
+	}
+	return id, err
+}
+
+/*
+Function Name:  PostBatch
+Description:    method of TrainerPokeIndex
+				writes through, a failed batch is rolled back by the wrapped
+				store so nothing is indexed
+Parameters:     defs: trainers to create, in order
+Return Value:   new trainer IDs in def order, or *BatchError
+Type:           []TrainerDef -> []uint16, error
+*/
+func (idx *TrainerPokeIndex) PostBatch(defs []TrainerDef) ([]uint16, error) {
+	ids, err := idx.inner.PostBatch(defs)
+	if err != nil {
+		return ids, err
+	}
+	for pos, id := range ids {
+		idx.set(id, defs[pos].Pokemon)
+	}
+	return ids, nil
+}
+
+func (idx *TrainerPokeIndex) Put(id uint16, pokemon []uint16) error {
+	err := idx.inner.Put(id, pokemon)
+	if err != nil {
+		idx.resync(id)
+		return err
+	}
+	idx.set(id, pokemon)
+	return nil
+}
+
+func (idx *TrainerPokeIndex) Delete(id uint16) error {
+	err := idx.inner.Delete(id)
+	if err != nil {
+		idx.resync(id)
+		return err
+	}
+	idx.set(id, nil)
+	return nil
+}
+
+func (idx *TrainerPokeIndex) All(visit func(TrainerRec) error) error {
+	return idx.inner.All(visit)
+}
+
+func (idx *TrainerPokeIndex) Count() (int, error) {
+	return idx.inner.Count()
+}
@@ -0,0 +1,144 @@
+/*
+Filename:  trainer_index_test.go
+Description:
+  - TrainerPokeIndex over a MemTrainerStore: every write path keeps the reverse index equal
+    to a full scan, failed writes included
+*/
+package recordlib
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+//store whose next write fails, after writing through when partial is set
+type failing_store struct {
+	TrainerStore
+	fail_next bool
+	partial   bool //the write reached the store before the error
+}
+
+func (s *failing_store) fail() error {
+	s.fail_next = false
+	return fmt.Errorf("injected write failure")
+}
+
+func (s *failing_store) Put(id uint16, pokemon []uint16) error {
+	if !s.fail_next {
+		return s.TrainerStore.Put(id, pokemon)
+	}
+	if s.partial {
+		s.TrainerStore.Put(id, pokemon)
+	}
+	return s.fail()
+}
+
+func (s *failing_store) Delete(id uint16) error {
+	if !s.fail_next {
+		return s.TrainerStore.Delete(id)
+	}
+	if s.partial {
+		s.TrainerStore.Delete(id)
+	}
+	return s.fail()
+}
+
+/*
+Function Name:  check_index
+Description:    fails the test unless the index lookup for every pokemon in
+				1..max_poke equals a full scan of the store
+Parameters:     t: test handle
+				idx: index under test
+				store: store it wraps
+				when: what was just done (for the message)
+Return Value:   n/a
+Type:           *testing.T, *TrainerPokeIndex, TrainerStore, string -> n/a
+*/
+func check_index(t *testing.T, idx *TrainerPokeIndex, store TrainerStore, when string) {
+	t.Helper()
+	const max_poke = 30
+	for poke_id := uint16(1); poke_id <= max_poke; poke_id++ {
+		_, want, err := CountTrainersWithPoke(store, poke_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := idx.LookupTrainersByPoke(poke_id); !slices.Equal(got, want) {
+			t.Fatalf("%s: pokemon %d indexed for %v, scan finds %v", when, poke_id, got, want)
+		}
+	}
+}
+
+/*
+Function Name:  TestTrainerPokeIndex
+Description:    the index built over existing trainers, then after posts, a
+				batch, puts, deletes, a failed put that changed nothing and
+				failed writes that did reach the store, matches a scan
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestTrainerPokeIndex(t *testing.T) {
+	mem := NewMemTrainerStore(open_test_poke(t))
+	if _, err := mem.Post("ash", []uint16{25, 6, 25}); err != nil { //a repeat counts once
+		t.Fatal(err)
+	}
+	store := &failing_store{TrainerStore: mem}
+	idx, err := NewTrainerPokeIndex(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check_index(t, idx, store, "build")
+
+	steps := []struct {
+		what string
+		do   func() error
+	}{
+		{"post", func() error { _, err := idx.Post("misty", []uint16{7, 25}); return err }},
+		{"empty post", func() error { _, err := idx.Post("brock", nil); return err }},
+		{"batch", func() error {
+			_, err := idx.PostBatch([]TrainerDef{{Name: "may", Pokemon: []uint16{6}}, {Name: "dawn", Pokemon: []uint16{7, 9}}})
+			return err
+		}},
+		{"put", func() error { return idx.Put(1, []uint16{9}) }},
+		{"put to empty", func() error { return idx.Put(2, nil) }},
+		{"put into empty", func() error { return idx.Put(3, []uint16{25, 26, 27}) }},
+		{"delete", func() error { return idx.Delete(4) }},
+		{"failed put", func() error {
+			store.fail_next, store.partial = true, false
+			if idx.Put(5, []uint16{1}) == nil {
+				return fmt.Errorf("injected failure not returned")
+			}
+			return nil
+		}},
+		{"partial put", func() error {
+			store.fail_next, store.partial = true, true
+			if idx.Put(5, []uint16{1, 2}) == nil {
+				return fmt.Errorf("injected failure not returned")
+			}
+			return nil
+		}},
+		{"partial delete", func() error {
+			store.fail_next, store.partial = true, true
+			if idx.Delete(3) == nil {
+				return fmt.Errorf("injected failure not returned")
+			}
+			return nil
+		}},
+		{"failed batch", func() error {
+			if _, err := idx.PostBatch([]TrainerDef{{Name: "iris", Pokemon: []uint16{8}}, {Name: "x", Pokemon: []uint16{0xFFFF}}}); err == nil {
+				return fmt.Errorf("batch with an unknown pokemon accepted")
+			}
+			return nil
+		}},
+	}
+	for _, step := range steps {
+		if err := step.do(); err != nil {
+			t.Fatalf("%s: %v", step.what, err)
+		}
+		check_index(t, idx, store, step.what)
+	}
+	if got := idx.LookupTrainersByPoke(25); len(got) != 0 { //ash, misty and brock all moved off it
+		t.Fatalf("pokemon 25 still indexed for %v after its trainers changed", got)
+	}
+}
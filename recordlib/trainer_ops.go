@@ -0,0 +1,198 @@
+/*
+Filename:  trainer_ops.go
+Description:
+  - Multi-step trainer operations built on TrainerStore Get and Put
+  - Work with any TrainerStore implementation
+  - Callers hold the GlobalManager write lock on the record for the whole operation
+    (on both records, taken with WLockRecords, for SwapTrainerPoke and MoveTrainerPoke)
+*/
+package recordlib
+
+import (
+	"fmt"
+	"io"
+)
+
+var (
+	ErrTrainerFull = fmt.Errorf("trainer full")
+	ErrSlotRange   = fmt.Errorf("slot must be 1-6")
+	ErrSlotEmpty   = fmt.Errorf("slot already empty")
+	ErrDestFull    = fmt.Errorf("destination full")
+)
+
+/*
+Function Name:  TrainerPokeIDs
+Description:    collects the IDs of the occupied pokemon slots of trainer in slot order
+Parameters:     trainer: trainer record
+Return Value:   list of pokemon IDs, empty if trainer has no pokemon
+Type:           TrainerRec -> []uint16
+*/
+func TrainerPokeIDs(trainer TrainerRec) []uint16 {
+	var ids []uint16
+	for _, slot := range []PokeDisplay{trainer.Poke1, trainer.Poke2, trainer.Poke3, trainer.Poke4, trainer.Poke5, trainer.Poke6} {
+		if slot.ID != 0 {
+			ids = append(ids, slot.ID)
+		}
+	}
+	return ids
+}
+
+/*
+Function Name:  AppendTrainerPoke
+Description:    reads the current trainer record and fills new pokemon into the
+				first empty slots, existing pokemon are kept
+Parameters:		store: trainer record store
+				id: the record ID to modify
+				new_poke: list of pokemon IDs to add
+Return Value:   nil on success, ErrTrainerFull if fewer than len(new_poke) slots
+				are free, ErrTrainerNotFound, ErrPokeNotFound or lookup error
+Type:           TrainerStore, uint16, []uint16 -> error
+*/
+func AppendTrainerPoke(store TrainerStore, id uint16, new_poke []uint16) error {
+	trainer, err := store.Get(id)
+	if err == io.EOF {
+		return ErrTrainerNotFound //id past end of store
+	} else if err != nil {
+		return err
+	}
+	pokemon := TrainerPokeIDs(trainer)
+	if len(pokemon)+len(new_poke) > 6 {
+		return ErrTrainerFull
+	}
+	return store.Put(id, append(pokemon, new_poke...)) //Put validates each ID with GetPokeName
+}
+
+/*
+Function Name:  SwapTrainerPoke
+Description:    trades the pokemon in slot_a of trainer id_a for the one in
+				slot_b of trainer id_b (the same trainer reorders its slots),
+				both slots must hold a pokemon, both records are read before
+				either is written and the first write is undone if the
+				second fails, the file store syncs after each write
+Parameters:		store: trainer record store
+				id_a: first trainer ID
+				slot_a: slot number 1-6 of the first trainer
+				id_b: second trainer ID
+				slot_b: slot number 1-6 of the second trainer
+Return Value:   nil on success, ErrSlotRange, ErrSlotEmpty, ErrTrainerNotFound or lookup/write error
+Type:           TrainerStore, uint16, int, uint16, int -> error
+*/
+func SwapTrainerPoke(store TrainerStore, id_a uint16, slot_a int, id_b uint16, slot_b int) error {
+	if slot_a < 1 || slot_a > 6 || slot_b < 1 || slot_b > 6 {
+		return ErrSlotRange
+	}
+	trainer_a, err := store.Get(id_a)
+	if err == io.EOF {
+		return ErrTrainerNotFound
+	} else if err != nil {
+		return err
+	}
+	trainer_b, err := store.Get(id_b)
+	if err == io.EOF {
+		return ErrTrainerNotFound
+	} else if err != nil {
+		return err
+	}
+	poke_a, poke_b := TrainerPokeIDs(trainer_a), TrainerPokeIDs(trainer_b)
+	if slot_a > len(poke_a) || slot_b > len(poke_b) {
+		return ErrSlotEmpty
+	}
+
+	if id_a == id_b {
+		poke_a[slot_a-1], poke_a[slot_b-1] = poke_a[slot_b-1], poke_a[slot_a-1]
+		return store.Put(id_a, poke_a)
+	}
+	poke_a[slot_a-1], poke_b[slot_b-1] = poke_b[slot_b-1], poke_a[slot_a-1]
+	if err := store.Put(id_a, poke_a); err != nil {
+		return err
+	}
+	if err := store.Put(id_b, poke_b); err != nil {
+		store.Put(id_a, TrainerPokeIDs(trainer_a)) //undo, neither trainer changes
+		return err
+	}
+	return nil
+}
+
+/*
+Function Name:  MoveTrainerPoke
+Description:    moves the pokemon in from_slot of trainer from_id into the
+				first free slot of trainer to_id, from_id's later pokemon
+				shift left (the same trainer moves it to its last slot),
+				both records are read before either is written and the
+				first write is undone if the second fails, the file store
+				syncs after each write
+Parameters:		store: trainer record store
+				from_id: trainer giving the pokemon
+				from_slot: slot number 1-6 of from_id
+				to_id: trainer receiving the pokemon
+Return Value:   nil on success, ErrSlotRange, ErrSlotEmpty, ErrDestFull, ErrTrainerNotFound
+				or lookup/write error
+Type:           TrainerStore, uint16, int, uint16 -> error
+*/
+func MoveTrainerPoke(store TrainerStore, from_id uint16, from_slot int, to_id uint16) error {
+	if from_slot < 1 || from_slot > 6 {
+		return ErrSlotRange
+	}
+	trainer_from, err := store.Get(from_id)
+	if err == io.EOF {
+		return ErrTrainerNotFound
+	} else if err != nil {
+		return err
+	}
+	trainer_to, err := store.Get(to_id)
+	if err == io.EOF {
+		return ErrTrainerNotFound
+	} else if err != nil {
+		return err
+	}
+	poke_from, poke_to := TrainerPokeIDs(trainer_from), TrainerPokeIDs(trainer_to)
+	if from_slot > len(poke_from) {
+		return ErrSlotEmpty
+	}
+
+	moved := poke_from[from_slot-1]
+	poke_from = append(poke_from[:from_slot-1], poke_from[from_slot:]...)
+	if from_id == to_id {
+		return store.Put(from_id, append(poke_from, moved))
+	}
+	if len(poke_to) == 6 {
+		return ErrDestFull
+	}
+	if err := store.Put(to_id, append(poke_to, moved)); err != nil {
+		return err
+	}
+	if err := store.Put(from_id, poke_from); err != nil {
+		store.Put(to_id, TrainerPokeIDs(trainer_to)) //undo, neither trainer changes
+		return err
+	}
+	return nil
+}
+
+/*
+Function Name:  RemoveTrainerPoke
+Description:    clears one pokemon slot of a trainer and shifts the following
+				pokemon left so there are no gaps, trailing slots are zeroed,
+				the file store syncs after writing
+Parameters:		store: trainer record store
+				id: the record ID to modify
+				slot: slot number 1-6
+Return Value:   nil on success, ErrSlotRange, ErrSlotEmpty, ErrTrainerNotFound or lookup error
+Type:           TrainerStore, uint16, int -> error
+*/
+func RemoveTrainerPoke(store TrainerStore, id uint16, slot int) error {
+	if slot < 1 || slot > 6 {
+		return ErrSlotRange
+	}
+	trainer, err := store.Get(id)
+	if err == io.EOF {
+		return ErrTrainerNotFound
+	} else if err != nil {
+		return err
+	}
+	pokemon := TrainerPokeIDs(trainer) //slots are always filled from the front
+	if slot > len(pokemon) {
+		return ErrSlotEmpty
+	}
+	pokemon = append(pokemon[:slot-1], pokemon[slot:]...)
+	return store.Put(id, pokemon)
+}
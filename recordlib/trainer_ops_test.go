@@ -0,0 +1,158 @@
+/*
+Filename:  trainer_ops_test.go
+Description:
+  - MoveTrainerPoke on a MemTrainerStore over the bundled poke.bin: refused moves, the
+    same trainer at 6/6, and the undo of the first write when the second fails
+*/
+package recordlib
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+//store whose Put number fail_at (counting from 1) fails without writing
+type put_fails_store struct {
+	TrainerStore
+	puts    int
+	fail_at int
+}
+
+func (s *put_fails_store) Put(id uint16, pokemon []uint16) error {
+	s.puts++
+	if s.puts == s.fail_at {
+		return fmt.Errorf("injected write failure")
+	}
+	return s.TrainerStore.Put(id, pokemon)
+}
+
+/*
+Function Name:  move_store
+Description:    MemTrainerStore with trainer 1 holding 25 6 9, trainer 2
+				full (1-6) and trainer 3 holding 7
+Parameters:     t: test handle
+Return Value:   the store
+Type:           *testing.T -> *MemTrainerStore
+*/
+func move_store(t *testing.T) *MemTrainerStore {
+	t.Helper()
+	store := NewMemTrainerStore(open_test_poke(t))
+	for _, pokemon := range [][]uint16{{25, 6, 9}, {1, 2, 3, 4, 5, 6}, {7}} {
+		if _, err := store.Post("ash", pokemon); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+/*
+Function Name:  trainer_poke
+Description:    pokemon IDs of one trainer in slot order
+Parameters:     t: test handle
+				store: trainer record store
+				id: trainer ID
+Return Value:   the IDs
+Type:           *testing.T, TrainerStore, uint16 -> []uint16
+*/
+func trainer_poke(t *testing.T, store TrainerStore, id uint16) []uint16 {
+	t.Helper()
+	trainer, err := store.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return TrainerPokeIDs(trainer)
+}
+
+/*
+Function Name:  TestMoveTrainerPokeRefused
+Description:    moving into a full trainer is ErrDestFull, from an empty
+				slot ErrSlotEmpty, from slot 0 or 7 ErrSlotRange, and
+				none of them changes either trainer
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestMoveTrainerPokeRefused(t *testing.T) {
+	store := move_store(t)
+	tests := []struct {
+		name      string
+		from_id   uint16
+		from_slot int
+		to_id     uint16
+		want      error
+	}{
+		{"destination full", 1, 1, 2, ErrDestFull},
+		{"empty slot", 3, 2, 1, ErrSlotEmpty},
+		{"slot 0", 1, 0, 3, ErrSlotRange},
+		{"slot 7", 1, 7, 3, ErrSlotRange},
+	}
+	for _, tt := range tests {
+		if err := MoveTrainerPoke(store, tt.from_id, tt.from_slot, tt.to_id); err != tt.want {
+			t.Fatalf("%s: %v, want %v", tt.name, err, tt.want)
+		}
+	}
+	for id, want := range map[uint16][]uint16{1: {25, 6, 9}, 2: {1, 2, 3, 4, 5, 6}, 3: {7}} {
+		if got := trainer_poke(t, store, id); !slices.Equal(got, want) {
+			t.Fatalf("trainer %d after refused moves: %v, want %v", id, got, want)
+		}
+	}
+}
+
+/*
+Function Name:  TestMoveTrainerPokeSameTrainer
+Description:    a move within one trainer puts the pokemon in the last slot
+				and shifts the rest left, a full trainer (6/6) is not
+				ErrDestFull since it gives up the slot it fills
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestMoveTrainerPokeSameTrainer(t *testing.T) {
+	store := move_store(t)
+	if err := MoveTrainerPoke(store, 1, 1, 1); err != nil {
+		t.Fatalf("trainer 1 to itself: %v", err)
+	}
+	if got := trainer_poke(t, store, 1); !slices.Equal(got, []uint16{6, 9, 25}) {
+		t.Fatalf("trainer 1 after moving slot 1 to itself: %v, want [6 9 25]", got)
+	}
+	if err := MoveTrainerPoke(store, 2, 2, 2); err != nil {
+		t.Fatalf("full trainer 2 to itself: %v, want nil", err)
+	}
+	if got := trainer_poke(t, store, 2); !slices.Equal(got, []uint16{1, 3, 4, 5, 6, 2}) {
+		t.Fatalf("trainer 2 after moving slot 2 to itself: %v, want [1 3 4 5 6 2]", got)
+	}
+}
+
+/*
+Function Name:  TestMoveTrainerPokeRollback
+Description:    when the write of the giving trainer fails after the
+				receiving one was written, the receiving trainer is put
+				back, so neither changes, and the error is returned
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestMoveTrainerPokeRollback(t *testing.T) {
+	store := &put_fails_store{TrainerStore: move_store(t), fail_at: 2}
+	if err := MoveTrainerPoke(store, 1, 2, 3); err == nil {
+		t.Fatal("move with a failing second write returned nil")
+	}
+	if got := trainer_poke(t, store, 3); !slices.Equal(got, []uint16{7}) {
+		t.Fatalf("receiving trainer after the rollback: %v, want [7]", got)
+	}
+	if got := trainer_poke(t, store, 1); !slices.Equal(got, []uint16{25, 6, 9}) {
+		t.Fatalf("giving trainer after the rollback: %v, want [25 6 9]", got)
+	}
+
+	store.puts, store.fail_at = 0, 0
+	if err := MoveTrainerPoke(store, 1, 2, 3); err != nil {
+		t.Fatal(err)
+	}
+	if got := trainer_poke(t, store, 3); !slices.Equal(got, []uint16{7, 6}) {
+		t.Fatalf("receiving trainer after the move: %v, want [7 6]", got)
+	}
+	if got := trainer_poke(t, store, 1); !slices.Equal(got, []uint16{25, 9}) {
+		t.Fatalf("giving trainer after the move: %v, want [25 9]", got)
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Filename:  trainer_query.go
+Description:
+  - Trainer predicates used by the bulk trainer commands
+  - Predicate text is one or more clauses joined by "and":
+      empty               trainer has no pokemon
+      name_prefix <text>  trainer name starts with text (case sensitive)
+      has_poke <id>       trainer owns pokemon id
+  - CountTrainersWithPoke, the reverse of a trainer's pokemon list (REQ_TRAINER_WITH_POKE)
+*/
+package recordlib
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type TrainerPredicate func(trainer TrainerRec) bool
+
+/*
+Function Name:  ParseTrainerPredicate
+Description:    parses predicate text into a TrainerPredicate, all clauses must match
+Parameters:     expr: predicate text, e.g. "empty" or "name_prefix Ash and has_poke 25"
+Return Value:   predicate and error naming the bad clause (if any)
+Type:           string -> TrainerPredicate, error
+*/
+func ParseTrainerPredicate(expr string) (TrainerPredicate, error) {
+	fields := strings.Fields(expr)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty predicate")
+	}
+
+	var clauses []TrainerPredicate
+	for len(fields) > 0 {
+		switch fields[0] {
+		case "empty":
+			clauses = append(clauses, func(trainer TrainerRec) bool {
+				return len(TrainerPokeIDs(trainer)) == 0
+			})
+			fields = fields[1:]
+		case "name_prefix":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("name_prefix requires <text>")
+			}
+			prefix := fields[1]
+			clauses = append(clauses, func(trainer TrainerRec) bool {
+				return strings.HasPrefix(CString(trainer.Name[:]), prefix)
+			})
+			fields = fields[2:]
+		case "has_poke":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("has_poke requires <id>")
+			}
+			id, err := strconv.Atoi(fields[1])
+			if err != nil || id <= 0 || id > 0xFFFF {
+				return nil, fmt.Errorf("has_poke '%s' is not a pokemon id", fields[1])
+			}
+			clauses = append(clauses, func(trainer TrainerRec) bool {
+				return slices.Contains(TrainerPokeIDs(trainer), uint16(id))
+			})
+			fields = fields[2:]
+		default:
+			return nil, fmt.Errorf("unknown predicate clause '%s'", fields[0])
+		}
+
+		if len(fields) > 0 {
+			if fields[0] != "and" || len(fields) == 1 {
+				return nil, fmt.Errorf("expected 'and <clause>' after clause, got '%s'", strings.Join(fields, " "))
+			}
+			fields = fields[1:]
+		}
+	}
+
+	return func(trainer TrainerRec) bool {
+		for _, clause := range clauses {
+			if !clause(trainer) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+/*
+Function Name:  MatchTrainers
+Description:    collects the IDs of every live trainer matching pred, ascending
+Parameters:     store: trainer record store
+                pred: trainer predicate
+Return Value:   matching IDs and error from the store (if any)
+Type:           TrainerStore, TrainerPredicate -> []uint16, error
+*/
+func MatchTrainers(store TrainerStore, pred TrainerPredicate) ([]uint16, error) {
+	var ids []uint16
+	err := store.All(func(trainer TrainerRec) error {
+		if pred(trainer) {
+			ids = append(ids, trainer.ID)
+		}
+		return nil
+	})
+	return ids, err
+}
+
+//reply for REQ_TRAINER_WITH_POKE, Trainers ascending
+type TrainersUsing struct {
+	PokeID   uint16   `json:"poke_id"`
+	Count    int      `json:"count"`
+	Trainers []uint16 `json:"trainers"`
+}
+
+/*
+Function Name:  CountTrainersWithPoke
+Description:    scans the live trainers for any slot holding poke_id, a
+				trainer with it in two slots counts once, caller holds
+				the read-all lock so the count and IDs agree
+Parameters:     store: trainer record store
+                poke_id: pokemon ID to look for
+Return Value:   number of trainers, their IDs ascending and error from the store (if any)
+Type:           TrainerStore, uint16 -> int, []uint16, error
+*/
+func CountTrainersWithPoke(store TrainerStore, poke_id uint16) (int, []uint16, error) {
+	ids := []uint16{}
+	err := store.All(func(trainer TrainerRec) error {
+		if slices.Contains(TrainerPokeIDs(trainer), poke_id) {
+			ids = append(ids, trainer.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return len(ids), ids, nil
+}
+
+/*
+Function Name:  DeleteTrainersWhere
+Description:    deletes the candidate trainers that still match pred,
+				candidates come from an earlier MatchTrainers scan so each
+				is re-checked, caller holds write locks on all candidates
+Parameters:     store: trainer record store
+                ids: candidate trainer IDs
+                pred: trainer predicate
+Return Value:   IDs actually deleted and error (if any), IDs deleted before
+				an error stay deleted
+Type:           TrainerStore, []uint16, TrainerPredicate -> []uint16, error
+*/
+func DeleteTrainersWhere(store TrainerStore, ids []uint16, pred TrainerPredicate) ([]uint16, error) {
+	var deleted []uint16
+	for _, id := range ids {
+		trainer, err := store.Get(id)
+		if err == ErrTrainerNotFound || err == io.EOF {
+			continue //deleted since the scan
+		} else if err != nil {
+			return deleted, err
+		}
+		if !pred(trainer) {
+			continue //modified since the scan
+		}
+		if err := store.Delete(id); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, id)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,124 @@
+/*
+Filename:  trainer_query_test.go
+Description:
+  - Trainer predicates, MatchTrainers and DeleteTrainersWhere against a MemTrainerStore
+*/
+package recordlib
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+/*
+Function Name:  query_store
+Description:    in-memory store holding ash (25, 6), an empty misty, brock (95),
+				an empty ashley and a deleted fifth trainer
+Parameters:     t: test handle
+Return Value:   the store
+Type:           *testing.T -> *MemTrainerStore
+*/
+func query_store(t *testing.T) *MemTrainerStore {
+	t.Helper()
+	store := NewMemTrainerStore(open_test_poke(t))
+	for _, def := range []TrainerDef{
+		{Name: "ash", Pokemon: []uint16{25, 6}},
+		{Name: "misty"},
+		{Name: "brock", Pokemon: []uint16{95}},
+		{Name: "ashley"},
+		{Name: "gone"},
+	} {
+		if _, err := store.Post(def.Name, def.Pokemon); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Delete(5); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+/*
+Function Name:  TestMatchTrainers
+Description:    each predicate form matches the expected live trainers in
+				ascending ID order, bad predicate text is refused
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestMatchTrainers(t *testing.T) {
+	store := query_store(t)
+	tests := []struct {
+		expr string
+		want []uint16
+	}{
+		{"empty", []uint16{2, 4}},
+		{"name_prefix ash", []uint16{1, 4}},
+		{"has_poke 25", []uint16{1}},
+		{"name_prefix ash and empty", []uint16{4}},
+		{"has_poke 25 and has_poke 95", nil},
+	}
+	for _, tt := range tests {
+		pred, err := ParseTrainerPredicate(tt.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.expr, err)
+		}
+		ids, err := MatchTrainers(store, pred)
+		if err != nil || !slices.Equal(ids, tt.want) {
+			t.Fatalf("%s: %v, %v, want %v", tt.expr, ids, err, tt.want)
+		}
+	}
+	for _, expr := range []string{"", "full", "has_poke", "has_poke 0", "empty and", "empty or empty"} {
+		if _, err := ParseTrainerPredicate(expr); err == nil {
+			t.Fatalf("%q accepted", expr)
+		}
+	}
+}
+
+/*
+Function Name:  TestDeleteTrainersWhere
+Description:    deletes every matched trainer that still matches: one given
+				pokemon and one deleted since the scan are skipped, the rest
+				are gone and the non-matching trainers untouched
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestDeleteTrainersWhere(t *testing.T) {
+	store := query_store(t)
+	for _, name := range []string{"may", "dawn", "iris"} { //empty trainers 6, 7, 8
+		if _, err := store.Post(name, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pred, _ := ParseTrainerPredicate("empty")
+	ids, err := MatchTrainers(store, pred)
+	if err != nil || !slices.Equal(ids, []uint16{2, 4, 6, 7, 8}) {
+		t.Fatalf("scan: %v, %v", ids, err)
+	}
+
+	//between the scan and the delete: misty gets a pokemon, dawn is deleted
+	if err := store.Put(2, []uint16{120}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(7); err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := DeleteTrainersWhere(store, ids, pred)
+	if err != nil || !slices.Equal(deleted, []uint16{4, 6, 8}) {
+		t.Fatalf("deleted %v, %v, want [4 6 8]", deleted, err)
+	}
+
+	var live []string
+	store.All(func(trainer TrainerRec) error {
+		live = append(live, CString(trainer.Name[:]))
+		return nil
+	})
+	if got := strings.Join(live, " "); got != "ash misty brock" {
+		t.Fatalf("left %q, want \"ash misty brock\"", got)
+	}
+	if left, _ := MatchTrainers(store, pred); len(left) != 0 {
+		t.Fatalf("empty trainers left: %v", left)
+	}
+}
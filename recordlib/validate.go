@@ -0,0 +1,104 @@
+/*
+Filename:  validate.go
+Description:
+  - Record sanity checks used by offline file inspection
+  - Each check returns a list of problems found, empty if the record looks sound
+  - Checks only look at one record, file size checks stay with the callers
+*/
+package recordlib
+
+import (
+	"fmt"
+)
+
+/*
+Function Name:  check_cstring
+Description:    checks a fixed size text field holds a printable string,
+				the last byte is only padding and not checked (the bundled
+				poke.bin has stray bytes there instead of a null)
+Parameters:     field: name of the field (for the message)
+				b: field bytes
+				required: whether an empty string is a problem
+Return Value:   problem description or ""
+Type:           string, []byte, bool -> string
+*/
+func check_cstring(field string, b []byte, required bool) string {
+	text := []byte(CString(b[:len(b)-1]))
+	if required && len(text) == 0 {
+		return fmt.Sprintf("%s is empty", field)
+	}
+	for _, c := range text {
+		if c < 0x20 || c > 0x7e {
+			return fmt.Sprintf("%s has non-printable byte 0x%02x", field, c)
+		}
+	}
+	return ""
+}
+
+/*
+Function Name:  CheckPokeRec
+Description:    sanity checks a pokemon record read from position id
+Parameters:     rec: pokemon record
+				id: ID the record's file position implies
+Return Value:   list of problems, empty if none
+Type:           PokeRec, uint16 -> []string
+*/
+func CheckPokeRec(rec PokeRec, id uint16) []string {
+	var problems []string
+	if rec.ID != id {
+		problems = append(problems, fmt.Sprintf("ID %d stored at position of ID %d", rec.ID, id))
+	}
+	for _, msg := range []string{
+		check_cstring("name", rec.Name[:], true),
+		check_cstring("type 1", rec.Type1[:], true),
+		check_cstring("type 2", rec.Type2[:], false),
+		check_cstring("color", rec.Color[:], true),
+		check_cstring("egg group 1", rec.EggGroup1[:], true),
+		check_cstring("egg group 2", rec.EggGroup2[:], false),
+		check_cstring("body style", rec.BodyStyle[:], true),
+	} {
+		if msg != "" {
+			problems = append(problems, msg)
+		}
+	}
+	if rec.IsLegendary > 1 || rec.HasGender > 1 || rec.HasMegaEvo > 1 {
+		problems = append(problems, "boolean field not 0 or 1")
+	}
+	if rec.HasGender != 0 && rec.PrMale > 8 { //unused when genderless
+		problems = append(problems, fmt.Sprintf("male probability %d/8 out of range", rec.PrMale))
+	}
+	return problems
+}
+
+/*
+Function Name:  CheckTrainerRec
+Description:    sanity checks a live trainer record read from position id,
+				pokemon slots must be filled from slot 1 with no gaps
+Parameters:     rec: trainer record
+				id: ID the record's file position implies
+Return Value:   list of problems, empty if none
+Type:           TrainerRec, uint16 -> []string
+*/
+func CheckTrainerRec(rec TrainerRec, id uint16) []string {
+	var problems []string
+	if rec.ID != id {
+		problems = append(problems, fmt.Sprintf("ID %d stored at position of ID %d", rec.ID, id))
+	}
+	if msg := check_cstring("name", rec.Name[:], true); msg != "" {
+		problems = append(problems, msg)
+	}
+	gap := false
+	for slot, poke := range []PokeDisplay{rec.Poke1, rec.Poke2, rec.Poke3, rec.Poke4, rec.Poke5, rec.Poke6} {
+		if poke.ID == 0 {
+			gap = true
+			continue
+		}
+		if gap {
+			problems = append(problems, fmt.Sprintf("pokemon in slot %d after an empty slot", slot+1))
+		}
+		if msg := check_cstring(fmt.Sprintf("slot %d pokemon name", slot+1), poke.Name[:], true); msg != "" {
+			problems = append(problems, msg)
+		}
+	}
+	return problems
+}
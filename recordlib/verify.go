@@ -0,0 +1,266 @@
+/*
+Filename:  verify.go
+Description:
+  - Whole file consistency scan (fsck) of the pokemon and trainer binary data files
+  - Reports size not a multiple of the record size, records whose ID doesn't match their
+    position (including ID 0 in a trainer slot that isn't blank), record check failures
+    and trainer pokemon references past the end of the pokemon file
+  - Issues collect in a VerifyReport that the server returns as JSON for REQ_FSCK
+  - ValidateTrainerRefs lists trainer slots holding a pokemon ID GetPokemon can't find
+    (REQ_VALIDATE_REFS), RepairTrainerRefs drops those slots from the trainers
+  - Callers hold the file locks, the scans only read
+*/
+package recordlib
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+)
+
+const MaxVerifyIssues = 100 //issues listed in a report, the rest are only counted
+
+//one problem found by a scan, Record is the record position (ID) or 0 for the whole file
+type VerifyIssue struct {
+	File    string `json:"file"`
+	Record  int64  `json:"record,omitempty"`
+	Problem string `json:"problem"`
+}
+
+//reply for REQ_FSCK, TotalIssues counts every issue, Issues lists the first MaxVerifyIssues
+type VerifyReport struct {
+	PokemonRecords int64         `json:"pokemon_records"`
+	TrainerRecords int64         `json:"trainer_records"`
+	Deleted        int64         `json:"deleted"`
+	TotalIssues    int           `json:"total_issues"`
+	Issues         []VerifyIssue `json:"issues"`
+}
+
+/*
+Function Name:  add
+Description:    method of VerifyReport
+				counts an issue and lists it unless MaxVerifyIssues are listed
+Parameters:     file: "pokemon" or "trainer"
+				record: record position or 0 for the whole file
+				problem: problem description
+Return Value:   n/a
+Type:           string, int64, string -> n/a
+*/
+func (r *VerifyReport) add(file string, record int64, problem string) {
+	r.TotalIssues++
+	if len(r.Issues) < MaxVerifyIssues {
+		r.Issues = append(r.Issues, VerifyIssue{File: file, Record: record, Problem: problem})
+	}
+}
+
+/*
+Function Name:  whole_records
+Description:    number of whole records after the header, a trailing partial
+				record or more records than IDs is added to the report
+Parameters:     file: the binary data file
+				kind: "pokemon" or "trainer" (for the report)
+				header_size: bytes before the first record
+				rec_size: size of one record in bytes
+				r: report to add issues to
+Return Value:   number of whole records to scan and error (if any)
+Type:           *os.File, string, int64, int64, *VerifyReport -> int64, error
+*/
+func whole_records(file *os.File, kind string, header_size int64, rec_size int64, r *VerifyReport) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := max(info.Size()-header_size, 0)
+	if size%rec_size != 0 {
+		r.add(kind, 0, fmt.Sprintf("%v: %d trailing bytes after the last whole record", ErrFileSize, size%rec_size))
+	}
+	num_recs := size / rec_size
+	if num_recs > 0xFFFF {
+		r.add(kind, 0, fmt.Sprintf("%d records but only 65535 IDs", num_recs))
+		num_recs = 0xFFFF
+	}
+	return num_recs, nil
+}
+
+/*
+Function Name:  VerifyPokemon
+Description:    scans every pokemon record, sets r.PokemonRecords, which
+				VerifyTrainers uses to check references, so run it first
+Parameters:     poke_file: the pokemon binary data file
+				r: report to add issues to
+Return Value:   nil or read error
+Type:           *os.File, *VerifyReport -> error
+*/
+func VerifyPokemon(poke_file *os.File, r *VerifyReport) error {
+	num_recs, err := whole_records(poke_file, "pokemon", 0, PokeRecSize, r)
+	if err != nil {
+		return err
+	}
+	r.PokemonRecords = num_recs
+	for idx := int64(1); idx <= num_recs; idx++ {
+		poke, err := GetPokemon(poke_file, uint16(idx))
+		if err != nil {
+			return err
+		}
+		for _, msg := range CheckPokeRec(poke, uint16(idx)) {
+			r.add("pokemon", idx, msg)
+		}
+	}
+	return nil
+}
+
+/*
+Function Name:  VerifyTrainers
+Description:    checks the trainer file header, then scans every record, an
+				all zero record is a deleted one, any other record must carry
+				its own ID, pass CheckTrainerRec and only reference pokemon
+				IDs up to r.PokemonRecords
+Parameters:     trainer_file: the trainer binary data file
+				r: report to add issues to
+Return Value:   nil or read error
+Type:           *os.File, *VerifyReport -> error
+*/
+func VerifyTrainers(trainer_file *os.File, r *VerifyReport) error {
+	if err := ValidateHeader(trainer_file, TrainerRecSize); err != nil {
+		r.add("trainer", 0, fmt.Sprintf("header: %v", err))
+		return nil //record offsets can't be trusted without a matching header
+	}
+	num_recs, err := whole_records(trainer_file, "trainer", HeaderSize, TrainerRecSize, r)
+	if err != nil {
+		return err
+	}
+	r.TrainerRecords = num_recs
+
+	blank := make([]byte, TrainerRecSize)
+	for idx := int64(1); idx <= num_recs; idx++ {
+		buf, err := read_at(trainer_file, trainer_offset(uint16(idx)), TrainerRecSize)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(buf, blank) {
+			r.Deleted++
+			continue
+		}
+		trainer, err := DecodeTrainerRec(buf)
+		if err != nil {
+			return err
+		}
+		if trainer.ID == 0 {
+			r.add("trainer", idx, "ID 0 in a record that is not blank")
+			continue
+		}
+		for _, msg := range CheckTrainerRec(trainer, uint16(idx)) {
+			r.add("trainer", idx, msg)
+		}
+		for slot, poke := range []PokeDisplay{trainer.Poke1, trainer.Poke2, trainer.Poke3, trainer.Poke4, trainer.Poke5, trainer.Poke6} {
+			if int64(poke.ID) > r.PokemonRecords {
+				r.add("trainer", idx, fmt.Sprintf("slot %d references pokemon %d, pokemon file has %d", slot+1, poke.ID, r.PokemonRecords))
+			}
+		}
+	}
+	return nil
+}
+
+//one trainer slot referencing a pokemon that isn't in the pokemon file, reply for REQ_VALIDATE_REFS
+type DanglingRef struct {
+	TrainerID uint16 `json:"trainer_id"`
+	Slot      int    `json:"slot"` //1-6
+	PokeID    uint16 `json:"poke_id"`
+}
+
+/*
+Function Name:  dangling_slots
+Description:    the slots of a trainer whose pokemon GetPokemon can't read or
+				that hold another ID, empty slots are skipped
+Parameters:     poke_file: the pokemon binary data file
+				trainer: trainer record
+Return Value:   dangling slots in slot order and read error (other than
+				past the end, which is dangling)
+Type:           *os.File, TrainerRec -> []DanglingRef, error
+*/
+func dangling_slots(poke_file *os.File, trainer TrainerRec) ([]DanglingRef, error) {
+	var refs []DanglingRef
+	for slot, poke := range []PokeDisplay{trainer.Poke1, trainer.Poke2, trainer.Poke3, trainer.Poke4, trainer.Poke5, trainer.Poke6} {
+		if poke.ID == 0 {
+			continue
+		}
+		rec, err := GetPokemon(poke_file, poke.ID)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		if err != nil || rec.ID != poke.ID {
+			refs = append(refs, DanglingRef{TrainerID: trainer.ID, Slot: slot + 1, PokeID: poke.ID})
+		}
+	}
+	return refs, nil
+}
+
+/*
+Function Name:  ValidateTrainerRefs
+Description:    scans every live trainer for slots referencing a pokemon that
+				isn't in the pokemon file, caller holds the read-all lock and
+				the pokemon read lock
+Parameters:     trainer_file: the trainer binary data file
+				poke_file: the pokemon binary data file
+Return Value:   dangling references in trainer then slot order (empty if
+				none) and read error (if any)
+Type:           *os.File, *os.File -> []DanglingRef, error
+*/
+func ValidateTrainerRefs(trainer_file *os.File, poke_file *os.File) ([]DanglingRef, error) {
+	refs := []DanglingRef{}
+	err := NewFileTrainerStore(trainer_file, poke_file).All(func(trainer TrainerRec) error {
+		found, err := dangling_slots(poke_file, trainer)
+		refs = append(refs, found...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+/*
+Function Name:  RepairTrainerRefs
+Description:    re-checks each trainer from an earlier ValidateTrainerRefs
+				scan and puts it back without its dangling slots, the other
+				pokemon move up to fill the gaps, caller holds write locks on
+				all the trainers and the pokemon read lock
+Parameters:     store: trainer record store
+				poke_file: the pokemon binary data file
+				ids: trainer IDs with dangling references
+Return Value:   references actually removed and error (if any), trainers
+				repaired before an error stay repaired
+Type:           TrainerStore, *os.File, []uint16 -> []DanglingRef, error
+*/
+func RepairTrainerRefs(store TrainerStore, poke_file *os.File, ids []uint16) ([]DanglingRef, error) {
+	removed := []DanglingRef{}
+	for _, id := range ids {
+		trainer, err := store.Get(id)
+		if err == ErrTrainerNotFound || err == io.EOF {
+			continue //deleted since the scan
+		} else if err != nil {
+			return removed, err
+		}
+		refs, err := dangling_slots(poke_file, trainer)
+		if err != nil {
+			return removed, err
+		}
+		if len(refs) == 0 {
+			continue //modified since the scan
+		}
+
+		var keep []uint16
+		for slot, poke := range []PokeDisplay{trainer.Poke1, trainer.Poke2, trainer.Poke3, trainer.Poke4, trainer.Poke5, trainer.Poke6} {
+			if poke.ID != 0 && !slices.ContainsFunc(refs, func(ref DanglingRef) bool { return ref.Slot == slot+1 }) {
+				keep = append(keep, poke.ID)
+			}
+		}
+		if err := store.Put(id, keep); err != nil {
+			return removed, err
+		}
+		removed = append(removed, refs...)
+	}
+	return removed, nil
+}
@@ -0,0 +1,61 @@
+/*
+Filename:  verify_test.go
+Description:
+  - Dangling trainer references: a slot written past the end of the pokemon file is
+    reported by ValidateTrainerRefs and dropped by RepairTrainerRefs
+*/
+package recordlib
+
+import (
+	"slices"
+	"testing"
+)
+
+/*
+Function Name:  TestDanglingTrainerRefs
+Description:    trainer 1's slot 2 written with the ID one past PokeCount
+				(bypassing Put's check) is reported as (1, 2, that ID), the
+				repair removes just that reference and shifts slot 3 up,
+				and a second scan finds nothing
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestDanglingTrainerRefs(t *testing.T) {
+	store, _ := temp_trainer_store(t)
+	for _, pokemon := range [][]uint16{{25, 6, 9}, {7}} {
+		if _, err := store.Post("ash", pokemon); err != nil {
+			t.Fatal(err)
+		}
+	}
+	count, err := PokeCount(store.PokeFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := uint16(count + 1)
+	trainer, err := store.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trainer.Poke2.ID = bad
+	if _, err := store.TrainerFile.WriteAt(EncodeTrainerRec(trainer), trainer_offset(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	refs, err := ValidateTrainerRefs(store.TrainerFile, store.PokeFile)
+	want := []DanglingRef{{TrainerID: 1, Slot: 2, PokeID: bad}}
+	if err != nil || !slices.Equal(refs, want) {
+		t.Fatalf("ValidateTrainerRefs: %+v, %v, want %+v", refs, err, want)
+	}
+	removed, err := RepairTrainerRefs(store, store.PokeFile, []uint16{1})
+	if err != nil || !slices.Equal(removed, want) {
+		t.Fatalf("RepairTrainerRefs: %+v, %v, want %+v", removed, err, want)
+	}
+	trainer, err = store.Get(1)
+	if err != nil || !slices.Equal(TrainerPokeIDs(trainer), []uint16{25, 9}) {
+		t.Fatalf("trainer 1 after the repair: %v, %v, want [25 9]", TrainerPokeIDs(trainer), err)
+	}
+	if refs, err := ValidateTrainerRefs(store.TrainerFile, store.PokeFile); err != nil || len(refs) != 0 {
+		t.Fatalf("scan after the repair: %+v, %v, want none", refs, err)
+	}
+}
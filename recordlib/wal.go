@@ -0,0 +1,215 @@
+/*
+Filename:  wal.go
+Description:
+  - Optional write-ahead log for trainer puts and deletes (server -wal): the new record
+    bytes and their offset are appended and synced to the log before the record is
+    written in place, so a crash that tears the in-place write is repaired on startup
+  - Entry: offset (int64) and length (uint32) little endian, crc32 (IEEE) of both and the
+    data, then the data, one whole trainer record
+  - ReplayWAL rewrites every complete entry in log order (later entries win, replaying
+    twice is harmless) and stops at a torn or corrupt tail, the entry being appended when
+    the server died, whose in-place write never started
+  - CheckpointWAL syncs the trainer file and then empties the log, only when every logged
+    write reached the file before that Sync and nothing was logged since
+*/
+package recordlib
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"sync"
+)
+
+const wal_entry_header = 16 //offset, length, crc32
+
+type WAL struct {
+	file     *os.File
+	lock     sync.Mutex //guards everything below, held across appends and checkpoints
+	size     int64      //end of the last complete entry, where the next one goes
+	seq      uint64     //entries appended so far
+	inflight int        //appended entries whose in-place write hasn't returned yet
+}
+
+/*
+Function Name:  NewWAL
+Description:    opens (or creates) a write-ahead log, entries already in it
+				are kept for ReplayWAL
+Parameters:     path: log file (next to the trainer file)
+Return Value:   the log and error (if any)
+Type:           string -> *WAL, error
+*/
+func NewWAL(path string) (*WAL, error) {
+	file, err := OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &WAL{file: file, size: info.Size()}, nil
+}
+
+/*
+Function Name:  Close
+Description:    method of WAL
+				closes the log file, checkpoint first to leave it empty
+Parameters:     n/a
+Return Value:   close error (if any)
+Type:           n/a -> error
+*/
+func (w *WAL) Close() error {
+	return w.file.Close()
+}
+
+/*
+Function Name:  AppendWAL
+Description:    appends one intended record write and syncs the log, the
+				caller writes data at offset only after this returns nil
+Parameters:     wal: write-ahead log
+				offset: trainer file offset of the record
+				data: the record's new bytes
+Return Value:   nil once the entry is on disk or write/sync error (the
+				partial entry is cut off again)
+Type:           *WAL, int64, []byte -> error
+*/
+func AppendWAL(wal *WAL, offset int64, data []byte) error {
+	entry := make([]byte, wal_entry_header, wal_entry_header+len(data))
+	binary.LittleEndian.PutUint64(entry[0:8], uint64(offset))
+	binary.LittleEndian.PutUint32(entry[8:12], uint32(len(data)))
+	entry = append(entry, data...)
+	sum := crc32.ChecksumIEEE(entry[0:12])
+	sum = crc32.Update(sum, crc32.IEEETable, data)
+	binary.LittleEndian.PutUint32(entry[12:16], sum)
+
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+	if _, err := wal.file.WriteAt(entry, wal.size); err != nil {
+		wal.file.Truncate(wal.size)
+		return err
+	}
+	if err := wal.file.Sync(); err != nil {
+		wal.file.Truncate(wal.size)
+		return err
+	}
+	wal.size += int64(len(entry))
+	wal.seq++
+	wal.inflight++
+	return nil
+}
+
+/*
+Function Name:  write_logged
+Description:    writes a record in place, logging it first when wal isn't nil
+Parameters:     trainer_file: the trainer binary data file
+				wal: write-ahead log, nil to write without one
+				offset: record offset
+				data: the record's new bytes
+Return Value:   log or write error (if any)
+Type:           *os.File, *WAL, int64, []byte -> error
+*/
+func write_logged(trainer_file *os.File, wal *WAL, offset int64, data []byte) error {
+	if wal != nil {
+		if err := AppendWAL(wal, offset, data); err != nil {
+			return err
+		}
+		defer func() {
+			wal.lock.Lock()
+			wal.inflight--
+			wal.lock.Unlock()
+		}()
+	}
+	_, err := trainer_file.WriteAt(data, offset)
+	return err
+}
+
+/*
+Function Name:  CheckpointWAL
+Description:    syncs the trainer file and empties the log if the Sync covered
+				every entry in it, otherwise the entries wait for the next
+				checkpoint, a nil wal only syncs
+Parameters:     wal: write-ahead log or nil
+				trainer_file: the trainer binary data file
+Return Value:   sync or truncate error (if any)
+Type:           *WAL, *os.File -> error
+*/
+func CheckpointWAL(wal *WAL, trainer_file *os.File) error {
+	if wal == nil {
+		return trainer_file.Sync()
+	}
+	wal.lock.Lock()
+	seq, applied := wal.seq, wal.inflight == 0
+	wal.lock.Unlock()
+
+	if err := trainer_file.Sync(); err != nil {
+		return err
+	}
+	if !applied {
+		return nil //a logged write was still going in when the Sync started
+	}
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+	if wal.seq != seq || wal.size == 0 {
+		return nil //logged since, not covered by the Sync
+	}
+	if err := wal.file.Truncate(0); err != nil {
+		return err
+	}
+	wal.size = 0
+	return wal.file.Sync()
+}
+
+/*
+Function Name:  ReplayWAL
+Description:    rewrites every complete entry of the log into the trainer
+				file, syncs it and empties the log, run at startup before
+				anything else writes, a torn or corrupt tail is dropped
+Parameters:     wal: write-ahead log
+				trainer_file: the trainer binary data file
+Return Value:   number of entries replayed and error (if any), an entry with
+				a valid checksum but no trainer record's offset or size is an error
+Type:           *WAL, *os.File -> int, error
+*/
+func ReplayWAL(wal *WAL, trainer_file *os.File) (int, error) {
+	wal.lock.Lock()
+	defer wal.lock.Unlock()
+	log := make([]byte, wal.size)
+	if _, err := wal.file.ReadAt(log, 0); err != nil && wal.size > 0 {
+		return 0, err
+	}
+
+	replayed := 0
+	for pos := 0; pos+wal_entry_header <= len(log); {
+		offset := int64(binary.LittleEndian.Uint64(log[pos : pos+8]))
+		length := int(binary.LittleEndian.Uint32(log[pos+8 : pos+12]))
+		end := pos + wal_entry_header + length
+		if length < 0 || end > len(log) {
+			break //torn tail
+		}
+		data := log[pos+wal_entry_header : end]
+		sum := crc32.ChecksumIEEE(log[pos : pos+12])
+		if crc32.Update(sum, crc32.IEEETable, data) != binary.LittleEndian.Uint32(log[pos+12:pos+16]) {
+			break //torn tail
+		}
+		if length != TrainerRecSize || offset < HeaderSize || (offset-HeaderSize)%TrainerRecSize != 0 {
+			return replayed, fmt.Errorf("wal entry %d: not a trainer record (offset %d, %d bytes)", replayed+1, offset, length)
+		}
+		if _, err := trainer_file.WriteAt(data, offset); err != nil {
+			return replayed, err
+		}
+		replayed++
+		pos = end
+	}
+
+	if err := trainer_file.Sync(); err != nil {
+		return replayed, err
+	}
+	if err := wal.file.Truncate(0); err != nil {
+		return replayed, err
+	}
+	wal.size = 0
+	return replayed, wal.file.Sync()
+}
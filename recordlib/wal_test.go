@@ -0,0 +1,182 @@
+/*
+Filename:  wal_test.go
+Description:
+  - Crash recovery and checkpointing of the write-ahead log against a trainer file in a
+    temporary directory, pokemon come from the bundled poke.bin
+*/
+package recordlib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/*
+Function Name:  open_test_poke
+Description:    opens the bundled pokemon file read-only for one test
+Parameters:     t: test handle, the file is closed when the test ends
+Return Value:   the pokemon file
+Type:           *testing.T -> *os.File
+*/
+func open_test_poke(t *testing.T) *os.File {
+	t.Helper()
+	poke_file, err := os.Open("../poke.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { poke_file.Close() })
+	return poke_file
+}
+
+/*
+Function Name:  temp_trainer_store
+Description:    FileTrainerStore over a new, empty trainer file (header only)
+				in the test's temporary directory
+Parameters:     t: test handle, the file is closed when the test ends
+Return Value:   the store, its trainer file path
+Type:           *testing.T -> *FileTrainerStore, string
+*/
+func temp_trainer_store(t *testing.T) (*FileTrainerStore, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "trainer.bin")
+	trainer_file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { trainer_file.Close() })
+	if err := WriteHeader(trainer_file, TrainerRecSize); err != nil {
+		t.Fatal(err)
+	}
+	return NewFileTrainerStore(trainer_file, open_test_poke(t)), path
+}
+
+/*
+Function Name:  wal_size
+Description:    size of a log file on disk
+Parameters:     t: test handle
+				path: log file
+Return Value:   size in bytes
+Type:           *testing.T, string -> int64
+*/
+func wal_size(t *testing.T, path string) int64 {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+/*
+Function Name:  TestWALCrashRecovery
+Description:    a put and a delete are logged but never written in place (a
+				crash between AppendWAL and the WriteAt), a torn entry is
+				left at the end, replaying on the next open applies both
+				complete entries, drops the torn one and empties the log
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestWALCrashRecovery(t *testing.T) {
+	store, path := temp_trainer_store(t)
+	for _, name := range []string{"ash", "misty"} {
+		if _, err := store.Post(name, []uint16{25}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wal_path := path + ".wal"
+	wal, err := NewWAL(wal_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put := TrainerRec{ID: 1}
+	copy(put.Name[:], "ash")
+	if err := fill_slots(store.PokeFile, &put, []uint16{6, 9}); err != nil {
+		t.Fatal(err)
+	}
+	if err := AppendWAL(wal, trainer_offset(1), EncodeTrainerRec(put)); err != nil {
+		t.Fatal(err)
+	}
+	if err := AppendWAL(wal, trainer_offset(2), EncodeTrainerRec(TrainerRec{})); err != nil {
+		t.Fatal(err)
+	}
+	//the entry being appended when the server died: header and half the data
+	torn := TrainerRec{ID: 1}
+	copy(torn.Name[:], "torn")
+	if err := AppendWAL(wal, trainer_offset(1), EncodeTrainerRec(torn)); err != nil {
+		t.Fatal(err)
+	}
+	wal.Close()
+	if err := os.Truncate(wal_path, wal_size(t, wal_path)-TrainerRecSize/2); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := GetTrainer(store.TrainerFile, 1); got.Poke1.ID != 25 {
+		t.Fatalf("trainer 1 changed before replay: %+v", got)
+	}
+
+	wal, err = NewWAL(wal_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	replayed, err := ReplayWAL(wal, store.TrainerFile)
+	if err != nil || replayed != 2 {
+		t.Fatalf("replay: %d entries, %v, want 2", replayed, err)
+	}
+	got, err := GetTrainer(store.TrainerFile, 1)
+	if err != nil || got.Poke1.ID != 6 || got.Poke2.ID != 9 || CString(got.Name[:]) != "ash" {
+		t.Fatalf("trainer 1 after replay: %+v, %v, want ash with 6 and 9", got, err)
+	}
+	if _, err := GetTrainer(store.TrainerFile, 2); err != ErrTrainerNotFound {
+		t.Fatalf("trainer 2 after replay: %v, want ErrTrainerNotFound", err)
+	}
+	if size := wal_size(t, wal_path); size != 0 {
+		t.Fatalf("log is %d bytes after replay, want 0", size)
+	}
+
+	//replaying an already applied log again changes nothing
+	if replayed, err := ReplayWAL(wal, store.TrainerFile); err != nil || replayed != 0 {
+		t.Fatalf("second replay: %d entries, %v", replayed, err)
+	}
+}
+
+/*
+Function Name:  TestWALDeleteCheckpoints
+Description:    deletes alone checkpoint the log, with no Syncer (every write
+				synced) and with a batch Syncer once -sync-every is reached
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestWALDeleteCheckpoints(t *testing.T) {
+	for _, mode := range []string{"unsynced", "batch"} {
+		store, path := temp_trainer_store(t)
+		for idx := 0; idx < 4; idx++ {
+			if _, err := store.Post("ash", nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		wal, err := NewWAL(path + ".wal")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer wal.Close()
+		store.WAL = wal
+		if mode == "batch" {
+			store.Syncer = NewSyncer(store.TrainerFile, SyncBatch, 2, 1<<40) //never by timer
+			store.Syncer.WAL = wal
+			defer store.Syncer.Close()
+		}
+
+		for id := uint16(1); id <= 4; id++ {
+			if err := store.Delete(id); err != nil {
+				t.Fatalf("%s: delete %d: %v", mode, id, err)
+			}
+		}
+		if size := wal_size(t, path+".wal"); size != 0 {
+			t.Fatalf("%s: log is %d bytes after 4 deletes, want 0", mode, size)
+		}
+	}
+}
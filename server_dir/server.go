@@ -5,96 +5,543 @@ Description:
   - Handles requests to read pokemon records and CRUD with trainer records and server log
   - Processes client commands using regex patterns, performs file-based operations and responding with JSON or status codes
   - Concurrent handling of clients, error logging and recovery from potential panics
-  - Deferred setup/teardown, gracefully exits upon receiving interrupt signal
+  - Deferred setup/teardown, gracefully exits upon receiving SIGINT or SIGTERM, rotates the log on SIGHUP
 */
 package main
 
 import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math/rand"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
-	"unsafe"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"project3/recordlib"
-	"golang.org/x/sys/unix"
 )
 
+type server_opts struct {
+	host              [4]byte       //listen address
+	port              int           //listen port
+	data_dir          string        //directory the data and log files are resolved in
+	poke_file_name    string        //pokemon binary file
+	trainer_file_name string        //trainer binary file
+	log_file_name     string        //log file
+	shutdown_timeout  time.Duration //max time to wait for clients on shutdown
+	secret            string        //token clients send with AUTH, mutations open and admin commands disabled if ""
+	max_clients       int           //concurrent clients served, others get SERVER_BUSY
+	http_port         int           //HTTP/JSON gateway port, 0 if disabled
+	no_cache          bool          //read pokemon from the file on every request
+	trainer_cache     int           //trainer LRU cache size, 0 if disabled
+	no_trainer_index  bool          //answer trainers using X by scanning instead of the reverse index
+	migrate           bool          //rewrite a trainer file with no or an old header instead of refusing
+	scan_timeout      time.Duration //longest a trainer listing may hold the read-all lock, 0 for no limit
+	lock_timeout      time.Duration //longest a single record write waits for its lock, 0 for no limit
+	tls               *tls.Config   //certificate from -cert/-key, nil for plaintext
+	net_transport     bool          //-transport net, net.Listener in place of raw syscalls
+	post_key_ttl      time.Duration //how long POST_TRAINER idempotency keys are remembered
+	lock_policy       recordlib.LockPolicy //record lock fairness, -lock-policy
+	sync_mode         recordlib.SyncMode   //when trainer writes are synced, -sync
+	sync_every        int           //batch mode, writes between syncs
+	sync_interval     time.Duration //batch mode, how often pending writes are synced
+	wal               bool          //log trainer puts and deletes to <trainer file>.wal first, -wal
+}
+
+//max time an accepted TLS client has to finish its handshake
+const tls_handshake_timeout = 5 * time.Second
+
+//largest n accepted by REQ_POKE_TOP
+const max_top_pokemon = 100
+
+//most IDs accepted by REQ_POKE_MULTI
+const max_multi_pokemon = 100
+
+//most trainers REQ_TRAINER_ALL_SORTED buffers, larger files get TOO_MANY
+const max_sorted_trainers = 10000
+
+//random trainer IDs tried before REQ_RANDOM_TRAINER scans for the live ones
+const max_random_tries = 16
+
+var ErrTooManySorted = fmt.Errorf("too many records")
+
+//connection handed from the accept loop to the clients manager
+type accepted_conn struct {
+	sock recordlib.Conn
+	ip   [4]byte
+	port int
+}
+
 /*
 Function Name:  get_opts
 Description:    parses flag arguments for server program
 				exits if -h for help
+				-a is optional, defaults to 127.0.0.1 (0.0.0.0 for all interfaces)
 Parameters:     N/A
-Return Value:   the three required arguments and error (if any)
-Type:           n/a -> int, string, string, error
+Return Value:   the parsed server options and error (if any)
+Type:           n/a -> server_opts, error
 */
-func get_opts() (int, string, string, string, error) {
+func get_opts() (server_opts, error) {
 	help_flag := flag.Bool("h", false, "Show help (must be used on its own)")
+	addr_flag := flag.String("a", "127.0.0.1", "IPv4 address to listen on (0.0.0.0 for all interfaces)")
 	port_flag := flag.Int("p", -1, "Port number")
 	bin_file_flag := flag.String("m", "", "Name of Pokemon binary file")
 	trainer_file_flag := flag.String("t", "", "Name of trainer binary file")
 	log_file_flag := flag.String("l", "", "Name of log file")
+	data_dir_flag := flag.String("d", ".", "Data directory, -m, -t and -l are relative to it and may not leave it")
+	shutdown_flag := flag.Duration("shutdown-timeout", 5*time.Second, "Max time to wait for clients to acknowledge shutdown")
+	secret_flag := flag.String("secret", "", "Token clients must send with AUTH to mutate trainers or run admin commands (mutations open, admin disabled if unset)")
+	max_clients_flag := flag.Int("c", 100, "Max concurrent clients, extra connections are sent SERVER_BUSY")
+	http_flag := flag.Int("http", 0, "Also serve an HTTP/JSON gateway on this port (off if 0)")
+	trainer_cache_flag := flag.Int("trainer-cache", 256, "Trainer records kept in an LRU cache (0 disables)")
+	migrate_flag := flag.Bool("migrate", false, "Rewrite a headerless or old layout trainer file in the current layout (backup kept as <file>.bak)")
+	no_cache_flag := flag.Bool("no-cache", false, "Read pokemon from the file on every request instead of an in-memory copy")
+	no_trainer_index_flag := flag.Bool("no-trainer-index", false, "Scan the trainers for each 'trainers using' query instead of keeping a pokemon -> trainers index in memory")
+	post_key_ttl_flag := flag.Duration("post-key-ttl", 10*time.Minute, "How long a post's idempotency key is remembered, a retry within it gets the same ID")
+	transport_flag := flag.String("transport", "syscall", "Socket transport: syscall (raw unix sockets) or net (Go net package)")
+	cert_flag := flag.String("cert", "", "TLS certificate file (PEM), clients must connect with -tls (needs -key)")
+	key_flag := flag.String("key", "", "TLS private key file (PEM) for -cert")
+	scan_timeout_flag := flag.Duration("scan-timeout", 30*time.Second, "Longest a trainer listing may hold the read-all lock, it is aborted with TIMEOUT after (0 for no limit)")
+	lock_timeout_flag := flag.Duration("lock-timeout", 0, "Longest a put, add or remove waits for its trainer's write lock, it then fails with a lock timeout (0 waits as long as it takes)")
+	lock_policy_flag := flag.String("lock-policy", "writer", "Record lock fairness: writer (writers first), reader (readers first) or fifo (arrival order)")
+	sync_flag := flag.String("sync", "always", "When trainer writes are synced to disk: always (every write), batch (see -sync-every/-sync-interval) or os (left to the OS)")
+	sync_every_flag := flag.Int("sync-every", 100, "With -sync batch, sync once this many writes are pending")
+	sync_interval_flag := flag.Duration("sync-interval", 200*time.Millisecond, "With -sync batch, how often pending writes are synced")
+	wal_flag := flag.Bool("wal", false, "Log trainer puts and deletes to <trainer file>.wal before writing them, replayed on the next start after a crash")
 
+	var opts server_opts
 	flag.Parse()
 	if *help_flag {
 		if flag.NFlag() > 1 {
-			return -1, "", "", "", fmt.Errorf("-h must be used alone")
+			return opts, fmt.Errorf("-h must be used alone")
 		}
 		fmt.Println("Usage:")
 		flag.PrintDefaults()
-		unix.Exit(0)
+		os.Exit(0)
 	}
 
 	if *port_flag == -1 || *bin_file_flag == "" || *trainer_file_flag == "" || *log_file_flag == "" {
-		return -1, "", "", "", fmt.Errorf("-p, -m, -t, and -l are required")
+		return opts, fmt.Errorf("-p, -m, -t, and -l are required")
+	}
+
+	parsed_ip := net.ParseIP(*addr_flag).To4()
+	if parsed_ip == nil {
+		return opts, fmt.Errorf("-a '%s' is not a valid IPv4 address", *addr_flag)
+	}
+	if *shutdown_flag <= 0 {
+		return opts, fmt.Errorf("-shutdown-timeout must be positive")
+	}
+	if *max_clients_flag < 1 {
+		return opts, fmt.Errorf("-c must be at least 1")
+	}
+	if *trainer_cache_flag < 0 {
+		return opts, fmt.Errorf("-trainer-cache must not be negative")
+	}
+	if *scan_timeout_flag < 0 {
+		return opts, fmt.Errorf("-scan-timeout must not be negative")
+	}
+	if *lock_timeout_flag < 0 {
+		return opts, fmt.Errorf("-lock-timeout must not be negative")
+	}
+	if *http_flag < 0 || *http_flag > 65535 || (*http_flag != 0 && *http_flag == *port_flag) {
+		return opts, fmt.Errorf("-http must be a free port other than -p")
+	}
+
+	if *transport_flag != "syscall" && *transport_flag != "net" {
+		return opts, fmt.Errorf("-transport must be syscall or net")
+	}
+	if *post_key_ttl_flag <= 0 {
+		return opts, fmt.Errorf("-post-key-ttl must be positive")
+	}
+	lock_policy, err := recordlib.ParseLockPolicy(*lock_policy_flag)
+	if err != nil {
+		return opts, fmt.Errorf("-lock-policy: %v", err)
+	}
+	sync_mode, err := recordlib.ParseSyncMode(*sync_flag)
+	if err != nil {
+		return opts, fmt.Errorf("-sync: %v", err)
+	}
+	if *sync_every_flag < 1 || *sync_interval_flag <= 0 {
+		return opts, fmt.Errorf("-sync-every must be at least 1 and -sync-interval positive")
+	}
+	if *wal_flag && sync_mode == recordlib.SyncOS {
+		//os mode never syncs, so the log would never be checkpointed and grow for the server's lifetime
+		return opts, fmt.Errorf("-wal needs -sync always or batch")
+	}
+	if (*cert_flag == "") != (*key_flag == "") {
+		return opts, fmt.Errorf("-cert and -key must be used together")
+	}
+	if *cert_flag != "" {
+		cfg, err := recordlib.ServerTLSConfig(*cert_flag, *key_flag)
+		if err != nil {
+			return opts, err
+		}
+		opts.tls = cfg
+	}
+
+	if err := check_data_dir(*data_dir_flag); err != nil {
+		return opts, err
+	}
+	var paths [3]string
+	for idx, name := range []string{*bin_file_flag, *trainer_file_flag, *log_file_flag} {
+		path, err := data_path(*data_dir_flag, name)
+		if err != nil {
+			return opts, err
+		}
+		paths[idx] = path
+	}
+
+	copy(opts.host[:], parsed_ip)
+	opts.port = *port_flag
+	opts.data_dir = *data_dir_flag
+	opts.poke_file_name = paths[0]
+	opts.trainer_file_name = paths[1]
+	opts.log_file_name = paths[2]
+	opts.shutdown_timeout = *shutdown_flag
+	opts.secret = *secret_flag
+	opts.max_clients = *max_clients_flag
+	opts.http_port = *http_flag
+	opts.no_cache = *no_cache_flag
+	opts.trainer_cache = *trainer_cache_flag
+	opts.no_trainer_index = *no_trainer_index_flag
+	opts.migrate = *migrate_flag
+	opts.scan_timeout = *scan_timeout_flag
+	opts.lock_timeout = *lock_timeout_flag
+	opts.net_transport = *transport_flag == "net"
+	opts.post_key_ttl = *post_key_ttl_flag
+	opts.lock_policy = lock_policy
+	opts.sync_mode = sync_mode
+	opts.sync_every = *sync_every_flag
+	opts.sync_interval = *sync_interval_flag
+	opts.wal = *wal_flag
+	return opts, nil
+}
+
+/*
+Function Name:  check_data_dir
+Description:    checks the data directory exists and the server can create
+                files in it (the trainer, log, rotated log and index files)
+Parameters:     dir: data directory (-d)
+Return Value:   nil or error naming the problem
+Type:           string -> error
+*/
+func check_data_dir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("-d %s: %v", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("-d %s is not a directory", dir)
+	}
+	probe, err := os.CreateTemp(dir, ".pokedb-write-check-*")
+	if err != nil {
+		return fmt.Errorf("-d %s is not writable: %v", dir, err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	return nil
+}
+
+/*
+Function Name:  data_path
+Description:    resolves a file flag inside the data directory, an absolute
+                path or one that leaves the directory through ".." is
+                refused, the check is on the path only (not symlinks)
+Parameters:     dir: data directory (-d)
+                name: file name as given to -m, -t or -l
+Return Value:   path to open and error (if any)
+Type:           string, string -> string, error
+*/
+func data_path(dir string, name string) (string, error) {
+	if filepath.IsAbs(name) {
+		return "", fmt.Errorf("%s: file names must be relative to the data directory (-d)", name)
+	}
+	rel := filepath.Clean(name)
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s: file names may not leave the data directory (-d)", name)
+	}
+	return filepath.Join(dir, rel), nil
+}
+
+/*
+Function Name:  check_poke_file
+Description:    checks the pokemon file is a whole number of records, an
+				empty one is only warned about, the server then replies
+				OUT_OF_BOUNDS to every pokemon lookup
+Parameters:     poke_file: the pokemon binary data file
+Return Value:   nil or error describing why the file can't be used
+Type:           *os.File -> error
+*/
+func check_poke_file(poke_file *os.File) error {
+	num_recs, err := recordlib.PokeCount(poke_file)
+	if errors.Is(err, recordlib.ErrFileSize) {
+		info, _ := poke_file.Stat()
+		return fmt.Errorf("%v (%d bytes, records are %d), it may be truncated or not a pokemon file", err, info.Size(), recordlib.PokeRecSize)
+	} else if err != nil {
+		return err
+	}
+	if num_recs == 0 {
+		fmt.Printf("Warning: pokemon file %s is empty, every pokemon lookup will reply OUT_OF_BOUNDS\n", poke_file.Name())
+	}
+	return nil
+}
+
+/*
+Function Name:  open_poke_file
+Description:    opens the pokemon file read/write (read only if that is all
+				it allows, pokemon patches then fail) and checks it with
+				check_poke_file, a missing file is reported with the -m and
+				-d flags that name it
+Parameters:     path: pokemon file, already resolved in the data directory
+				data_dir: data directory (-d), for the message
+Return Value:   the open pokemon file, or nil and an error to print before exiting
+Type:           string, string -> *os.File, error
+*/
+func open_poke_file(path string, data_dir string) (*os.File, error) {
+	poke_file, err := recordlib.OpenFile(path, os.O_RDWR, 0644)
+	if errors.Is(err, os.ErrPermission) {
+		poke_file, err = recordlib.OpenFile(path, os.O_RDONLY, 0644)
+		if err == nil {
+			fmt.Printf("Pokemon file %s is read only, pokemon patches will fail\n", path)
+		}
 	}
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("pokemon file %s not found\nPass the pokemon binary data file with -m (relative to -d %s), e.g. -m poke.bin", path, data_dir)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open pokemon file %s: %v", path, err)
+	}
+	if err := check_poke_file(poke_file); err != nil {
+		poke_file.Close()
+		return nil, fmt.Errorf("pokemon file %s: %v", path, err)
+	}
+	return poke_file, nil
+}
+
+/*
+Function Name:  prepare_trainer_file
+Description:    writes the header of a newly created (empty) trainer file,
+				otherwise checks the header matches recordlib.TrainerRecSize,
+				a headerless or other record size file is migrated if
+				migrate is set, else refused
+Parameters:     trainer_file: the trainer binary data file, locked
+				migrate: -migrate flag
+Return Value:   nil or error describing why the file can't be used
+Type:           *os.File, bool -> error
+*/
+func prepare_trainer_file(trainer_file *os.File, migrate bool) error {
+	trainer_size := int64(recordlib.TrainerRecSize)
+	info, err := trainer_file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return recordlib.WriteHeader(trainer_file, trainer_size)
+	}
+
+	err = recordlib.ValidateHeader(trainer_file, trainer_size)
+	if errors.Is(err, recordlib.ErrNoHeader) || errors.Is(err, recordlib.ErrRecordSize) {
+		if !migrate {
+			return fmt.Errorf("%v\nBack up %s and restart with -migrate to rewrite it in the current layout", err, trainer_file.Name())
+		}
+		num_recs, err := recordlib.MigrateTrainers(trainer_file)
+		if err != nil {
+			return fmt.Errorf("migration failed: %v (original kept in %s.bak)", err, trainer_file.Name())
+		}
+		fmt.Printf("Migrated %d trainer records to the current layout, original kept in %s.bak\n", num_recs, trainer_file.Name())
+		return nil
+	}
+	return err
+}
+
+/*
+Function Name:  open_wal
+Description:    replays a write-ahead log left by a crashed run, even when
+				-wal is now off, then keeps it open for this run if enabled
+				(an unused empty log is removed)
+Parameters:     trainer_file: the trainer binary data file, prepared
+				path: log file next to the trainer file
+				enabled: -wal flag
+Return Value:   the open log, nil when disabled, and error (if any)
+Type:           *os.File, string, bool -> *recordlib.WAL, error
+*/
+func open_wal(trainer_file *os.File, path string, enabled bool) (*recordlib.WAL, error) {
+	if _, err := os.Stat(path); !enabled && errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	wal, err := recordlib.NewWAL(path)
+	if err != nil {
+		return nil, err
+	}
+	replayed, err := recordlib.ReplayWAL(wal, trainer_file)
+	if err != nil {
+		wal.Close()
+		return nil, fmt.Errorf("replay failed after %d entries: %v", replayed, err)
+	}
+	if replayed > 0 {
+		fmt.Printf("Replayed %d trainer writes from %s\n", replayed, path)
+	}
+	if enabled {
+		fmt.Printf("Trainer puts and deletes logged to %s\n", path)
+		return wal, nil
+	}
+	wal.Close()
+	return nil, os.Remove(path)
+}
+
+/*
+Function Name:  parse_id
+Description:    parses a record ID captured by a request regexp, the regexps
+                only check for digits so huge values must not wrap into uint16
+Parameters:     digits: captured ID
+Return Value:   the ID and whether it is 1-65535
+Type:           string -> uint16, bool
+*/
+func parse_id(digits string) (uint16, bool) {
+	num, err := strconv.Atoi(digits)
+	if err != nil || num < 1 || num > 0xFFFF {
+		return 0, false
+	}
+	return uint16(num), true
+}
 
-	return *port_flag, *bin_file_flag, *trainer_file_flag, *log_file_flag, nil
+/*
+Function Name:  parse_slot
+Description:    parses a trainer slot captured by a request regexp, digits
+                too long for an int are out of range like any slot past 6
+Parameters:     digits: captured slot
+Return Value:   the slot and whether it is 1-6
+Type:           string -> int, bool
+*/
+func parse_slot(digits string) (int, bool) {
+	num, err := strconv.Atoi(digits)
+	if err != nil || num < 1 || num > 6 {
+		return 0, false
+	}
+	return num, true
+}
+
+/*
+Function Name:  parse_log_n
+Description:    parses the line count captured by a log tail request, the
+                regexps only check for digits so a huge value may not fit
+                an int, anything above recordlib.MaxLogN is refused
+Parameters:     digits: captured count
+Return Value:   the count and whether it is at most MaxLogN
+Type:           string -> int, bool
+*/
+func parse_log_n(digits string) (int, bool) {
+	num, err := strconv.Atoi(digits)
+	if err != nil || num > recordlib.MaxLogN {
+		return 0, false
+	}
+	return num, true
+}
+
+/*
+Function Name:  parse_poke_ids
+Description:    parses the pokemon ID captures of a post/put/append request,
+                stopping at the first empty (unmatched optional) capture
+Parameters:     captures: pokemon ID captures
+Return Value:   pokemon IDs and nil or recordlib.ErrPokeIDRange
+Type:           []string -> []uint16, error
+*/
+func parse_poke_ids(captures []string) ([]uint16, error) {
+	var pokemon []uint16
+	for _, digits := range captures {
+		if digits == "" {
+			break
+		}
+		id, ok := parse_id(digits)
+		if !ok {
+			return nil, recordlib.ErrPokeIDRange
+		}
+		pokemon = append(pokemon, id)
+	}
+	return pokemon, nil
+}
+
+/*
+Function Name:  read_pokemon
+Description:    looks up a pokemon in the cache, or reads the pokemon file
+                when the cache is disabled, caller holds poke_lock read lock
+Parameters:     poke_file: pokemon binary file
+                poke_cache: in-memory pokemon records, nil if disabled
+                id: the record id to look up
+Return Value:   the pokemon record and error (io.EOF out of range)
+Type:           *os.File, *recordlib.PokeCache, uint16 -> recordlib.PokeRec, error
+*/
+func read_pokemon(poke_file *os.File, poke_cache *recordlib.PokeCache, id uint16) (recordlib.PokeRec, error) {
+	if poke_cache != nil {
+		return poke_cache.Get(id)
+	}
+	return recordlib.GetPokemon(poke_file, id)
 }
 
 /*
 Function Name:  process_req_get_poke
 Description:    parses GET pokemon requests, reads pokemon record from
-				pokemon file under read lock, send JSON or status to client
+				pokemon file under read lock, send record (JSON or binary)
+				or status to client
 Parameters:     req: raw client request
                 client: client socket file for reply
                 src_port: client source port (for logging)
                 poke_file: pokemon binary file
-                poke_lock: RW lock protecting poke_file
+                poke_cache: in-memory pokemon records, nil to read poke_file
+                poke_lock: RW lock protecting poke_file and poke_cache
+                sess: client session (record response mode, request timing)
 Return Value:   n/a
-Type:           string, *os.File, int, *os.File, *sync.RWMutex -> n/a
+Type:           string, recordlib.Conn, int, *os.File, *recordlib.PokeCache, recordlib.RWLocker, *session -> n/a
 */
-func process_req_get_poke(req string, client *os.File, src_port int, poke_file *os.File, poke_lock *sync.RWMutex) {
+func process_req_get_poke(req string, client recordlib.Conn, src_port int, poke_file *os.File, poke_cache *recordlib.PokeCache, poke_lock recordlib.RWLocker, sess *session) {
 	captures := recordlib.ReqGetPokeID.FindStringSubmatch(req)
-	log.Printf("[127.0.0.1:%d] %s\n", src_port, req)
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
 	if len(captures) > 0 {
-		id, _ := strconv.Atoi(captures[1])
-		//can't trust that regexp is 100% perfect
-		//but assuming Atoi should not fail on matched regexp
+		id, ok := parse_id(captures[1])
+		if !ok {
+			fmt.Printf("[%d] Client requested id out of bounds\n", src_port)
+			sess.reply(client, "OUT_OF_BOUNDS")
+			return
+		}
+		sess.t.begin()
 		poke_lock.RLock()
-		rec, err := recordlib.GetPokemon(poke_file, uint16(id))
+		sess.t.end_lock()
+		sess.t.begin()
+		rec, err := read_pokemon(poke_file, poke_cache, id)
+		sess.t.end_io()
 		poke_lock.RUnlock()
 
 		if err != nil {
 			if err == io.EOF {
 				fmt.Printf("[%d] Client requested id out of bounds\n", src_port)
-				recordlib.ReallyWrite(client, "OUT_OF_BOUNDS")
+				sess.reply(client, "OUT_OF_BOUNDS")
 			} else {
 				fmt.Printf("[%d] Error in GetPokemon: %v\n", src_port, err)
-				recordlib.ReallyWrite(client, "SERVER_ERROR")
+				sess.reply(client, "SERVER_ERROR")
 			}
 		} else {
-			bytes, err := json.Marshal(rec)
+			msg, err := sess.encode_record(rec)
 			if err != nil {
-				fmt.Printf("[%d] Error on json encoding: %v\n", src_port, err)
-				recordlib.ReallyWrite(client, "SERVER_ERROR")
+				fmt.Printf("[%d] Error on record encoding: %v\n", src_port, err)
+				sess.reply(client, "SERVER_ERROR")
 			} else {
-				recordlib.ReallyWrite(client, string(bytes))
+				sess.reply(client, msg)
 				fmt.Printf("[%d] Pokemon record sent to client\n", src_port)
 			}
 		}
@@ -102,297 +549,3411 @@ func process_req_get_poke(req string, client *os.File, src_port int, poke_file *
 }
 
 /*
-Function Name:  process_req_get_trainer
-Description:    parses GET trainer requests, reads trainer record using
-                global manager record-level locks, sends JSON or status
+Function Name:  process_req_get_poke_multi
+Description:    parses a multiple pokemon ID request, reads every record
+				under one read lock and replies with a JSON array in request
+				order, an ID that is out of bounds is a null entry so the
+				client can tell which ones failed, JSON even in binary mode,
+				at most max_multi_pokemon IDs (BAD_COUNT otherwise)
 Parameters:     req: raw client request
                 client: client socket file for reply
                 src_port: client source port (for logging)
-                trainer_file: trainer binary file
-                gm: record-level lock manager
+                poke_file: pokemon binary file
+                poke_cache: in-memory pokemon records, nil to read poke_file
+                poke_lock: RW lock protecting poke_file and poke_cache
+                sess: client session (request timing)
 Return Value:   n/a
-Type:           string, *os.File, int, *os.File, *recordlib.GlobalManager -> n/a
+Type:           string, recordlib.Conn, int, *os.File, *recordlib.PokeCache, recordlib.RWLocker, *session -> n/a
 */
-func process_req_get_trainer(req string, client *os.File, src_port int, trainer_file *os.File, gm *recordlib.GlobalManager) {
-	captures := recordlib.ReqGetTrainerID.FindStringSubmatch(req)
-	log.Printf("[127.0.0.1:%d] %s\n", src_port, req)
-	if len(captures) > 0 {
-		id, _ := strconv.Atoi(captures[1])
-		gm.RLockRecord(uint16(id))
-		rec, err := recordlib.GetTrainer(trainer_file, uint16(id))
-		gm.RUnlockRecord(uint16(id))
+func process_req_get_poke_multi(req string, client recordlib.Conn, src_port int, poke_file *os.File, poke_cache *recordlib.PokeCache, poke_lock recordlib.RWLocker, sess *session) {
+	captures := recordlib.ReqPokeMulti.FindStringSubmatch(req)
+	if len(captures) == 0 {
+		return //handle_client sends SERVER_ERROR
+	}
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	fields := strings.FieldsFunc(captures[1], func(r rune) bool { return r == ',' })
+	if len(fields) > max_multi_pokemon {
+		fmt.Printf("[%d] Refuse multiple pokemon: at most %d IDs\n", src_port, max_multi_pokemon)
+		sess.reply(client, "BAD_COUNT")
+		return
+	}
+
+	recs := make([]*recordlib.PokeRec, len(fields)) //nil marshals as null
+	sess.t.begin()
+	poke_lock.RLock()
+	sess.t.end_lock()
+	sess.t.begin()
+	var err error
+	for idx, field := range fields {
+		id, ok := parse_id(field)
+		if !ok {
+			continue
+		}
+		var rec recordlib.PokeRec
+		rec, err = read_pokemon(poke_file, poke_cache, id)
+		if err == io.EOF {
+			err = nil
+			continue
+		} else if err != nil {
+			break
+		}
+		recs[idx] = &rec
+	}
+	sess.t.end_io()
+	poke_lock.RUnlock()
+
+	if err != nil {
+		fmt.Printf("[%d] Error in GetPokemon: %v\n", src_port, err)
+		sess.reply(client, "SERVER_ERROR")
+		return
+	}
+	msg, err := json.Marshal(recs)
+	if err != nil {
+		fmt.Printf("[%d] Error on record encoding: %v\n", src_port, err)
+		sess.reply(client, "SERVER_ERROR")
+		return
+	}
+	sess.reply(client, string(msg))
+	fmt.Printf("[%d] %d pokemon records sent to client\n", src_port, len(recs))
+}
 
+/*
+Function Name:  stream_pokemon
+Description:    streams pokemon records (JSON or binary) framed by SENDING
+                and DONE, or OUT_OF_BOUNDS if there are none
+Parameters:     client: client socket file for reply
+                src_port: client source port (for logging)
+                sess: client session (record response mode, request timing)
+                recs: records to send, in order
+Return Value:   n/a
+Type:           recordlib.Conn, int, *session, []recordlib.PokeRec -> n/a
+*/
+func stream_pokemon(client recordlib.Conn, src_port int, sess *session, recs []recordlib.PokeRec) {
+	if len(recs) == 0 {
+		fmt.Printf("[%d] No pokemon matched\n", src_port)
+		sess.reply(client, "OUT_OF_BOUNDS")
+		return
+	}
+	recordlib.ReallyWrite(client, "SENDING")
+	for _, rec := range recs {
+		msg, err := sess.encode_record(rec)
 		if err != nil {
-			if err == io.EOF || err.Error() == "trainer ID not found" {
-				fmt.Printf("[%d] Client requested id out of bounds\n", src_port)
-				recordlib.ReallyWrite(client, "OUT_OF_BOUNDS")
-			} else {
-				fmt.Printf("[%d] Error in GetTrainer: %v\n", src_port, err)
-				recordlib.ReallyWrite(client, "SERVER_ERROR")
-			}
-		} else {
-			bytes, err := json.Marshal(rec)
-			if err != nil {
-				fmt.Printf("[%d] Error on json encoding: %v\n", src_port, err)
-				recordlib.ReallyWrite(client, "SERVER_ERROR")
-			} else {
-				recordlib.ReallyWrite(client, string(bytes))
-				fmt.Printf("[%d] Trainer record sent to client\n", src_port)
-			}
+			fmt.Printf("[%d] Error in record encoding: %v\n", src_port, err)
+			sess.reply(client, "SERVER_ERROR")
+			return
 		}
+		recordlib.ReallyWrite(client, msg)
 	}
+	sess.reply(client, "DONE")
+	fmt.Printf("[%d] %d Pokemon records sent to client\n", src_port, len(recs))
 }
 
 /*
-Function Name:  process_req_get_trainer_all
-Description:    handle request to stream all trainer records, acquires
-                read-all lock from global manager, validates file size and
-                iterates records, sending JSON lines or status
+Function Name:  process_req_top_poke
+Description:    parses a top pokemon request, scans the pokemon file under
+                read lock for the n highest stat totals and streams them
+                strongest first, n must be 1-max_top_pokemon
 Parameters:     req: raw client request
                 client: client socket file for reply
                 src_port: client source port (for logging)
-                trainer_file: trainer binary file
-                gm: record-level lock manager
+                poke_file: pokemon binary file
+                poke_lock: RW lock protecting poke_file
+                sess: client session (record response mode, request timing)
 Return Value:   n/a
-Type:           string, *os.File, int, *os.File, *recordlib.GlobalManager -> n/a
+Type:           string, recordlib.Conn, int, *os.File, recordlib.RWLocker, *session -> n/a
 */
-func process_req_get_trainer_all(req string, client *os.File, src_port int, trainer_file *os.File, gm *recordlib.GlobalManager) {
-	log.Printf("[127.0.0.1:%d] %s\n", src_port, req)
-	gm.LockReadAll()
-	trainer_size := int64(unsafe.Sizeof(recordlib.TrainerRec{}))
-	info, err := trainer_file.Stat()
+func process_req_top_poke(req string, client recordlib.Conn, src_port int, poke_file *os.File, poke_lock recordlib.RWLocker, sess *session) {
+	captures := recordlib.ReqTopPoke.FindStringSubmatch(req)
+	if len(captures) == 0 {
+		return //handle_client sends SERVER_ERROR
+	}
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	n, err := strconv.Atoi(captures[1])
+	if err != nil || n < 1 || n > max_top_pokemon {
+		fmt.Printf("[%d] Refuse top pokemon: count must be 1-%d\n", src_port, max_top_pokemon)
+		sess.reply(client, "BAD_COUNT")
+		return
+	}
+
+	sess.t.begin()
+	poke_lock.RLock()
+	sess.t.end_lock()
+	sess.t.begin()
+	recs, err := recordlib.TopPokemonByTotal(poke_file, n)
+	sess.t.end_io()
+	poke_lock.RUnlock()
 	if err != nil {
-		fmt.Printf("[%d] Error in file.Stat: %v\n", src_port, err)
-		recordlib.ReallyWrite(client, "SERVER_ERROR")
-		gm.UnlockReadAll()
+		fmt.Printf("[%d] Error in TopPokemonByTotal: %v\n", src_port, err)
+		sess.reply(client, "FILE_ERROR")
+		return
+	}
+	stream_pokemon(client, src_port, sess, recs)
+}
+
+/*
+Function Name:  process_req_poke_filter
+Description:    parses a pokemon filter request (generation, legendary only),
+                scans the pokemon file under read lock and streams matching
+                records in ID order, OUT_OF_BOUNDS if none match
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                poke_file: pokemon binary file
+                poke_lock: RW lock protecting poke_file
+                sess: client session (record response mode, request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, *os.File, recordlib.RWLocker, *session -> n/a
+*/
+func process_req_poke_filter(req string, client recordlib.Conn, src_port int, poke_file *os.File, poke_lock recordlib.RWLocker, sess *session) {
+	captures := recordlib.ReqGetPokeFilter.FindStringSubmatch(req)
+	if len(captures) == 0 {
+		return //handle_client sends SERVER_ERROR
+	}
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	gen, err := strconv.Atoi(captures[1])
+	if err != nil || gen > 0xFF {
+		sess.reply(client, "CLIENT_REQ_INVALID")
+		return
+	}
+
+	sess.t.begin()
+	poke_lock.RLock()
+	sess.t.end_lock()
+	sess.t.begin()
+	recs, err := recordlib.FilterPokemon(poke_file, gen, captures[2] == "1")
+	sess.t.end_io()
+	poke_lock.RUnlock()
+	if err != nil {
+		fmt.Printf("[%d] Error in FilterPokemon: %v\n", src_port, err)
+		sess.reply(client, "FILE_ERROR")
+		return
+	}
+	stream_pokemon(client, src_port, sess, recs)
+}
+
+/*
+Function Name:  process_req_poke_similar
+Description:    parses a similar pokemon request, scans the pokemon file under
+                read lock for pokemon sharing the reference's body style or
+                an egg group and streams them in ID order, BAD_POKE_ID if the
+                reference isn't in the file, OUT_OF_BOUNDS if nothing matches
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                poke_file: pokemon binary file
+                poke_lock: RW lock protecting poke_file
+                sess: client session (record response mode, request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, *os.File, recordlib.RWLocker, *session -> n/a
+*/
+func process_req_poke_similar(req string, client recordlib.Conn, src_port int, poke_file *os.File, poke_lock recordlib.RWLocker, sess *session) {
+	captures := recordlib.ReqPokeSimilar.FindStringSubmatch(req)
+	if len(captures) == 0 {
+		return //handle_client sends SERVER_ERROR
+	}
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	id, ok := parse_id(captures[1])
+	if !ok {
+		fmt.Printf("[%d] Client requested id out of bounds\n", src_port)
+		sess.reply(client, "BAD_POKE_ID")
+		return
+	}
+
+	sess.t.begin()
+	poke_lock.RLock()
+	sess.t.end_lock()
+	sess.t.begin()
+	recs, err := recordlib.SimilarPokemon(poke_file, id)
+	sess.t.end_io()
+	poke_lock.RUnlock()
+	if err == io.EOF {
+		fmt.Printf("[%d] Client requested id out of bounds\n", src_port)
+		sess.reply(client, "BAD_POKE_ID")
+		return
+	}
+	if err != nil {
+		fmt.Printf("[%d] Error in SimilarPokemon: %v\n", src_port, err)
+		sess.reply(client, "FILE_ERROR")
+		return
+	}
+	stream_pokemon(client, src_port, sess, recs)
+}
+
+/*
+Function Name:  process_req_poke_agg
+Description:    scans the pokemon file once under read lock for the totals
+                in recordlib.PokeAggregate and replies with them as JSON
+                (JSON even in binary mode), OUT_OF_BOUNDS for an empty file
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                poke_file: pokemon binary file
+                poke_lock: RW lock protecting poke_file
+                sess: client session (request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, *os.File, recordlib.RWLocker, *session -> n/a
+*/
+func process_req_poke_agg(req string, client recordlib.Conn, src_port int, poke_file *os.File, poke_lock recordlib.RWLocker, sess *session) {
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	sess.t.begin()
+	poke_lock.RLock()
+	sess.t.end_lock()
+	sess.t.begin()
+	agg, err := recordlib.PokemonAggregate(poke_file)
+	sess.t.end_io()
+	poke_lock.RUnlock()
+	if err != nil {
+		fmt.Printf("[%d] Error in PokemonAggregate: %v\n", src_port, err)
+		sess.reply(client, "FILE_ERROR")
 		return
 	}
-	file_size := info.Size()
-	if file_size == 0 {
+	if agg.Count == 0 {
 		fmt.Printf("[%d] Client requested from empty file\n", src_port)
-		recordlib.ReallyWrite(client, "OUT_OF_BOUNDS")
-		gm.UnlockReadAll()
+		sess.reply(client, "OUT_OF_BOUNDS")
+		return
+	}
+	bytes, err := json.Marshal(agg)
+	if err != nil {
+		fmt.Printf("[%d] Error encoding aggregate: %v\n", src_port, err)
+		sess.reply(client, "SERVER_ERROR")
+		return
+	}
+	sess.reply(client, string(bytes))
+	fmt.Printf("[%d] Pokemon aggregate over %d records sent to client\n", src_port, agg.Count)
+}
+
+/*
+Function Name:  process_req_poke_types
+Description:    scans the pokemon file once under read lock for the distinct
+                types and replies with them as a JSON array (JSON even in
+                binary mode), OUT_OF_BOUNDS for an empty file
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                poke_file: pokemon binary file
+                poke_lock: RW lock protecting poke_file
+                sess: client session (request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, *os.File, recordlib.RWLocker, *session -> n/a
+*/
+func process_req_poke_types(req string, client recordlib.Conn, src_port int, poke_file *os.File, poke_lock recordlib.RWLocker, sess *session) {
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	sess.t.begin()
+	poke_lock.RLock()
+	sess.t.end_lock()
+	sess.t.begin()
+	types, err := recordlib.DistinctPokemonTypes(poke_file)
+	sess.t.end_io()
+	poke_lock.RUnlock()
+	if err != nil {
+		fmt.Printf("[%d] Error in DistinctPokemonTypes: %v\n", src_port, err)
+		sess.reply(client, "FILE_ERROR")
+		return
+	}
+	if len(types) == 0 {
+		fmt.Printf("[%d] Client requested from empty file\n", src_port)
+		sess.reply(client, "OUT_OF_BOUNDS")
+		return
+	}
+	bytes, err := json.Marshal(types)
+	if err != nil {
+		fmt.Printf("[%d] Error encoding types: %v\n", src_port, err)
+		sess.reply(client, "SERVER_ERROR")
+		return
+	}
+	sess.reply(client, string(bytes))
+	fmt.Printf("[%d] %d pokemon types sent to client\n", src_port, len(types))
+}
+
+/*
+Function Name:  process_req_get_poke_name
+Description:    parses GET pokemon name requests, reads only the name field
+				from pokemon file under read lock, send JSON or status to client
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                poke_file: pokemon binary file
+                poke_lock: RW lock protecting poke_file
+                sess: client session (request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, *os.File, recordlib.RWLocker, *session -> n/a
+*/
+func process_req_get_poke_name(req string, client recordlib.Conn, src_port int, poke_file *os.File, poke_lock recordlib.RWLocker, sess *session) {
+	captures := recordlib.ReqGetPokeName.FindStringSubmatch(req)
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	if len(captures) > 0 {
+		id, ok := parse_id(captures[1])
+		if !ok {
+			fmt.Printf("[%d] Client requested id out of bounds\n", src_port)
+			sess.reply(client, "OUT_OF_BOUNDS")
+			return
+		}
+		sess.t.begin()
+		poke_lock.RLock()
+		sess.t.end_lock()
+		sess.t.begin()
+		name, err := recordlib.GetPokeName(poke_file, id)
+		sess.t.end_io()
+		poke_lock.RUnlock()
+
+		if err != nil {
+			if err == io.EOF {
+				fmt.Printf("[%d] Client requested id out of bounds\n", src_port)
+				sess.reply(client, "OUT_OF_BOUNDS")
+			} else {
+				fmt.Printf("[%d] Error in GetPokeName: %v\n", src_port, err)
+				sess.reply(client, "SERVER_ERROR")
+			}
+		} else {
+			bytes, err := json.Marshal(recordlib.PokeName{ID: id, Name: recordlib.CString(name[:])})
+			if err != nil {
+				fmt.Printf("[%d] Error on json encoding: %v\n", src_port, err)
+				sess.reply(client, "SERVER_ERROR")
+			} else {
+				sess.reply(client, string(bytes))
+				fmt.Printf("[%d] Pokemon name sent to client\n", src_port)
+			}
+		}
+	}
+}
+
+/*
+Function Name:  process_req_get_poke_raw
+Description:    parses GET pokemon raw requests, reads the record bytes straight
+				from the pokemon file (never the cache) under read lock, replies
+				with them hex encoded (2*PokeRecSize chars) or status
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                poke_file: pokemon binary file
+                poke_lock: RW lock protecting poke_file
+                sess: client session (request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, *os.File, recordlib.RWLocker, *session -> n/a
+*/
+func process_req_get_poke_raw(req string, client recordlib.Conn, src_port int, poke_file *os.File, poke_lock recordlib.RWLocker, sess *session) {
+	captures := recordlib.ReqPokeRaw.FindStringSubmatch(req)
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	if len(captures) > 0 {
+		id, ok := parse_id(captures[1])
+		if !ok {
+			fmt.Printf("[%d] Client requested id out of bounds\n", src_port)
+			sess.reply(client, "OUT_OF_BOUNDS")
+			return
+		}
+		sess.t.begin()
+		poke_lock.RLock()
+		sess.t.end_lock()
+		sess.t.begin()
+		raw, err := recordlib.GetPokeRaw(poke_file, id)
+		sess.t.end_io()
+		poke_lock.RUnlock()
+
+		if err == io.EOF {
+			fmt.Printf("[%d] Client requested id out of bounds\n", src_port)
+			sess.reply(client, "OUT_OF_BOUNDS")
+		} else if err != nil {
+			fmt.Printf("[%d] Error in GetPokeRaw: %v\n", src_port, err)
+			sess.reply(client, "SERVER_ERROR")
+		} else {
+			sess.reply(client, hex.EncodeToString(raw))
+			fmt.Printf("[%d] Raw pokemon record sent to client\n", src_port)
+		}
+	}
+}
+
+/*
+Function Name:  process_req_poke_name_list
+Description:    sends every pokemon id/name pair from the in-memory name
+				index to client as a single message of JSON lines
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                name_index: pokemon name index
+                sess: client session (request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, *recordlib.PokeNameIndex, *session -> n/a
+*/
+func process_req_poke_name_list(req string, client recordlib.Conn, src_port int, name_index *recordlib.PokeNameIndex, sess *session) {
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	names := name_index.Names()
+	if len(names) == 0 {
+		sess.reply(client, "OUT_OF_BOUNDS")
+		return
+	}
+
+	var lines strings.Builder
+	for _, name := range names {
+		bytes, err := json.Marshal(name)
+		if err != nil {
+			fmt.Printf("[%d] Error on json encoding: %v\n", src_port, err)
+			sess.reply(client, "SERVER_ERROR")
+			return
+		}
+		lines.Write(bytes)
+		lines.WriteByte('\n')
+	}
+	sess.reply(client, lines.String())
+	fmt.Printf("[%d] Pokemon name list sent to client\n", src_port)
+}
+
+/*
+Function Name:  process_req_get_trainer
+Description:    parses GET trainer requests, reads trainer record using
+                global manager record-level locks, sends record (JSON or
+                binary) or status
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                store: trainer record store
+                gm: record-level lock manager
+                sess: client session (record response mode, request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, recordlib.TrainerStore, *recordlib.GlobalManager, *session -> n/a
+*/
+func process_req_get_trainer(req string, client recordlib.Conn, src_port int, store recordlib.TrainerStore, gm *recordlib.GlobalManager, sess *session) {
+	captures := recordlib.ReqGetTrainerID.FindStringSubmatch(req)
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	if len(captures) > 0 {
+		id, ok := parse_id(captures[1])
+		if !ok {
+			fmt.Printf("[%d] Client requested id out of bounds\n", src_port)
+			sess.reply(client, "OUT_OF_BOUNDS")
+			return
+		}
+		sess.t.begin()
+		gm.RLockRecord(id)
+		sess.t.end_lock()
+		sess.t.begin()
+		rec, err := store.Get(id)
+		sess.t.end_io()
+		gm.RUnlockRecord(id)
+
+		if err != nil {
+			if err == io.EOF || err == recordlib.ErrTrainerNotFound {
+				fmt.Printf("[%d] Client requested id out of bounds\n", src_port)
+				sess.reply(client, "OUT_OF_BOUNDS")
+			} else {
+				fmt.Printf("[%d] Error in GetTrainer: %v\n", src_port, err)
+				sess.reply(client, "SERVER_ERROR")
+			}
+		} else {
+			msg, err := sess.encode_record(rec)
+			if err != nil {
+				fmt.Printf("[%d] Error on record encoding: %v\n", src_port, err)
+				sess.reply(client, "SERVER_ERROR")
+			} else {
+				sess.reply(client, msg)
+				fmt.Printf("[%d] Trainer record sent to client\n", src_port)
+			}
+		}
+	}
+}
+
+/*
+Function Name:  process_req_random_poke
+Description:    picks a uniformly random pokemon ID from the record count
+				(cache length, or pokemon file size) under the pokemon read
+				lock and sends that record (JSON or binary), OUT_OF_BOUNDS
+				for an empty pokemon file
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                poke_file: pokemon binary file
+                poke_cache: in-memory pokemon records, nil to read poke_file
+                poke_lock: RW lock protecting poke_file and poke_cache
+                sess: client session (record response mode, request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, *os.File, *recordlib.PokeCache, recordlib.RWLocker, *session -> n/a
+*/
+func process_req_random_poke(req string, client recordlib.Conn, src_port int, poke_file *os.File, poke_cache *recordlib.PokeCache, poke_lock recordlib.RWLocker, sess *session) {
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	sess.t.begin()
+	poke_lock.RLock()
+	sess.t.end_lock()
+	sess.t.begin()
+	var rec recordlib.PokeRec
+	var num_recs int
+	var err error
+	if poke_cache != nil {
+		num_recs = poke_cache.Len()
+	} else {
+		num_recs, err = recordlib.PokeCount(poke_file)
+	}
+	if err == nil && num_recs > 0 {
+		rec, err = read_pokemon(poke_file, poke_cache, uint16(1+rand.Intn(min(num_recs, 0xFFFF))))
+	}
+	sess.t.end_io()
+	poke_lock.RUnlock()
+
+	switch {
+	case err != nil:
+		fmt.Printf("[%d] Error in GetPokemon: %v\n", src_port, err)
+		sess.reply(client, "SERVER_ERROR")
+	case num_recs == 0:
+		fmt.Printf("[%d] Client requested from empty file\n", src_port)
+		sess.reply(client, "OUT_OF_BOUNDS")
+	default:
+		msg, err := sess.encode_record(rec)
+		if err != nil {
+			fmt.Printf("[%d] Error on record encoding: %v\n", src_port, err)
+			sess.reply(client, "SERVER_ERROR")
+			return
+		}
+		sess.reply(client, msg)
+		fmt.Printf("[%d] Random pokemon %d sent to client\n", src_port, rec.ID)
+	}
+}
+
+/*
+Function Name:  random_trainer
+Description:    picks a uniformly random live trainer, random IDs up to the
+				store's Count are tried max_random_tries times, deleted
+				slots are skipped, then it falls back to choosing among the
+				IDs a full scan finds so a mostly deleted file still ends,
+				the caller holds the read-all lock so nothing changes between
+				count and read
+Parameters:     store: trainer record store
+Return Value:   the trainer and nil, ErrTrainerNotFound if no trainer is
+				live, or count/read error
+Type:           recordlib.TrainerStore -> recordlib.TrainerRec, error
+*/
+func random_trainer(store recordlib.TrainerStore) (recordlib.TrainerRec, error) {
+	num_recs, err := store.Count()
+	if err != nil {
+		return recordlib.TrainerRec{}, err
+	}
+	num_recs = min(num_recs, 0xFFFF)
+	for try := 0; try < max_random_tries && num_recs > 0; try++ {
+		trainer, err := store.Get(uint16(1 + rand.Intn(num_recs)))
+		if err != recordlib.ErrTrainerNotFound {
+			return trainer, err
+		}
+	}
+
+	var live []recordlib.TrainerRec
+	if err := store.All(func(trainer recordlib.TrainerRec) error {
+		live = append(live, trainer)
+		return nil
+	}); err != nil {
+		return recordlib.TrainerRec{}, err
+	}
+	if len(live) == 0 {
+		return recordlib.TrainerRec{}, recordlib.ErrTrainerNotFound
+	}
+	return live[rand.Intn(len(live))], nil
+}
+
+/*
+Function Name:  process_req_random_trainer
+Description:    sends a random live trainer (random_trainer) as JSON or
+				binary, under the read-all lock, OUT_OF_BOUNDS when the
+				file is empty or every trainer is deleted
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                store: trainer record store
+                gm: record-level lock manager
+                sess: client session (record response mode, request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, recordlib.TrainerStore, *recordlib.GlobalManager, *session -> n/a
+*/
+func process_req_random_trainer(req string, client recordlib.Conn, src_port int, store recordlib.TrainerStore, gm *recordlib.GlobalManager, sess *session) {
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	sess.t.begin()
+	gm.LockReadAll()
+	sess.t.end_lock()
+	sess.t.begin()
+	rec, err := random_trainer(store)
+	sess.t.end_io()
+	gm.UnlockReadAll()
+
+	switch {
+	case err == recordlib.ErrTrainerNotFound:
+		fmt.Printf("[%d] Client requested from empty file\n", src_port)
+		sess.reply(client, "OUT_OF_BOUNDS")
+	case err == recordlib.ErrFileSize:
+		fmt.Printf("[%d] Error: file size is not a multiple of record size\n", src_port)
+		sess.reply(client, "FILE_ERROR")
+	case err != nil:
+		fmt.Printf("[%d] Error in GetTrainer: %v\n", src_port, err)
+		sess.reply(client, "SERVER_ERROR")
+	default:
+		msg, err := sess.encode_record(rec)
+		if err != nil {
+			fmt.Printf("[%d] Error on record encoding: %v\n", src_port, err)
+			sess.reply(client, "SERVER_ERROR")
+			return
+		}
+		sess.reply(client, msg)
+		fmt.Printf("[%d] Random trainer %d sent to client\n", src_port, rec.ID)
+	}
+}
+
+/*
+Function Name:  process_req_trainer_with_poke
+Description:    parses a trainers using pokemon request, checks the pokemon
+                exists under the pokemon read lock, then looks up the live
+                trainers holding it in the reverse index, or scans for them
+                with -no-trainer-index, under the read-all lock (the locks
+                are not nested) and replies with a JSON recordlib.TrainersUsing,
+                JSON even in binary mode, BAD_POKE_ID for an unknown pokemon
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                store: trainer record store
+                trainer_index: pokemon -> trainers index, nil to scan store
+                gm: record-level lock manager
+                poke_file: pokemon binary file
+                poke_cache: in-memory pokemon records, nil to read poke_file
+                poke_lock: RW lock protecting poke_file and poke_cache
+                sess: client session (request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, recordlib.TrainerStore, *recordlib.TrainerPokeIndex, *recordlib.GlobalManager, *os.File, *recordlib.PokeCache, recordlib.RWLocker, *session -> n/a
+*/
+func process_req_trainer_with_poke(req string, client recordlib.Conn, src_port int, store recordlib.TrainerStore, trainer_index *recordlib.TrainerPokeIndex, gm *recordlib.GlobalManager, poke_file *os.File, poke_cache *recordlib.PokeCache, poke_lock recordlib.RWLocker, sess *session) {
+	captures := recordlib.ReqTrainerWithPoke.FindStringSubmatch(req)
+	if len(captures) == 0 {
+		return //handle_client sends SERVER_ERROR
+	}
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	poke_id, ok := parse_id(captures[1])
+	if !ok {
+		sess.reply(client, "BAD_POKE_ID")
+		return
+	}
+
+	sess.t.begin()
+	poke_lock.RLock()
+	sess.t.end_lock()
+	sess.t.begin()
+	_, err := read_pokemon(poke_file, poke_cache, poke_id)
+	sess.t.end_io()
+	poke_lock.RUnlock()
+	if err == io.EOF {
+		fmt.Printf("[%d] Client asked for trainers using unknown pokemon %d\n", src_port, poke_id)
+		sess.reply(client, "BAD_POKE_ID")
+		return
+	} else if err != nil {
+		fmt.Printf("[%d] Error in GetPokemon: %v\n", src_port, err)
+		sess.reply(client, "SERVER_ERROR")
+		return
+	}
+
+	sess.t.begin()
+	gm.LockReadAll()
+	sess.t.end_lock()
+	sess.t.begin()
+	var count int
+	var ids []uint16
+	if trainer_index != nil {
+		ids = trainer_index.LookupTrainersByPoke(poke_id)
+		count = len(ids)
+	} else {
+		count, ids, err = recordlib.CountTrainersWithPoke(store, poke_id)
+	}
+	sess.t.end_io()
+	gm.UnlockReadAll()
+	if err != nil {
+		fmt.Printf("[%d] Error in CountTrainersWithPoke: %v\n", src_port, err)
+		sess.reply(client, "FILE_ERROR")
+		return
+	}
+
+	bytes, err := json.Marshal(recordlib.TrainersUsing{PokeID: poke_id, Count: count, Trainers: ids})
+	if err != nil {
+		fmt.Printf("[%d] Error encoding trainer IDs: %v\n", src_port, err)
+		sess.reply(client, "SERVER_ERROR")
+		return
+	}
+	sess.reply(client, string(bytes))
+	fmt.Printf("[%d] %d trainers using pokemon %d sent to client\n", src_port, count, poke_id)
+}
+
+/*
+Function Name:  process_req_trainer_expand
+Description:    parses a trainer expand request, reads the trainer under its
+                record read lock, then the full record of each pokemon it
+                holds under the pokemon read lock (the locks are not nested),
+                replies with a JSON recordlib.TrainerExpanded, a slot whose
+                pokemon is missing from the pokemon file is null
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                store: trainer record store
+                gm: record-level lock manager
+                poke_file: pokemon binary file
+                poke_cache: in-memory pokemon records, nil to read poke_file
+                poke_lock: RW lock protecting poke_file and poke_cache
+                sess: client session (request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, recordlib.TrainerStore, *recordlib.GlobalManager, *os.File, *recordlib.PokeCache, recordlib.RWLocker, *session -> n/a
+*/
+func process_req_trainer_expand(req string, client recordlib.Conn, src_port int, store recordlib.TrainerStore, gm *recordlib.GlobalManager, poke_file *os.File, poke_cache *recordlib.PokeCache, poke_lock recordlib.RWLocker, sess *session) {
+	captures := recordlib.ReqTrainerExpand.FindStringSubmatch(req)
+	if len(captures) == 0 {
+		return //handle_client sends SERVER_ERROR
+	}
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	id, ok := parse_id(captures[1])
+	if !ok {
+		fmt.Printf("[%d] Client requested id out of bounds\n", src_port)
+		sess.reply(client, "OUT_OF_BOUNDS")
+		return
+	}
+	sess.t.begin()
+	gm.RLockRecord(id)
+	sess.t.end_lock()
+	sess.t.begin()
+	trainer, err := store.Get(id)
+	sess.t.end_io()
+	gm.RUnlockRecord(id)
+	if err == io.EOF || err == recordlib.ErrTrainerNotFound {
+		fmt.Printf("[%d] Client requested id out of bounds\n", src_port)
+		sess.reply(client, "OUT_OF_BOUNDS")
+		return
+	} else if err != nil {
+		fmt.Printf("[%d] Error in GetTrainer: %v\n", src_port, err)
+		sess.reply(client, "SERVER_ERROR")
+		return
+	}
+
+	expanded := recordlib.TrainerExpanded{Trainer: trainer}
+	sess.t.begin()
+	poke_lock.RLock()
+	sess.t.end_lock()
+	sess.t.begin()
+	for slot, poke := range []recordlib.PokeDisplay{trainer.Poke1, trainer.Poke2, trainer.Poke3, trainer.Poke4, trainer.Poke5, trainer.Poke6} {
+		if poke.ID == 0 {
+			continue
+		}
+		var rec recordlib.PokeRec
+		rec, err = read_pokemon(poke_file, poke_cache, poke.ID)
+		if err == io.EOF {
+			fmt.Printf("[%d] Trainer %d slot %d: pokemon %d not in pokemon file\n", src_port, id, slot+1, poke.ID)
+			err = nil
+			continue
+		} else if err != nil {
+			break
+		}
+		expanded.Pokemon[slot] = &rec
+	}
+	sess.t.end_io()
+	poke_lock.RUnlock()
+	if err != nil {
+		fmt.Printf("[%d] Error in GetPokemon: %v\n", src_port, err)
+		sess.reply(client, "SERVER_ERROR")
+		return
+	}
+
+	msg, err := json.Marshal(expanded)
+	if err != nil {
+		fmt.Printf("[%d] Error on record encoding: %v\n", src_port, err)
+		sess.reply(client, "SERVER_ERROR")
+		return
+	}
+	sess.reply(client, string(msg))
+	fmt.Printf("[%d] Trainer record with pokemon sent to client\n", src_port)
+}
+
+/*
+Function Name:  snapshot_trainers
+Description:    takes the record count under the read-all lock, releases it,
+                then copies records 1 to count out of the store one at a time,
+                each under its own record read lock, nothing is sent to the
+                client until the copy is done, so writers only ever wait for
+                one record read, never for the whole scan or a slow client,
+                consistency is per record: every record is seen whole (no
+                half-applied write), records written while the copy runs may
+                be seen before or after the write, records posted after the
+                count are not included, at most 65535 records (every ID),
+                fewer with limit, a copy past the -scan-timeout stops
+Parameters:     store: trainer record store
+                gm: record-level lock manager
+                sess: client session (request timing)
+                limit: most records to copy (ErrTooManySorted past it), 0 for no limit
+Return Value:   records in ascending ID order and nil, context.DeadlineExceeded,
+                ErrTooManySorted or read error
+Type:           recordlib.TrainerStore, *recordlib.GlobalManager, *session, int -> []recordlib.TrainerRec, error
+*/
+func snapshot_trainers(store recordlib.TrainerStore, gm *recordlib.GlobalManager, sess *session, limit int) ([]recordlib.TrainerRec, error) {
+	var recs []recordlib.TrainerRec
+	ctx, cancel := sess.scan_context()
+	defer cancel()
+
+	sess.t.begin()
+	gm.LockReadAll() //no post half done, so every ID up to count has a record
+	sess.t.end_lock()
+	sess.t.begin()
+	count, err := store.Count()
+	sess.t.end_io()
+	gm.UnlockReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	for id := 1; id <= count; id++ {
+		if err := ctx.Err(); err != nil {
+			return recs, err
+		}
+		sess.t.begin()
+		gm.RLockRecord(uint16(id))
+		sess.t.end_lock()
+		sess.t.begin()
+		trainer, err := store.Get(uint16(id))
+		sess.t.end_io()
+		gm.RUnlockRecord(uint16(id))
+		switch {
+		case err == recordlib.ErrTrainerNotFound:
+			continue //deleted slot
+		case err == io.EOF:
+			return recs, nil //file shrank under the count
+		case err != nil:
+			return recs, err
+		}
+		if limit > 0 && len(recs) == limit {
+			return recs, ErrTooManySorted
+		}
+		recs = append(recs, trainer)
+	}
+	return recs, nil
+}
+
+/*
+Function Name:  stream_trainers
+Description:    takes a snapshot of every live record in the store (see
+                snapshot_trainers) and streams SENDING, the optional header
+                line and one encoded line per record, then "DONE <count>"
+                (records, not the header) or status,
+                records are always streamed in strictly ascending ID order,
+                after COMPRESS gzip the header and records go in one gzip
+                frame, a snapshot past the -scan-timeout replies TIMEOUT
+Parameters:     client: client socket file for reply
+                src_port: client source port (for logging)
+                store: trainer record store
+                gm: record-level lock manager
+                sess: client session (request timing)
+                header: line sent right after SENDING, "" for none
+                encode: formats one record for the wire
+Return Value:   n/a
+Type:           recordlib.Conn, int, recordlib.TrainerStore, *recordlib.GlobalManager, *session, string, func(recordlib.TrainerRec) (string, error) -> n/a
+*/
+func stream_trainers(client recordlib.Conn, src_port int, store recordlib.TrainerStore, gm *recordlib.GlobalManager, sess *session, header string, encode func(recordlib.TrainerRec) (string, error)) {
+	recs, err := snapshot_trainers(store, gm, sess, 0)
+	switch {
+	case err == context.DeadlineExceeded:
+		fmt.Printf("[%d] Trainer listing aborted after %v\n", src_port, sess.scan_timeout)
+		sess.reply(client, "TIMEOUT")
+		return
+	case err == recordlib.ErrFileSize:
+		fmt.Printf("[%d] Error: file size is not a multiple of record size\n", src_port)
+		sess.reply(client, "FILE_ERROR")
+		return
+	case err != nil:
+		fmt.Printf("[%d] Error in GetTrainer: %v\n", src_port, err)
+		sess.reply(client, "FILE_ERROR")
+		return
+	case len(recs) == 0:
+		fmt.Printf("[%d] Client requested from empty file\n", src_port)
+		sess.reply(client, "OUT_OF_BOUNDS")
+		return
+	}
+
+	sess.t.begin() //file I/O here is streaming records to the client
+	recordlib.ReallyWrite(client, "SENDING")
+	out := sess.new_stream(client)
+	if header != "" {
+		out.send(header)
+	}
+	for _, trainer := range recs {
+		msg, err := encode(trainer)
+		if err != nil {
+			sess.t.end_io()
+			fmt.Printf("[%d] Error in record encoding: %v\n", src_port, err)
+			sess.reply(client, "SERVER_ERROR")
+			return
+		}
+		out.send(msg)
+	}
+	if err := out.flush(); err != nil {
+		sess.t.end_io()
+		fmt.Printf("[%d] Error in stream compression: %v\n", src_port, err)
+		sess.reply(client, "SERVER_ERROR")
+		return
+	}
+	sess.t.end_io()
+	sess.reply(client, fmt.Sprintf("DONE %d", len(recs))) //client checks it got every record
+	fmt.Printf("[%d] %d Trainer records sent to client\n", src_port, len(recs))
+}
+
+/*
+Function Name:  process_req_get_trainer_all
+Description:    handle request to stream all trainer records (JSON or binary)
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                store: trainer record store
+                gm: record-level lock manager
+                sess: client session (record response mode, request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, recordlib.TrainerStore, *recordlib.GlobalManager, *session -> n/a
+*/
+func process_req_get_trainer_all(req string, client recordlib.Conn, src_port int, store recordlib.TrainerStore, gm *recordlib.GlobalManager, sess *session) {
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	stream_trainers(client, src_port, store, gm, sess, "", func(trainer recordlib.TrainerRec) (string, error) {
+		return sess.encode_record(trainer)
+	})
+}
+
+/*
+Function Name:  process_req_get_trainer_all_sorted
+Description:    handle request to stream all trainer records sorted by name
+                or ID, sorts a snapshot (snapshot_trainers) and streams
+                it after the read-all lock is released, names that tie
+                are ordered by ascending ID, replies TOO_MANY past
+                max_sorted_trainers so the buffer stays bounded
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                store: trainer record store
+                gm: record-level lock manager
+                sess: client session (record response mode, request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, recordlib.TrainerStore, *recordlib.GlobalManager, *session -> n/a
+*/
+func process_req_get_trainer_all_sorted(req string, client recordlib.Conn, src_port int, store recordlib.TrainerStore, gm *recordlib.GlobalManager, sess *session) {
+	captures := recordlib.ReqGetTrainerAllSorted.FindStringSubmatch(req)
+	if len(captures) == 0 {
+		return //handle_client sends SERVER_ERROR
+	}
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	key, desc := captures[1], captures[2] == "desc"
+
+	recs, err := snapshot_trainers(store, gm, sess, max_sorted_trainers)
+	switch {
+	case err == context.DeadlineExceeded:
+		fmt.Printf("[%d] Sorted trainer listing aborted after %v\n", src_port, sess.scan_timeout)
+		sess.reply(client, "TIMEOUT")
+		return
+	case err == ErrTooManySorted:
+		fmt.Printf("[%d] Refuse to sort: more than %d trainers\n", src_port, max_sorted_trainers)
+		sess.reply(client, "TOO_MANY")
+		return
+	case err == recordlib.ErrFileSize:
+		fmt.Printf("[%d] Error: file size is not a multiple of record size\n", src_port)
+		sess.reply(client, "FILE_ERROR")
+		return
+	case err != nil:
+		fmt.Printf("[%d] Error in GetTrainer: %v\n", src_port, err)
+		sess.reply(client, "FILE_ERROR")
+		return
+	case len(recs) == 0:
+		fmt.Printf("[%d] Client requested from empty file\n", src_port)
+		sess.reply(client, "OUT_OF_BOUNDS")
+		return
+	}
+
+	sess.t.begin()
+	sort.Slice(recs, func(i, j int) bool {
+		a, b := recs[i], recs[j]
+		if desc {
+			a, b = b, a
+		}
+		if key == "name" {
+			a_name, b_name := recordlib.CString(a.Name[:]), recordlib.CString(b.Name[:])
+			if a_name != b_name {
+				return a_name < b_name
+			}
+			return recs[i].ID < recs[j].ID //ties stay in ascending ID order either direction
+		}
+		return a.ID < b.ID
+	})
+
+	recordlib.ReallyWrite(client, "SENDING")
+	out := sess.new_stream(client)
+	for _, trainer := range recs {
+		msg, err := sess.encode_record(trainer)
+		if err != nil {
+			sess.t.end_io()
+			fmt.Printf("[%d] Error in record encoding: %v\n", src_port, err)
+			sess.reply(client, "SERVER_ERROR")
+			return
+		}
+		out.send(msg)
+	}
+	if err := out.flush(); err != nil {
+		sess.t.end_io()
+		fmt.Printf("[%d] Error in stream compression: %v\n", src_port, err)
+		sess.reply(client, "SERVER_ERROR")
+		return
+	}
+	sess.t.end_io()
+	sess.reply(client, fmt.Sprintf("DONE %d", len(recs)))
+	fmt.Printf("[%d] %d sorted Trainer records sent to client\n", src_port, len(recs))
+}
+
+/*
+Function Name:  process_req_export_trainers
+Description:    handle request to export all trainer records as CSV, streams
+                a header line then one CSV line per record, always text
+                whatever the session record response mode
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                store: trainer record store
+                gm: record-level lock manager
+                sess: client session (request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, recordlib.TrainerStore, *recordlib.GlobalManager, *session -> n/a
+*/
+func process_req_export_trainers(req string, client recordlib.Conn, src_port int, store recordlib.TrainerStore, gm *recordlib.GlobalManager, sess *session) {
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	stream_trainers(client, src_port, store, gm, sess, recordlib.TrainerCSVHeader(), recordlib.TrainerCSVRow)
+}
+
+//most idempotency keys remembered, past it expired keys are dropped, then the oldest
+const max_post_keys = 10000
+
+//a POST_TRAINER that carried a KEY, replayed while the key is fresh
+type post_key_entry struct {
+	req     string //request without the key, the same key with other fields is refused
+	reply   string //"<id> <stored name>" sent the first time
+	expires time.Time
+}
+
+//idempotency keys of recent posts, guarded by gm.AppendLock (posts hold it
+//from the key lookup through the append), so a retry racing the original
+//still sees its key
+type post_keys struct {
+	ttl  time.Duration
+	keys map[string]post_key_entry
+}
+
+/*
+Function Name:  new_post_keys
+Description:    empty idempotency key cache
+Parameters:     ttl: how long a key is remembered after its post
+Return Value:   the cache
+Type:           time.Duration -> *post_keys
+*/
+func new_post_keys(ttl time.Duration) *post_keys {
+	return &post_keys{ttl: ttl, keys: make(map[string]post_key_entry)}
+}
+
+/*
+Function Name:  lookup
+Description:    method of post_keys
+				finds an unexpired key, caller holds gm.AppendLock
+Parameters:     key: client idempotency key
+Return Value:   the entry and whether the key is known
+Type:           string -> post_key_entry, bool
+*/
+func (pk *post_keys) lookup(key string) (post_key_entry, bool) {
+	entry, ok := pk.keys[key]
+	if ok && time.Now().After(entry.expires) {
+		delete(pk.keys, key)
+		return post_key_entry{}, false
+	}
+	return entry, ok
+}
+
+/*
+Function Name:  remember
+Description:    method of post_keys
+				stores a successful post's reply under its key, at
+				max_post_keys expired keys are dropped first, then the key
+				closest to expiring, caller holds gm.AppendLock
+Parameters:     key: client idempotency key
+				req: request without the key
+				reply: reply sent for it
+Return Value:   n/a
+Type:           string, string, string -> n/a
+*/
+func (pk *post_keys) remember(key string, req string, reply string) {
+	now := time.Now()
+	if len(pk.keys) >= max_post_keys {
+		oldest := ""
+		for k, entry := range pk.keys {
+			if now.After(entry.expires) {
+				delete(pk.keys, k)
+			} else if oldest == "" || entry.expires.Before(pk.keys[oldest].expires) {
+				oldest = k
+			}
+		}
+		if len(pk.keys) >= max_post_keys {
+			delete(pk.keys, oldest)
+		}
+	}
+	pk.keys[key] = post_key_entry{req: req, reply: reply, expires: now.Add(pk.ttl)}
+}
+
+/*
+Function Name:  process_req_post_trainer
+Description:    parses a POST trainer request, validates name and pokemon IDs,
+                appends under the global read lock, the append lock (next ID
+                and write as one step) and the poke read lock, reply with
+                "<id> <stored name>" or status, a KEY= seen within its TTL
+                gets the first post's reply again with nothing appended
+                (BAD_KEY if the rest of the request differs)
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                store: trainer record store
+                poke_lock: RW lock protecting poke_file
+                gm: record-level lock manager
+                keys: idempotency keys of recent posts
+                sess: client session (request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, recordlib.TrainerStore, recordlib.RWLocker, *recordlib.GlobalManager, *post_keys, *session -> n/a
+*/
+func process_req_post_trainer(req string, client recordlib.Conn, src_port int, store recordlib.TrainerStore, poke_lock recordlib.RWLocker, gm *recordlib.GlobalManager, keys *post_keys, sess *session) {
+	captures := recordlib.ReqPostTrainer.FindStringSubmatch(req)
+	log.Printf("%s [%s] %s", sess.trace_tag(), sess.addr, req)
+	if len(captures) > 0 {
+		name, key := captures[1], captures[8]
+		if len(name) > 15 {
+			fmt.Printf("[%d] Refuse to post: name too long\n", src_port)
+			sess.reply(client, "LONG_NAME")
+			return
+		}
+		if err := recordlib.CheckTrainerName(name); err != nil {
+			fmt.Printf("[%d] Refuse to post: %v\n", src_port, err)
+			sess.reply(client, "BAD_NAME")
+			return
+		}
+		pokemon, err := parse_poke_ids(captures[2:8])
+		if err != nil {
+			fmt.Printf("[%d] Refuse to post: %v\n", src_port, err)
+			sess.reply(client, "BAD_POKE_ID")
+			return
+		}
+		keyless := strings.TrimSuffix(req, " KEY="+key)
+		//no pokemon is allowed, trainer is posted with all six slots empty
+		sess.t.begin()
+		gm.LockAppend()
+		poke_lock.RLock()
+		sess.t.end_lock()
+		var seen post_key_entry
+		var dup bool
+		var id uint16
+		if key != "" {
+			seen, dup = keys.lookup(key)
+		}
+		if !dup {
+			sess.t.begin()
+			id, err = store.Post(name, pokemon)
+			sess.t.end_io()
+			if err == nil && id != 0 && key != "" {
+				keys.remember(key, keyless, fmt.Sprintf("%d %s", id, recordlib.StoredTrainerName(name)))
+			}
+		}
+		poke_lock.RUnlock()
+		gm.UnlockAppend()
+
+		if dup && seen.req != keyless {
+			fmt.Printf("[%d] Refuse to post: key reused for a different trainer\n", src_port)
+			sess.reply(client, "BAD_KEY")
+		} else if dup {
+			sess.reply(client, seen.reply)
+			fmt.Printf("[%d] Post retried with a known key, first post's id sent to client\n", src_port)
+		} else if err != nil {
+			fmt.Printf("[%d] Error in PostTrainer: %v", src_port, err)
+			sess.reply(client, "BAD_POST")
+		} else if id == 0 { //never a valid ID, but the client still needs a reply
+			fmt.Printf("[%d] Error in PostTrainer: store returned ID 0\n", src_port)
+			sess.reply(client, "SERVER_ERROR")
+		} else {
+			//reply "<id> <stored name>" so the client shows what was actually stored
+			sess.reply(client, fmt.Sprintf("%d %s", id, recordlib.StoredTrainerName(name)))
+			fmt.Printf("[%d] Post successful, trainer file modified, id sent to client\n", src_port)
+		}
+	}
+}
+
+/*
+Function Name:  process_req_bulk_post
+Description:    parses a BULK_POST_TRAINER request, one trainer definition
+                per line, validates every name, then posts the whole batch
+                under one global read, append and poke read lock section, the store
+                writes all rows or none, reply with "POSTED <id>..." or
+                "BAD_BULK <row> <status>" for the first failing row
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                store: trainer record store
+                poke_lock: RW lock protecting poke_file
+                gm: record-level lock manager
+                sess: client session (request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, recordlib.TrainerStore, recordlib.RWLocker, *recordlib.GlobalManager, *session -> n/a
+*/
+func process_req_bulk_post(req string, client recordlib.Conn, src_port int, store recordlib.TrainerStore, poke_lock recordlib.RWLocker, gm *recordlib.GlobalManager, sess *session) {
+	captures := recordlib.ReqBulkPost.FindStringSubmatch(req)
+	if len(captures) == 0 {
+		return //handle_client sends SERVER_ERROR
+	}
+	rows := strings.Split(strings.TrimPrefix(captures[1], "\n"), "\n")
+	log.Printf("%s [%s] BULK_POST_TRAINER (%d rows)\n", sess.trace_tag(), sess.addr, len(rows))
+
+	defs := make([]recordlib.TrainerDef, len(rows))
+	for idx, row := range rows {
+		fields := strings.Fields(row)
+		if len(fields[0]) > 15 {
+			fmt.Printf("[%d] Refuse to bulk post: row %d name too long\n", src_port, idx+1)
+			sess.reply(client, fmt.Sprintf("BAD_BULK %d LONG_NAME", idx+1))
+			return
+		}
+		if err := recordlib.CheckTrainerName(fields[0]); err != nil {
+			fmt.Printf("[%d] Refuse to bulk post: row %d %v\n", src_port, idx+1, err)
+			sess.reply(client, fmt.Sprintf("BAD_BULK %d BAD_NAME", idx+1))
+			return
+		}
+		defs[idx].Name = fields[0]
+		pokemon, err := parse_poke_ids(fields[1:])
+		if err != nil {
+			fmt.Printf("[%d] Refuse to bulk post: row %d %v\n", src_port, idx+1, err)
+			sess.reply(client, fmt.Sprintf("BAD_BULK %d BAD_POKE_ID", idx+1))
+			return
+		}
+		defs[idx].Pokemon = pokemon
+	}
+
+	sess.t.begin()
+	gm.LockAppend()
+	poke_lock.RLock()
+	sess.t.end_lock()
+	sess.t.begin()
+	ids, err := store.PostBatch(defs)
+	sess.t.end_io()
+	poke_lock.RUnlock()
+	gm.UnlockAppend()
+
+	if err != nil {
+		fmt.Printf("[%d] Error in PostBatch: %v\n", src_port, err)
+		row := 1
+		var batch_err *recordlib.BatchError
+		if errors.As(err, &batch_err) {
+			row = batch_err.Row
+		}
+		status := "FILE_ERROR"
+		if errors.Is(err, recordlib.ErrPokeNotFound) {
+			status = "BAD_POST"
+		}
+		sess.reply(client, fmt.Sprintf("BAD_BULK %d %s", row, status))
+		return
+	}
+
+	reply := "POSTED"
+	for _, id := range ids {
+		reply += fmt.Sprintf(" %d", id)
+	}
+	sess.reply(client, reply)
+	fmt.Printf("[%d] Bulk post of %d trainers successful, trainer file modified, ids sent to client\n", src_port, len(ids))
+}
+
+/*
+Function Name:  process_req_put_trainer
+Description:    parses a PUT trainer request, trainer ID,
+                acquires global poke read lock and trainer write locking to
+                append, reply with id or status
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                store: trainer record store
+                poke_lock: RW lock protecting poke_file
+                gm: record-level lock manager
+                sess: client session (request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, recordlib.TrainerStore, recordlib.RWLocker, *recordlib.GlobalManager, *session -> n/a
+*/
+func process_req_put_trainer(req string, client recordlib.Conn, src_port int, store recordlib.TrainerStore, poke_lock recordlib.RWLocker, gm *recordlib.GlobalManager, sess *session) {
+	captures := recordlib.ReqPutTrainer.FindStringSubmatch(req)
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	if len(captures) > 0 {
+		id, ok := parse_id(captures[1])
+		if !ok {
+			fmt.Printf("[%d] Refuse to put: bad trainer id %s\n", src_port, captures[1])
+			sess.reply(client, fmt.Sprintf("BAD_PUT.%s", recordlib.ErrTrainerIDRange))
+			return
+		}
+		pokemon, err := parse_poke_ids(captures[2:])
+		if err != nil {
+			fmt.Printf("[%d] Refuse to put: %v\n", src_port, err)
+			sess.reply(client, fmt.Sprintf("BAD_PUT.%s", err))
+			return
+		}
+		sess.t.begin()
+		if err := sess.wlock_record(gm, id); err != nil {
+			sess.t.end_lock()
+			fmt.Printf("[%d] Refuse to put: %v\n", src_port, err)
+			sess.reply(client, fmt.Sprintf("BAD_PUT.%s", err))
+			return
+		}
+		poke_lock.RLock()
+		sess.t.end_lock()
+		sess.t.begin()
+		err = store.Put(id, pokemon)
+		sess.t.end_io()
+		poke_lock.RUnlock()
+		gm.WUnlockRecord(id)
+
+		if err != nil {
+			fmt.Printf("[%d] Error in PutTrainer: %v\n", src_port, err)
+			err_msg := fmt.Sprintf("BAD_PUT.%s", err)
+			sess.reply(client, err_msg)
+		} else {
+			sess.reply(client, "GOOD_PUT")
+			fmt.Printf("[%d] Put successful, trainer file modified\n", src_port)
+		}
+	}
+}
+
+/*
+Function Name:  process_req_append_trainer
+Description:    parses an APPEND trainer request, trainer ID and new pokemon,
+                acquires global poke read lock and trainer write locking to
+                add pokemon to the free slots, reply with status
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                store: trainer record store
+                poke_lock: RW lock protecting poke_file
+                gm: record-level lock manager
+                sess: client session (request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, recordlib.TrainerStore, recordlib.RWLocker, *recordlib.GlobalManager, *session -> n/a
+*/
+func process_req_append_trainer(req string, client recordlib.Conn, src_port int, store recordlib.TrainerStore, poke_lock recordlib.RWLocker, gm *recordlib.GlobalManager, sess *session) {
+	captures := recordlib.ReqAppendTrainer.FindStringSubmatch(req)
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	if len(captures) > 0 {
+		id, ok := parse_id(captures[1])
+		if !ok {
+			fmt.Printf("[%d] Refuse to append: bad trainer id %s\n", src_port, captures[1])
+			sess.reply(client, fmt.Sprintf("BAD_PUT.%s", recordlib.ErrTrainerIDRange))
+			return
+		}
+		pokemon, err := parse_poke_ids(captures[2:])
+		if err != nil {
+			fmt.Printf("[%d] Refuse to append: %v\n", src_port, err)
+			sess.reply(client, fmt.Sprintf("BAD_PUT.%s", err))
+			return
+		}
+		sess.t.begin()
+		if err := sess.wlock_record(gm, id); err != nil {
+			sess.t.end_lock()
+			fmt.Printf("[%d] Refuse to append: %v\n", src_port, err)
+			sess.reply(client, fmt.Sprintf("BAD_PUT.%s", err))
+			return
+		}
+		poke_lock.RLock()
+		sess.t.end_lock()
+		sess.t.begin()
+		err = recordlib.AppendTrainerPoke(store, id, pokemon)
+		sess.t.end_io()
+		poke_lock.RUnlock()
+		gm.WUnlockRecord(id)
+
+		if err != nil {
+			fmt.Printf("[%d] Error in AppendTrainerPoke: %v\n", src_port, err)
+			sess.reply(client, fmt.Sprintf("BAD_PUT.%s", err))
+		} else {
+			sess.reply(client, "GOOD_PUT")
+			fmt.Printf("[%d] Append successful, trainer file modified\n", src_port)
+		}
+	}
+}
+
+/*
+Function Name:  process_req_remove_trainer_poke
+Description:    parses a REMOVE trainer pokemon request, trainer ID and slot,
+                acquires global poke read lock and trainer write locking to
+                clear the slot and compact the rest, reply with status
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                store: trainer record store
+                poke_lock: RW lock protecting poke_file
+                gm: record-level lock manager
+                sess: client session (request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, recordlib.TrainerStore, recordlib.RWLocker, *recordlib.GlobalManager, *session -> n/a
+*/
+func process_req_remove_trainer_poke(req string, client recordlib.Conn, src_port int, store recordlib.TrainerStore, poke_lock recordlib.RWLocker, gm *recordlib.GlobalManager, sess *session) {
+	captures := recordlib.ReqRemoveTrainerPoke.FindStringSubmatch(req)
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	if len(captures) > 0 {
+		id, ok := parse_id(captures[1])
+		if !ok {
+			fmt.Printf("[%d] Refuse to remove: bad trainer id %s\n", src_port, captures[1])
+			sess.reply(client, fmt.Sprintf("BAD_PUT.%s", recordlib.ErrTrainerIDRange))
+			return
+		}
+		slot, ok := parse_slot(captures[2])
+		if !ok {
+			fmt.Printf("[%d] Refuse to remove: bad slot %s\n", src_port, captures[2])
+			sess.reply(client, fmt.Sprintf("BAD_PUT.%s", recordlib.ErrSlotRange))
+			return
+		}
+
+		sess.t.begin()
+		if err := sess.wlock_record(gm, id); err != nil {
+			sess.t.end_lock()
+			fmt.Printf("[%d] Refuse to remove: %v\n", src_port, err)
+			sess.reply(client, fmt.Sprintf("BAD_PUT.%s", err))
+			return
+		}
+		poke_lock.RLock()
+		sess.t.end_lock()
+		sess.t.begin()
+		err := recordlib.RemoveTrainerPoke(store, id, slot)
+		sess.t.end_io()
+		poke_lock.RUnlock()
+		gm.WUnlockRecord(id)
+
+		if err != nil {
+			fmt.Printf("[%d] Error in RemoveTrainerPoke: %v\n", src_port, err)
+			sess.reply(client, fmt.Sprintf("BAD_PUT.%s", err))
+		} else {
+			sess.reply(client, "GOOD_PUT")
+			fmt.Printf("[%d] Remove successful, trainer file modified\n", src_port)
+		}
+	}
+}
+
+/*
+Function Name:  process_req_swap
+Description:    parses a SWAP trainer pokemon request, two trainer IDs and a
+                slot of each, write locks both records in ascending ID order
+                (WLockRecords, so opposite swaps of the same pair can't
+                deadlock) and the poke lock, trades the two pokemon, reply with status
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                store: trainer record store
+                poke_lock: RW lock protecting poke_file
+                gm: record-level lock manager
+                sess: client session (request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, recordlib.TrainerStore, recordlib.RWLocker, *recordlib.GlobalManager, *session -> n/a
+*/
+func process_req_swap(req string, client recordlib.Conn, src_port int, store recordlib.TrainerStore, poke_lock recordlib.RWLocker, gm *recordlib.GlobalManager, sess *session) {
+	captures := recordlib.ReqSwap.FindStringSubmatch(req)
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	if len(captures) > 0 {
+		id_a, ok_a := parse_id(captures[1])
+		id_b, ok_b := parse_id(captures[3])
+		if !ok_a || !ok_b {
+			fmt.Printf("[%d] Refuse to swap: bad trainer id\n", src_port)
+			sess.reply(client, fmt.Sprintf("BAD_PUT.%s", recordlib.ErrTrainerIDRange))
+			return
+		}
+		slot_a, ok_a := parse_slot(captures[2])
+		slot_b, ok_b := parse_slot(captures[4])
+		if !ok_a || !ok_b {
+			fmt.Printf("[%d] Refuse to swap: bad slot\n", src_port)
+			sess.reply(client, fmt.Sprintf("BAD_PUT.%s", recordlib.ErrSlotRange))
+			return
+		}
+
+		sess.t.begin()
+		gm.WLockRecords(id_a, id_b)
+		poke_lock.RLock()
+		sess.t.end_lock()
+		sess.t.begin()
+		err := recordlib.SwapTrainerPoke(store, id_a, slot_a, id_b, slot_b)
+		sess.t.end_io()
+		poke_lock.RUnlock()
+		gm.WUnlockRecords(id_a, id_b)
+
+		if err != nil {
+			fmt.Printf("[%d] Error in SwapTrainerPoke: %v\n", src_port, err)
+			sess.reply(client, fmt.Sprintf("BAD_PUT.%s", err))
+		} else {
+			sess.reply(client, "GOOD_PUT")
+			fmt.Printf("[%d] Swap successful, trainer file modified\n", src_port)
+		}
+	}
+}
+
+/*
+Function Name:  process_req_move
+Description:    parses a MOVE trainer pokemon request, source trainer ID and
+                slot and destination trainer ID, write locks both records
+                in ascending ID order (WLockRecords, like swap) and the poke
+                lock, moves the pokemon, reply with status
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                store: trainer record store
+                poke_lock: RW lock protecting poke_file
+                gm: record-level lock manager
+                sess: client session (request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, recordlib.TrainerStore, recordlib.RWLocker, *recordlib.GlobalManager, *session -> n/a
+*/
+func process_req_move(req string, client recordlib.Conn, src_port int, store recordlib.TrainerStore, poke_lock recordlib.RWLocker, gm *recordlib.GlobalManager, sess *session) {
+	captures := recordlib.ReqMovePoke.FindStringSubmatch(req)
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	if len(captures) > 0 {
+		from_id, ok_from := parse_id(captures[1])
+		to_id, ok_to := parse_id(captures[3])
+		if !ok_from || !ok_to {
+			fmt.Printf("[%d] Refuse to move: bad trainer id\n", src_port)
+			sess.reply(client, fmt.Sprintf("BAD_PUT.%s", recordlib.ErrTrainerIDRange))
+			return
+		}
+		from_slot, ok := parse_slot(captures[2])
+		if !ok {
+			fmt.Printf("[%d] Refuse to move: bad slot %s\n", src_port, captures[2])
+			sess.reply(client, fmt.Sprintf("BAD_PUT.%s", recordlib.ErrSlotRange))
+			return
+		}
+
+		sess.t.begin()
+		gm.WLockRecords(from_id, to_id)
+		poke_lock.RLock()
+		sess.t.end_lock()
+		sess.t.begin()
+		err := recordlib.MoveTrainerPoke(store, from_id, from_slot, to_id)
+		sess.t.end_io()
+		poke_lock.RUnlock()
+		gm.WUnlockRecords(from_id, to_id)
+
+		if err != nil {
+			fmt.Printf("[%d] Error in MoveTrainerPoke: %v\n", src_port, err)
+			sess.reply(client, fmt.Sprintf("BAD_PUT.%s", err))
+		} else {
+			sess.reply(client, "GOOD_PUT")
+			fmt.Printf("[%d] Move successful, trainer file modified\n", src_port)
+		}
+	}
+}
+
+/*
+Function Name:  process_req_patch_poke
+Description:    parses a PATCH pokemon request, ID, field name and value,
+                write locks the poke lock (readers of the file and cache
+                wait), writes the field and refreshes the cached record,
+                reply with status
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                poke_file: the pokemon binary data file
+                poke_cache: in-memory pokemon records, nil if disabled
+                poke_lock: RW lock protecting poke_file and poke_cache
+                sess: client session (request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, *os.File, *recordlib.PokeCache, recordlib.RWLocker, *session -> n/a
+*/
+func process_req_patch_poke(req string, client recordlib.Conn, src_port int, poke_file *os.File, poke_cache *recordlib.PokeCache, poke_lock recordlib.RWLocker, sess *session) {
+	captures := recordlib.ReqPatchPoke.FindStringSubmatch(req)
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	if len(captures) > 0 {
+		id, ok := parse_id(captures[1])
+		if !ok {
+			fmt.Printf("[%d] Refuse to patch: bad pokemon id\n", src_port)
+			sess.reply(client, fmt.Sprintf("BAD_PUT.%s", recordlib.ErrPokeNotFound))
+			return
+		}
+		value, err := strconv.ParseUint(captures[3], 10, 64)
+		if err != nil {
+			fmt.Printf("[%d] Refuse to patch: bad value %s\n", src_port, captures[3])
+			sess.reply(client, fmt.Sprintf("BAD_PUT.%s", recordlib.ErrFieldRange))
+			return
+		}
+
+		sess.t.begin()
+		poke_lock.Lock()
+		sess.t.end_lock()
+		sess.t.begin()
+		err = recordlib.PatchPokemonField(poke_file, id, captures[2], value)
+		if err == nil && poke_cache != nil {
+			var rec recordlib.PokeRec
+			if rec, err = recordlib.GetPokemon(poke_file, id); err == nil {
+				poke_cache.Put(rec)
+			}
+		}
+		sess.t.end_io()
+		poke_lock.Unlock()
+
+		if err != nil {
+			fmt.Printf("[%d] Error in PatchPokemonField: %v\n", src_port, err)
+			sess.reply(client, fmt.Sprintf("BAD_PUT.%s", err))
+		} else {
+			sess.reply(client, "GOOD_PUT")
+			fmt.Printf("[%d] Patch successful, pokemon file modified\n", src_port)
+		}
+	}
+}
+
+/*
+Function Name:  process_req_delete_trainer
+Description:    parses a DELETE trainer request, lock the specific trainer record
+                using global manager, perform logical deletion, reply with result
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                store: trainer record store
+                gm: record-level lock manager
+                sess: client session (request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, recordlib.TrainerStore, *recordlib.GlobalManager, *session -> n/a
+*/
+func process_req_delete_trainer(req string, client recordlib.Conn, src_port int, store recordlib.TrainerStore, gm *recordlib.GlobalManager, sess *session) {
+	captures := recordlib.ReqDelTrainer.FindStringSubmatch(req)
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	if len(captures) > 0 {
+		id, ok := parse_id(captures[1])
+		if !ok {
+			fmt.Printf("[%d] Client requested id out of bounds\n", src_port)
+			sess.reply(client, "OUT_OF_BOUNDS")
+			return
+		}
+		sess.t.begin()
+		gm.WLockRecord(id)
+		sess.t.end_lock()
+		sess.t.begin()
+		err := store.Delete(id)
+		sess.t.end_io()
+		if err == recordlib.ErrIDMismatch {
+			fmt.Printf("[%d] Error in DeleteTrainer: %v\n", src_port, err)
+			sess.reply(client, "SERVER_ERROR") //file is damaged, not a bad request
+		} else if err != nil {
+			fmt.Printf("[%d] Error in DeleteTrainer: %v\n", src_port, err)
+			sess.reply(client, "OUT_OF_BOUNDS")
+		} else {
+			sess.reply(client, "DELETED")
+			fmt.Printf("[%d] Logically deleted record, trainer file modified\n", src_port)
+		}
+		gm.WUnlockRecord(id)
+	}
+}
+
+/*
+Function Name:  process_req_delete_where
+Description:    admin only, parses a bulk DELETE trainer request with a
+                predicate, scans for matches under the read-all lock, then
+                write locks the matched records in ID order and deletes the
+                ones that still match, replies "DELETED <count>[ <id>...]"
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                store: trainer record store
+                gm: record-level lock manager
+                sess: client session (auth, request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, recordlib.TrainerStore, *recordlib.GlobalManager, *session -> n/a
+*/
+func process_req_delete_where(req string, client recordlib.Conn, src_port int, store recordlib.TrainerStore, gm *recordlib.GlobalManager, sess *session) {
+	captures := recordlib.ReqDelTrainerWhere.FindStringSubmatch(req)
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	if len(captures) > 0 {
+		if !sess.authed {
+			fmt.Printf("[%d] Refuse to bulk delete: not authenticated\n", src_port)
+			sess.reply(client, "UNAUTHORIZED")
+			return
+		}
+		pred, err := recordlib.ParseTrainerPredicate(captures[1])
+		if err != nil {
+			sess.reply(client, fmt.Sprintf("BAD_PREDICATE.%s", err))
+			return
+		}
+
+		sess.t.begin()
+		gm.LockReadAll()
+		sess.t.end_lock()
+		sess.t.begin()
+		ids, err := recordlib.MatchTrainers(store, pred)
+		sess.t.end_io()
+		gm.UnlockReadAll()
+		if err != nil {
+			fmt.Printf("[%d] Error in MatchTrainers: %v\n", src_port, err)
+			sess.reply(client, "FILE_ERROR")
+			return
+		}
+
+		//records may change between scan and lock, DeleteTrainersWhere re-checks
+		sess.t.begin()
+		gm.WLockRecords(ids...)
+		sess.t.end_lock()
+		sess.t.begin()
+		deleted, err := recordlib.DeleteTrainersWhere(store, ids, pred)
+		sess.t.end_io()
+		gm.WUnlockRecords(ids...)
+		if err != nil {
+			fmt.Printf("[%d] Error in DeleteTrainersWhere after %d deletes: %v\n", src_port, len(deleted), err)
+			sess.reply(client, "FILE_ERROR")
+			return
+		}
+
+		resp := fmt.Sprintf("DELETED %d", len(deleted))
+		for _, id := range deleted {
+			resp += " " + strconv.Itoa(int(id))
+		}
+		sess.reply(client, resp)
+		fmt.Printf("[%d] Bulk deleted %d records, trainer file modified\n", src_port, len(deleted))
+	}
+}
+
+/*
+Function Name:  process_req_get_log
+Description:    parses a GET log N request, read last N log entries
+                sends back logs or error status
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                log_file: server log file
+                log_lock: mutex protecting log_file
+                sess: client session (request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, *os.File, *sync.Mutex, *session -> n/a
+*/
+func process_req_get_log(req string, client recordlib.Conn, src_port int, log_file *os.File, log_lock *sync.Mutex, sess *session) {
+	captures := recordlib.ReqGetLogN.FindStringSubmatch(req)
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	if len(captures) > 0 {
+		n, ok := parse_log_n(captures[1])
+		if !ok {
+			fmt.Printf("[%d] Refuse get log: n must be at most %d\n", src_port, recordlib.MaxLogN)
+			sess.reply(client, "BAD_COUNT")
+			return
+		}
+		sess.t.begin()
+		log_lock.Lock()
+		sess.t.end_lock()
+		sess.t.begin()
+		logs, err := recordlib.LogReadN(log_file, n)
+		sess.t.end_io()
+		if err != nil {
+			fmt.Printf("[%d] Error in GetLog: %v\n", src_port, err)
+			sess.reply(client, "SERVER_ERROR")
+		} else {
+			sess.reply(client, logs)
+			fmt.Printf("[%d] Requested logs sent to client\n", src_port)
+		}
+		log_lock.Unlock()
+	}
+}
+
+/*
+Function Name:  process_req_log_since
+Description:    parses a GET log since request, reads the log entries stamped
+                at or after the timestamp under log_lock, replies with the
+                entries, "No log entries since <time>." or BAD_TIME
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                log_file: server log file
+                log_lock: mutex protecting log_file
+                sess: client session (request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, *os.File, *sync.Mutex, *session -> n/a
+*/
+func process_req_log_since(req string, client recordlib.Conn, src_port int, log_file *os.File, log_lock *sync.Mutex, sess *session) {
+	captures := recordlib.ReqLogSince.FindStringSubmatch(req)
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	if len(captures) > 0 {
+		since, err := recordlib.ParseLogSince(captures[1])
+		if err != nil {
+			fmt.Printf("[%d] Refuse log since: %v\n", src_port, err)
+			sess.reply(client, "BAD_TIME")
+			return
+		}
+		sess.t.begin()
+		log_lock.Lock()
+		sess.t.end_lock()
+		sess.t.begin()
+		logs, err := recordlib.LogReadSince(log_file, since)
+		sess.t.end_io()
+		log_lock.Unlock()
+
+		if err != nil {
+			fmt.Printf("[%d] Error in LogReadSince: %v\n", src_port, err)
+			sess.reply(client, "SERVER_ERROR")
+		} else if logs == "" {
+			sess.reply(client, fmt.Sprintf("No log entries since %s.", since.Format(time.RFC3339)))
+		} else {
+			sess.reply(client, logs)
+			fmt.Printf("[%d] Requested logs sent to client\n", src_port)
+		}
+	}
+}
+
+//"[<ip>:<port>] " address tag of request lines, any IPv4 or IPv6 client address
+var log_addr_tag = regexp.MustCompile(`\[[0-9A-Fa-f.:]+:(\d+)\] `)
+
+/*
+Function Name:  log_filter
+Description:    builds the line predicate for a log filter, port matches the
+                [<ip>:<port>] tag of request lines and the client=<ip>:<port>
+                field of lifecycle lines whatever the client's address, cmd
+                matches lines whose request (after the address tag) starts
+                with the command word, e.g. POST_TRAINER
+Parameters:     field: "port" or "cmd"
+                value: port number or command word
+Return Value:   predicate and true, or false if a port is not 1-65535
+Type:           string, string -> func(string) bool, bool
+*/
+func log_filter(field string, value string) (func(string) bool, bool) {
+	if field == "port" {
+		port, err := strconv.Atoi(value)
+		if err != nil || port < 1 || port > 65535 {
+			return nil, false
+		}
+		want := strconv.Itoa(port)
+		client_field := regexp.MustCompile(`(?:^| )client=[0-9A-Fa-f.:]+:` + want + `(?: |$)`)
+		return func(line string) bool {
+			for _, tag := range log_addr_tag.FindAllStringSubmatch(line, -1) {
+				if tag[1] == want {
+					return true
+				}
+			}
+			return client_field.MatchString(line)
+		}, true
+	}
+	return func(line string) bool {
+		loc := log_addr_tag.FindStringIndex(line)
+		if loc == nil {
+			return false
+		}
+		rest := line[loc[1]:]
+		return rest == value || strings.HasPrefix(rest, value+" ")
+	}, true
+}
+
+/*
+Function Name:  process_req_log_filter
+Description:    parses a GET log filter request, reads the last n log entries
+                matching the port or command under log_lock, replies with the
+                entries, "No matching entries." or BAD_FILTER
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                log_file: server log file
+                log_lock: mutex protecting log_file
+                sess: client session (request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, *os.File, *sync.Mutex, *session -> n/a
+*/
+func process_req_log_filter(req string, client recordlib.Conn, src_port int, log_file *os.File, log_lock *sync.Mutex, sess *session) {
+	captures := recordlib.ReqLogFilter.FindStringSubmatch(req)
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	if len(captures) > 0 {
+		match, ok := log_filter(captures[1], captures[2])
+		if !ok {
+			fmt.Printf("[%d] Refuse log filter: bad port %s\n", src_port, captures[2])
+			sess.reply(client, "BAD_FILTER")
+			return
+		}
+		n, ok := parse_log_n(captures[3])
+		if !ok {
+			fmt.Printf("[%d] Refuse log filter: n must be at most %d\n", src_port, recordlib.MaxLogN)
+			sess.reply(client, "BAD_COUNT")
+			return
+		}
+		sess.t.begin()
+		log_lock.Lock()
+		sess.t.end_lock()
+		sess.t.begin()
+		logs, err := recordlib.LogReadFiltered(log_file, n, match)
+		sess.t.end_io()
+		log_lock.Unlock()
+
+		if err != nil {
+			fmt.Printf("[%d] Error in LogReadFiltered: %v\n", src_port, err)
+			sess.reply(client, "SERVER_ERROR")
+		} else if logs == "" {
+			sess.reply(client, "No matching entries.")
+		} else {
+			sess.reply(client, logs)
+			fmt.Printf("[%d] Requested logs sent to client\n", src_port)
+		}
+	}
+}
+
+const log_follow_poll = 250 * time.Millisecond //how often log follow checks for new lines
+
+//a request frame read by a handler on behalf of the handle_client loop
+type read_result struct {
+	req string
+	err error
+}
+
+/*
+Function Name:  process_req_log_follow
+Description:    streams lines appended to the log, framed by SENDING, polls
+                the log every log_follow_poll and sends new whole lines as
+                they arrive, log_lock is only held for each poll, a rotation
+                (SIGHUP) is picked up and following goes on in the new log,
+                the client sends STOP to end it, on shutdown it ends by itself,
+                either way it is closed with DONE
+                the one frame read while following is the stop, anything else
+                (EXIT after BYE, read error) is returned for the caller to handle
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                log_file: server log file
+                log_lock: mutex protecting log_file
+                shutdown: closed once server begins shutting down
+                sess: per-connection session stats
+Return Value:   frame read while following if it wasn't STOP, else nil
+Type:           string, recordlib.Conn, int, *os.File, *sync.Mutex, <-chan struct{}, *session -> *read_result
+*/
+func process_req_log_follow(req string, client recordlib.Conn, src_port int, log_file *os.File, log_lock *sync.Mutex, shutdown <-chan struct{}, sess *session) *read_result {
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	log_lock.Lock()
+	follower, err := recordlib.NewLogFollower(log_file)
+	log_lock.Unlock()
+	if err != nil {
+		fmt.Printf("[%d] Error in NewLogFollower: %v\n", src_port, err)
+		sess.reply(client, "SERVER_ERROR")
+		return nil
+	}
+
+	stop := make(chan read_result, 1)
+	go func() {
+		req, err := recordlib.ReallyRead(client)
+		stop <- read_result{req: req, err: err}
+	}()
+
+	recordlib.ReallyWrite(client, "SENDING")
+	fmt.Printf("[%d] Following log\n", src_port)
+	ticker := time.NewTicker(log_follow_poll)
+	defer ticker.Stop()
+	var ahead *read_result
+follow:
+	for {
+		select {
+		case got := <-stop:
+			ahead = &got
+			break follow
+		case <-shutdown:
+			break follow
+		case <-ticker.C:
+		}
+
+		log_lock.Lock()
+		lines, err := follower.Next()
+		log_lock.Unlock()
+		if err != nil {
+			fmt.Printf("[%d] Error following log: %v\n", src_port, err)
+			break
+		}
+		if lines != "" {
+			if err := recordlib.ReallyWrite(client, lines); err != nil {
+				break
+			}
+		}
+	}
+	sess.reply(client, "DONE")
+	fmt.Printf("[%d] Stopped following log\n", src_port)
+
+	if ahead == nil {
+		got := <-stop //the client still sends STOP, or EXIT after BYE
+		ahead = &got
+	}
+	if ahead.err == nil && ahead.req == "STOP" {
+		return nil
+	}
+	return ahead
+}
+
+/*
+Function Name:  rotate_log
+Description:    SIGHUP handler, rotates the log to <log>.1 under log_lock and
+                starts the new log with a note of the rotation
+Parameters:     log_file: server log file
+                log_lock: mutex protecting log_file
+Return Value:   n/a
+Type:           *os.File, *sync.Mutex -> n/a
+*/
+func rotate_log(log_file *os.File, log_lock *sync.Mutex) {
+	log_lock.Lock()
+	err := recordlib.RotateLog(log_file)
+	if err == nil {
+		log.Printf("log rotated to %s.1 on SIGHUP\n", log_file.Name())
+	}
+	log_lock.Unlock()
+	if err != nil {
+		fmt.Printf("Error: Failed to rotate log file!\n%v\n", err)
+	}
+}
+
+/*
+Function Name:  process_req_get_log_all
+Description:    parses a GET log N request spanning rotated logs, reads last
+                N log entries across the rotated and current log files
+                sends back logs or error status
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                log_file: server log file (current, its name is the rotation base)
+                log_lock: mutex protecting log_file
+                sess: client session (request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, *os.File, *sync.Mutex, *session -> n/a
+*/
+func process_req_get_log_all(req string, client recordlib.Conn, src_port int, log_file *os.File, log_lock *sync.Mutex, sess *session) {
+	captures := recordlib.ReqGetLogAllN.FindStringSubmatch(req)
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	if len(captures) > 0 {
+		n, ok := parse_log_n(captures[1])
+		if !ok {
+			fmt.Printf("[%d] Refuse get log all: n must be at most %d\n", src_port, recordlib.MaxLogN)
+			sess.reply(client, "BAD_COUNT")
+			return
+		}
+		sess.t.begin()
+		log_lock.Lock()
+		sess.t.end_lock()
+		sess.t.begin()
+		logs, err := recordlib.LogReadNAcrossFiles(log_file.Name(), n)
+		sess.t.end_io()
+		log_lock.Unlock()
+		if err != nil {
+			fmt.Printf("[%d] Error in GetLogAll: %v\n", src_port, err)
+			sess.reply(client, "SERVER_ERROR")
+		} else {
+			sess.reply(client, logs)
+			fmt.Printf("[%d] Requested logs (all files) sent to client\n", src_port)
+		}
+	}
+}
+
+/*
+Function Name:  process_req_clear_log
+Description:    admin only, truncates the log file in place under log_lock
+                so the open descriptor (and the log MultiWriter) keep working,
+                then logs who cleared it, replies LOG_CLEARED or status
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                log_file: server log file
+                log_lock: mutex protecting log_file
+                sess: client session (auth, request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, *os.File, *sync.Mutex, *session -> n/a
+*/
+func process_req_clear_log(req string, client recordlib.Conn, src_port int, log_file *os.File, log_lock *sync.Mutex, sess *session) {
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	if !sess.authed {
+		fmt.Printf("[%d] Refuse to clear log: not authenticated\n", src_port)
+		sess.reply(client, "UNAUTHORIZED")
+		return
+	}
+
+	sess.t.begin()
+	log_lock.Lock()
+	sess.t.end_lock()
+	sess.t.begin()
+	err := log_file.Truncate(0)
+	if err == nil {
+		_, err = log_file.Seek(0, 0) //O_APPEND writes go to the new end regardless
+	}
+	sess.t.end_io()
+	if err == nil {
+		log.Printf("log cleared by %s\n", sess.addr)
+	}
+	log_lock.Unlock()
+
+	if err != nil {
+		fmt.Printf("[%d] Error in ClearLog: %v\n", src_port, err)
+		sess.reply(client, "SERVER_ERROR")
+	} else {
+		sess.reply(client, "LOG_CLEARED")
+		fmt.Printf("[%d] Log file cleared\n", src_port)
+	}
+}
+
+/*
+Function Name:  process_req_dump_index
+Description:    admin only, writes the pokemon name index to index_path so
+                the next start can load it instead of scanning the pokemon file
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                name_index: pokemon name index
+                index_path: index dump file
+                sess: client session (auth, request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, *recordlib.PokeNameIndex, string, *session -> n/a
+*/
+func process_req_dump_index(req string, client recordlib.Conn, src_port int, name_index *recordlib.PokeNameIndex, index_path string, sess *session) {
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	if !sess.authed {
+		fmt.Printf("[%d] Refuse to dump index: not authenticated\n", src_port)
+		sess.reply(client, "UNAUTHORIZED")
+		return
+	}
+	sess.t.begin()
+	err := name_index.Dump(index_path)
+	sess.t.end_io()
+	if err != nil {
+		fmt.Printf("[%d] Error in DumpIndex: %v\n", src_port, err)
+		sess.reply(client, "SERVER_ERROR")
+		return
+	}
+	sess.reply(client, fmt.Sprintf("INDEX_DUMPED %d", len(name_index.Names())))
+	fmt.Printf("[%d] Name index dumped to %s\n", src_port, index_path)
+}
+
+/*
+Function Name:  process_req_reload_index
+Description:    admin only, reloads the pokemon name index from index_path,
+                rebuilding it from the pokemon file if the dump is missing
+                or stale, replies INDEX_LOADED <n> or INDEX_REBUILT <n>
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                name_index: pokemon name index
+                index_path: index dump file
+                poke_file: pokemon binary file
+                poke_lock: RW lock protecting poke_file
+                sess: client session (auth, request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, *recordlib.PokeNameIndex, string, *os.File, recordlib.RWLocker, *session -> n/a
+*/
+func process_req_reload_index(req string, client recordlib.Conn, src_port int, name_index *recordlib.PokeNameIndex, index_path string, poke_file *os.File, poke_lock recordlib.RWLocker, sess *session) {
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	if !sess.authed {
+		fmt.Printf("[%d] Refuse to reload index: not authenticated\n", src_port)
+		sess.reply(client, "UNAUTHORIZED")
+		return
+	}
+	sess.t.begin()
+	poke_lock.RLock()
+	sess.t.end_lock()
+	sess.t.begin()
+	loaded, err := name_index.LoadOrRebuild(index_path, poke_file)
+	sess.t.end_io()
+	poke_lock.RUnlock()
+	if err != nil {
+		fmt.Printf("[%d] Error in ReloadIndex: %v\n", src_port, err)
+		sess.reply(client, "SERVER_ERROR")
+		return
+	}
+	status := "INDEX_REBUILT"
+	if loaded {
+		status = "INDEX_LOADED"
+	}
+	sess.reply(client, fmt.Sprintf("%s %d", status, len(name_index.Names())))
+	fmt.Printf("[%d] Name index reloaded (%s)\n", src_port, status)
+}
+
+type session struct {
+	addr     string    //client address ip:port
+	start    time.Time //connection time
+	requests int       //number of requests served
+	reason   string    //disconnect reason: EOF, EXIT, BYE, error
+	binary   bool      //records sent as raw binary instead of JSON (HELLO binary)
+	timing   bool      //final reply of each request prefixed with TIMING line (TIMING on)
+	authed   bool      //sent the server -secret with AUTH, may run admin commands
+	trace    bool      //final reply of each request prefixed with TRACE line (TRACE on)
+	compress bool      //trainer streams sent as one gzip frame (COMPRESS gzip)
+	scan_timeout time.Duration //server -scan-timeout, 0 for no limit
+	lock_timeout time.Duration //server -lock-timeout, 0 for no limit
+	conn_id  uint64    //server wide connection number, assigned in accept order
+	seq      uint64    //number of the current request on this connection, from 1
+	t        req_timing
+}
+
+/*
+Function Name:  wlock_record
+Description:    method of session
+				write locks a trainer record for a single record write (put,
+				add, remove), waiting at most the server -lock-timeout
+Parameters:     gm: record-level lock manager
+				id: trainer record id
+Return Value:   nil once locked (release with WUnlockRecord) or
+				recordlib.ErrLockTimeout with nothing held
+Type:           *recordlib.GlobalManager, uint16 -> error
+*/
+func (sess *session) wlock_record(gm *recordlib.GlobalManager, id uint16) error {
+	if sess.lock_timeout == 0 {
+		gm.WLockRecord(id)
+		return nil
+	}
+	return gm.WLockRecordTimeout(id, sess.lock_timeout)
+}
+
+/*
+Function Name:  trace_tag
+Description:    method of session
+				correlation tag for log lines of the current request
+Parameters:     n/a
+Return Value:   "[conn=<n> req=<n>]"
+Type:           n/a -> string
+*/
+func (sess *session) trace_tag() string {
+	return fmt.Sprintf("[conn=%d req=%d]", sess.conn_id, sess.seq)
+}
+
+//per-request phase durations, reset before every request
+type req_timing struct {
+	start       time.Time //request received
+	phase_start time.Time //start of the phase being measured
+	lock_wait   time.Duration
+	io          time.Duration
+	status      string //status of the final reply, for the completion log line
+}
+
+/*
+Function Name:  begin
+Description:    method of req_timing
+				marks the start of a lock wait or file I/O phase
+Parameters:     n/a
+Return Value:   n/a
+Type:           n/a -> n/a
+*/
+func (t *req_timing) begin() {
+	t.phase_start = time.Now()
+}
+
+/*
+Function Name:  end_lock
+Description:    method of req_timing
+				adds time since begin to the lock wait total
+Parameters:     n/a
+Return Value:   n/a
+Type:           n/a -> n/a
+*/
+func (t *req_timing) end_lock() {
+	t.lock_wait += time.Since(t.phase_start)
+}
+
+/*
+Function Name:  end_io
+Description:    method of req_timing
+				adds time since begin to the file I/O total
+Parameters:     n/a
+Return Value:   n/a
+Type:           n/a -> n/a
+*/
+func (t *req_timing) end_io() {
+	t.io += time.Since(t.phase_start)
+}
+
+/*
+Function Name:  reply_status
+Description:    names the status of a reply for the access log, status words
+				(OUT_OF_BOUNDS, BAD_PUT.<msg>, DELETED 3 ...) give their first
+				word, records and other data replies are OK
+Parameters:     msg: reply message
+Return Value:   status word or OK
+Type:           string -> string
+*/
+func reply_status(msg string) string {
+	word := msg
+	if idx := strings.IndexAny(msg, " .\n"); idx >= 0 {
+		word = msg[:idx]
+	}
+	if word == "" || strings.Trim(word, "ABCDEFGHIJKLMNOPQRSTUVWXYZ_") != "" {
+		return "OK"
+	}
+	return word
+}
+
+/*
+Function Name:  reply
+Description:    method of session
+				sends the final reply of a request, when timing is on the
+				message is prefixed with one line
+				"TIMING lock_us=<n> io_us=<n> total_us=<n>"
+				and when trace is on, before that, "TRACE conn=<n> req=<n>"
+				stream frames before the final reply are sent unprefixed
+Parameters:     client: client socket file for reply
+                msg: reply message or status
+Return Value:   nil or write error
+Type:           recordlib.Conn, string -> error
+*/
+func (sess *session) reply(client recordlib.Conn, msg string) error {
+	sess.t.status = reply_status(msg)
+	if sess.timing {
+		msg = fmt.Sprintf("TIMING lock_us=%d io_us=%d total_us=%d\n%s",
+			sess.t.lock_wait.Microseconds(), sess.t.io.Microseconds(),
+			time.Since(sess.t.start).Microseconds(), msg)
+	}
+	if sess.trace {
+		msg = fmt.Sprintf("TRACE conn=%d req=%d\n%s", sess.conn_id, sess.seq, msg)
+	}
+	return recordlib.ReallyWrite(client, msg)
+}
+
+//frames of one stream between SENDING and DONE, see session.new_stream
+type stream_out struct {
+	client recordlib.Conn
+	gz     *recordlib.FrameGzip //nil sends each frame as it comes
+}
+
+/*
+Function Name:  new_stream
+Description:    method of session
+				output for the frames of a stream, sent straight away or,
+				after COMPRESS gzip, collected and sent as one frame by flush
+Parameters:     client: client socket file for reply
+Return Value:   stream output
+Type:           recordlib.Conn -> *stream_out
+*/
+func (sess *session) new_stream(client recordlib.Conn) *stream_out {
+	out := &stream_out{client: client}
+	if sess.compress {
+		out.gz = recordlib.NewFrameGzip()
+	}
+	return out
+}
+
+/*
+Function Name:  send
+Description:    method of stream_out
+				sends one frame of the stream, or adds it to the gzip frame
+Parameters:     msg: the frame
+Return Value:   n/a
+Type:           string -> n/a
+*/
+func (out *stream_out) send(msg string) {
+	if out.gz != nil {
+		out.gz.Add(msg)
+		return
+	}
+	recordlib.ReallyWrite(out.client, msg)
+}
+
+/*
+Function Name:  flush
+Description:    method of stream_out
+				sends the gzip frame, if compressing, call before DONE
+Parameters:     n/a
+Return Value:   nil or compression error
+Type:           n/a -> error
+*/
+func (out *stream_out) flush() error {
+	if out.gz == nil {
+		return nil
+	}
+	msg, err := out.gz.Close()
+	if err != nil {
+		return err
+	}
+	return recordlib.ReallyWrite(out.client, msg)
+}
+
+/*
+Function Name:  scan_context
+Description:    method of session
+				context of a trainer scan under the read-all lock, done once
+				the -scan-timeout has passed so the scan can give up the lock
+Parameters:     n/a
+Return Value:   scan context and its cancel func (always call it)
+Type:           n/a -> context.Context, context.CancelFunc
+*/
+func (sess *session) scan_context() (context.Context, context.CancelFunc) {
+	if sess.scan_timeout == 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), sess.scan_timeout)
+}
+
+/*
+Function Name:  encode_record
+Description:    method of session
+				encodes a pokemon or trainer record in the negotiated mode,
+				JSON by default or raw binary after HELLO binary
+Parameters:     rec: PokeRec or TrainerRec
+Return Value:   message to send and encoding error (if any)
+Type:           any -> string, error
+*/
+func (sess *session) encode_record(rec any) (string, error) {
+	if sess.binary {
+		return recordlib.EncodeRecordBinary(rec)
+	}
+	bytes, err := json.Marshal(rec)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes), nil
+}
+
+/*
+Function Name:  process_req_hello
+Description:    sets the record response mode of the session, replies HELLO <mode>
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                sess: client session
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, *session -> n/a
+*/
+func process_req_hello(req string, client recordlib.Conn, src_port int, sess *session) {
+	captures := recordlib.ReqHello.FindStringSubmatch(req)
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	if len(captures) > 0 {
+		sess.binary = captures[1] == "binary"
+		sess.t.status = "HELLO"
+		recordlib.ReallyWrite(client, "HELLO "+captures[1])
+		fmt.Printf("[%d] Record response mode set to %s\n", src_port, captures[1])
+	}
+}
+
+/*
+Function Name:  process_req_timing
+Description:    turns per-request timing metadata on or off, replies TIMING <on|off>
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                sess: client session
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, *session -> n/a
+*/
+func process_req_timing(req string, client recordlib.Conn, src_port int, sess *session) {
+	captures := recordlib.ReqTiming.FindStringSubmatch(req)
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	if len(captures) > 0 {
+		sess.timing = captures[1] == "on"
+		sess.t.status = "TIMING"
+		recordlib.ReallyWrite(client, "TIMING "+captures[1])
+		fmt.Printf("[%d] Request timing turned %s\n", src_port, captures[1])
+	}
+}
+
+/*
+Function Name:  process_req_trace
+Description:    turns the per-request correlation ID line on or off, replies TRACE <on|off>
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                sess: client session
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, *session -> n/a
+*/
+func process_req_trace(req string, client recordlib.Conn, src_port int, sess *session) {
+	captures := recordlib.ReqTrace.FindStringSubmatch(req)
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	if len(captures) > 0 {
+		sess.trace = captures[1] == "on"
+		sess.t.status = "TRACE"
+		recordlib.ReallyWrite(client, "TRACE "+captures[1])
+		fmt.Printf("[%d] Request tracing turned %s\n", src_port, captures[1])
+	}
+}
+
+/*
+Function Name:  process_req_compress
+Description:    turns gzip compression of trainer streams on or off, replies COMPRESS <gzip|off>
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                sess: client session
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, *session -> n/a
+*/
+func process_req_compress(req string, client recordlib.Conn, src_port int, sess *session) {
+	captures := recordlib.ReqCompress.FindStringSubmatch(req)
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	if len(captures) > 0 {
+		sess.compress = captures[1] == "gzip"
+		sess.t.status = "COMPRESS"
+		recordlib.ReallyWrite(client, "COMPRESS "+captures[1])
+		fmt.Printf("[%d] Stream compression set to %s\n", src_port, captures[1])
+	}
+}
+
+/*
+Function Name:  process_req_auth
+Description:    checks the token against the server -secret and marks the
+                session authenticated, replies AUTH_OK or UNAUTHORIZED
+                (always UNAUTHORIZED when the server has no -secret)
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                sess: client session
+                secret: server -secret token
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, *session, string -> n/a
+*/
+func process_req_auth(req string, client recordlib.Conn, src_port int, sess *session, secret string) {
+	captures := recordlib.ReqAuth.FindStringSubmatch(req)
+	log.Printf("%s [%s] AUTH <redacted>\n", sess.trace_tag(), sess.addr) //never log the token
+	if len(captures) > 0 {
+		if secret != "" && subtle.ConstantTimeCompare([]byte(captures[1]), []byte(secret)) == 1 {
+			sess.authed = true
+			sess.t.status = "AUTH_OK"
+			recordlib.ReallyWrite(client, "AUTH_OK")
+			fmt.Printf("[%d] Client authenticated\n", src_port)
+		} else {
+			sess.authed = false
+			sess.t.status = "UNAUTHORIZED"
+			recordlib.ReallyWrite(client, "UNAUTHORIZED")
+			fmt.Printf("[%d] Client failed authentication\n", src_port)
+		}
+	}
+}
+
+//requests that change trainer or pokemon records, need AUTH first when the server has a -secret
+var mutating_reqs = []*regexp.Regexp{
+	recordlib.ReqPostTrainer,
+	recordlib.ReqBulkPost,
+	recordlib.ReqPutTrainer,
+	recordlib.ReqAppendTrainer,
+	recordlib.ReqRemoveTrainerPoke,
+	recordlib.ReqSwap,
+	recordlib.ReqMovePoke,
+	recordlib.ReqPatchPoke,
+	recordlib.ReqDelTrainer,
+}
+
+/*
+Function Name:  needs_auth
+Description:    whether a request has to be refused because it mutates
+				trainers and the session hasn't authenticated, never when
+				the server has no -secret
+Parameters:     req: raw client request
+				secret: server -secret token
+				sess: client session
+Return Value:   true if the request must be refused
+Type:           string, string, *session -> bool
+*/
+func needs_auth(req string, secret string, sess *session) bool {
+	if secret == "" || sess.authed {
+		return false
+	}
+	for _, re := range mutating_reqs {
+		if re.MatchString(req) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Function Name:  process_req_unauthorized
+Description:    refuses a mutation from a session that hasn't sent AUTH,
+				replies UNAUTHORIZED without touching any lock or file
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                sess: client session
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, *session -> n/a
+*/
+func process_req_unauthorized(req string, client recordlib.Conn, src_port int, sess *session) {
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	fmt.Printf("[%d] Refuse to mutate: not authenticated\n", src_port)
+	sess.reply(client, "UNAUTHORIZED")
+}
+
+//request counters shared by every client handler, see REQ_METRICS
+type server_metrics struct {
+	start    time.Time
+	requests atomic.Uint64 //every request but EXIT
+	gets     atomic.Uint64 //pokemon and trainer reads
+	posts    atomic.Uint64 //single and bulk trainer posts
+	puts     atomic.Uint64 //put, append and remove slot
+	deletes  atomic.Uint64 //delete by ID and delete where
+	errors   atomic.Uint64 //final reply was an error status
+
+	conn_query chan chan conn_counts //answered by the clients manager goroutine, see REQ_STATUS
+}
+
+//final reply statuses counted as errors, any BAD_<reason> status is also an error
+var error_statuses = map[string]bool{
+	"CLIENT_REQ_INVALID": true,
+	"SERVER_ERROR":       true,
+	"FILE_ERROR":         true,
+	"OUT_OF_BOUNDS":      true,
+	"UNAUTHORIZED":       true,
+	"LONG_NAME":          true,
+	"TOO_MANY":           true,
+}
+
+//connection counts owned by the clients manager goroutine
+type conn_counts struct {
+	served uint64 //clients handed to handle_client since start
+	active int    //clients not yet disconnected
+}
+
+/*
+Function Name:  connections
+Description:    method of server_metrics
+				asks the clients manager goroutine for its connection counts,
+				gives up once shutdown starts since the manager then stops
+				answering
+Parameters:     shutdown: closed once server begins shutting down
+Return Value:   the counts and true, or false during shutdown
+Type:           <-chan struct{} -> conn_counts, bool
+*/
+func (m *server_metrics) connections(shutdown <-chan struct{}) (conn_counts, bool) {
+	answer := make(chan conn_counts, 1) //manager never blocks on the reply
+	select {
+	case m.conn_query <- answer:
+		return <-answer, true
+	case <-shutdown:
+		return conn_counts{}, false
+	}
+}
+
+/*
+Function Name:  count
+Description:    method of server_metrics
+				counts one finished request, kind is the per-type counter
+				picked by the handle_client switch or nil for requests
+				outside the get/post/put/delete types
+Parameters:     kind: per-type counter or nil
+				status: status of the final reply (see reply_status)
+Return Value:   n/a
+Type:           *atomic.Uint64, string -> n/a
+*/
+func (m *server_metrics) count(kind *atomic.Uint64, status string) {
+	m.requests.Add(1)
+	if kind != nil {
+		kind.Add(1)
+	}
+	if error_statuses[status] || strings.HasPrefix(status, "BAD_") {
+		m.errors.Add(1)
+	}
+}
+
+/*
+Function Name:  process_req_metrics
+Description:    replies with the server request counters as JSON
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                metrics: server request counters
+                sess: client session
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, *server_metrics, *session -> n/a
+*/
+func process_req_metrics(req string, client recordlib.Conn, src_port int, metrics *server_metrics, sess *session) {
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	snapshot := recordlib.Metrics{
+		UptimeSec: int64(time.Since(metrics.start).Seconds()),
+		Requests:  metrics.requests.Load(),
+		Gets:      metrics.gets.Load(),
+		Posts:     metrics.posts.Load(),
+		Puts:      metrics.puts.Load(),
+		Deletes:   metrics.deletes.Load(),
+		Errors:    metrics.errors.Load(),
+	}
+	bytes, err := json.Marshal(snapshot)
+	if err != nil {
+		fmt.Printf("[%d] Error encoding metrics: %v\n", src_port, err)
+		sess.reply(client, "SERVER_ERROR")
+		return
+	}
+	sess.reply(client, string(bytes))
+	fmt.Printf("[%d] Metrics sent to client\n", src_port)
+}
+
+/*
+Function Name:  process_req_fsck
+Description:    scans the pokemon and trainer files for corruption while
+                holding the trainer read-all lock and the pokemon read lock,
+                so no write lands mid scan, replies with the
+                JSON VerifyReport or SERVER_ERROR if a read failed
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                poke_file: pokemon binary file
+                trainer_file: trainer binary file
+                poke_lock: RW lock protecting poke_file
+                gm: record-level lock manager
+                sess: client session (request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, *os.File, *os.File, recordlib.RWLocker, *recordlib.GlobalManager, *session -> n/a
+*/
+func process_req_fsck(req string, client recordlib.Conn, src_port int, poke_file *os.File, trainer_file *os.File, poke_lock recordlib.RWLocker, gm *recordlib.GlobalManager, sess *session) {
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	var report recordlib.VerifyReport
+	sess.t.begin()
+	gm.LockReadAll() //waits out record writers, holds off new ones
+	poke_lock.RLock() //after the global lock, like the writers, a PATCH_POKE may be waiting
+	sess.t.end_lock()
+	sess.t.begin()
+	err := recordlib.VerifyPokemon(poke_file, &report)
+	if err == nil {
+		err = recordlib.VerifyTrainers(trainer_file, &report)
+	}
+	sess.t.end_io()
+	poke_lock.RUnlock()
+	gm.UnlockReadAll()
+
+	if err != nil {
+		fmt.Printf("[%d] Error in verify: %v\n", src_port, err)
+		sess.reply(client, "SERVER_ERROR")
+		return
+	}
+	bytes, err := json.Marshal(report)
+	if err != nil {
+		fmt.Printf("[%d] Error encoding verify report: %v\n", src_port, err)
+		sess.reply(client, "SERVER_ERROR")
+		return
+	}
+	sess.reply(client, string(bytes))
+	fmt.Printf("[%d] Verify report sent to client (%d issues)\n", src_port, report.TotalIssues)
+}
+
+/*
+Function Name:  process_req_validate_refs
+Description:    scans the trainers for slots referencing a pokemon that isn't
+                in the pokemon file under the read-all and pokemon read locks,
+                with REPAIR (admin) then write locks the trainers found, in
+                ascending ID order, and drops those slots, replies with the
+                JSON list of references found (or removed)
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                store: trainer record store
+                poke_file: pokemon binary file
+                trainer_file: trainer binary file
+                poke_lock: RW lock protecting poke_file
+                gm: record-level lock manager
+                sess: client session (auth, request timing)
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, recordlib.TrainerStore, *os.File, *os.File, recordlib.RWLocker, *recordlib.GlobalManager, *session -> n/a
+*/
+func process_req_validate_refs(req string, client recordlib.Conn, src_port int, store recordlib.TrainerStore, poke_file *os.File, trainer_file *os.File, poke_lock recordlib.RWLocker, gm *recordlib.GlobalManager, sess *session) {
+	captures := recordlib.ReqValidateRefs.FindStringSubmatch(req)
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	if len(captures) > 0 {
+		repair := captures[1] != ""
+		if repair && !sess.authed {
+			fmt.Printf("[%d] Refuse to repair references: not authenticated\n", src_port)
+			sess.reply(client, "UNAUTHORIZED")
+			return
+		}
+
+		sess.t.begin()
+		gm.LockReadAll()
+		poke_lock.RLock()
+		sess.t.end_lock()
+		sess.t.begin()
+		refs, err := recordlib.ValidateTrainerRefs(trainer_file, poke_file)
+		sess.t.end_io()
+		poke_lock.RUnlock()
+		gm.UnlockReadAll()
+		if err != nil {
+			fmt.Printf("[%d] Error in ValidateTrainerRefs: %v\n", src_port, err)
+			sess.reply(client, "FILE_ERROR")
+			return
+		}
+
+		if repair && len(refs) > 0 {
+			var ids []uint16
+			for _, ref := range refs {
+				if len(ids) == 0 || ids[len(ids)-1] != ref.TrainerID {
+					ids = append(ids, ref.TrainerID)
+				}
+			}
+			//records may change between scan and lock, RepairTrainerRefs re-checks
+			sess.t.begin()
+			gm.WLockRecords(ids...)
+			poke_lock.RLock()
+			sess.t.end_lock()
+			sess.t.begin()
+			refs, err = recordlib.RepairTrainerRefs(store, poke_file, ids)
+			sess.t.end_io()
+			poke_lock.RUnlock()
+			gm.WUnlockRecords(ids...)
+			if err != nil {
+				fmt.Printf("[%d] Error in RepairTrainerRefs after %d references: %v\n", src_port, len(refs), err)
+				sess.reply(client, "FILE_ERROR")
+				return
+			}
+			fmt.Printf("[%d] Removed %d dangling references, trainer file modified\n", src_port, len(refs))
+		}
+
+		bytes, err := json.Marshal(refs)
+		if err != nil {
+			fmt.Printf("[%d] Error encoding references: %v\n", src_port, err)
+			sess.reply(client, "SERVER_ERROR")
+			return
+		}
+		sess.reply(client, string(bytes))
+		fmt.Printf("[%d] Reference report sent to client (%d dangling)\n", src_port, len(refs))
+	}
+}
+
+/*
+Function Name:  process_req_status
+Description:    replies with the server start time, uptime, connections served
+				and connections active as JSON, SERVER_ERROR once shutting down
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                metrics: server request counters (start time, manager query)
+                shutdown: closed once server begins shutting down
+                sess: client session
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, *server_metrics, <-chan struct{}, *session -> n/a
+*/
+func process_req_status(req string, client recordlib.Conn, src_port int, metrics *server_metrics, shutdown <-chan struct{}, sess *session) {
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	counts, ok := metrics.connections(shutdown)
+	if !ok {
+		fmt.Printf("[%d] Status not sent, server is shutting down\n", src_port)
+		sess.reply(client, "SERVER_ERROR")
+		return
+	}
+	status := recordlib.ServerStatus{
+		StartTime:   metrics.start.Format(time.RFC3339),
+		UptimeSec:   int64(time.Since(metrics.start).Seconds()),
+		Connections: counts.served,
+		Active:      counts.active,
+	}
+	bytes, err := json.Marshal(status)
+	if err != nil {
+		fmt.Printf("[%d] Error encoding status: %v\n", src_port, err)
+		sess.reply(client, "SERVER_ERROR")
+		return
+	}
+	sess.reply(client, string(bytes))
+	fmt.Printf("[%d] Server status sent to client\n", src_port)
+}
+
+/*
+Function Name:  process_req_schema
+Description:    replies with the pokemon or trainer record format as JSON
+                (recordlib.RecordSchema), no locks or files involved
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                sess: client session
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, *session -> n/a
+*/
+func process_req_schema(req string, client recordlib.Conn, src_port int, sess *session) {
+	captures := recordlib.ReqSchema.FindStringSubmatch(req)
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	if len(captures) > 0 {
+		schema, err := recordlib.DescribeRecord(captures[1])
+		if err != nil {
+			fmt.Printf("[%d] Error describing %s record: %v\n", src_port, captures[1], err)
+			sess.reply(client, "SERVER_ERROR")
+			return
+		}
+		bytes, err := json.Marshal(schema)
+		if err != nil {
+			fmt.Printf("[%d] Error encoding schema: %v\n", src_port, err)
+			sess.reply(client, "SERVER_ERROR")
+			return
+		}
+		sess.reply(client, string(bytes))
+		fmt.Printf("[%d] %s schema sent to client\n", src_port, schema.Record)
+	}
+}
+
+/*
+Function Name:  process_req_whoami
+Description:    replies with the asking connection's source port, connection
+				ID and age, the server uptime and protocol version as JSON
+Parameters:     req: raw client request
+                client: client socket file for reply
+                src_port: client source port (for logging)
+                metrics: server request counters (server start time)
+                sess: client session
+Return Value:   n/a
+Type:           string, recordlib.Conn, int, *server_metrics, *session -> n/a
+*/
+func process_req_whoami(req string, client recordlib.Conn, src_port int, metrics *server_metrics, sess *session) {
+	log.Printf("%s [%s] %s\n", sess.trace_tag(), sess.addr, req)
+	info := recordlib.WhoAmI{
+		SrcPort:      src_port,
+		ConnID:       sess.conn_id,
+		ConnectedSec: int64(time.Since(sess.start).Seconds()),
+		UptimeSec:    int64(time.Since(metrics.start).Seconds()),
+		Protocol:     fmt.Sprintf("%d.%d", recordlib.ProtocolMajor, recordlib.ProtocolMinor),
+	}
+	bytes, err := json.Marshal(info)
+	if err != nil {
+		fmt.Printf("[%d] Error encoding connection info: %v\n", src_port, err)
+		sess.reply(client, "SERVER_ERROR")
+		return
+	}
+	sess.reply(client, string(bytes))
+	fmt.Printf("[%d] Connection info sent to client\n", src_port)
+}
+
+/*
+Function Name:  log_connect
+Description:    method of session
+				emits structured lifecycle event for a new connection
+Parameters:     n/a
+Return Value:   n/a
+Type:           n/a -> n/a
+*/
+func (sess *session) log_connect() {
+	log.Printf("[lifecycle] event=connect conn=%d client=%s\n", sess.conn_id, sess.addr)
+}
+
+/*
+Function Name:  log_disconnect
+Description:    method of session
+				emits structured lifecycle event when a connection ends
+				with session duration, requests served and disconnect reason
+Parameters:     n/a
+Return Value:   n/a
+Type:           n/a -> n/a
+*/
+func (sess *session) log_disconnect() {
+	duration := time.Since(sess.start).Round(time.Millisecond)
+	log.Printf("[lifecycle] event=disconnect conn=%d client=%s duration=%s requests=%d reason=%s\n", sess.conn_id, sess.addr, duration, sess.requests, sess.reason)
+}
+
+//shared state for the HTTP/JSON frontend, same store and locks as the socket handlers
+type http_gateway struct {
+	poke_file  *os.File
+	poke_cache *recordlib.PokeCache
+	store     recordlib.TrainerStore
+	poke_lock *sync.RWMutex
+	gm        *recordlib.GlobalManager
+	secret    string //server -secret, mutations need "Authorization: Bearer <secret>" if set
+}
+
+//JSON request bodies for POST /trainer and PUT /trainer/{id}
+type http_trainer_body struct {
+	Name    string   `json:"name"`
+	Pokemon []uint16 `json:"pokemon"`
+}
+
+/*
+Function Name:  respond
+Description:    method of http_gateway
+				logs the request with its status and writes body as JSON,
+				a nil body writes no content
+Parameters:     w: response writer
+				r: request
+				status: HTTP status code
+				body: value to marshal, or nil
+Return Value:   n/a
+Type:           http.ResponseWriter, *http.Request, int, any -> n/a
+*/
+func (gw *http_gateway) respond(w http.ResponseWriter, r *http.Request, status int, body any) {
+	log.Printf("[http %s] %s %s status=%d\n", r.RemoteAddr, r.Method, r.URL.Path, status)
+	if body == nil {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+/*
+Function Name:  fail
+Description:    method of http_gateway
+				responds with {"error": msg}
+Parameters:     w: response writer
+				r: request
+				status: HTTP status code
+				msg: error description
+Return Value:   n/a
+Type:           http.ResponseWriter, *http.Request, int, string -> n/a
+*/
+func (gw *http_gateway) fail(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	gw.respond(w, r, status, map[string]string{"error": msg})
+}
+
+/*
+Function Name:  authorized
+Description:    method of http_gateway
+				wraps a mutating handler, when the server has a -secret the
+				request must carry it as a bearer token or gets 401
+Parameters:     next: handler to run once authorized
+Return Value:   wrapped handler
+Type:           http.HandlerFunc -> http.HandlerFunc
+*/
+func (gw *http_gateway) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if gw.secret != "" {
+			token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !found || subtle.ConstantTimeCompare([]byte(token), []byte(gw.secret)) != 1 {
+				gw.fail(w, r, http.StatusUnauthorized, "missing or wrong bearer token")
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+/*
+Function Name:  path_id
+Description:    parses the {id} path value as a record ID (1-65535)
+Parameters:     r: request
+Return Value:   record ID and whether it was valid
+Type:           *http.Request -> uint16, bool
+*/
+func path_id(r *http.Request) (uint16, bool) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 16)
+	return uint16(id), err == nil && id > 0
+}
+
+/*
+Function Name:  read_body
+Description:    decodes a trainer JSON body and checks the pokemon count,
+				name is only checked when required (POST)
+Parameters:     r: request
+				need_name: whether body must carry a valid trainer name
+Return Value:   decoded body and error message ("" if valid)
+Type:           *http.Request, bool -> http_trainer_body, string
+*/
+func read_body(r *http.Request, need_name bool) (http_trainer_body, string) {
+	var body http_trainer_body
+	if err := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 4096)).Decode(&body); err != nil {
+		return body, fmt.Sprintf("bad JSON body: %v", err)
+	}
+	if need_name {
+		if body.Name == "" || strings.ContainsAny(body.Name, " \t\n") {
+			return body, "name must be one word"
+		}
+		if len(body.Name) > 15 {
+			return body, "name too long, max 15 characters"
+		}
+		if err := recordlib.CheckTrainerName(body.Name); err != nil {
+			return body, err.Error()
+		}
+	}
+	if len(body.Pokemon) > 6 {
+		return body, "at most 6 pokemon"
+	}
+	return body, ""
+}
+
+/*
+Function Name:  get_pokemon
+Description:    method of http_gateway
+				GET /pokemon/{id}, reads the pokemon record (cache first) under
+				poke_lock read lock
+				200 with the record, 400 bad ID, 404 not found, 500 read error
+Parameters:     w: response writer
+				r: request
+Return Value:   n/a
+Type:           http.ResponseWriter, *http.Request -> n/a
+*/
+func (gw *http_gateway) get_pokemon(w http.ResponseWriter, r *http.Request) {
+	id, ok := path_id(r)
+	if !ok {
+		gw.fail(w, r, http.StatusBadRequest, "bad pokemon ID")
+		return
+	}
+	gw.poke_lock.RLock()
+	rec, err := read_pokemon(gw.poke_file, gw.poke_cache, id)
+	gw.poke_lock.RUnlock()
+	switch {
+	case err == io.EOF:
+		gw.fail(w, r, http.StatusNotFound, "pokemon ID not found")
+	case err != nil:
+		gw.fail(w, r, http.StatusInternalServerError, err.Error())
+	default:
+		gw.respond(w, r, http.StatusOK, rec)
+	}
+}
+
+/*
+Function Name:  get_trainer
+Description:    method of http_gateway
+				GET /trainer/{id}, reads the trainer record under the
+				GlobalManager record read lock for that ID
+				200 with the record, 400 bad ID, 404 not found, 500 read error
+Parameters:     w: response writer
+				r: request
+Return Value:   n/a
+Type:           http.ResponseWriter, *http.Request -> n/a
+*/
+func (gw *http_gateway) get_trainer(w http.ResponseWriter, r *http.Request) {
+	id, ok := path_id(r)
+	if !ok {
+		gw.fail(w, r, http.StatusBadRequest, "bad trainer ID")
+		return
+	}
+	gw.gm.RLockRecord(id)
+	rec, err := gw.store.Get(id)
+	gw.gm.RUnlockRecord(id)
+	switch {
+	case err == io.EOF || err == recordlib.ErrTrainerNotFound:
+		gw.fail(w, r, http.StatusNotFound, recordlib.ErrTrainerNotFound.Error())
+	case err != nil:
+		gw.fail(w, r, http.StatusInternalServerError, err.Error())
+	default:
+		gw.respond(w, r, http.StatusOK, rec)
+	}
+}
+
+/*
+Function Name:  get_trainers
+Description:    method of http_gateway
+				GET /trainers, reads every trainer record under the
+				GlobalManager read-all lock
+				200 with the records ([] when empty), 500 read error
+Parameters:     w: response writer
+				r: request
+Return Value:   n/a
+Type:           http.ResponseWriter, *http.Request -> n/a
+*/
+func (gw *http_gateway) get_trainers(w http.ResponseWriter, r *http.Request) {
+	recs := []recordlib.TrainerRec{} //empty file gives [] not null
+	gw.gm.LockReadAll()
+	err := gw.store.All(func(trainer recordlib.TrainerRec) error {
+		recs = append(recs, trainer)
+		return nil
+	})
+	gw.gm.UnlockReadAll()
+	if err != nil {
+		gw.fail(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	gw.respond(w, r, http.StatusOK, recs)
+}
+
+/*
+Function Name:  post_trainer
+Description:    method of http_gateway
+				POST /trainer, appends a trainer under the GlobalManager append
+				lock, then poke_lock read lock to look up its pokemon
+				201 with ID and stored name, 400 bad body, 401 bad token (authorized),
+				422 pokemon not found, 500 write error
+Parameters:     w: response writer
+				r: request
+Return Value:   n/a
+Type:           http.ResponseWriter, *http.Request -> n/a
+*/
+func (gw *http_gateway) post_trainer(w http.ResponseWriter, r *http.Request) {
+	body, msg := read_body(r, true)
+	if msg != "" {
+		gw.fail(w, r, http.StatusBadRequest, msg)
+		return
+	}
+	gw.gm.LockAppend()
+	gw.poke_lock.RLock()
+	id, err := gw.store.Post(body.Name, body.Pokemon)
+	gw.poke_lock.RUnlock()
+	gw.gm.UnlockAppend()
+	switch {
+	case err == recordlib.ErrPokeNotFound:
+		gw.fail(w, r, http.StatusUnprocessableEntity, err.Error())
+	case err != nil:
+		gw.fail(w, r, http.StatusInternalServerError, err.Error())
+	default:
+		gw.respond(w, r, http.StatusCreated, recordlib.PokeName{ID: id, Name: recordlib.StoredTrainerName(body.Name)})
+	}
+}
+
+/*
+Function Name:  put_trainer
+Description:    method of http_gateway
+				PUT /trainer/{id}, replaces a trainer's pokemon under the
+				GlobalManager record write lock for that ID, then poke_lock
+				read lock to look them up
+				204 on success, 400 bad ID or body, 401 bad token (authorized), 404 trainer
+				not found, 422 pokemon not found, 500 write error
+Parameters:     w: response writer
+				r: request
+Return Value:   n/a
+Type:           http.ResponseWriter, *http.Request -> n/a
+*/
+func (gw *http_gateway) put_trainer(w http.ResponseWriter, r *http.Request) {
+	id, ok := path_id(r)
+	if !ok {
+		gw.fail(w, r, http.StatusBadRequest, "bad trainer ID")
 		return
 	}
-	if file_size%trainer_size != 0 { //gofmt pushes these together?
-		fmt.Printf("[%d] Error: file size is not a multiple of record size\n", src_port)
-		recordlib.ReallyWrite(client, "FILE_ERROR")
-		gm.UnlockReadAll()
+	body, msg := read_body(r, false)
+	if msg != "" {
+		gw.fail(w, r, http.StatusBadRequest, msg)
 		return
 	}
-	count := 0
-	idx := 1
-
-	recordlib.ReallyWrite(client, "SENDING")
-	for {
-		trainer, err := recordlib.GetTrainer(trainer_file, uint16(idx))
-		if err != nil {
-			if err.Error() == "trainer ID not found" {
-				idx++
-				continue //blank record from deletion
-			}
-			break //EOF
-		}
-		bytes, err := json.Marshal(trainer)
-		if err != nil {
-			fmt.Printf("[%d] Error in json encoding: %v\n", src_port, err)
-			recordlib.ReallyWrite(client, "SERVER_ERROR")
-			break
-		} else {
-			recordlib.ReallyWrite(client, string(bytes))
-			idx++
-			count++
-		}
-	}
-
-	gm.UnlockReadAll()
-	if count == 0 {
-		fmt.Printf("[%d] Client requested from empty file\n", src_port)
-		recordlib.ReallyWrite(client, "OUT_OF_BOUNDS")
-	} else {
-		recordlib.ReallyWrite(client, "DONE")
-		fmt.Printf("[%d] All Trainer records sent to client\n", src_port)
+	gw.gm.WLockRecord(id)
+	gw.poke_lock.RLock()
+	err := gw.store.Put(id, body.Pokemon)
+	gw.poke_lock.RUnlock()
+	gw.gm.WUnlockRecord(id)
+	switch {
+	case err == io.EOF || err == recordlib.ErrTrainerNotFound:
+		gw.fail(w, r, http.StatusNotFound, recordlib.ErrTrainerNotFound.Error())
+	case err == recordlib.ErrPokeNotFound:
+		gw.fail(w, r, http.StatusUnprocessableEntity, err.Error())
+	case err != nil:
+		gw.fail(w, r, http.StatusInternalServerError, err.Error())
+	default:
+		gw.respond(w, r, http.StatusNoContent, nil)
 	}
 }
 
 /*
-Function Name:  process_req_post_trainer
-Description:    parses a POST trainer request, validates name and pokemon IDs,
-                acquires global poke read lock and trainer write locking to
-                append, reply with id or status
-Parameters:     req: raw client request
-                client: client socket file for reply
-                src_port: client source port (for logging)
-                poke_file: pokemon binary file
-                trainer_file: trainer binary file
-                poke_lock: RW lock protecting poke_file
-                gm: record-level lock manager
+Function Name:  delete_trainer
+Description:    method of http_gateway
+				DELETE /trainer/{id}, deletes a trainer under the GlobalManager
+				record write lock for that ID
+				204 on success, 400 bad ID, 401 bad token (authorized), 404 not found,
+				500 write error
+Parameters:     w: response writer
+				r: request
 Return Value:   n/a
-Type:           string, *os.File, int, *os.File, *os.File, *sync.RWMutex, *recordlib.GlobalManager -> n/a
+Type:           http.ResponseWriter, *http.Request -> n/a
 */
-func process_req_post_trainer(req string, client *os.File, src_port int, poke_file *os.File, trainer_file *os.File, poke_lock *sync.RWMutex, gm *recordlib.GlobalManager) {
-	captures := recordlib.ReqPostTrainer.FindStringSubmatch(req)
-	log.Printf("[127.0.0.1:%d] %s", src_port, req)
-	if len(captures) > 0 {
-		var name string
-		var pokemon []uint16
-		for idx := 1; idx < len(captures); idx++ {
-			if idx == 1 {
-				name = captures[1]
-				if len(name) > 15 {
-					fmt.Printf("[%d] Refuse to post: name too long\n", src_port)
-					recordlib.ReallyWrite(client, "LONG_NAME")
-					break
-				}
-			} else {
-				if captures[idx] == "" {
-					break
-				}
-				num, err := strconv.Atoi(captures[idx])
-				if err != nil {
-					fmt.Printf("[%d] Error: %v\n", src_port, err)
-					recordlib.ReallyWrite(client, "SERVER_ERROR")
-					return
-				}
-				pokemon = append(pokemon, uint16(num))
-			}
-		}
-		if len(pokemon) == 0 {
-			return
-		}
-		gm.GlobalLock.RLock()
-		poke_lock.Lock()
-		id, err := recordlib.PostTrainer(trainer_file, poke_file, name, pokemon)
-		poke_lock.Unlock()
-		gm.GlobalLock.RUnlock()
-
-		if err != nil {
-			fmt.Printf("[%d] Error in PostTrainer: %v", src_port, err)
-			recordlib.ReallyWrite(client, "BAD_POST")
-		} else if id != 0 {
-			recordlib.ReallyWrite(client, strconv.Itoa(int(id)))
-			fmt.Printf("[%d] Post successful, trainer file modified, id sent to client", src_port)
-		}
+func (gw *http_gateway) delete_trainer(w http.ResponseWriter, r *http.Request) {
+	id, ok := path_id(r)
+	if !ok {
+		gw.fail(w, r, http.StatusBadRequest, "bad trainer ID")
+		return
+	}
+	gw.gm.WLockRecord(id)
+	err := gw.store.Delete(id)
+	gw.gm.WUnlockRecord(id)
+	switch {
+	case err == io.EOF || err == recordlib.ErrTrainerNotFound:
+		gw.fail(w, r, http.StatusNotFound, recordlib.ErrTrainerNotFound.Error())
+	case err != nil:
+		gw.fail(w, r, http.StatusInternalServerError, err.Error())
+	default:
+		gw.respond(w, r, http.StatusNoContent, nil)
 	}
 }
 
 /*
-Function Name:  process_req_put_trainer
-Description:    parses a PUT trainer request, trainer ID,
-                acquires global poke read lock and trainer write locking to
-                append, reply with id or status
-Parameters:     req: raw client request
-                client: client socket file for reply
-                src_port: client source port (for logging)
-                poke_file: pokemon binary file
-                trainer_file: trainer binary file
-                poke_lock: RW lock protecting poke_file
-                gm: record-level lock manager
-Return Value:   n/a
-Type:           string, *os.File, int, *os.File, *os.File, *sync.RWMutex, *recordlib.GlobalManager -> n/a
+Function Name:  start_http_gateway
+Description:    serves the HTTP/JSON frontend on host:port until shutdown
+				is closed, then stops it, waiting at most timeout for
+				in-flight requests
+Parameters:     host: IPv4 address to listen on
+				port: HTTP port
+				gw: store and locks shared with the socket handlers
+				shutdown: closed when the server shuts down
+				timeout: max time to wait for in-flight requests
+Return Value:   channel closed once the gateway has stopped, and listen error (if any)
+Type:           [4]byte, int, *http_gateway, <-chan struct{}, time.Duration -> <-chan struct{}, error
 */
-func process_req_put_trainer(req string, client *os.File, src_port int, poke_file *os.File, trainer_file *os.File, poke_lock *sync.RWMutex, gm *recordlib.GlobalManager) {
-	captures := recordlib.ReqPutTrainer.FindStringSubmatch(req)
-	log.Printf("[127.0.0.1:%d] %s\n", src_port, req)
-	if len(captures) > 0 {
-		var id uint16
-		var pokemon []uint16
-		for idx := 1; idx < len(captures); idx++ {
-			if idx == 1 {
-				num, err := strconv.Atoi(captures[idx])
-				if err == nil {
-					id = uint16(num)
-				} else {
-					fmt.Printf("[%d] Error: %v\n", src_port, err)
-					recordlib.ReallyWrite(client, "SERVER_ERROR")
-					return
-				}
-			} else {
-				if captures[idx] == "" {
-					break
-				}
-				num, err := strconv.Atoi(captures[idx])
-				if err != nil {
-					fmt.Printf("[%d] Error in Atoi: %v\n", src_port, err)
-					recordlib.ReallyWrite(client, "SERVER_ERROR")
-					return
-				}
-				pokemon = append(pokemon, uint16(num))
-			}
-		}
-		gm.WLockRecord(id)
-		poke_lock.Lock()
-		err := recordlib.PutTrainer(trainer_file, poke_file, id, pokemon)
-		poke_lock.Unlock()
-		gm.WUnlockRecord(id)
+func start_http_gateway(host [4]byte, port int, gw *http_gateway, shutdown <-chan struct{}, timeout time.Duration) (<-chan struct{}, error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /pokemon/{id}", gw.get_pokemon)
+	mux.HandleFunc("GET /trainer/{id}", gw.get_trainer)
+	mux.HandleFunc("GET /trainers", gw.get_trainers)
+	mux.HandleFunc("POST /trainer", gw.authorized(gw.post_trainer))
+	mux.HandleFunc("PUT /trainer/{id}", gw.authorized(gw.put_trainer))
+	mux.HandleFunc("DELETE /trainer/{id}", gw.authorized(gw.delete_trainer))
 
-		if err != nil {
-			fmt.Printf("[%d] Error in PutTrainer: %v\n", src_port, err)
-			err_msg := fmt.Sprintf("BAD_PUT.%s", err)
-			recordlib.ReallyWrite(client, err_msg)
-		} else if id != 0 {
-			recordlib.ReallyWrite(client, "GOOD_PUT")
-			fmt.Printf("[%d] Put successful, trainer file modified", src_port)
-		}
+	ln, err := net.Listen("tcp4", net.JoinHostPort(net.IP(host[:]).String(), strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
 	}
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	stopped := make(chan struct{})
+	go func() {
+		if err := srv.Serve(ln); err != http.ErrServerClosed {
+			fmt.Printf("HTTP gateway stopped: %v\n", err)
+		}
+	}()
+	go func() {
+		defer close(stopped)
+		<-shutdown
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			fmt.Printf("HTTP gateway shutdown: %v\n", err)
+		}
+	}()
+	return stopped, nil
 }
 
 /*
-Function Name:  process_req_delete_trainer
-Description:    parses a DELETE trainer request, lock the specific trainer record
-                using global manager, perform logical deletion, reply with result
-Parameters:     req: raw client request
-                client: client socket file for reply
-                src_port: client source port (for logging)
-                trainer_file: trainer binary file
-                gm: record-level lock manager
+Function Name:  shutdown_clients
+Description:    sends BYE to every connected client at once, each from its
+				own goroutine so one stuck client can't stall the rest, then
+				drains client_done (closing each exited client) and refuses
+				new connections until every handler returned or timeout
+				passed, when the clients still connected are closed, a
+				client that already left on EOF is just drained
+Parameters:     clients: connected clients, emptied as they exit
+				client_done: handlers send their client here when they return
+				new_client: accepted connections still arriving, closed
+				handlers: outstanding handle_client goroutines
+				timeout: -shutdown-timeout
 Return Value:   n/a
-Type:           string, *os.File, int, *os.File, *recordlib.GlobalManager -> n/a
+Type:           map[recordlib.Conn]bool, <-chan recordlib.Conn, <-chan accepted_conn, *sync.WaitGroup, time.Duration -> n/a
 */
-func process_req_delete_trainer(req string, client *os.File, src_port int, trainer_file *os.File, gm *recordlib.GlobalManager) {
-	captures := recordlib.ReqDelTrainer.FindStringSubmatch(req)
-	log.Printf("[127.0.0.1:%d] %s\n", src_port, req)
-	if len(captures) > 0 {
-		id, _ := strconv.Atoi(captures[1])
-		gm.WLockRecord(uint16(id))
-		if err := recordlib.DeleteTrainer(trainer_file, uint16(id)); err != nil {
-			fmt.Printf("[%d] Error in DeleteTrainer: %v\n", src_port, err)
-			recordlib.ReallyWrite(client, "OUT_OF_BOUNDS")
-		} else {
-			recordlib.ReallyWrite(client, "DELETED")
-			fmt.Printf("[%d] Logically deleted record, trainer file modified\n", src_port)
+func shutdown_clients(clients map[recordlib.Conn]bool, client_done <-chan recordlib.Conn, new_client <-chan accepted_conn, handlers *sync.WaitGroup, timeout time.Duration) {
+	for client := range clients {
+		go recordlib.ReallyWrite(client, "BYE")
+	}
+
+	handlers_done := make(chan struct{})
+	go func() {
+		handlers.Wait()
+		close(handlers_done)
+	}()
+
+	//keep draining client_done so no handler blocks on exit
+	deadline := time.After(timeout)
+	for {
+		select {
+		case client := <-client_done:
+			client.Close()
+			delete(clients, client)
+		case conn := <-new_client:
+			conn.sock.Close()
+		case <-handlers_done:
+			return
+		case <-deadline:
+			fmt.Printf("Shutdown timeout, forcing %d client(s) closed\n", len(clients))
+			for client := range clients {
+				client.Close()
+			}
+			return
 		}
-		gm.WUnlockRecord(uint16(id))
 	}
 }
 
 /*
-Function Name:  process_req_get_log
-Description:    parses a GET log N request, read last N log entries
-                sends back logs or error status
-Parameters:     req: raw client request
-                client: client socket file for reply
-                src_port: client source port (for logging)
-                log_file: server log file
-                log_lock: mutex protecting log_file
+Function Name:  accept_clients
+Description:    accept loop, hands each accepted connection (TLS wrapped, no
+				I/O yet, if tls_cfg is set) to the clients manager, retries
+				transient Accept errors (EINTR, out of fds, ...) with a
+				backoff doubling from 5ms to 1s, returns on shutdown or any
+				other Accept error
+Parameters:     accept: the listener's Accept
+				tls_cfg: config from -cert/-key, nil for plaintext
+				new_client: the clients manager's accepted connections
+				shutdown: closed once server begins shutting down
+				accept_done: closed once the manager has returned
 Return Value:   n/a
-Type:           string, *os.File, int, *os.File, *sync.Mutex -> n/a
+Type:           func() (recordlib.Conn, [4]byte, int, error), *tls.Config, chan<- accepted_conn, <-chan struct{}, <-chan struct{} -> n/a
 */
-func process_req_get_log(req string, client *os.File, src_port int, log_file *os.File, log_lock *sync.Mutex) {
-	captures := recordlib.ReqGetLogN.FindStringSubmatch(req)
-	log.Printf("[127.0.0.1:%d] %s\n", src_port, req)
-	if len(captures) > 0 {
-		n, _ := strconv.Atoi(captures[1])
-		log_lock.Lock()
-		logs, err := recordlib.LogReadN(log_file, n)
+func accept_clients(accept func() (recordlib.Conn, [4]byte, int, error), tls_cfg *tls.Config, new_client chan<- accepted_conn, shutdown <-chan struct{}, accept_done <-chan struct{}) {
+	backoff := 5 * time.Millisecond
+	for {
+		client_sock, client_ip, client_port, err := accept()
 		if err != nil {
-			fmt.Printf("[%d] Error in GetLog: %v\n", src_port, err)
-			recordlib.ReallyWrite(client, "SERVER_ERROR")
-		} else {
-			recordlib.ReallyWrite(client, logs)
-			fmt.Printf("[%d] Requested logs sent to client\n", src_port)
+			select {
+			case <-shutdown:
+				return //listener closed by shutdown
+			default:
+			}
+			if recordlib.IsRetriableAcceptError(err) {
+				//e.g. EINTR or out of fds, back off so EMFILE doesn't spin
+				log.Printf("Accept failed, retrying in %v: %v\n", backoff, err)
+				time.Sleep(backoff)
+				backoff = min(2*backoff, time.Second)
+				continue
+			}
+			fmt.Printf("Server stopped accepting: %v\n", err)
+			return
+		}
+		backoff = 5 * time.Millisecond
+		if tls_cfg != nil { //no I/O yet, the handshake runs in the client's goroutine
+			client_sock, err = recordlib.WrapServerTLS(client_sock, tls_cfg)
+			if err != nil {
+				log.Printf("[%s:%d] TLS wrap failed: %v\n", net.IP(client_ip[:]).String(), client_port, err)
+				continue
+			}
+		}
+
+		//manager decides whether to serve or turn away the client
+		select {
+		case new_client <- accepted_conn{sock: client_sock, ip: client_ip, port: client_port}:
+		case <-accept_done: //manager already returned
+			client_sock.Close()
+			return
 		}
-		log_lock.Unlock()
 	}
 }
 
@@ -400,97 +3961,332 @@ func process_req_get_log(req string, client *os.File, src_port int, log_file *os
 Function Name:  handle_client
 Description:	handles client requests, concurrent handling of clients
 				error logging and recovery from potential panics
+				tracks per-connection stats for the lifecycle log
 Parameters:		src_port: source port of client connection
+				src_ip: source address of client connection
 				client: client's socket file stream
 				poke_file: file to read pokemon records from
-				trainer_file: file to read trainer records from
+				trainer_file: trainer binary file, only read directly by verify
+				store: trainer record store
 				log_file: file to write logs to and read from
-				poke_lock: mutex lock for pokemon file access
+				shared_poke_lock: mutex lock for pokemon file access
 				gm: global manager for mutex locks for trainer file access
 				log_lock: mutex lock for log file access
 				client_exit: channel to send to client to exit
+				shutdown: closed once server begins shutting down
+				secret: token for AUTH, "" leaves mutations open and disables admin commands
+				name_index: pokemon name index
+				index_path: name index dump file
+				poke_cache: in-memory pokemon records, nil with -no-cache
+				metrics: server request counters
+				scan_timeout: longest a trainer listing may hold the read-all lock, 0 for no limit
+				lock_timeout: longest a single record write waits for its lock, 0 for no limit
+				post_keys: idempotency keys of recent posts, shared by every client
+				trainer_index: pokemon -> trainers reverse index, nil with -no-trainer-index
+				conn_id: connection number for log correlation
 Return Value:   n/a
-Type:           int, *os.File, *os.File, *os.File, *os.File, *sync.RWMutex, *recordlib.GlobalManager, *sync.Mutex, chan<- *os.File -> n/a
+Type:           int, string, recordlib.Conn, *os.File, *os.File, recordlib.TrainerStore, *os.File, *sync.RWMutex, *recordlib.GlobalManager, *sync.Mutex, chan<- recordlib.Conn, <-chan struct{}, string, *recordlib.PokeNameIndex, string, *recordlib.PokeCache, *server_metrics, time.Duration, time.Duration, *post_keys, *recordlib.TrainerPokeIndex, uint64 -> n/a
 */
-func handle_client(src_port int, client *os.File, poke_file *os.File, trainer_file *os.File, log_file *os.File, poke_lock *sync.RWMutex, gm *recordlib.GlobalManager, log_lock *sync.Mutex, client_exit chan<- *os.File) {
+func handle_client(src_port int, src_ip string, client recordlib.Conn, poke_file *os.File, trainer_file *os.File, store recordlib.TrainerStore, log_file *os.File, shared_poke_lock *sync.RWMutex, gm *recordlib.GlobalManager, log_lock *sync.Mutex, client_exit chan<- recordlib.Conn, shutdown <-chan struct{}, secret string, name_index *recordlib.PokeNameIndex, index_path string, poke_cache *recordlib.PokeCache, metrics *server_metrics, scan_timeout time.Duration, lock_timeout time.Duration, post_keys *post_keys, trainer_index *recordlib.TrainerPokeIndex, conn_id uint64) {
+	sess := &session{
+		addr:    fmt.Sprintf("%s:%d", src_ip, src_port),
+		start:   time.Now(),
+		reason:  "EOF",
+		conn_id: conn_id,
+		scan_timeout: scan_timeout,
+		lock_timeout: lock_timeout,
+	}
+	sess.log_connect()
+	gm = gm.Track() //remembers the locks the current request holds
+	poke_lock := recordlib.TrackRWMutex(shared_poke_lock)
 	defer func() {
 		if r := recover(); r != nil {
-			log.Printf("[%d] Recovered from panic in client handler: %v", src_port, r)
+			log.Printf("%s [%d] Recovered from panic in client handler: %v", sess.trace_tag(), src_port, r)
+			sess.reason = "error"
+		}
+		//a panic between lock and unlock, poke_lock is taken last so it goes first
+		if released := poke_lock.ReleaseHeld(); released > 0 {
+			log.Printf("%s [%d] Released %d pokemon locks left held", sess.trace_tag(), src_port, released)
 		}
+		if released := gm.ReleaseHeld(); released > 0 {
+			log.Printf("%s [%d] Released %d record locks left held", sess.trace_tag(), src_port, released)
+		}
+		sess.log_disconnect()
 		client_exit <- client
 	}()
 
+	if err := recordlib.HandshakeTLS(client, tls_handshake_timeout); err != nil { //no-op without -cert
+		log.Printf("%s [%d] TLS handshake failed: %v\n", sess.trace_tag(), src_port, err)
+		sess.reason = "error"
+		return
+	}
+
+	recordlib.ReallyWrite(client, recordlib.Handshake())
 	recordlib.ReallyWrite(client, strconv.Itoa(src_port))
+	var ahead *read_result //frame a handler already read (log follow), handled before reading on
 	for {
-		req, err := recordlib.ReallyRead(client)
+		var req string
+		var err error
+		if ahead != nil {
+			req, err, ahead = ahead.req, ahead.err, nil
+		} else {
+			req, err = recordlib.ReallyRead(client)
+		}
 		if err != nil {
 			if err == io.EOF {
-				log.Printf("[127.0.0.1:%d] Client disconnected (EOF).\n", src_port)
+				sess.reason = "EOF"
 				return
 			}
+			if err == recordlib.ErrChecksum { //frame fully read, stream still in step
+				log.Printf("%s [%s] Request frame failed checksum\n", sess.trace_tag(), sess.addr)
+				sess.reply(client, "BAD_CHECKSUM")
+				continue
+			}
 			fmt.Printf("[%d] Error on read: %v\n", src_port, err)
+			sess.reason = "error"
+			return
 		}
 
+		sess.seq++
+		if req != "EXIT" {
+			sess.requests++
+		}
+		sess.t = req_timing{start: time.Now()}
+		var kind *atomic.Uint64 //per-type metrics counter, nil if none
 		switch {
 		case req == "EXIT":
 			fmt.Printf("\r")
-			log.Printf("[127.0.0.1:%d] Client disconnected.\n", src_port)
-			client_exit <- client
-			return
+			select {
+			case <-shutdown:
+				sess.reason = "BYE" //acknowledging server shutdown
+			default:
+				sess.reason = "EXIT"
+			}
+			return //deferred func reports exit exactly once
+
+		case recordlib.ReqPing.MatchString(req): //ping, liveness check, no locks or files
+			sess.reply(client, "PONG")
+
+		case recordlib.ReqHello.MatchString(req): //client -b flag on connect
+			process_req_hello(req, client, src_port, sess)
+
+		case recordlib.ReqAuth.MatchString(req): //client -secret flag on connect
+			process_req_auth(req, client, src_port, sess, secret)
+
+		case recordlib.ReqTiming.MatchString(req): //client --timing flag on connect
+			process_req_timing(req, client, src_port, sess)
+
+		case recordlib.ReqTrace.MatchString(req): //client --trace flag on connect
+			process_req_trace(req, client, src_port, sess)
+
+		case recordlib.ReqCompress.MatchString(req): //client --compress flag on connect
+			process_req_compress(req, client, src_port, sess)
+
+		case needs_auth(req, secret, sess): //mutation before AUTH on a -secret server
+			process_req_unauthorized(req, client, src_port, sess)
+
+		case recordlib.ReqMetrics.MatchString(req): //get metrics
+			process_req_metrics(req, client, src_port, metrics, sess)
+
+		case recordlib.ReqFsck.MatchString(req): //verify
+			process_req_fsck(req, client, src_port, poke_file, trainer_file, poke_lock, gm, sess)
+
+		case recordlib.ReqValidateRefs.MatchString(req): //verify refs [repair]
+			process_req_validate_refs(req, client, src_port, store, poke_file, trainer_file, poke_lock, gm, sess)
+
+		case recordlib.ReqStatus.MatchString(req): //get status
+			process_req_status(req, client, src_port, metrics, shutdown, sess)
+
+		case recordlib.ReqWhoAmI.MatchString(req): //whoami
+			process_req_whoami(req, client, src_port, metrics, sess)
+
+		case recordlib.ReqSchema.MatchString(req): //describe pokemon|trainer
+			process_req_schema(req, client, src_port, sess)
+
+		case recordlib.ReqPokeMulti.MatchString(req): //get pokemon ids _,_
+			kind = &metrics.gets
+			process_req_get_poke_multi(req, client, src_port, poke_file, poke_cache, poke_lock, sess)
 
 		case recordlib.ReqGetPokeID.MatchString(req): //get pokemon _
-			process_req_get_poke(req, client, src_port, poke_file, poke_lock)
+			kind = &metrics.gets
+			process_req_get_poke(req, client, src_port, poke_file, poke_cache, poke_lock, sess)
+
+		case recordlib.ReqRandomPoke.MatchString(req): //random pokemon
+			kind = &metrics.gets
+			process_req_random_poke(req, client, src_port, poke_file, poke_cache, poke_lock, sess)
+
+		case recordlib.ReqTopPoke.MatchString(req): //get pokemon top _
+			kind = &metrics.gets
+			process_req_top_poke(req, client, src_port, poke_file, poke_lock, sess)
+
+		case recordlib.ReqPokeSimilar.MatchString(req): //get pokemon similar _
+			kind = &metrics.gets
+			process_req_poke_similar(req, client, src_port, poke_file, poke_lock, sess)
+
+		case recordlib.ReqPokeAgg.MatchString(req): //get pokemon agg
+			kind = &metrics.gets
+			process_req_poke_agg(req, client, src_port, poke_file, poke_lock, sess)
+
+		case recordlib.ReqPokeTypes.MatchString(req): //get pokemon types
+			kind = &metrics.gets
+			process_req_poke_types(req, client, src_port, poke_file, poke_lock, sess)
+
+		case recordlib.ReqGetPokeFilter.MatchString(req): //get pokemon where ...
+			kind = &metrics.gets
+			process_req_poke_filter(req, client, src_port, poke_file, poke_lock, sess)
+
+		case recordlib.ReqGetPokeName.MatchString(req): //get pokename _
+			kind = &metrics.gets
+			process_req_get_poke_name(req, client, src_port, poke_file, poke_lock, sess)
+
+		case recordlib.ReqPokeRaw.MatchString(req): //get pokemon raw _
+			kind = &metrics.gets
+			process_req_get_poke_raw(req, client, src_port, poke_file, poke_lock, sess)
+
+		case recordlib.ReqPokeNameList.MatchString(req): //sent by client on connect
+			process_req_poke_name_list(req, client, src_port, name_index, sess)
 
 		case recordlib.ReqGetTrainerID.MatchString(req): //get trainer _
-			process_req_get_trainer(req, client, src_port, trainer_file, gm)
+			kind = &metrics.gets
+			process_req_get_trainer(req, client, src_port, store, gm, sess)
+
+		case recordlib.ReqRandomTrainer.MatchString(req): //random trainer
+			kind = &metrics.gets
+			process_req_random_trainer(req, client, src_port, store, gm, sess)
+
+		case recordlib.ReqTrainerWithPoke.MatchString(req): //get trainers using _
+			kind = &metrics.gets
+			process_req_trainer_with_poke(req, client, src_port, store, trainer_index, gm, poke_file, poke_cache, poke_lock, sess)
+
+		case recordlib.ReqTrainerExpand.MatchString(req): //get trainer _ full
+			kind = &metrics.gets
+			process_req_trainer_expand(req, client, src_port, store, gm, poke_file, poke_cache, poke_lock, sess)
 
 		case recordlib.ReqGetTrainerAll.MatchString(req): //get trainer
-			process_req_get_trainer_all(req, client, src_port, trainer_file, gm)
+			kind = &metrics.gets
+			process_req_get_trainer_all(req, client, src_port, store, gm, sess)
+
+		case recordlib.ReqGetTrainerAllSorted.MatchString(req): //get trainer sort _ _
+			kind = &metrics.gets
+			process_req_get_trainer_all_sorted(req, client, src_port, store, gm, sess)
+
+		case recordlib.ReqExportTrainers.MatchString(req): //export trainers _
+			kind = &metrics.gets
+			process_req_export_trainers(req, client, src_port, store, gm, sess)
 
 		case recordlib.ReqPostTrainer.MatchString(req): //post trainer _ _ ...
-			process_req_post_trainer(req, client, src_port, poke_file, trainer_file, poke_lock, gm)
+			kind = &metrics.posts
+			process_req_post_trainer(req, client, src_port, store, poke_lock, gm, post_keys, sess)
+
+		case recordlib.ReqBulkPost.MatchString(req): //import trainers _
+			kind = &metrics.posts
+			process_req_bulk_post(req, client, src_port, store, poke_lock, gm, sess)
 
 		case recordlib.ReqPutTrainer.MatchString(req): //put trainer _ _ ...
-			process_req_put_trainer(req, client, src_port, poke_file, trainer_file, poke_lock, gm)
+			kind = &metrics.puts
+			process_req_put_trainer(req, client, src_port, store, poke_lock, gm, sess)
+
+		case recordlib.ReqAppendTrainer.MatchString(req): //add trainer _ _ ...
+			kind = &metrics.puts
+			process_req_append_trainer(req, client, src_port, store, poke_lock, gm, sess)
+
+		case recordlib.ReqRemoveTrainerPoke.MatchString(req): //remove trainer _ _
+			kind = &metrics.puts
+			process_req_remove_trainer_poke(req, client, src_port, store, poke_lock, gm, sess)
+
+		case recordlib.ReqSwap.MatchString(req): //swap _ _ _ _
+			kind = &metrics.puts
+			process_req_swap(req, client, src_port, store, poke_lock, gm, sess)
+
+		case recordlib.ReqMovePoke.MatchString(req): //move _ _ _
+			kind = &metrics.puts
+			process_req_move(req, client, src_port, store, poke_lock, gm, sess)
+
+		case recordlib.ReqPatchPoke.MatchString(req): //patch pokemon _ _ _
+			kind = &metrics.puts
+			process_req_patch_poke(req, client, src_port, poke_file, poke_cache, poke_lock, sess)
+
+		case recordlib.ReqDelTrainerWhere.MatchString(req): //delete trainer where _
+			kind = &metrics.deletes
+			process_req_delete_where(req, client, src_port, store, gm, sess)
 
 		case recordlib.ReqDelTrainer.MatchString(req): //delete trainer _
-			process_req_delete_trainer(req, client, src_port, trainer_file, gm)
+			kind = &metrics.deletes
+			process_req_delete_trainer(req, client, src_port, store, gm, sess)
 
 		case recordlib.ReqGetLogN.MatchString(req):
-			process_req_get_log(req, client, src_port, log_file, log_lock)
+			process_req_get_log(req, client, src_port, log_file, log_lock, sess)
+
+		case recordlib.ReqGetLogAllN.MatchString(req):
+			process_req_get_log_all(req, client, src_port, log_file, log_lock, sess)
+
+		case recordlib.ReqLogFilter.MatchString(req): //get log port|cmd _ _
+			process_req_log_filter(req, client, src_port, log_file, log_lock, sess)
+
+		case recordlib.ReqLogFollow.MatchString(req): //tail log
+			ahead = process_req_log_follow(req, client, src_port, log_file, log_lock, shutdown, sess)
+
+		case recordlib.ReqLogSince.MatchString(req): //get log since _
+			process_req_log_since(req, client, src_port, log_file, log_lock, sess)
+
+		case recordlib.ReqClearLog.MatchString(req): //clear log
+			process_req_clear_log(req, client, src_port, log_file, log_lock, sess)
+
+		case recordlib.ReqDumpIndex.MatchString(req): //dump index
+			process_req_dump_index(req, client, src_port, name_index, index_path, sess)
+
+		case recordlib.ReqReloadIndex.MatchString(req): //reload index
+			process_req_reload_index(req, client, src_port, name_index, index_path, poke_file, poke_lock, sess)
 
 		default:
-			log.Printf("[127.0.0.1:%d] Request didn't match valid options\n", src_port) //regexp didn't match, invalid arg from client
-			recordlib.ReallyWrite(client, "CLIENT_REQ_INVALID")
+			log.Printf("%s [%s] Request didn't match valid options\n", sess.trace_tag(), sess.addr) //regexp didn't match, invalid arg from client
+			sess.reply(client, "CLIENT_REQ_INVALID")
+		}
+
+		//every request gets exactly one final reply, a handler that sent
+		//none (submatch found nothing despite MatchString) must not leave
+		//the client blocked waiting on it
+		if sess.t.status == "" {
+			log.Printf("%s [%s] Handler sent no reply, sending SERVER_ERROR\n", sess.trace_tag(), sess.addr)
+			sess.reply(client, "SERVER_ERROR")
+		}
+		//locks are scoped to the request, one a handler returned without
+		//releasing (an early return before its unlock) would block that
+		//record for every other client
+		if released := poke_lock.ReleaseHeld(); released > 0 {
+			log.Printf("%s [%s] Handler returned holding %d pokemon locks, released\n", sess.trace_tag(), sess.addr, released)
+		}
+		if released := gm.ReleaseHeld(); released > 0 {
+			log.Printf("%s [%s] Handler returned holding %d record locks, released\n", sess.trace_tag(), sess.addr, released)
 		}
+		metrics.count(kind, sess.t.status)
+
+		//access log completion line, the request line itself is logged by the handler
+		command, _, _ := strings.Cut(req, " ")
+		command, _, _ = strings.Cut(command, "\n") //BULK_POST_TRAINER rows
+		log.Printf("%s [%s] %s completed in %.3fms status=%s\n", sess.trace_tag(), sess.addr, command,
+			float64(time.Since(sess.t.start).Microseconds())/1000, sess.t.status)
 	}
 }
 
 func main() {
-	port, poke_file_name, trainer_file_name, log_file_name, err := get_opts()
+	opts, err := get_opts()
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 		fmt.Printf("Usage:\n")
 		flag.PrintDefaults()
-		unix.Exit(1) //doesn't have to return, no deferred cleanup
+		os.Exit(1) //doesn't have to return, no deferred cleanup
 	}
-	if port < 10000 || port > 65535 {
+	if opts.port < 10000 || opts.port > 65535 {
 		fmt.Printf("Error: Invalid port number!\n")
-		unix.Exit(1)
+		os.Exit(1)
 	}
 
-	//set up and open the binary data files
-	poke_fd, err := unix.Open(poke_file_name, unix.O_RDONLY, 0644)
+	//set up and open the binary data files, pokemon read/write for PATCH_POKE
+	poke_file, err := open_poke_file(opts.poke_file_name, opts.data_dir)
 	if err != nil {
-		log.Fatalf("Error: Failed to open pokemon bin file!\n%v", err)
-	}
-	poke_file := os.NewFile(uintptr(poke_fd), poke_file_name)
-	if poke_file == nil {
-		if err := unix.Close(poke_fd); err != nil {
-			log.Printf("Error: Failed to close poke_fd!\n%v", err)
-		}
-		log.Println("Error: Failed to wrap poke_fd into File!")
-		return
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
 	}
 	defer func() {
 		if err := poke_file.Close(); err != nil {
@@ -498,39 +4294,56 @@ func main() {
 		} //poke_fd closed on poke_file.Close()
 	}()
 
-	trainer_fd, err := unix.Open(trainer_file_name, unix.O_RDWR|unix.O_CREAT, 0644)
+	trainer_file, err := recordlib.OpenFile(opts.trainer_file_name, os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
 		log.Printf("Error: Failed to open trainer bin file!\n%v", err)
 		return
 	}
-	trainer_file := os.NewFile(uintptr(trainer_fd), trainer_file_name)
-	if trainer_file == nil {
-		if err := unix.Close(trainer_fd); err != nil {
-			log.Printf("Error: Failed to close trainer_fd!\n%v", err)
-		}
-		log.Println("Error: Failed to wrap trainer_fd into File!")
-		return
+	if err := recordlib.LockFile(trainer_file); err != nil {
+		fmt.Printf("Error: another server is using these files (%s): %v\n", opts.trainer_file_name, err)
+		os.Exit(1) //refuse to start, fds and any lock taken are released on exit
+	}
+	if err := prepare_trainer_file(trainer_file, opts.migrate); err != nil {
+		fmt.Printf("Error: trainer file %s: %v\n", opts.trainer_file_name, err)
+		os.Exit(1)
 	}
 	defer func() {
+		if err := recordlib.UnlockFile(trainer_file); err != nil {
+			log.Printf("Error: Failed to unlock trainer bin file!\n%v", err)
+		}
 		if err := trainer_file.Close(); err != nil {
 			log.Printf("Error: Failed to close trainer bin file!\n%v", err)
 		} //trainer_fd closed on trainer_file.Close()
 	}()
+	wal, err := open_wal(trainer_file, opts.trainer_file_name+".wal", opts.wal)
+	if err != nil {
+		fmt.Printf("Error: write-ahead log %s.wal: %v\n", opts.trainer_file_name, err)
+		os.Exit(1)
+	}
+	if wal != nil {
+		defer func() { //after the Syncer is closed, before the trainer file is
+			if err := recordlib.CheckpointWAL(wal, trainer_file); err != nil {
+				log.Printf("Error: Failed to checkpoint write-ahead log!\n%v", err)
+			}
+			if err := wal.Close(); err != nil {
+				log.Printf("Error: Failed to close write-ahead log!\n%v", err)
+			}
+		}()
+	}
 
-	log_fd, err := unix.Open(log_file_name, unix.O_APPEND|unix.O_RDWR|unix.O_CREAT, 0644)
+	log_file, err := recordlib.OpenFile(opts.log_file_name, os.O_APPEND|os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
 		log.Printf("Error: Failed to open log file!\n%v", err)
 		return
 	}
-	log_file := os.NewFile(uintptr(log_fd), log_file_name)
-	if log_file == nil {
-		if err := unix.Close(log_fd); err != nil {
-			log.Printf("Error: Failed to close log_fd!\n%v", err)
-		}
-		log.Println("Error: Failed to wrap log_fd into File!")
-		return
+	if err := recordlib.LockFile(log_file); err != nil {
+		fmt.Printf("Error: another server is using these files (%s): %v\n", opts.log_file_name, err)
+		os.Exit(1) //refuse to start, fds and any lock taken are released on exit
 	}
 	defer func() {
+		if err := recordlib.UnlockFile(log_file); err != nil {
+			log.Printf("Error: Failed to unlock log file!\n%v", err)
+		}
 		if err := log_file.Close(); err != nil {
 			log.Printf("Error: Failed to close log file!\n%v", err)
 		} //log_fd closed on log_file.Close()
@@ -539,80 +4352,155 @@ func main() {
 	mw := io.MultiWriter(os.Stdout, log_file)
 	log.SetOutput(mw)
 	var poke_lock sync.RWMutex
-	gm := recordlib.NewGlobalManager()
-	var log_lock sync.Mutex //log always written to then read
+	gm := recordlib.NewGlobalManager(opts.lock_policy)
+	file_store := recordlib.NewFileTrainerStore(trainer_file, poke_file)
+	file_store.WAL = wal
+	if opts.sync_mode != recordlib.SyncAlways {
+		file_store.Syncer = recordlib.NewSyncer(trainer_file, opts.sync_mode, opts.sync_every, opts.sync_interval)
+		file_store.Syncer.WAL = wal
+		defer func() { //before the trainer file is closed
+			if err := file_store.Syncer.Close(); err != nil {
+				log.Printf("Error: Failed to sync trainer bin file!\n%v", err)
+			}
+		}()
+		fmt.Printf("Trainer writes synced in %s mode\n", opts.sync_mode)
+	}
+	var store recordlib.TrainerStore = file_store
+	if opts.trainer_cache > 0 {
+		store = recordlib.NewTrainerCache(store, opts.trainer_cache)
+	}
+	//outermost, so every write made through store also updates the index
+	var trainer_index *recordlib.TrainerPokeIndex
+	if !opts.no_trainer_index {
+		trainer_index, err = recordlib.NewTrainerPokeIndex(store)
+		if err != nil {
+			fmt.Printf("Error: Failed to build trainer index!\n%v\n", err)
+			return
+		}
+		if len(trainer_index.Mismatched) > 0 {
+			fmt.Printf("Warning: %d trainer records hold another ID and are left out of the trainer index: %v\n", len(trainer_index.Mismatched), trainer_index.Mismatched)
+			fmt.Printf("Check it with ./inspect %s trainer, requests for those IDs fail until it is repaired\n", opts.trainer_file_name)
+		}
+		store = trainer_index
+	}
 
-	//use socket, serve on localhost:port
-	sock_fd, err := unix.Socket(unix.AF_INET, unix.SOCK_STREAM, 0)
+	//name index dump lives next to the pokemon file, rescan only if missing or stale
+	index_path := opts.poke_file_name + ".idx"
+	name_index := &recordlib.PokeNameIndex{}
+	loaded, err := name_index.LoadOrRebuild(index_path, poke_file)
 	if err != nil {
-		fmt.Printf("Error: Failed to create socket!\n%v", err)
+		fmt.Printf("Error: Failed to build pokemon name index!\n%v\n", err)
 		return
 	}
-	defer func() {
-		if err := unix.Close(sock_fd); err != nil {
-			fmt.Printf("Error: Failed to close server socket!\n%v", err)
+	if loaded {
+		fmt.Printf("Pokemon name index loaded from %s\n", index_path)
+	}
+	var log_lock sync.Mutex //log always written to then read
+
+	//loaded once here, PATCH_POKE is the only pokemon write and keeps it in step through PokeCache.Put
+	var poke_cache *recordlib.PokeCache
+	if !opts.no_cache {
+		poke_cache, err = recordlib.NewPokeCache(poke_file)
+		if err != nil {
+			fmt.Printf("Error: Failed to load pokemon cache!\n%v\n", err)
+			return
 		}
-	}()
+		fmt.Printf("Pokemon cache loaded (%d records)\n", poke_cache.Len())
+	}
 
-	host := [4]byte{127, 0, 0, 1} //localhost
-	addr := &unix.SockaddrInet4{Addr: host, Port: port}
-	if err := unix.Bind(sock_fd, addr); err != nil {
-		fmt.Printf("Error: Failed to bind socket!\n%v", err)
+	//use socket, serve on host:port
+	var listener *recordlib.Listener
+	if opts.net_transport {
+		listener, err = recordlib.ListenNet(opts.host, opts.port)
+	} else {
+		listener, err = recordlib.Listen(opts.host, opts.port, 10)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
 		return
 	}
-	if err := unix.Listen(sock_fd, 10); err != nil {
-		fmt.Printf("Error: Failed to listen on socket!\n%v", err)
+	var listener_once sync.Once //closed on shutdown and again on return
+	close_listener := func() {
+		listener_once.Do(func() {
+			if err := listener.Close(); err != nil {
+				fmt.Printf("Error: Failed to close server socket!\n%v", err)
+			}
+		})
 	}
+	defer close_listener()
 
-	fmt.Printf("Listening on host - ")
-	for _, b := range host {
-		if b == host[3] {
-			fmt.Printf("%d", b)
-		} else {
-			fmt.Printf("%d.", b)
-		}
+	fmt.Printf("Listening on host - %s:%d\n", net.IP(opts.host[:]), opts.port)
+	if opts.tls != nil {
+		fmt.Println("TLS on, clients must connect with -tls")
 	}
-	fmt.Printf(":%d\n", port)
 
+	//SIGTERM from kill, systemd or docker stop takes the same graceful path as ^C
 	signal_chan := make(chan os.Signal, 1)
-	signal.Notify(signal_chan, unix.SIGINT)
+	signal.Notify(signal_chan, os.Interrupt, syscall.SIGTERM)
+	hup_chan := make(chan os.Signal, 1)
+	signal.Notify(hup_chan, syscall.SIGHUP)
 
-	new_client := make(chan *os.File)
-	client_done := make(chan *os.File)
+	new_client := make(chan accepted_conn)
+	client_done := make(chan recordlib.Conn)
 	accept_done := make(chan struct{})
+	shutdown := make(chan struct{})
+	var handlers sync.WaitGroup //outstanding handle_client goroutines
+	metrics := &server_metrics{start: time.Now(), conn_query: make(chan chan conn_counts)}
+	post_keys := new_post_keys(opts.post_key_ttl) //shared, a retry usually comes on a new connection
+
+	var http_done <-chan struct{} //nil when the gateway is off
+	if opts.http_port != 0 {
+		gw := &http_gateway{poke_file: poke_file, poke_cache: poke_cache, store: store, poke_lock: &poke_lock, gm: gm, secret: opts.secret}
+		http_done, err = start_http_gateway(opts.host, opts.http_port, gw, shutdown, opts.shutdown_timeout)
+		if err != nil {
+			fmt.Printf("Error: failed to start HTTP gateway: %v\n", err)
+			return
+		}
+		fmt.Printf("HTTP gateway on - %s:%d\n", net.IP(opts.host[:]).String(), opts.http_port)
+	}
 
 	go func() {
-		clients := make(map[*os.File]bool)
+		clients := make(map[recordlib.Conn]bool)
 		shutting_down := false
+		var next_conn_id uint64 //only this goroutine hands out connection IDs
 
 		for {
 			select {
-			case client := <-new_client:
+			case conn := <-new_client:
 				if shutting_down {
-					client.Close() //reject new clients during shutdown
+					conn.sock.Close() //reject new clients during shutdown
+				} else if len(clients) >= opts.max_clients {
+					log.Printf("[%s:%d] Rejected, at %d client limit\n", net.IP(conn.ip[:]).String(), conn.port, opts.max_clients)
+					go func(sock recordlib.Conn) { //don't stall the manager on a slow peer
+						recordlib.ReallyWrite(sock, "SERVER_BUSY")
+						sock.Close()
+					}(conn.sock)
 				} else {
-					clients[client] = true
+					clients[conn.sock] = true
+					next_conn_id++
+					conn_id := next_conn_id
+					handlers.Add(1) //manager never Adds once it starts waiting on shutdown
+					go func() {
+						defer handlers.Done()
+						handle_client(conn.port, net.IP(conn.ip[:]).String(), conn.sock, poke_file, trainer_file, store, log_file, &poke_lock, gm, &log_lock, client_done, shutdown, opts.secret, name_index, index_path, poke_cache, metrics, opts.scan_timeout, opts.lock_timeout, post_keys, trainer_index, conn_id)
+					}()
 				}
 
 			case client := <-client_done:
 				client.Close()
 				delete(clients, client)
 
-			case <-signal_chan:
+			case answer := <-metrics.conn_query: //get status
+				answer <- conn_counts{served: next_conn_id, active: len(clients)}
+
+			case sig := <-signal_chan:
 				fmt.Printf("\r")
-				log.Println("Interrupt received, shutting down server...")
+				log.Printf("Signal received (%v), shutting down server...\n", sig)
 				shutting_down = true
-				conns := len(clients)
-
-				if conns != 0 {
-					for client := range clients {
-						recordlib.ReallyWrite(client, "BYE")
-						if <-client_done != nil {
-							delete(clients, client)
-						}
-					}
-					fmt.Println("All clients disconnected.")
-				}
+				close(shutdown)
+				close_listener() //wakes the accept loop, nothing new is accepted
+				shutdown_clients(clients, client_done, new_client, &handlers, opts.shutdown_timeout)
+				fmt.Println("All clients disconnected.")
 				close(accept_done)
 				return
 			}
@@ -621,25 +4509,22 @@ func main() {
 
 	go func() {
 		for {
-			client_fd, client_addr, err := unix.Accept(sock_fd)
-			if err != nil {
-				fmt.Printf("Server stopped accepting: %v", err)
+			select {
+			case <-hup_chan:
+				rotate_log(log_file, &log_lock)
+			case <-shutdown:
 				return
 			}
-
-			client_port := client_addr.(*unix.SockaddrInet4).Port
-			log.Printf("Client connected - 127.0.0.1:%v\n", client_port)
-			client_sock := os.NewFile(uintptr(client_fd), "client_sock")
-			if client_sock == nil {
-				continue
-			}
-
-			new_client <- client_sock
-			go handle_client(client_port, client_sock, poke_file, trainer_file, log_file, &poke_lock, gm, &log_lock, client_done)
 		}
 	}()
 
+	go accept_clients(listener.Accept, opts.tls, new_client, shutdown, accept_done)
+
 	//wait for ALL clients
 	<-accept_done
+	if opts.http_port != 0 {
+		<-http_done //files close on return, let in-flight HTTP requests finish
+	}
 	signal.Stop(signal_chan)
+	signal.Stop(hup_chan)
 }
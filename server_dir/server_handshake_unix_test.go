@@ -0,0 +1,86 @@
+//go:build unix
+
+/*
+Filename:  server_handshake_unix_test.go
+Description:
+  - The connection handshake over a real socket pair (unix.Socketpair), the server end
+    wrapped in an *os.File the way the syscall transport wraps accepted sockets
+*/
+package main
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	"golang.org/x/sys/unix"
+
+	"project3/recordlib"
+)
+
+/*
+Function Name:  TestHandshakeSocketpair
+Description:    handle_client sends the handshake and then the port, the
+				client side accepts the handshake with CheckHandshake and a
+				request works after it, CheckHandshake refuses another major
+				version and a pre-handshake server's bare port
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestHandshakeSocketpair(t *testing.T) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := os.NewFile(uintptr(fds[0]), "server")
+	client := os.NewFile(uintptr(fds[1]), "client")
+	defer client.Close()
+
+	env := new_test_env(t)
+	exited := make(chan recordlib.Conn, 1)
+	go serve(env, env.store, server, exited)
+
+	handshake, err := recordlib.ReallyRead(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if handshake != recordlib.Handshake() {
+		t.Fatalf("first frame %q, want %q", handshake, recordlib.Handshake())
+	}
+	if err := recordlib.CheckHandshake(handshake); err != nil {
+		t.Fatalf("client refused the server's handshake: %v", err)
+	}
+	port, err := recordlib.ReallyRead(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := strconv.Atoi(port); err != nil {
+		t.Fatalf("second frame %q, want the port", port)
+	}
+	if err := recordlib.ReallyWrite(client, "PING"); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := recordlib.ReallyRead(client); err != nil || reply != "PONG" {
+		t.Fatalf("PING after the handshake: %q, %v", reply, err)
+	}
+	recordlib.ReallyWrite(client, "EXIT")
+	<-exited
+
+	tests := []struct {
+		msg string
+		ok  bool
+	}{
+		{"POKEDB/" + strconv.Itoa(recordlib.ProtocolMajor) + ".0", true},
+		{"POKEDB/" + strconv.Itoa(recordlib.ProtocolMajor) + ".999", true}, //newer minor, same commands and more
+		{"POKEDB/" + strconv.Itoa(recordlib.ProtocolMajor+1) + ".0", false},
+		{"POKEDB/" + strconv.Itoa(recordlib.ProtocolMajor-1) + "." + strconv.Itoa(recordlib.ProtocolMinor), false},
+		{"POKEDB/x.1", false},
+		{"40123", false}, //a server from before the handshake sends the port first
+	}
+	for _, tt := range tests {
+		if err := recordlib.CheckHandshake(tt.msg); (err == nil) != tt.ok {
+			t.Fatalf("CheckHandshake(%q): %v, want ok %v", tt.msg, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+//go:build unix
+
+/*
+Filename:  server_lock_unix_test.go
+Description:
+  - A second server started on trainer files another process has locked refuses to start,
+    run as a child process since startup ends in os.Exit (flock is a no-op off unix)
+*/
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"project3/recordlib"
+)
+
+/*
+Function Name:  TestSecondServerRefused
+Description:    holds the lock a running server would hold on the trainer file,
+				then starts the server on the same files in a child process:
+				it exits 1 naming the trainer file and leaves it unchanged,
+				in the child (POKEDB_TEST_SERVER set) this runs main
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestSecondServerRefused(t *testing.T) {
+	if dir := os.Getenv("POKEDB_TEST_SERVER"); dir != "" {
+		os.Args = []string{"server", "-p", "40123", "-d", dir, "-m", "poke.bin", "-t", "trainer.bin", "-l", "server.log"}
+		main()
+		os.Exit(0) //main returned, the server started
+	}
+
+	dir := t.TempDir()
+	data, err := os.ReadFile("../poke.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "poke.bin"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	trainer_path := filepath.Join(dir, "trainer.bin")
+	first, err := os.OpenFile(trainer_path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	if err := recordlib.WriteHeader(first, recordlib.TrainerRecSize); err != nil {
+		t.Fatal(err)
+	}
+	if err := recordlib.LockFile(first); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.ReadFile(trainer_path)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second) //a server that did start is killed
+	defer cancel()
+	second := exec.CommandContext(ctx, os.Args[0], "-test.run=^TestSecondServerRefused$")
+	second.Env = append(os.Environ(), "POKEDB_TEST_SERVER="+dir)
+	var out bytes.Buffer
+	second.Stdout, second.Stderr = &out, &out
+	err = second.Run()
+	var exit *exec.ExitError
+	if !errors.As(err, &exit) || exit.ExitCode() != 1 {
+		t.Fatalf("second server: %v, want exit status 1, output:\n%s", err, out.String())
+	}
+	if !strings.Contains(out.String(), "another server is using these files ("+trainer_path+")") {
+		t.Fatalf("second server output doesn't name the locked file:\n%s", out.String())
+	}
+	if after, _ := os.ReadFile(trainer_path); !bytes.Equal(after, before) {
+		t.Fatal("refused server changed the trainer file")
+	}
+
+	//once the first one lets go, the lock is free again
+	if err := recordlib.UnlockFile(first); err != nil {
+		t.Fatal(err)
+	}
+	again, err := os.Open(trainer_path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.Close()
+	if err := recordlib.LockFile(again); err != nil {
+		t.Fatalf("lock after the first server released it: %v", err)
+	}
+}
@@ -0,0 +1,329 @@
+//go:build unix
+
+/*
+Filename:  server_main_unix_test.go
+Description:
+  - The whole server (main) run in a child process on files in a temporary directory and a
+    free loopback port, for what only main does: the client limit and signal shutdown
+  - The accept loop (accept_clients) on a stand-in Accept that fails with errnos
+  - The child is this test binary with POKEDB_TEST_MAIN set, TestMain then runs main with
+    those arguments instead of the tests
+*/
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"project3/recordlib"
+)
+
+/*
+Function Name:  TestMain
+Description:    runs main when started as a test server child, the tests
+				otherwise
+Parameters:     m: test runner
+Return Value:   n/a
+Type:           *testing.M -> n/a
+*/
+func TestMain(m *testing.M) {
+	if args := os.Getenv("POKEDB_TEST_MAIN"); args != "" {
+		os.Args = append([]string{"server"}, strings.Split(args, "\n")...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+//a server child process and what it printed so far
+type test_server struct {
+	cmd  *exec.Cmd
+	dir  string //data directory, holds poke.bin, trainer.bin and server.log
+	port int
+	lock sync.Mutex
+	out  strings.Builder
+	done chan struct{} //closed once the output is read to the end
+}
+
+/*
+Function Name:  output
+Description:    method of test_server
+				everything the child printed so far
+Parameters:     n/a
+Return Value:   the output
+Type:           n/a -> string
+*/
+func (s *test_server) output() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.out.String()
+}
+
+/*
+Function Name:  start_server
+Description:    starts main in a child process on a copy of poke.bin, an
+				empty trainer file and a free port, waits until it listens,
+				the child is killed if still running when the test ends
+Parameters:     t: test handle
+				args: extra server flags
+Return Value:   the running server
+Type:           *testing.T, ...string -> *test_server
+*/
+func start_server(t *testing.T, args ...string) *test_server {
+	t.Helper()
+	srv := &test_server{dir: t.TempDir(), port: free_port(t), done: make(chan struct{})}
+	data, err := os.ReadFile("../poke.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(srv.dir, "poke.bin"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	t.Cleanup(cancel)
+	args = append([]string{"-p", strconv.Itoa(srv.port), "-d", srv.dir, "-m", "poke.bin", "-t", "trainer.bin", "-l", "server.log"}, args...)
+	srv.cmd = exec.CommandContext(ctx, os.Args[0], "-test.run=^$")
+	srv.cmd.Env = append(os.Environ(), "POKEDB_TEST_MAIN="+strings.Join(args, "\n"))
+	pipe, err := srv.cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.cmd.Stderr = srv.cmd.Stdout
+	if err := srv.cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		srv.cmd.Process.Kill()
+		<-srv.done
+		srv.cmd.Wait()
+	})
+
+	listening := make(chan struct{})
+	go func() {
+		defer close(srv.done)
+		scanner := bufio.NewScanner(pipe)
+		for scanner.Scan() {
+			srv.lock.Lock()
+			srv.out.WriteString(scanner.Text() + "\n")
+			srv.lock.Unlock()
+			if strings.HasPrefix(scanner.Text(), "Listening on host") {
+				close(listening)
+			}
+		}
+	}()
+	select {
+	case <-listening:
+	case <-srv.done:
+		t.Fatalf("server exited before listening:\n%s", srv.output())
+	case <-time.After(10 * time.Second):
+		t.Fatalf("server not listening after 10s:\n%s", srv.output())
+	}
+	return srv
+}
+
+/*
+Function Name:  dial
+Description:    method of test_server
+				connects to the server with a 5s read timeout
+Parameters:     t: test handle, the connection is closed when the test ends
+Return Value:   the connection
+Type:           *testing.T -> recordlib.Conn
+*/
+func (s *test_server) dial(t *testing.T) recordlib.Conn {
+	t.Helper()
+	conn, err := recordlib.DialNet([4]byte{127, 0, 0, 1}, s.port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := recordlib.SetReadTimeout(conn, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+/*
+Function Name:  TestServerBusy
+Description:    with -c 2 the first two connections get the handshake and
+				are served, the third reads SERVER_BUSY and is closed, and
+				once one of the two leaves a new connection is served
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestServerBusy(t *testing.T) {
+	srv := start_server(t, "-c", "2")
+	var served []recordlib.Conn
+	for idx := 0; idx < 2; idx++ {
+		conn := srv.dial(t)
+		if msg, err := recordlib.ReallyRead(conn); err != nil || recordlib.CheckHandshake(msg) != nil {
+			t.Fatalf("connection %d: %q, %v, want the handshake", idx+1, msg, err)
+		}
+		recordlib.ReallyRead(conn) //port
+		served = append(served, conn)
+	}
+	third := srv.dial(t)
+	if msg, err := recordlib.ReallyRead(third); err != nil || msg != "SERVER_BUSY" {
+		t.Fatalf("third connection: %q, %v, want SERVER_BUSY", msg, err)
+	}
+	if msg, err := recordlib.ReallyRead(third); err == nil {
+		t.Fatalf("third connection still open after SERVER_BUSY, read %q", msg)
+	}
+	for _, conn := range served {
+		if err := recordlib.ReallyWrite(conn, "PING"); err != nil {
+			t.Fatal(err)
+		}
+		if reply, err := recordlib.ReallyRead(conn); err != nil || reply != "PONG" {
+			t.Fatalf("served connection: %q, %v, want PONG", reply, err)
+		}
+	}
+
+	recordlib.ReallyWrite(served[0], "EXIT")
+	recordlib.ReallyRead(served[0]) //EOF once the server closed it, the slot is free
+	fourth := srv.dial(t)
+	if msg, err := recordlib.ReallyRead(fourth); err != nil || recordlib.CheckHandshake(msg) != nil {
+		t.Fatalf("connection after one left: %q, %v, want the handshake", msg, err)
+	}
+}
+
+/*
+Function Name:  TestSigtermShutdown
+Description:    SIGTERM takes the same graceful path as ^C: a connected
+				client gets BYE, the server exits 0 once it leaves, and the
+				deferred closes run, so the write-ahead log holding a put
+				not yet batch synced is checkpointed to empty, the put is in
+				the trainer file and no close error is printed
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestSigtermShutdown(t *testing.T) {
+	srv := start_server(t, "-wal", "-sync", "batch", "-sync-every", "1000", "-sync-interval", "1h")
+	conn := srv.dial(t)
+	recordlib.ReallyRead(conn) //handshake
+	recordlib.ReallyRead(conn) //port
+	recordlib.ReallyWrite(conn, "POST_TRAINER ash 25")
+	if reply, err := recordlib.ReallyRead(conn); err != nil || reply != "1 ash" {
+		t.Fatalf("POST_TRAINER ash 25: %q, %v, want 1 ash", reply, err)
+	}
+	recordlib.ReallyWrite(conn, "PUT_TRAINER 1 6")
+	if reply, err := recordlib.ReallyRead(conn); err != nil || reply != "GOOD_PUT" {
+		t.Fatalf("PUT_TRAINER 1 6: %q, %v, want GOOD_PUT", reply, err)
+	}
+	wal_path := filepath.Join(srv.dir, "trainer.bin.wal")
+	wal_size := func() int64 {
+		info, err := os.Stat(wal_path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.Size()
+	}
+	if wal_size() == 0 {
+		t.Fatal("write-ahead log empty before SIGTERM, want the unsynced put in it")
+	}
+
+	if err := srv.cmd.Process.Signal(unix.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := recordlib.ReallyRead(conn); err != nil || msg != "BYE" {
+		t.Fatalf("after SIGTERM: %q, %v, want BYE", msg, err)
+	}
+	recordlib.ReallyWrite(conn, "EXIT")
+	select {
+	case <-srv.done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("server still running 10s after SIGTERM:\n%s", srv.output())
+	}
+	if err := srv.cmd.Wait(); err != nil {
+		t.Fatalf("server exit after SIGTERM: %v\n%s", err, srv.output())
+	}
+	out := srv.output()
+	for _, want := range []string{"Signal received (terminated)", "All clients disconnected."} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("server output has no %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "Error: Failed to") {
+		t.Fatalf("server reported a close error:\n%s", out)
+	}
+
+	if size := wal_size(); size != 0 {
+		t.Fatalf("write-ahead log is %d bytes after SIGTERM, want it checkpointed to empty", size)
+	}
+	trainer_file, err := os.Open(filepath.Join(srv.dir, "trainer.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer trainer_file.Close()
+	trainer, err := recordlib.NewFileTrainerStore(trainer_file, nil).Get(1)
+	if err != nil || recordlib.CString(trainer.Name[:]) != "ash" || trainer.Poke1.ID != 6 {
+		t.Fatalf("trainer 1 after SIGTERM: %+v, %v, want ash with pokemon 6", trainer, err)
+	}
+	if log_data, err := os.ReadFile(filepath.Join(srv.dir, "server.log")); err != nil || !strings.Contains(string(log_data), "Signal received (terminated)") {
+		t.Fatalf("server.log after SIGTERM (%v) has no shutdown line", err)
+	}
+}
+
+/*
+Function Name:  TestAcceptRetries
+Description:    accept_clients keeps accepting after EINTR and EMFILE and
+				hands the next connection to the manager, then returns on an
+				error that isn't transient (EBADF) without calling Accept
+				again
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestAcceptRetries(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	results := []error{unix.EINTR, unix.EMFILE, nil, unix.EBADF}
+	calls := 0
+	accept := func() (recordlib.Conn, [4]byte, int, error) {
+		calls++
+		if calls > len(results) {
+			return nil, [4]byte{}, 0, unix.EBADF
+		}
+		if err := results[calls-1]; err != nil {
+			return nil, [4]byte{}, 0, err
+		}
+		return server, [4]byte{192, 0, 2, 7}, 40000, nil
+	}
+
+	new_client := make(chan accepted_conn)
+	returned := make(chan struct{})
+	go func() {
+		accept_clients(accept, nil, new_client, make(chan struct{}), make(chan struct{}))
+		close(returned)
+	}()
+	select {
+	case conn := <-new_client:
+		if conn.sock != server || conn.port != 40000 {
+			t.Fatalf("manager got %+v, want the accepted connection", conn)
+		}
+	case <-returned:
+		t.Fatal("accept loop returned on a retriable error")
+	case <-time.After(5 * time.Second):
+		t.Fatal("no connection 5s after two retriable errors")
+	}
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("accept loop still running 5s after EBADF")
+	}
+	if calls != len(results) {
+		t.Fatalf("Accept called %d times, want %d", calls, len(results))
+	}
+}
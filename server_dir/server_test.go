@@ -0,0 +1,1774 @@
+/*
+Filename:  server_test.go
+Description:
+  - Tests that drive request handlers over an in-memory connection (net.Pipe) against a
+    MemTrainerStore and the bundled poke.bin, no listener or data files are needed
+*/
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"project3/recordlib"
+)
+
+//what a handler needs to run outside of the accept loop
+type test_env struct {
+	poke_file *os.File
+	store     recordlib.TrainerStore
+	poke_lock *sync.RWMutex
+	gm        *recordlib.GlobalManager
+	keys      *post_keys
+	secret    string //server -secret, "" for none
+}
+
+/*
+Function Name:  new_test_env
+Description:    empty in-memory trainer store over the bundled pokemon file
+Parameters:     t: test handle, the pokemon file is closed when the test ends
+Return Value:   the handler dependencies
+Type:           *testing.T -> *test_env
+*/
+func new_test_env(t *testing.T) *test_env {
+	poke_file, err := os.Open("../poke.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { poke_file.Close() })
+	return &test_env{
+		poke_file: poke_file,
+		store:     recordlib.NewMemTrainerStore(poke_file),
+		poke_lock: new(sync.RWMutex),
+		gm:        recordlib.NewGlobalManager(recordlib.LockWriterFirst),
+		keys:      new_post_keys(time.Minute),
+	}
+}
+
+/*
+Function Name:  use_file_store
+Description:    swaps the env's store for a FileTrainerStore over a new trainer
+				file (header only) in the test's temporary directory
+Parameters:     t: test handle
+				env: handler dependencies
+Return Value:   the file store
+Type:           *testing.T, *test_env -> *recordlib.FileTrainerStore
+*/
+func use_file_store(t *testing.T, env *test_env) *recordlib.FileTrainerStore {
+	t.Helper()
+	trainer_file, err := os.OpenFile(filepath.Join(t.TempDir(), "trainer.bin"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { trainer_file.Close() })
+	if err := recordlib.WriteHeader(trainer_file, recordlib.TrainerRecSize); err != nil {
+		t.Fatal(err)
+	}
+	store := recordlib.NewFileTrainerStore(trainer_file, env.poke_file)
+	env.store = store
+	return store
+}
+
+/*
+Function Name:  call
+Description:    runs one handler on the server end of a net.Pipe and collects
+				every frame it sends until it returns
+Parameters:     t: test handle
+				handler: the request handler, given the server end and a
+				fresh session
+Return Value:   frames in the order they were sent
+Type:           *testing.T, func(recordlib.Conn, *session) -> []string
+*/
+func call(t *testing.T, handler func(recordlib.Conn, *session)) []string {
+	t.Helper()
+	server, client := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		handler(server, &session{addr: "pipe", t: req_timing{start: time.Now()}})
+	}()
+	var frames []string
+	for {
+		msg, err := recordlib.ReallyRead(client)
+		if err != nil {
+			return frames
+		}
+		frames = append(frames, msg)
+	}
+}
+
+/*
+Function Name:  post
+Description:    sends one POST_TRAINER request through process_req_post_trainer
+Parameters:     t: test handle
+				env: handler dependencies
+				req: the raw request
+Return Value:   the reply
+Type:           *testing.T, *test_env, string -> string
+*/
+func post(t *testing.T, env *test_env, req string) string {
+	t.Helper()
+	frames := call(t, func(conn recordlib.Conn, sess *session) {
+		process_req_post_trainer(req, conn, 0, env.store, env.poke_lock, env.gm, env.keys, sess)
+	})
+	if len(frames) != 1 {
+		t.Fatalf("%s: %d reply frames %q, want 1", req, len(frames), frames)
+	}
+	return frames[0]
+}
+
+/*
+Function Name:  TestPostTrainerKeyRetry
+Description:    retrying a post with the same key gets the first reply and
+				creates nothing, a different trainer under it is BAD_KEY,
+				and a leading KEY token is just a trainer name
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestPostTrainerKeyRetry(t *testing.T) {
+	env := new_test_env(t)
+
+	first := post(t, env, "POST_TRAINER ash 25 6 KEY=retry-1")
+	if first != "1 ash" {
+		t.Fatalf("first post: %q, want \"1 ash\"", first)
+	}
+	for i := 0; i < 3; i++ {
+		if again := post(t, env, "POST_TRAINER ash 25 6 KEY=retry-1"); again != first {
+			t.Fatalf("retry %d: %q, want %q", i+1, again, first)
+		}
+	}
+	if got := post(t, env, "POST_TRAINER misty 120 KEY=retry-1"); got != "BAD_KEY" {
+		t.Fatalf("key reused for another trainer: %q, want BAD_KEY", got)
+	}
+	if count, _ := env.store.Count(); count != 1 {
+		t.Fatalf("%d trainers after retries, want 1", count)
+	}
+
+	//a leading key would misread these: a trainer named KEY, and a name that looks like a key
+	if got := post(t, env, "POST_TRAINER KEY 25 26"); got != "2 KEY" {
+		t.Fatalf("trainer named KEY: %q, want \"2 KEY\"", got)
+	}
+	trainer, err := env.store.Get(2)
+	if err != nil || trainer.Poke1.ID != 25 || trainer.Poke2.ID != 26 {
+		t.Fatalf("trainer 2: %+v, %v, want pokemon 25 and 26", trainer, err)
+	}
+	if got := post(t, env, "POST_TRAINER KEY=abc"); got != fmt.Sprintf("3 %s", recordlib.StoredTrainerName("KEY=abc")) {
+		t.Fatalf("keyless post of name KEY=abc: %q", got)
+	}
+	if got := post(t, env, "POST_TRAINER brock KEY=retry-2"); got != "4 brock" {
+		t.Fatalf("key with no pokemon: %q, want \"4 brock\"", got)
+	}
+}
+
+//store whose next Put panics after the record and pokemon locks are taken
+type panic_store struct {
+	recordlib.TrainerStore
+	panic_next bool
+}
+
+func (s *panic_store) Put(id uint16, pokemon []uint16) error {
+	if s.panic_next {
+		s.panic_next = false
+		panic("injected Put failure")
+	}
+	return s.TrainerStore.Put(id, pokemon)
+}
+
+/*
+Function Name:  serve
+Description:    runs handle_client for client 192.0.2.7:40000 (conn 1) on the
+				server end of a connection until it returns
+Parameters:     env: handler dependencies
+				store: trainer store for this connection
+				server: server end of the connection
+				exited: receives server when handle_client returns
+Return Value:   n/a
+Type:           *test_env, recordlib.TrainerStore, recordlib.Conn, chan recordlib.Conn -> n/a
+*/
+func serve(env *test_env, store recordlib.TrainerStore, server recordlib.Conn, exited chan recordlib.Conn) {
+	metrics := &server_metrics{start: time.Now(), conn_query: make(chan chan conn_counts)}
+	shutdown := make(chan struct{})
+	handle_client(40000, "192.0.2.7", server, env.poke_file, nil, store, nil, env.poke_lock, env.gm,
+		new(sync.Mutex), exited, shutdown, env.secret, nil, "", nil, metrics, 0, 0, env.keys, nil, 1)
+}
+
+/*
+Function Name:  connect
+Description:    runs handle_client on the server end of a net.Pipe, reads
+				the handshake and port frames, the connection's client_exit
+				send goes to exited
+Parameters:     t: test handle
+				env: handler dependencies
+				store: trainer store for this connection
+				exited: receives the server end when handle_client returns
+Return Value:   the client end, ready for requests
+Type:           *testing.T, *test_env, recordlib.TrainerStore, chan recordlib.Conn -> net.Conn
+*/
+func connect(t *testing.T, env *test_env, store recordlib.TrainerStore, exited chan recordlib.Conn) net.Conn {
+	t.Helper()
+	server, client := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	go serve(env, store, server, exited)
+	for _, what := range []string{"handshake", "port"} {
+		if _, err := recordlib.ReallyRead(client); err != nil {
+			t.Fatalf("reading %s: %v", what, err)
+		}
+	}
+	return client
+}
+
+/*
+Function Name:  request
+Description:    sends one request and reads its reply frame
+Parameters:     t: test handle
+				client: connection from connect
+				req: the raw request
+Return Value:   the reply
+Type:           *testing.T, net.Conn, string -> string
+*/
+func request(t *testing.T, client net.Conn, req string) string {
+	t.Helper()
+	if err := recordlib.ReallyWrite(client, req); err != nil {
+		t.Fatalf("sending %s: %v", req, err)
+	}
+	reply, err := recordlib.ReallyRead(client)
+	if err != nil {
+		t.Fatalf("reply to %s: %v", req, err)
+	}
+	return reply
+}
+
+/*
+Function Name:  TestPanicReleasesLocks
+Description:    a PUT that panics inside the store, holding the record write
+				lock and poke_lock's read side, ends only its connection: the
+				locks are released, so a later PUT of the same record and a
+				pokemon write lock (PATCH_POKE) don't block
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestPanicReleasesLocks(t *testing.T) {
+	env := new_test_env(t)
+	store := &panic_store{TrainerStore: env.store}
+	if _, err := store.Post("ash", []uint16{25}); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan recordlib.Conn, 2)
+
+	first := connect(t, env, store, exited)
+	store.panic_next = true
+	recordlib.ReallyWrite(first, "PUT_TRAINER 1 6")
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler didn't return after the panic")
+	}
+
+	if !env.poke_lock.TryLock() {
+		t.Fatal("poke_lock still read locked after the panic, PATCH_POKE would block forever")
+	}
+	env.poke_lock.Unlock()
+
+	second := connect(t, env, store, exited)
+	done := make(chan string, 1)
+	go func() { //no t.Fatal off the test goroutine, a failed read shows as the reply
+		recordlib.ReallyWrite(second, "PUT_TRAINER 1 6 7")
+		reply, err := recordlib.ReallyRead(second)
+		if err != nil {
+			reply = err.Error()
+		}
+		done <- reply
+	}()
+	select {
+	case reply := <-done:
+		if reply != "GOOD_PUT" {
+			t.Fatalf("put after the panic: %q, want GOOD_PUT", reply)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("put after the panic blocked, record 1 is still write locked")
+	}
+	trainer, err := store.Get(1)
+	if err != nil || trainer.Poke1.ID != 6 || trainer.Poke2.ID != 7 {
+		t.Fatalf("trainer 1 after the second put: %+v, %v", trainer, err)
+	}
+}
+
+/*
+Function Name:  TestPutLockTimeout
+Description:    with -lock-timeout a put of a record another connection holds
+				fails with the lock timeout instead of waiting, and succeeds
+				once the record is free
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestPutLockTimeout(t *testing.T) {
+	env := new_test_env(t)
+	if _, err := env.store.Post("ash", []uint16{25}); err != nil {
+		t.Fatal(err)
+	}
+	put := func() string {
+		frames := call(t, func(conn recordlib.Conn, sess *session) {
+			sess.lock_timeout = 20 * time.Millisecond
+			process_req_put_trainer("PUT_TRAINER 1 6", conn, 0, env.store, env.poke_lock, env.gm, sess)
+		})
+		if len(frames) != 1 {
+			t.Fatalf("%d reply frames %q, want 1", len(frames), frames)
+		}
+		return frames[0]
+	}
+
+	holder := env.gm.Track()
+	holder.WLockRecord(1)
+	if got, want := put(), "BAD_PUT."+recordlib.ErrLockTimeout.Error(); got != want {
+		t.Fatalf("put of a locked record: %q, want %q", got, want)
+	}
+	holder.WUnlockRecord(1)
+	if got := put(); got != "GOOD_PUT" {
+		t.Fatalf("put of a free record: %q, want GOOD_PUT", got)
+	}
+	if !env.poke_lock.TryLock() {
+		t.Fatal("timed out put left poke_lock held")
+	}
+	env.poke_lock.Unlock()
+}
+
+/*
+Function Name:  TestLogFilter
+Description:    port and cmd filters match request and lifecycle lines of
+				loopback and remote clients alike, and no other port
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestLogFilter(t *testing.T) {
+	lines := []string{
+		"2026/10/14 12:00:00 [lifecycle] event=connect conn=1 client=127.0.0.1:40000",
+		"2026/10/14 12:00:00 [conn=1 req=2] [127.0.0.1:40000] POST_TRAINER ash 25",
+		"2026/10/14 12:00:01 [lifecycle] event=connect conn=2 client=192.0.2.7:40001",
+		"2026/10/14 12:00:01 [conn=2 req=2] [192.0.2.7:40001] PUT_TRAINER 1 6",
+		"2026/10/14 12:00:01 [conn=2 req=2] [192.0.2.7:40001] PUT_TRAINER completed in 0.100ms status=GOOD_PUT",
+		"2026/10/14 12:00:02 [conn=3 req=2] [::1:400] POST_TRAINER_X",
+		"2026/10/14 12:00:03 [lifecycle] event=disconnect conn=2 client=192.0.2.7:40001 duration=1s requests=1 reason=EOF",
+	}
+	tests := []struct {
+		field, value string
+		want         []int //indexes into lines
+	}{
+		{"port", "40000", []int{0, 1}},
+		{"port", "40001", []int{2, 3, 4, 6}},
+		{"port", "400", []int{5}},
+		{"port", "4000", nil},
+		{"cmd", "PUT_TRAINER", []int{3, 4}},
+		{"cmd", "POST_TRAINER", []int{1}},
+	}
+	for _, tt := range tests {
+		match, ok := log_filter(tt.field, tt.value)
+		if !ok {
+			t.Fatalf("%s %s refused", tt.field, tt.value)
+		}
+		var got []int
+		for idx, line := range lines {
+			if match(line) {
+				got = append(got, idx)
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Fatalf("%s %s matched lines %v, want %v", tt.field, tt.value, got, tt.want)
+		}
+	}
+	if _, ok := log_filter("port", "70000"); ok {
+		t.Fatal("port 70000 accepted")
+	}
+}
+
+/*
+Function Name:  capture_log
+Description:    sends the standard logger to a buffer until the test ends
+Parameters:     t: test handle
+Return Value:   the buffer, read it only after the logging goroutines are done
+Type:           *testing.T -> *bytes.Buffer
+*/
+func capture_log(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+/*
+Function Name:  TestRequestLogAddress
+Description:    request log lines of a remote client carry its address, the
+				same one the lifecycle lines print, not 127.0.0.1
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestRequestLogAddress(t *testing.T) {
+	env := new_test_env(t)
+	logged := capture_log(t)
+	exited := make(chan recordlib.Conn, 1)
+	client := connect(t, env, env.store, exited)
+	request(t, client, "POST_TRAINER ash 25")
+	recordlib.ReallyWrite(client, "EXIT")
+	<-exited
+
+	lines := strings.Split(logged.String(), "\n")
+	var request_line, connect_line bool
+	for _, line := range lines {
+		if strings.Contains(line, "127.0.0.1") {
+			t.Fatalf("log line with the loopback address: %q", line)
+		}
+		request_line = request_line || strings.HasSuffix(line, "[192.0.2.7:40000] POST_TRAINER ash 25")
+		connect_line = connect_line || strings.HasSuffix(line, "event=connect conn=1 client=192.0.2.7:40000")
+	}
+	if !request_line || !connect_line {
+		t.Fatalf("request line logged %v, connect line %v, in:\n%s", request_line, connect_line, logged)
+	}
+}
+
+/*
+Function Name:  TestConcurrentPostHandler
+Description:    many POST_TRAINER requests at once through the handler on a
+				trainer file, beside pokemon readers holding poke_lock's read
+				side (posts only read lock it), every reply is a distinct ID
+				1..n and each record is at its ID's position
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestConcurrentPostHandler(t *testing.T) {
+	const posters = 40
+	env := new_test_env(t)
+	store := use_file_store(t, env)
+
+	env.poke_lock.RLock() //a long pokemon read, posts must not need the write side
+	replies := make([][]string, posters)
+	var done sync.WaitGroup
+	for idx := 0; idx < posters; idx++ {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			req := fmt.Sprintf("POST_TRAINER t%d %d", idx, idx+1)
+			replies[idx] = call(t, func(conn recordlib.Conn, sess *session) {
+				process_req_post_trainer(req, conn, 0, env.store, env.poke_lock, env.gm, env.keys, sess)
+			})
+		}()
+	}
+	finished := make(chan struct{})
+	go func() {
+		done.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(10 * time.Second):
+		t.Fatal("posts blocked behind a pokemon read lock")
+	}
+	env.poke_lock.RUnlock()
+
+	seen := make(map[int]bool)
+	for idx, frames := range replies {
+		var id int
+		var name string
+		if len(frames) != 1 {
+			t.Fatalf("post %d: reply frames %q", idx, frames)
+		}
+		if _, err := fmt.Sscanf(frames[0], "%d %s", &id, &name); err != nil || name != fmt.Sprintf("t%d", idx) {
+			t.Fatalf("post %d: reply %q", idx, frames[0])
+		}
+		if id < 1 || id > posters || seen[id] {
+			t.Fatalf("post %d: ID %d out of 1..%d or a duplicate", idx, id, posters)
+		}
+		seen[id] = true
+		trainer, err := store.Get(uint16(id))
+		if err != nil || recordlib.CString(trainer.Name[:]) != name || int(trainer.Poke1.ID) != idx+1 {
+			t.Fatalf("record %d: %+v, %v", id, trainer, err)
+		}
+	}
+}
+
+//store whose read of one record (Get or All's visit) waits for the test, so a listing
+//can be held mid-copy
+type gated_store struct {
+	recordlib.TrainerStore
+	gate_id uint16
+	reached chan struct{} //closed when the gated Get starts
+	release chan struct{} //close to let it finish
+}
+
+func (s *gated_store) Get(id uint16) (recordlib.TrainerRec, error) {
+	if id == s.gate_id {
+		close(s.reached)
+		<-s.release
+	}
+	return s.TrainerStore.Get(id)
+}
+
+func (s *gated_store) All(visit func(recordlib.TrainerRec) error) error {
+	return s.TrainerStore.All(func(trainer recordlib.TrainerRec) error {
+		if trainer.ID == s.gate_id {
+			close(s.reached)
+			<-s.release
+		}
+		return visit(trainer)
+	})
+}
+
+/*
+Function Name:  TestListingLetsWritesThrough
+Description:    while a trainer listing is stopped mid-copy (inside the read of
+				record 10), a PUT of a record further on and a POST both
+				finish, the listing then shows the PUT (it had not reached
+				that record yet) and not the trainer posted after its count
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestListingLetsWritesThrough(t *testing.T) {
+	const trainers = 40
+	env := new_test_env(t)
+	for idx := 0; idx < trainers; idx++ {
+		if _, err := env.store.Post(fmt.Sprintf("t%d", idx), []uint16{25}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store := &gated_store{TrainerStore: env.store, gate_id: 10, reached: make(chan struct{}), release: make(chan struct{})}
+
+	listing := make(chan []string, 1)
+	go func() {
+		listing <- call(t, func(conn recordlib.Conn, sess *session) {
+			process_req_get_trainer_all("REQ_TRAINER_ALL", conn, 0, store, env.gm, sess)
+		})
+	}()
+	select {
+	case <-store.reached:
+	case <-time.After(5 * time.Second):
+		t.Fatal("listing never read record 10")
+	}
+
+	writes := make(chan []string, 2)
+	go func() {
+		writes <- call(t, func(conn recordlib.Conn, sess *session) {
+			process_req_put_trainer("PUT_TRAINER 30 6", conn, 0, store, env.poke_lock, env.gm, sess)
+		})
+		writes <- call(t, func(conn recordlib.Conn, sess *session) {
+			process_req_post_trainer("POST_TRAINER late 7", conn, 0, store, env.poke_lock, env.gm, env.keys, sess)
+		})
+	}()
+	for _, want := range []string{"GOOD_PUT", fmt.Sprintf("%d late", trainers+1)} {
+		select {
+		case frames := <-writes:
+			if len(frames) != 1 || frames[0] != want {
+				t.Fatalf("write during the listing: %q, want %q", frames, want)
+			}
+		case <-time.After(5 * time.Second):
+			close(store.release)
+			t.Fatal("write blocked behind the listing")
+		}
+	}
+	close(store.release)
+
+	frames := <-listing
+	if len(frames) != trainers+2 || frames[0] != "SENDING" || frames[len(frames)-1] != fmt.Sprintf("DONE %d", trainers) {
+		t.Fatalf("listing: %d frames, first %q, last %q", len(frames), frames[0], frames[len(frames)-1])
+	}
+	var trainer recordlib.TrainerRec
+	if err := json.Unmarshal([]byte(frames[30]), &trainer); err != nil || trainer.ID != 30 || trainer.Poke1.ID != 6 {
+		t.Fatalf("record 30 in the listing: %+v, %v, want the put's pokemon 6", trainer, err)
+	}
+}
+
+/*
+Function Name:  TestDeleteWhereEmpty
+Description:    REQ_TRAINER_DELETE_WHERE empty deletes every trainer with an
+				empty party and no other, lists their IDs, and is refused
+				without authentication
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestDeleteWhereEmpty(t *testing.T) {
+	env := new_test_env(t)
+	store := use_file_store(t, env)
+	for idx := 1; idx <= 12; idx++ {
+		var pokemon []uint16
+		if idx%3 != 0 { //3, 6, 9 and 12 stay empty
+			pokemon = []uint16{uint16(idx)}
+		}
+		if _, err := store.Post(fmt.Sprintf("t%d", idx), pokemon); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Delete(6); err != nil { //a deleted slot is not deleted again
+		t.Fatal(err)
+	}
+	delete_where := func(authed bool) []string {
+		return call(t, func(conn recordlib.Conn, sess *session) {
+			sess.authed = authed
+			process_req_delete_where("REQ_TRAINER_DELETE_WHERE empty", conn, 0, env.store, env.gm, sess)
+		})
+	}
+
+	if frames := delete_where(false); len(frames) != 1 || frames[0] != "UNAUTHORIZED" {
+		t.Fatalf("unauthenticated: %q, want UNAUTHORIZED", frames)
+	}
+	if frames := delete_where(true); len(frames) != 1 || frames[0] != "DELETED 3 3 9 12" {
+		t.Fatalf("delete where empty: %q, want \"DELETED 3 3 9 12\"", frames)
+	}
+	for id := uint16(1); id <= 12; id++ {
+		_, err := store.Get(id)
+		if gone := id%3 == 0; gone != (err == recordlib.ErrTrainerNotFound) {
+			t.Fatalf("trainer %d after the delete: %v", id, err)
+		}
+	}
+	if frames := delete_where(true); len(frames) != 1 || frames[0] != "DELETED 0" {
+		t.Fatalf("second delete where empty: %q, want \"DELETED 0\"", frames)
+	}
+}
+
+/*
+Function Name:  TestOpenPokeFile
+Description:    startup on a missing pokemon file names the path and the -m
+				flag, a partial one (not a whole number of records) is
+				refused, an empty one opens and every lookup, cached or
+				not, replies OUT_OF_BOUNDS, and the bundled file opens
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestOpenPokeFile(t *testing.T) {
+	dir := t.TempDir()
+	data, err := os.ReadFile("../poke.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	missing := filepath.Join(dir, "missing.bin")
+	if _, err := open_poke_file(missing, dir); err == nil || !strings.Contains(err.Error(), missing) || !strings.Contains(err.Error(), "-m") {
+		t.Fatalf("missing file: %v, want the path and a hint about -m", err)
+	}
+
+	partial := filepath.Join(dir, "partial.bin")
+	if err := os.WriteFile(partial, data[:recordlib.PokeRecSize*3/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := open_poke_file(partial, dir); err == nil || !strings.Contains(err.Error(), "truncated") {
+		t.Fatalf("partial file: %v, want it refused as truncated", err)
+	}
+
+	empty := filepath.Join(dir, "empty.bin")
+	if err := os.WriteFile(empty, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	poke_file, err := open_poke_file(empty, dir)
+	if err != nil {
+		t.Fatalf("empty file: %v, want a warning only", err)
+	}
+	defer poke_file.Close()
+	cache, err := recordlib.NewPokeCache(poke_file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, poke_cache := range []*recordlib.PokeCache{nil, cache} {
+		frames := call(t, func(conn recordlib.Conn, sess *session) {
+			process_req_get_poke("REQ_POKE_ID 1", conn, 0, poke_file, poke_cache, new(sync.RWMutex), sess)
+		})
+		if len(frames) != 1 || frames[0] != "OUT_OF_BOUNDS" {
+			t.Fatalf("lookup in an empty file (cache %v): %q, want OUT_OF_BOUNDS", poke_cache != nil, frames)
+		}
+	}
+
+	whole := filepath.Join(dir, "poke.bin")
+	if err := os.WriteFile(whole, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if poke_file, err := open_poke_file(whole, dir); err != nil {
+		t.Fatalf("bundled file: %v", err)
+	} else {
+		poke_file.Close()
+	}
+}
+
+/*
+Function Name:  TestLifecycleLog
+Description:    a connection that serves requests and then sends EXIT, and one
+				that just closes, each log one connect and one disconnect
+				line with the client address, the served request count (EXIT
+				not counted) and the reason
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestLifecycleLog(t *testing.T) {
+	env := new_test_env(t)
+	logged := capture_log(t)
+	exited := make(chan recordlib.Conn, 2)
+
+	client := connect(t, env, env.store, exited)
+	request(t, client, "POST_TRAINER ash 25")
+	request(t, client, "REQ_TRAINER_ID 1")
+	request(t, client, "PING")
+	recordlib.ReallyWrite(client, "EXIT")
+	<-exited
+	dropped := connect(t, env, env.store, exited)
+	request(t, dropped, "PING")
+	dropped.Close()
+	<-exited
+
+	var lifecycle []string
+	for _, line := range strings.Split(logged.String(), "\n") {
+		if _, event, found := strings.Cut(line, "[lifecycle] "); found {
+			lifecycle = append(lifecycle, event)
+		}
+	}
+	want := []string{
+		`^event=connect conn=1 client=192\.0\.2\.7:40000$`,
+		`^event=disconnect conn=1 client=192\.0\.2\.7:40000 duration=\S+ requests=3 reason=EXIT$`,
+		`^event=connect conn=1 client=192\.0\.2\.7:40000$`,
+		`^event=disconnect conn=1 client=192\.0\.2\.7:40000 duration=\S+ requests=1 reason=EOF$`,
+	}
+	if len(lifecycle) != len(want) {
+		t.Fatalf("lifecycle lines %q, want %d", lifecycle, len(want))
+	}
+	for idx, line := range lifecycle {
+		if !regexp.MustCompile(want[idx]).MatchString(line) {
+			t.Fatalf("line %d: %q, want %s", idx, line, want[idx])
+		}
+	}
+}
+
+/*
+Function Name:  simulated_clients
+Description:    n connections with a stand-in handler on each server end,
+				the handler returns (client_done, handlers.Done) on EXIT or
+				a read error the way handle_client does, each client end
+				answers BYE with EXIT after delay, or closes right away when
+				gone (exited on EOF, its handler not yet drained) or never
+				reads when stuck
+Parameters:     t: test handle
+				n: connections
+				delay: how long a client takes to answer BYE
+				gone: clients that closed their end before the shutdown
+				stuck: clients that never read
+				clients: gets every server end
+				client_done: the handlers' exit sends
+				handlers: counts the handlers
+Return Value:   client ends of the stuck clients
+Type:           *testing.T, int, time.Duration, int, int, map[recordlib.Conn]bool, chan recordlib.Conn, *sync.WaitGroup -> []net.Conn
+*/
+func simulated_clients(t *testing.T, n int, delay time.Duration, gone int, stuck int, clients map[recordlib.Conn]bool, client_done chan recordlib.Conn, handlers *sync.WaitGroup) []net.Conn {
+	var stuck_ends []net.Conn
+	for idx := 0; idx < n; idx++ {
+		server, client := net.Pipe()
+		t.Cleanup(func() { client.Close() })
+		clients[server] = true
+		handlers.Add(1)
+		go func() {
+			defer handlers.Done()
+			for {
+				msg, err := recordlib.ReallyRead(server)
+				if err != nil || msg == "EXIT" {
+					client_done <- server
+					return
+				}
+			}
+		}()
+		switch {
+		case idx < gone:
+			client.Close()
+		case idx < gone+stuck:
+			stuck_ends = append(stuck_ends, client)
+		default:
+			go func() {
+				if msg, err := recordlib.ReallyRead(client); err == nil && msg == "BYE" {
+					time.Sleep(delay)
+					recordlib.ReallyWrite(client, "EXIT")
+				}
+			}()
+		}
+	}
+	return stuck_ends
+}
+
+/*
+Function Name:  TestShutdownBroadcast
+Description:    200 clients that each take 50ms to answer BYE, 20 of them
+				already gone on EOF, are all told and drained well under the
+				10s a serial broadcast would take, and with a client that
+				never reads the rest still finish and the timeout closes the
+				stuck one instead of blocking the shutdown
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestShutdownBroadcast(t *testing.T) {
+	tests := []struct {
+		name    string
+		stuck   int
+		timeout time.Duration
+	}{
+		{"all answer", 0, 10 * time.Second},
+		{"one stuck", 1, 500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		clients := make(map[recordlib.Conn]bool)
+		client_done := make(chan recordlib.Conn, 200) //handlers of force-closed clients exit after the drain
+		var handlers sync.WaitGroup
+		stuck_ends := simulated_clients(t, 200, 50*time.Millisecond, 20, tt.stuck, clients, client_done, &handlers)
+
+		start := time.Now()
+		finished := make(chan struct{})
+		go func() {
+			shutdown_clients(clients, client_done, make(chan accepted_conn), &handlers, tt.timeout)
+			close(finished)
+		}()
+		select {
+		case <-finished:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%s: shutdown still running after 10s", tt.name)
+		}
+		if took := time.Since(start); took > 3*time.Second {
+			t.Fatalf("%s: shutdown took %v", tt.name, took)
+		}
+		if len(clients) != tt.stuck {
+			t.Fatalf("%s: %d clients left connected, want %d", tt.name, len(clients), tt.stuck)
+		}
+		for _, client := range stuck_ends {
+			client.SetReadDeadline(time.Now().Add(time.Second))
+			if _, err := recordlib.ReallyRead(client); err != io.EOF {
+				t.Fatalf("%s: stuck client read %v, want io.EOF from the forced close", tt.name, err)
+			}
+		}
+	}
+}
+
+/*
+Function Name:  list_trainers
+Description:    sends REQ_TRAINER_ALL and collects the frames up to DONE
+Parameters:     t: test handle
+				client: connection from connect
+Return Value:   frames between SENDING and DONE, the DONE line
+Type:           *testing.T, net.Conn -> []string, string
+*/
+func list_trainers(t *testing.T, client net.Conn) ([]string, string) {
+	t.Helper()
+	if reply := request(t, client, "REQ_TRAINER_ALL"); reply != "SENDING" {
+		t.Fatalf("REQ_TRAINER_ALL: %q, want SENDING", reply)
+	}
+	var frames []string
+	for {
+		msg, err := recordlib.ReallyRead(client)
+		if err != nil {
+			t.Fatalf("reading the listing: %v", err)
+		}
+		if strings.HasPrefix(msg, "DONE") {
+			return frames, msg
+		}
+		frames = append(frames, msg)
+	}
+}
+
+/*
+Function Name:  TestCompressedListing
+Description:    a 5000 trainer listing after COMPRESS gzip arrives as one GZIP
+				frame between SENDING and DONE, inflates to exactly the
+				records an uncompressed connection gets, and is smaller
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestCompressedListing(t *testing.T) {
+	env := new_test_env(t)
+	for idx := 0; idx < 5000; idx++ {
+		if _, err := env.store.Post(fmt.Sprintf("trainer%d", idx), []uint16{uint16(idx%151 + 1), 25, 6}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exited := make(chan recordlib.Conn, 2)
+
+	plain_frames, plain_done := list_trainers(t, connect(t, env, env.store, exited))
+	if plain_done != "DONE 5000" || len(plain_frames) != 5000 {
+		t.Fatalf("uncompressed: %d frames, %q, want 5000 and DONE 5000", len(plain_frames), plain_done)
+	}
+
+	client := connect(t, env, env.store, exited)
+	if reply := request(t, client, "COMPRESS gzip"); reply != "COMPRESS gzip" {
+		t.Fatalf("COMPRESS gzip: %q", reply)
+	}
+	frames, done := list_trainers(t, client)
+	if len(frames) != 1 || !strings.HasPrefix(frames[0], recordlib.CompressPrefix) || done != plain_done {
+		t.Fatalf("compressed: %d frames, %q, want one GZIP frame and %s", len(frames), done, plain_done)
+	}
+	msgs, err := recordlib.InflateFrames(frames[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != len(plain_frames) {
+		t.Fatalf("inflated %d records, want %d", len(msgs), len(plain_frames))
+	}
+	plain_size := 0
+	for idx, msg := range msgs {
+		if msg != plain_frames[idx] {
+			t.Fatalf("record %d: %q, uncompressed %q", idx, msg, plain_frames[idx])
+		}
+		plain_size += recordlib.FrameHeaderSize + len(msg)
+	}
+	if len(frames[0]) >= plain_size {
+		t.Fatalf("compressed frame is %d bytes, uncompressed stream %d", len(frames[0]), plain_size)
+	}
+}
+
+/*
+Function Name:  TestMutationsNeedAuth
+Description:    on a -secret server every request in mutating_reqs is
+				refused with UNAUTHORIZED before AUTH and changes nothing, a
+				wrong token is UNAUTHORIZED too, reads answer without AUTH,
+				and after AUTH_OK posts, puts and deletes go through
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestMutationsNeedAuth(t *testing.T) {
+	env := new_test_env(t)
+	env.secret = "s3cret"
+	for _, name := range []string{"ash", "misty"} {
+		if _, err := env.store.Post(name, []uint16{25, 6}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mutations := []string{
+		"POST_TRAINER brock 74",
+		"BULK_POST_TRAINER\nbrock 74",
+		"PUT_TRAINER 1 6",
+		"APPEND_TRAINER 1 9",
+		"REMOVE_TRAINER_POKE 1 1",
+		"SWAP_TRAINER_POKE 1 1 2 1",
+		"MOVE_TRAINER_POKE 1 1 2",
+		"PATCH_POKE 25 HP 40",
+		"DEL_TRAINER 2",
+	}
+	for _, re := range mutating_reqs {
+		if !slices.ContainsFunc(mutations, re.MatchString) {
+			t.Fatalf("no test request for %s", re)
+		}
+	}
+
+	client := connect(t, env, env.store, make(chan recordlib.Conn, 1))
+	for _, req := range append(mutations, "AUTH wrong") {
+		if reply := request(t, client, req); reply != "UNAUTHORIZED" {
+			t.Fatalf("%q before AUTH: %q, want UNAUTHORIZED", req, reply)
+		}
+	}
+	if count, _ := env.store.Count(); count != 2 {
+		t.Fatalf("%d trainers after refused mutations, want 2", count)
+	}
+	if got, err := env.store.Get(1); err != nil || got.Poke1.ID != 25 || got.Poke2.ID != 6 {
+		t.Fatalf("trainer 1 after refused mutations: %+v, %v", got, err)
+	}
+	for _, req := range []string{"REQ_TRAINER_ID 1", "REQ_POKE_ID 25"} {
+		if reply := request(t, client, req); !strings.HasPrefix(reply, `{"ID":`) {
+			t.Fatalf("%q without AUTH: %q, want the record", req, reply)
+		}
+	}
+
+	if reply := request(t, client, "AUTH s3cret"); reply != "AUTH_OK" {
+		t.Fatalf("AUTH with the secret: %q", reply)
+	}
+	for _, step := range []struct{ req, want string }{
+		{"POST_TRAINER brock 74", "3 brock"},
+		{"PUT_TRAINER 1 7", "GOOD_PUT"},
+		{"DEL_TRAINER 2", "DELETED"},
+	} {
+		if reply := request(t, client, step.req); reply != step.want {
+			t.Fatalf("%q after AUTH: %q, want %q", step.req, reply, step.want)
+		}
+	}
+	if got, err := env.store.Get(1); err != nil || got.Poke1.ID != 7 {
+		t.Fatalf("trainer 1 after the authorized put: %+v, %v", got, err)
+	}
+}
+
+//store that counts every call that reads or writes a record
+type counting_store struct {
+	recordlib.TrainerStore
+	calls atomic.Int32
+}
+
+func (s *counting_store) Get(id uint16) (recordlib.TrainerRec, error) {
+	s.calls.Add(1)
+	return s.TrainerStore.Get(id)
+}
+
+func (s *counting_store) Put(id uint16, pokemon []uint16) error {
+	s.calls.Add(1)
+	return s.TrainerStore.Put(id, pokemon)
+}
+
+func (s *counting_store) Delete(id uint16) error {
+	s.calls.Add(1)
+	return s.TrainerStore.Delete(id)
+}
+
+/*
+Function Name:  TestOverflowingIDs
+Description:    pokemon and trainer IDs past 0xFFFF, up to ones too long for
+				an int, are refused (OUT_OF_BOUNDS, BAD_PUT.<range>,
+				BAD_POKE_ID) before any record is read or written, so none
+				wraps around to a real record or to id 0's offset
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestOverflowingIDs(t *testing.T) {
+	env := new_test_env(t)
+	if _, err := env.store.Post("ash", []uint16{25}); err != nil {
+		t.Fatal(err)
+	}
+	store := &counting_store{TrainerStore: env.store}
+	env.store = store
+
+	//any read of the closed pokemon file would reply SERVER_ERROR
+	closed, err := os.Open("../poke.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	get_poke := func(req string) string {
+		frames := call(t, func(conn recordlib.Conn, sess *session) {
+			process_req_get_poke(req, conn, 0, closed, nil, env.poke_lock, sess)
+		})
+		return strings.Join(frames, "\n")
+	}
+	if reply := get_poke("REQ_POKE_ID 25"); reply != "SERVER_ERROR" {
+		t.Fatalf("REQ_POKE_ID 25 on the closed file: %q, want SERVER_ERROR", reply)
+	}
+	for _, req := range []string{"REQ_POKE_ID 65536", "REQ_POKE_ID 99999999999999999999"} {
+		if reply := get_poke(req); reply != "OUT_OF_BOUNDS" {
+			t.Fatalf("%s: %q, want OUT_OF_BOUNDS", req, reply)
+		}
+	}
+
+	tests := []struct {
+		req  string
+		want string
+		run  func(string, recordlib.Conn, *session)
+	}{
+		{"REQ_TRAINER_ID 65536", "OUT_OF_BOUNDS", func(req string, conn recordlib.Conn, sess *session) {
+			process_req_get_trainer(req, conn, 0, env.store, env.gm, sess)
+		}},
+		{"REQ_TRAINER_ID 99999999999999999999", "OUT_OF_BOUNDS", func(req string, conn recordlib.Conn, sess *session) {
+			process_req_get_trainer(req, conn, 0, env.store, env.gm, sess)
+		}},
+		{"DEL_TRAINER 65537", "OUT_OF_BOUNDS", func(req string, conn recordlib.Conn, sess *session) {
+			process_req_delete_trainer(req, conn, 0, env.store, env.gm, sess)
+		}},
+		{"PUT_TRAINER 65536 25", "BAD_PUT." + recordlib.ErrTrainerIDRange.Error(), func(req string, conn recordlib.Conn, sess *session) {
+			process_req_put_trainer(req, conn, 0, env.store, env.poke_lock, env.gm, sess)
+		}},
+		{"POST_TRAINER misty 99999999999999999999", "BAD_POKE_ID", func(req string, conn recordlib.Conn, sess *session) {
+			process_req_post_trainer(req, conn, 0, env.store, env.poke_lock, env.gm, env.keys, sess)
+		}},
+	}
+	for _, tt := range tests {
+		frames := call(t, func(conn recordlib.Conn, sess *session) { tt.run(tt.req, conn, sess) })
+		if len(frames) != 1 || frames[0] != tt.want {
+			t.Fatalf("%s: %q, want %s", tt.req, frames, tt.want)
+		}
+	}
+	if calls := store.calls.Load(); calls != 0 {
+		t.Fatalf("%d store calls for out of range IDs, want none", calls)
+	}
+	if got, err := store.TrainerStore.Get(1); err != nil || got.Poke1.ID != 25 {
+		t.Fatalf("trainer 1 after the requests: %+v, %v", got, err)
+	}
+}
+
+/*
+Function Name:  TestLogNTooLarge
+Description:    REQ_LOG_FILE, REQ_LOG_FILTER and REQ_LOG_FILE_ALL with n one
+				past MaxLogN, or digits too long for an int, reply
+				BAD_COUNT (the client's n must be at most MaxLogN) without
+				reading the log, n equal to MaxLogN is served
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestLogNTooLarge(t *testing.T) {
+	log_file, err := os.OpenFile(filepath.Join(t.TempDir(), "server.log"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log_file.Close()
+	if _, err := log_file.WriteString("2026/10/14 12:00:00 [conn=1 req=1] [192.0.2.7:40000] PING\n"); err != nil {
+		t.Fatal(err)
+	}
+	log_lock := new(sync.Mutex)
+	handlers := []struct {
+		format string
+		run    func(string, recordlib.Conn, *session)
+	}{
+		{"REQ_LOG_FILE %s", func(req string, conn recordlib.Conn, sess *session) {
+			process_req_get_log(req, conn, 0, log_file, log_lock, sess)
+		}},
+		{"REQ_LOG_FILTER port 40000 %s", func(req string, conn recordlib.Conn, sess *session) {
+			process_req_log_filter(req, conn, 0, log_file, log_lock, sess)
+		}},
+		{"REQ_LOG_FILE_ALL %s", func(req string, conn recordlib.Conn, sess *session) {
+			process_req_get_log_all(req, conn, 0, log_file, log_lock, sess)
+		}},
+	}
+	max_n := strconv.Itoa(recordlib.MaxLogN)
+	for _, handler := range handlers {
+		for _, n := range []string{strconv.Itoa(recordlib.MaxLogN + 1), "99999999999999999999"} {
+			req := fmt.Sprintf(handler.format, n)
+			frames := call(t, func(conn recordlib.Conn, sess *session) { handler.run(req, conn, sess) })
+			if len(frames) != 1 || frames[0] != "BAD_COUNT" {
+				t.Fatalf("%s: %q, want BAD_COUNT", req, frames)
+			}
+		}
+		req := fmt.Sprintf(handler.format, max_n)
+		frames := call(t, func(conn recordlib.Conn, sess *session) { handler.run(req, conn, sess) })
+		if len(frames) != 1 || !strings.Contains(frames[0], "PING") {
+			t.Fatalf("%s: %q, want the log line", req, frames)
+		}
+	}
+}
+
+/*
+Function Name:  TestValidateRefsRepairAuth
+Description:    with a trainer slot pointing past the pokemon file, verify
+				refs reports it without AUTH, verify refs repair without AUTH
+				is UNAUTHORIZED and leaves the trainer file as it was, and an
+				authenticated repair drops the slot
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestValidateRefsRepairAuth(t *testing.T) {
+	env := new_test_env(t)
+	store := use_file_store(t, env)
+	if _, err := store.Post("ash", []uint16{25, 6}); err != nil {
+		t.Fatal(err)
+	}
+	count, err := recordlib.PokeCount(env.poke_file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trainer, err := store.Get(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trainer.Poke2.ID = uint16(count + 1)
+	if _, err := store.TrainerFile.WriteAt(recordlib.EncodeTrainerRec(trainer), recordlib.HeaderSize); err != nil {
+		t.Fatal(err)
+	}
+	validate := func(req string, authed bool) string {
+		frames := call(t, func(conn recordlib.Conn, sess *session) {
+			sess.authed = authed
+			process_req_validate_refs(req, conn, 0, env.store, env.poke_file, store.TrainerFile, env.poke_lock, env.gm, sess)
+		})
+		if len(frames) != 1 {
+			t.Fatalf("%s: %d reply frames %q, want 1", req, len(frames), frames)
+		}
+		return frames[0]
+	}
+	want := fmt.Sprintf(`[{"trainer_id":1,"slot":2,"poke_id":%d}]`, count+1)
+
+	if reply := validate("REQ_VALIDATE_REFS", false); reply != want {
+		t.Fatalf("verify refs: %s, want %s", reply, want)
+	}
+	before, err := os.ReadFile(store.TrainerFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply := validate("REQ_VALIDATE_REFS REPAIR", false); reply != "UNAUTHORIZED" {
+		t.Fatalf("verify refs repair without AUTH: %s, want UNAUTHORIZED", reply)
+	}
+	if after, err := os.ReadFile(store.TrainerFile.Name()); err != nil || !bytes.Equal(after, before) {
+		t.Fatalf("trainer file changed by an unauthorized repair (%v)", err)
+	}
+
+	if reply := validate("REQ_VALIDATE_REFS REPAIR", true); reply != want {
+		t.Fatalf("verify refs repair: %s, want %s", reply, want)
+	}
+	if reply := validate("REQ_VALIDATE_REFS", false); reply != "[]" {
+		t.Fatalf("verify refs after the repair: %s, want []", reply)
+	}
+}
+
+/*
+Function Name:  TestPostBadName
+Description:    POST_TRAINER names holding NUL, escape, vertical tab or DEL
+				bytes get BAD_NAME and store nothing; an embedded tab splits
+				the request so it never matches POST_TRAINER at all
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestPostBadName(t *testing.T) {
+	env := new_test_env(t)
+	for _, name := range []string{"a\x00b", "ash\x00", "\x1b[31mred", "a\vb", "del\x7f"} {
+		if reply := post(t, env, "POST_TRAINER "+name+" 25"); reply != "BAD_NAME" {
+			t.Fatalf("post %q: %s, want BAD_NAME", name, reply)
+		}
+	}
+
+	client := connect(t, env, env.store, make(chan recordlib.Conn, 1))
+	if reply := request(t, client, "POST_TRAINER a\tb 25"); reply != "CLIENT_REQ_INVALID" {
+		t.Fatalf("post with an embedded tab: %s, want CLIENT_REQ_INVALID", reply)
+	}
+	if count, _ := env.store.Count(); count != 0 {
+		t.Fatalf("%d trainers stored from bad names, want 0", count)
+	}
+	if reply := post(t, env, "POST_TRAINER ash 25"); reply != "1 ash" {
+		t.Fatalf("post ash: %s, want 1 ash", reply)
+	}
+}
+
+/*
+Function Name:  TestPostReplyStoredName
+Description:    the name in a POST_TRAINER reply is the name read back from
+				the stored record, up to the 15 character limit; a longer
+				name is LONG_NAME and stores nothing
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestPostReplyStoredName(t *testing.T) {
+	env := new_test_env(t)
+	for idx, name := range []string{"a", "abcdefghijklmno", "Mr.Mime~Fan_99"} {
+		reply := post(t, env, "POST_TRAINER "+name+" 25")
+		trainer, err := env.store.Get(uint16(idx + 1))
+		if err != nil {
+			t.Fatalf("get %d: %v", idx+1, err)
+		}
+		if want := fmt.Sprintf("%d %s", idx+1, recordlib.CString(trainer.Name[:])); reply != want {
+			t.Fatalf("post %q: %s, want %s from the stored record", name, reply, want)
+		}
+	}
+	for _, name := range []string{"abcdefghijklmnop", "abcdefghijklmnopqrstuvwxyz"} {
+		if reply := post(t, env, "POST_TRAINER "+name+" 25"); reply != "LONG_NAME" {
+			t.Fatalf("post %q: %s, want LONG_NAME", name, reply)
+		}
+	}
+	if count, _ := env.store.Count(); count != 3 {
+		t.Fatalf("%d trainers stored, want 3", count)
+	}
+}
+
+/*
+Function Name:  TestPostWithoutPokemonReplies
+Description:    POST_TRAINER with a name and no pokemon gets a reply and
+				creates the trainer with empty slots, as does a PUT_TRAINER
+				clearing every slot, instead of returning silently and
+				leaving the client blocked on its response
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestPostWithoutPokemonReplies(t *testing.T) {
+	env := new_test_env(t)
+	if reply := post(t, env, "POST_TRAINER Ash"); reply != "1 Ash" {
+		t.Fatalf("POST_TRAINER Ash: %s, want 1 Ash", reply)
+	}
+
+	client := connect(t, env, env.store, make(chan recordlib.Conn, 1))
+	client.SetReadDeadline(time.Now().Add(3 * time.Second)) //a missing reply fails here instead of hanging
+	if reply := request(t, client, "POST_TRAINER Misty"); reply != "2 Misty" {
+		t.Fatalf("POST_TRAINER Misty: %s, want 2 Misty", reply)
+	}
+	if reply := request(t, client, "PUT_TRAINER 1 25"); reply != "GOOD_PUT" {
+		t.Fatalf("PUT_TRAINER 1 25: %s, want GOOD_PUT", reply)
+	}
+	if reply := request(t, client, "PUT_TRAINER 1"); reply != "GOOD_PUT" {
+		t.Fatalf("PUT_TRAINER 1: %s, want GOOD_PUT", reply)
+	}
+	for id := uint16(1); id <= 2; id++ {
+		trainer, err := env.store.Get(id)
+		if err != nil || trainer.Poke1.ID != 0 {
+			t.Fatalf("trainer %d: %+v, %v, want no pokemon", id, trainer, err)
+		}
+	}
+}
+
+/*
+Function Name:  TestEdgeInputsAlwaysReply
+Description:    requests with leading zeros, zero, or numbers too long for an
+				int each get exactly one reply through handle_client, never
+				silence; a PING afterwards still gets its own PONG
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestEdgeInputsAlwaysReply(t *testing.T) {
+	env := new_test_env(t)
+	if _, err := env.store.Post("ash", []uint16{25, 6}); err != nil {
+		t.Fatal(err)
+	}
+	huge := "99999999999999999999"
+	tests := []struct {
+		req, want string
+	}{
+		{"REQ_POKE_ID 0025", "CLIENT_REQ_INVALID"},
+		{"REQ_TRAINER_ID 0001", "CLIENT_REQ_INVALID"},
+		{"REQ_POKE_NAME_ID 0", "OUT_OF_BOUNDS"},
+		{"REQ_POKE_RAW 00000", "OUT_OF_BOUNDS"},
+		{"REQ_POKE_TOP 0", "BAD_COUNT"},
+		{"REQ_POKE_TOP " + huge, "BAD_COUNT"},
+		{"REQ_POKE_FILTER gen=" + huge + " legendary=0", "CLIENT_REQ_INVALID"},
+		{"REQ_POKE_MULTI " + huge, "[null]"},
+		{"POST_TRAINER misty 0025", "2 misty"},
+		{"PUT_TRAINER 0001 0006", "GOOD_PUT"},
+		{"APPEND_TRAINER 1 " + huge, "BAD_PUT." + recordlib.ErrPokeIDRange.Error()},
+		{"REMOVE_TRAINER_POKE 1 0", "BAD_PUT." + recordlib.ErrSlotRange.Error()},
+		{"SWAP_TRAINER_POKE 1 0 1 " + huge, "BAD_PUT." + recordlib.ErrSlotRange.Error()},
+		{"MOVE_TRAINER_POKE " + huge + " 1 2", "BAD_PUT." + recordlib.ErrTrainerIDRange.Error()},
+		{"DEL_TRAINER 00", "OUT_OF_BOUNDS"},
+		{"PING", "PONG"},
+	}
+	client := connect(t, env, env.store, make(chan recordlib.Conn, 1))
+	for _, tt := range tests {
+		client.SetReadDeadline(time.Now().Add(3 * time.Second)) //a missing reply fails here instead of hanging
+		if reply := request(t, client, tt.req); reply != tt.want {
+			t.Fatalf("%s: %q, want %s", tt.req, reply, tt.want)
+		}
+	}
+	for id, want := range map[uint16]uint16{1: 6, 2: 25} {
+		if trainer, err := env.store.Get(id); err != nil || trainer.Poke1.ID != want {
+			t.Fatalf("trainer %d: %+v, %v, want pokemon %d first", id, trainer, err, want)
+		}
+	}
+}
+
+/*
+Function Name:  TestPokeIDNoWrap
+Description:    pokemon and trainer IDs one 65536 past a real record (65561
+				would wrap to pikachu, 65537 to trainer 1) are rejected, not
+				assigned to the record they wrap onto
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestPokeIDNoWrap(t *testing.T) {
+	env := new_test_env(t)
+	if _, err := env.store.Post("ash", []uint16{6}); err != nil {
+		t.Fatal(err)
+	}
+	for _, req := range []string{"POST_TRAINER misty 65561", "POST_TRAINER misty 70000", "POST_TRAINER misty 1 65561"} {
+		if reply := post(t, env, req); reply != "BAD_POKE_ID" {
+			t.Fatalf("%s: %s, want BAD_POKE_ID", req, reply)
+		}
+	}
+
+	client := connect(t, env, env.store, make(chan recordlib.Conn, 1))
+	client.SetReadDeadline(time.Now().Add(3 * time.Second))
+	tests := []struct {
+		req, want string
+	}{
+		{"PUT_TRAINER 1 65561", "BAD_PUT." + recordlib.ErrPokeIDRange.Error()},
+		{"APPEND_TRAINER 1 65561", "BAD_PUT." + recordlib.ErrPokeIDRange.Error()},
+		{"PUT_TRAINER 65537 25", "BAD_PUT." + recordlib.ErrTrainerIDRange.Error()},
+		{"REQ_TRAINER_ID 65537", "OUT_OF_BOUNDS"},
+	}
+	for _, tt := range tests {
+		if reply := request(t, client, tt.req); reply != tt.want {
+			t.Fatalf("%s: %s, want %s", tt.req, reply, tt.want)
+		}
+	}
+	if count, _ := env.store.Count(); count != 1 {
+		t.Fatalf("%d trainers after the wrapping posts, want 1", count)
+	}
+	if trainer, err := env.store.Get(1); err != nil || trainer.Poke1.ID != 6 || trainer.Poke2.ID != 0 {
+		t.Fatalf("trainer 1: %+v, %v, want only pokemon 6", trainer, err)
+	}
+}
+
+/*
+Function Name:  TestListingTimeout
+Description:    a plain and a sorted trainer listing held up past the
+				session's scan timeout by a slowed store read reply TIMEOUT
+				alone, nothing partial, and leave no lock behind; with no
+				timeout the same slow listing finishes
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestListingTimeout(t *testing.T) {
+	const trainers = 20
+	env := new_test_env(t)
+	for idx := 0; idx < trainers; idx++ {
+		if _, err := env.store.Post(fmt.Sprintf("t%d", idx), []uint16{25}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list := func(req string, timeout time.Duration) []string {
+		store := &gated_store{TrainerStore: env.store, gate_id: 5, reached: make(chan struct{}), release: make(chan struct{})}
+		listing := make(chan []string, 1)
+		go func() {
+			listing <- call(t, func(conn recordlib.Conn, sess *session) {
+				sess.scan_timeout = timeout
+				if req == "REQ_TRAINER_ALL" {
+					process_req_get_trainer_all(req, conn, 0, store, env.gm, sess)
+				} else {
+					process_req_get_trainer_all_sorted(req, conn, 0, store, env.gm, sess)
+				}
+			})
+		}()
+		select {
+		case <-store.reached:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s never read record 5", req)
+		}
+		time.Sleep(200 * time.Millisecond) //the slow write of record 5
+		close(store.release)
+		select {
+		case frames := <-listing:
+			return frames
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s never finished", req)
+			return nil
+		}
+	}
+
+	for _, req := range []string{"REQ_TRAINER_ALL", "REQ_TRAINER_ALL_SORTED name asc"} {
+		if frames := list(req, 50*time.Millisecond); len(frames) != 1 || frames[0] != "TIMEOUT" {
+			t.Fatalf("%s past the scan timeout: %d frames %.80q, want TIMEOUT", req, len(frames), strings.Join(frames, " "))
+		}
+		put := make(chan []string, 1)
+		go func() {
+			put <- call(t, func(conn recordlib.Conn, sess *session) {
+				process_req_put_trainer("PUT_TRAINER 5 6", conn, 0, env.store, env.poke_lock, env.gm, sess)
+			})
+		}()
+		select {
+		case frames := <-put:
+			if len(frames) != 1 || frames[0] != "GOOD_PUT" {
+				t.Fatalf("put after the timed out %s: %q", req, frames)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("put blocked by a lock the timed out %s kept", req)
+		}
+	}
+
+	frames := list("REQ_TRAINER_ALL", 0)
+	if len(frames) != trainers+2 || frames[len(frames)-1] != fmt.Sprintf("DONE %d", trainers) {
+		t.Fatalf("slow listing with no timeout: %d frames, last %q", len(frames), frames[len(frames)-1])
+	}
+}
+
+/*
+Function Name:  TestSortedListingTies
+Description:    REQ_TRAINER_ALL_SORTED by name, ascending and descending,
+				keeps trainers sharing a name in ascending ID order; by id
+				it is plain ID order either way
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestSortedListingTies(t *testing.T) {
+	env := new_test_env(t)
+	names := []string{"misty", "ash", "brock", "ash", "misty", "gary"}
+	for idx := 0; idx < 40; idx++ { //past sort.Slice's insertion sort size, where ties would land anywhere
+		if _, err := env.store.Post(names[idx%len(names)], []uint16{25}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	listing := func(req string) []recordlib.TrainerRec {
+		frames := call(t, func(conn recordlib.Conn, sess *session) {
+			process_req_get_trainer_all_sorted(req, conn, 0, env.store, env.gm, sess)
+		})
+		if len(frames) != 42 || frames[0] != "SENDING" || frames[41] != "DONE 40" {
+			t.Fatalf("%s: %d frames, first %q", req, len(frames), frames[0])
+		}
+		recs := make([]recordlib.TrainerRec, 40)
+		for idx, frame := range frames[1:41] {
+			if err := json.Unmarshal([]byte(frame), &recs[idx]); err != nil {
+				t.Fatalf("%s record %d: %v", req, idx, err)
+			}
+		}
+		return recs
+	}
+
+	for _, desc := range []bool{false, true} {
+		req := "REQ_TRAINER_ALL_SORTED name asc"
+		if desc {
+			req = "REQ_TRAINER_ALL_SORTED name desc"
+		}
+		recs := listing(req)
+		for idx := 1; idx < len(recs); idx++ {
+			prev, cur := recordlib.CString(recs[idx-1].Name[:]), recordlib.CString(recs[idx].Name[:])
+			switch {
+			case prev == cur && recs[idx-1].ID > recs[idx].ID:
+				t.Fatalf("%s: %s tie %d before %d, want ascending IDs", req, cur, recs[idx-1].ID, recs[idx].ID)
+			case prev != cur && (prev < cur) == desc:
+				t.Fatalf("%s: %s before %s", req, prev, cur)
+			}
+		}
+	}
+
+	for _, dir := range []string{"asc", "desc"} {
+		recs := listing("REQ_TRAINER_ALL_SORTED id " + dir)
+		for idx, trainer := range recs {
+			want := uint16(idx + 1)
+			if dir == "desc" {
+				want = uint16(40 - idx)
+			}
+			if trainer.ID != want {
+				t.Fatalf("id %s: record %d has ID %d, want %d", dir, idx, trainer.ID, want)
+			}
+		}
+	}
+}
+
+/*
+Function Name:  TestListingOrderAfterReuse
+Description:    after deletes and re-posts leave holes in the middle and new
+				records past them, REQ_TRAINER_ALL streams exactly the live
+				trainers in strictly ascending ID order, for the memory and
+				the file store, and the store's All visits them the same way
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestListingOrderAfterReuse(t *testing.T) {
+	for _, file := range []bool{false, true} {
+		env := new_test_env(t)
+		if file {
+			use_file_store(t, env)
+		}
+		for idx := 0; idx < 10; idx++ {
+			if _, err := env.store.Post(fmt.Sprintf("t%d", idx), []uint16{25}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, id := range []uint16{2, 5, 9} {
+			if err := env.store.Delete(id); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, name := range []string{"again2", "again5", "again9"} {
+			if _, err := env.store.Post(name, []uint16{6}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := env.store.Delete(12); err != nil {
+			t.Fatal(err)
+		}
+		if err := env.store.Put(1, []uint16{7}); err != nil {
+			t.Fatal(err)
+		}
+		want := []uint16{1, 3, 4, 6, 7, 8, 10, 11, 13}
+
+		frames := call(t, func(conn recordlib.Conn, sess *session) {
+			process_req_get_trainer_all("REQ_TRAINER_ALL", conn, 0, env.store, env.gm, sess)
+		})
+		if len(frames) != len(want)+2 || frames[len(frames)-1] != fmt.Sprintf("DONE %d", len(want)) {
+			t.Fatalf("file store %v: %d frames, last %q", file, len(frames), frames[len(frames)-1])
+		}
+		var streamed []uint16
+		for _, frame := range frames[1 : len(frames)-1] {
+			var trainer recordlib.TrainerRec
+			if err := json.Unmarshal([]byte(frame), &trainer); err != nil {
+				t.Fatal(err)
+			}
+			streamed = append(streamed, trainer.ID)
+		}
+		var visited []uint16
+		if err := env.store.All(func(trainer recordlib.TrainerRec) error {
+			visited = append(visited, trainer.ID)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(streamed, want) || !slices.Equal(visited, want) {
+			t.Fatalf("file store %v: streamed %v, All visited %v, want %v", file, streamed, visited, want)
+		}
+	}
+}
+
+/*
+Function Name:  TestListingTrailingDeletes
+Description:    a trainer file ending in a run of deleted records lists only
+				the live ones before it and ends with the right DONE count,
+				and a file whose every record is deleted replies
+				OUT_OF_BOUNDS, both without running on past the last record
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestListingTrailingDeletes(t *testing.T) {
+	env := new_test_env(t)
+	use_file_store(t, env)
+	for idx := 0; idx < 10; idx++ {
+		if _, err := env.store.Post(fmt.Sprintf("t%d", idx), []uint16{25}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list := func() []string {
+		listing := make(chan []string, 1)
+		go func() {
+			listing <- call(t, func(conn recordlib.Conn, sess *session) {
+				process_req_get_trainer_all("REQ_TRAINER_ALL", conn, 0, env.store, env.gm, sess)
+			})
+		}()
+		select {
+		case frames := <-listing:
+			return frames
+		case <-time.After(5 * time.Second):
+			t.Fatal("listing never finished")
+			return nil
+		}
+	}
+
+	for id := uint16(5); id <= 10; id++ {
+		if err := env.store.Delete(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	frames := list()
+	if len(frames) != 6 || frames[0] != "SENDING" || frames[5] != "DONE 4" {
+		t.Fatalf("listing with 6 trailing deletes: %d frames %.80q, want 4 records and DONE 4", len(frames), strings.Join(frames, " "))
+	}
+	var last recordlib.TrainerRec
+	if err := json.Unmarshal([]byte(frames[4]), &last); err != nil || last.ID != 4 {
+		t.Fatalf("last record listed: %+v, %v, want trainer 4", last, err)
+	}
+
+	for id := uint16(1); id <= 4; id++ {
+		if err := env.store.Delete(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if frames := list(); len(frames) != 1 || frames[0] != "OUT_OF_BOUNDS" {
+		t.Fatalf("listing with every record deleted: %q, want OUT_OF_BOUNDS", frames)
+	}
+}
+
+/*
+Function Name:  TestHelloBinary
+Description:    after HELLO binary, REQ_POKE_ID and REQ_TRAINER_ID replies are
+				the records' on-disk bytes and decode to what the files hold,
+				status replies stay text, HELLO json goes back to JSON
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestHelloBinary(t *testing.T) {
+	env := new_test_env(t)
+	if _, err := env.store.Post("ash", []uint16{25, 6}); err != nil {
+		t.Fatal(err)
+	}
+	client := connect(t, env, env.store, make(chan recordlib.Conn, 1))
+	client.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if reply := request(t, client, "HELLO binary"); reply != "HELLO binary" {
+		t.Fatalf("HELLO binary: %s", reply)
+	}
+
+	var poke recordlib.PokeRec
+	if err := recordlib.DecodeRecordBinary(request(t, client, "REQ_POKE_ID 25"), &poke); err != nil {
+		t.Fatal(err)
+	}
+	if want, err := recordlib.GetPokemon(env.poke_file, 25); err != nil || poke != want {
+		t.Fatalf("binary pokemon 25: %+v, want %+v (%v)", poke, want, err)
+	}
+	var trainer recordlib.TrainerRec
+	if err := recordlib.DecodeRecordBinary(request(t, client, "REQ_TRAINER_ID 1"), &trainer); err != nil {
+		t.Fatal(err)
+	}
+	if want, err := env.store.Get(1); err != nil || trainer != want {
+		t.Fatalf("binary trainer 1: %+v, want %+v (%v)", trainer, want, err)
+	}
+	if reply := request(t, client, "REQ_TRAINER_ID 9"); reply != "OUT_OF_BOUNDS" {
+		t.Fatalf("missing trainer in binary mode: %q, want OUT_OF_BOUNDS", reply)
+	}
+
+	if reply := request(t, client, "HELLO json"); reply != "HELLO json" {
+		t.Fatalf("HELLO json: %s", reply)
+	}
+	if err := json.Unmarshal([]byte(request(t, client, "REQ_TRAINER_ID 1")), &trainer); err != nil || trainer.Poke1.ID != 25 {
+		t.Fatalf("trainer 1 back in JSON mode: %+v, %v", trainer, err)
+	}
+}
+
+/*
+Function Name:  TestTimingReply
+Description:    after TIMING on, the final reply of a record read, a put, an
+				invalid request and a listing starts with a TIMING line whose
+				lock_us, io_us and total_us are all present, non-negative and
+				lock plus I/O within total, stream frames before DONE have
+				none, and TIMING off drops the line again
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestTimingReply(t *testing.T) {
+	env := new_test_env(t)
+	if _, err := env.store.Post("ash", []uint16{25}); err != nil {
+		t.Fatal(err)
+	}
+	client := connect(t, env, env.store, make(chan recordlib.Conn, 1))
+	client.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if reply := request(t, client, "TIMING on"); reply != "TIMING on" {
+		t.Fatalf("TIMING on: %s", reply)
+	}
+	timing := regexp.MustCompile(`^TIMING lock_us=(-?\d+) io_us=(-?\d+) total_us=(-?\d+)\n`)
+	check := func(req, reply, want string) {
+		t.Helper()
+		fields := timing.FindStringSubmatch(reply)
+		if fields == nil {
+			t.Fatalf("%s: %q has no TIMING line", req, reply)
+		}
+		var us [3]int
+		for idx := range us {
+			us[idx], _ = strconv.Atoi(fields[idx+1])
+			if us[idx] < 0 {
+				t.Fatalf("%s: negative time in %q", req, strings.TrimSpace(fields[0]))
+			}
+		}
+		if us[0]+us[1] > us[2] {
+			t.Fatalf("%s: lock and I/O past the total in %q", req, strings.TrimSpace(fields[0]))
+		}
+		if rest := reply[len(fields[0]):]; !strings.HasPrefix(rest, want) {
+			t.Fatalf("%s: reply after TIMING %q, want %s", req, rest, want)
+		}
+	}
+
+	check("REQ_POKE_ID 25", request(t, client, "REQ_POKE_ID 25"), `{"ID":25`)
+	check("PUT_TRAINER 1 6", request(t, client, "PUT_TRAINER 1 6"), "GOOD_PUT")
+	check("NOT_A_REQUEST", request(t, client, "NOT_A_REQUEST"), "CLIENT_REQ_INVALID")
+	if reply := request(t, client, "REQ_TRAINER_ALL"); reply != "SENDING" {
+		t.Fatalf("REQ_TRAINER_ALL: %q, want SENDING without TIMING", reply)
+	}
+	if reply, err := recordlib.ReallyRead(client); err != nil || !strings.HasPrefix(reply, `{"ID":1`) {
+		t.Fatalf("listed record: %q, %v, want it without TIMING", reply, err)
+	}
+	done, err := recordlib.ReallyRead(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("REQ_TRAINER_ALL", done, "DONE 1")
+
+	if reply := request(t, client, "TIMING off"); reply != "TIMING off" {
+		t.Fatalf("TIMING off: %s", reply)
+	}
+	if reply := request(t, client, "PUT_TRAINER 1 25"); reply != "GOOD_PUT" {
+		t.Fatalf("PUT_TRAINER with timing off: %q, want GOOD_PUT", reply)
+	}
+}
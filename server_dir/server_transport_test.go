@@ -0,0 +1,112 @@
+/*
+Filename:  server_transport_test.go
+Description:
+  - The connection handshake and a request over real loopback TCP, once per pairing of the
+    syscall transport (Listen/Dial) and the net package one (-transport net, ListenNet/DialNet)
+*/
+package main
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"project3/recordlib"
+)
+
+/*
+Function Name:  free_port
+Description:    a loopback TCP port nothing is listening on right now
+Parameters:     t: test handle
+Return Value:   the port
+Type:           *testing.T -> int
+*/
+func free_port(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+/*
+Function Name:  TestTransports
+Description:    for each server and client transport pairing, handle_client
+				on the accepted connection sends a handshake CheckHandshake
+				accepts and the port, then PING and a pokemon read are
+				answered and EXIT ends the connection
+Parameters:     t: test handle
+Return Value:   n/a
+Type:           *testing.T -> n/a
+*/
+func TestTransports(t *testing.T) {
+	loopback := [4]byte{127, 0, 0, 1}
+	listen := map[string]func(int) (*recordlib.Listener, error){
+		"syscall": func(port int) (*recordlib.Listener, error) { return recordlib.Listen(loopback, port, 8) },
+		"net":     func(port int) (*recordlib.Listener, error) { return recordlib.ListenNet(loopback, port) },
+	}
+	dial := map[string]func(int) (recordlib.Conn, error){
+		"syscall": func(port int) (recordlib.Conn, error) { return recordlib.Dial(loopback, port) },
+		"net":     func(port int) (recordlib.Conn, error) { return recordlib.DialNet(loopback, port) },
+	}
+	tests := []struct{ server, client string }{
+		{"syscall", "syscall"},
+		{"net", "net"},
+		{"syscall", "net"},
+		{"net", "syscall"},
+	}
+	env := new_test_env(t)
+	for _, tt := range tests {
+		name := tt.server + " server, " + tt.client + " client"
+		port := free_port(t)
+		ln, err := listen[tt.server](port)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		exited := make(chan recordlib.Conn, 1)
+		go func() {
+			conn, _, _, err := ln.Accept()
+			if err == nil {
+				serve(env, env.store, conn, exited)
+			}
+		}()
+
+		client, err := dial[tt.client](port)
+		if err != nil {
+			ln.Close()
+			t.Fatalf("%s: %v", name, err)
+		}
+		handshake, err := recordlib.ReallyRead(client)
+		if err != nil || recordlib.CheckHandshake(handshake) != nil {
+			t.Fatalf("%s: handshake %q, %v", name, handshake, err)
+		}
+		if port, err := recordlib.ReallyRead(client); err != nil {
+			t.Fatalf("%s: port frame: %v", name, err)
+		} else if _, err := strconv.Atoi(port); err != nil {
+			t.Fatalf("%s: second frame %q, want the port", name, port)
+		}
+		for _, step := range []struct{ req, want string }{
+			{"PING", "PONG"},
+			{"REQ_POKE_ID 25", `{"ID":25,`},
+		} {
+			if err := recordlib.ReallyWrite(client, step.req); err != nil {
+				t.Fatalf("%s: sending %s: %v", name, step.req, err)
+			}
+			if reply, err := recordlib.ReallyRead(client); err != nil || !strings.HasPrefix(reply, step.want) {
+				t.Fatalf("%s: %s got %q, %v, want %q", name, step.req, reply, err, step.want)
+			}
+		}
+		recordlib.ReallyWrite(client, "EXIT")
+		select {
+		case <-exited:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: handler still running 5s after EXIT", name)
+		}
+		client.Close()
+		ln.Close()
+	}
+}